@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// CheckConfig holds the settings validated by -check
+type CheckConfig struct {
+	ServerURL         string
+	APIKey            string
+	LocalOnly         bool
+	Insecure          bool
+	CACertFile        string
+	ClientCertFile    string
+	ClientKeyFile     string
+	CalibrationFile   string
+	AllowMACs         string
+	DenyMACs          string
+	SpoolDir          string
+	SpoolMaxBytes     int64
+	MinSendInterval   time.Duration
+	HeartbeatInterval time.Duration
+	ShutdownTimeout   time.Duration
+	SendRetries       int
+	RetryBase         time.Duration
+	RetryMax          time.Duration
+	BLEAdapter        string
+	MQTTBroker        string
+	Duration          time.Duration
+	HTTPTimeout       time.Duration
+	RoundTemp         int
+	RoundHumidity     int
+	Pressure          float64
+}
+
+// checkResult is one line of the -check report
+type checkResult struct {
+	name string
+	err  error
+}
+
+// validateConfig checks flag values without touching the network or BLE adapter
+func validateConfig(cfg CheckConfig) []error {
+	var errs []error
+	if cfg.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("-duration must be positive"))
+	}
+	if cfg.HTTPTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("-http-timeout must be positive"))
+	}
+	if cfg.RoundTemp < -1 || cfg.RoundHumidity < -1 {
+		errs = append(errs, fmt.Errorf("-round-temp and -round-humidity must be -1 or more"))
+	}
+	if err := validatePressure(cfg.Pressure); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.SpoolMaxBytes < 0 {
+		errs = append(errs, fmt.Errorf("-spool-max-bytes must not be negative"))
+	}
+	if cfg.MinSendInterval < 0 {
+		errs = append(errs, fmt.Errorf("-min-send-interval must not be negative"))
+	}
+	if cfg.HeartbeatInterval < 0 {
+		errs = append(errs, fmt.Errorf("-heartbeat-interval must not be negative"))
+	}
+	if _, err := parseBLEAdapter(cfg.BLEAdapter); err != nil {
+		errs = append(errs, fmt.Errorf("-ble-adapter: %v", err))
+	}
+	if cfg.SendRetries < 0 {
+		errs = append(errs, fmt.Errorf("-send-retries must not be negative"))
+	}
+	if cfg.RetryBase <= 0 || cfg.RetryMax < cfg.RetryBase {
+		errs = append(errs, fmt.Errorf("-retry-base must be positive and no more than -retry-max"))
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("-shutdown-timeout must be positive"))
+	}
+
+	if !cfg.LocalOnly {
+		if u, err := url.Parse(cfg.ServerURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("-server %q is not an http(s) URL", cfg.ServerURL))
+		}
+		if cfg.APIKey == "" && cfg.ClientCertFile == "" {
+			errs = append(errs, fmt.Errorf("-apikey is required unless -local or -client-cert is set"))
+		}
+		if (cfg.ClientCertFile == "") != (cfg.ClientKeyFile == "") {
+			errs = append(errs, fmt.Errorf("-client-cert and -client-key must be set together"))
+		}
+		if cfg.Insecure && cfg.CACertFile != "" {
+			errs = append(errs, fmt.Errorf("-ca-cert has no effect with -insecure-skip-tls-verify-dangerous"))
+		}
+	}
+
+	if cfg.MQTTBroker != "" {
+		if u, err := url.Parse(cfg.MQTTBroker); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("-mqtt-broker %q is not a broker URL (e.g., tcp://localhost:1883)", cfg.MQTTBroker))
+		}
+	}
+	return errs
+}
+
+// checkFiles loads the CA certificate, calibration file and MAC lists and makes sure the spool
+// directory is writable
+func checkFiles(cfg CheckConfig) []error {
+	var errs []error
+	if cfg.CACertFile != "" || (cfg.ClientCertFile != "" && cfg.ClientKeyFile != "") {
+		if _, err := newTLSConfig(false, cfg.CACertFile, cfg.ClientCertFile, cfg.ClientKeyFile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if cfg.CalibrationFile != "" {
+		if _, err := loadCalibration(cfg.CalibrationFile); err != nil {
+			errs = append(errs, fmt.Errorf("failed to load calibration: %v", err))
+		}
+	}
+	if _, err := NewMACFilter(cfg.AllowMACs, cfg.DenyMACs); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.SpoolDir != "" {
+		if err := os.MkdirAll(cfg.SpoolDir, 0755); err != nil {
+			errs = append(errs, fmt.Errorf("spool directory: %v", err))
+		} else if f, err := os.CreateTemp(cfg.SpoolDir, ".check-*"); err != nil {
+			errs = append(errs, fmt.Errorf("spool directory is not writable: %v", err))
+		} else {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}
+	return errs
+}
+
+// serverBaseURL strips the /readings endpoint from the -server URL
+func serverBaseURL(serverURL string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(serverURL, "/"), "/readings")
+}
+
+// checkServer confirms the server is healthy and accepts the API key. It reads /devices rather
+// than posting a reading so the check leaves no data behind.
+func checkServer(serverURL, apiKey string, client *http.Client) error {
+	base := serverBaseURL(serverURL)
+
+	resp, err := client.Get(base + "/health")
+	if err != nil {
+		return fmt.Errorf("server unreachable: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check responded with status %d", resp.StatusCode)
+	}
+
+	req, err := http.NewRequest("GET", base+"/devices", nil)
+	if err != nil {
+		return fmt.Errorf("error creating HTTP request: %v", err)
+	}
+	req.Header.Set("X-API-Key", apiKey)
+	resp, err = client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error contacting server: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("authentication failed: Invalid API key")
+	} else if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// checkBLE opens the BLE adapter and closes it again
+func checkBLE(adapter string) error {
+	adapterID, err := parseBLEAdapter(adapter)
+	if err != nil {
+		return err
+	}
+	d, err := openBLEDevice(adapterID, false)
+	if err != nil {
+		return fmt.Errorf("failed to open device: %v", err)
+	}
+	return d.Stop()
+}
+
+// runChecks validates the configuration, adapter and server connection, prints a
+// pass/fail report and returns whether everything passed
+func runChecks(cfg CheckConfig) bool {
+	var results []checkResult
+	for _, err := range validateConfig(cfg) {
+		results = append(results, checkResult{"flags", err})
+	}
+	if len(results) == 0 {
+		results = append(results, checkResult{"flags", nil})
+	}
+
+	fileErrs := checkFiles(cfg)
+	for _, err := range fileErrs {
+		results = append(results, checkResult{"files", err})
+	}
+	if len(fileErrs) == 0 {
+		results = append(results, checkResult{"files", nil})
+	}
+
+	results = append(results, checkResult{"bluetooth", checkBLE(cfg.BLEAdapter)})
+
+	if !cfg.LocalOnly {
+		tlsConfig, err := newTLSConfig(cfg.Insecure, cfg.CACertFile, cfg.ClientCertFile, cfg.ClientKeyFile)
+		if err == nil {
+			client := &http.Client{Timeout: cfg.HTTPTimeout, Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+			err = checkServer(cfg.ServerURL, cfg.APIKey, client)
+		}
+		results = append(results, checkResult{"server", err})
+	}
+
+	passed := true
+	for _, result := range results {
+		if result.err != nil {
+			passed = false
+			fmt.Printf("[FAIL] %-10s %v\n", result.name, result.err)
+		} else {
+			fmt.Printf("[PASS] %s\n", result.name)
+		}
+	}
+	if passed {
+		fmt.Println("All checks passed")
+	} else {
+		fmt.Println("Some checks failed")
+	}
+	return passed
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validCheckConfig returns a configuration that passes validateConfig
+func validCheckConfig() CheckConfig {
+	return CheckConfig{
+		ServerURL:       "http://localhost:8080/readings",
+		APIKey:          "test-api-key",
+		SpoolMaxBytes:   10 << 20,
+		Duration:        30 * time.Second,
+		HTTPTimeout:     10 * time.Second,
+		ShutdownTimeout: 10 * time.Second,
+		BLEAdapter:      "hci0",
+		SendRetries:     2,
+		RetryBase:       time.Second,
+		RetryMax:        30 * time.Second,
+		RoundTemp:       1,
+		RoundHumidity:   1,
+		Pressure:        defaultPressureHPa,
+	}
+}
+
+// TestValidateConfig tests flag validation for the -check mode
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(*CheckConfig)
+		expected string
+	}{
+		{"Valid", func(c *CheckConfig) {}, ""},
+		{"Local mode needs no server", func(c *CheckConfig) { c.LocalOnly = true; c.ServerURL = ""; c.APIKey = "" }, ""},
+		{"Missing API key", func(c *CheckConfig) { c.APIKey = "" }, "-apikey"},
+		{"Bad server URL", func(c *CheckConfig) { c.ServerURL = "localhost:8080" }, "-server"},
+		{"Zero duration", func(c *CheckConfig) { c.Duration = 0 }, "-duration"},
+		{"Zero HTTP timeout", func(c *CheckConfig) { c.HTTPTimeout = 0 }, "-http-timeout"},
+		{"Bad rounding", func(c *CheckConfig) { c.RoundTemp = -2 }, "-round-temp"},
+		{"Pressure out of range", func(c *CheckConfig) { c.Pressure = 0 }, "-pressure"},
+		{"Negative spool size", func(c *CheckConfig) { c.SpoolMaxBytes = -1 }, "-spool-max-bytes"},
+		{"Negative send interval", func(c *CheckConfig) { c.MinSendInterval = -time.Second }, "-min-send-interval"},
+		{"Negative heartbeat interval", func(c *CheckConfig) { c.HeartbeatInterval = -time.Second }, "-heartbeat-interval"},
+		{"Zero shutdown timeout", func(c *CheckConfig) { c.ShutdownTimeout = 0 }, "-shutdown-timeout"},
+		{"Negative send retries", func(c *CheckConfig) { c.SendRetries = -1 }, "-send-retries"},
+		{"Zero retry base", func(c *CheckConfig) { c.RetryBase = 0 }, "-retry-base"},
+		{"Retry max below base", func(c *CheckConfig) { c.RetryMax = 500 * time.Millisecond }, "-retry-base"},
+		{"Bad BLE adapter", func(c *CheckConfig) { c.BLEAdapter = "bluetooth0" }, "-ble-adapter"},
+		{"CA cert with insecure", func(c *CheckConfig) { c.Insecure = true; c.CACertFile = "ca.pem" }, "-ca-cert"},
+		{"Client cert instead of API key", func(c *CheckConfig) {
+			c.APIKey = ""
+			c.ClientCertFile = "client.pem"
+			c.ClientKeyFile = "client-key.pem"
+		}, ""},
+		{"Client cert without key", func(c *CheckConfig) { c.ClientCertFile = "client.pem" }, "-client-key"},
+		{"Bad MQTT broker", func(c *CheckConfig) { c.MQTTBroker = "localhost" }, "-mqtt-broker"},
+		{"Valid MQTT broker", func(c *CheckConfig) { c.MQTTBroker = "tcp://localhost:1883" }, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validCheckConfig()
+			tt.modify(&cfg)
+			errs := validateConfig(cfg)
+
+			if tt.expected == "" {
+				if len(errs) != 0 {
+					t.Errorf("Expected no errors, got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.expected) {
+				t.Errorf("Expected one error mentioning %s, got %v", tt.expected, errs)
+			}
+		})
+	}
+}
+
+// TestCheckFiles tests loading of the CA certificate and calibration files
+func TestCheckFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	badCA := filepath.Join(tmpDir, "ca.pem")
+	os.WriteFile(badCA, []byte("not a certificate"), 0644)
+	calibration := filepath.Join(tmpDir, "calibration.json")
+	os.WriteFile(calibration, []byte(`{"A4:C1:38:25:A1:E3": {"temp_offset": -0.5}}`), 0644)
+
+	cfg := validCheckConfig()
+	cfg.CalibrationFile = calibration
+	cfg.SpoolDir = filepath.Join(tmpDir, "spool")
+	if errs := checkFiles(cfg); len(errs) != 0 {
+		t.Errorf("Expected no errors, got %v", errs)
+	}
+
+	cfg.CACertFile = badCA
+	cfg.CalibrationFile = filepath.Join(tmpDir, "missing.json")
+	if errs := checkFiles(cfg); len(errs) != 2 {
+		t.Errorf("Expected CA and calibration errors, got %v", errs)
+	}
+}
+
+// TestCheckServer tests the server connectivity and authentication check
+func TestCheckServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			w.Write([]byte(`{"status":"healthy"}`))
+		case "/devices":
+			if r.Header.Get("X-API-Key") != "good-key" {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`[]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Timeout: 2 * time.Second}
+
+	if err := checkServer(server.URL+"/readings", "good-key", client); err != nil {
+		t.Errorf("Expected check to pass, got %v", err)
+	}
+
+	err := checkServer(server.URL+"/readings", "bad-key", client)
+	if err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("Expected authentication failure, got %v", err)
+	}
+
+	// Unreachable server
+	addr := server.URL
+	server.Close()
+	if err := checkServer(addr+"/readings", "good-key", client); err == nil || !strings.Contains(err.Error(), "unreachable") {
+		t.Errorf("Expected unreachable server error, got %v", err)
+	}
+}
+
+// TestServerBaseURL tests deriving the server root from the readings endpoint
+func TestServerBaseURL(t *testing.T) {
+	tests := map[string]string{
+		"http://localhost:8080/readings":   "http://localhost:8080",
+		"https://example.com/readings/":    "https://example.com",
+		"https://example.com/api/readings": "https://example.com/api",
+		"http://localhost:8080":            "http://localhost:8080",
+	}
+	for input, expected := range tests {
+		if got := serverBaseURL(input); got != expected {
+			t.Errorf("serverBaseURL(%q) = %q, want %q", input, got, expected)
+		}
+	}
+}
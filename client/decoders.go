@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Decoder reads temperature, humidity and battery level from the advertisements of one
+// family of Govee sensors. Decoded values are uncalibrated and unrounded; offsets and
+// rounding are applied by the caller.
+type Decoder interface {
+	// Matches reports whether an advertisement comes from a sensor this decoder handles
+	Matches(name string, mfr []byte) bool
+	// Model returns the model of the sensor that sent an advertisement with this name, e.g. H5075
+	Model(name string) string
+	// Decode extracts the readings from the manufacturer data, rejecting unusable payloads
+	Decode(mfr []byte) (tempC, humidity float64, battery int, err error)
+}
+
+// decoders are tried in order and the first match wins; H5075 is the default
+var decoders = []Decoder{
+	h5075Decoder{},
+	h5074Decoder{},
+	h5101Decoder{},
+}
+
+// findDecoder returns the decoder for an advertisement, or nil if it isn't from a supported sensor
+func findDecoder(name string, mfr []byte) Decoder {
+	for _, d := range decoders {
+		if d.Matches(name, mfr) {
+			return d
+		}
+	}
+	return nil
+}
+
+// decodedValueKey packs decoded temperature and humidity into one int at 0.01 resolution,
+// for Scanner.HasValueChanged
+func decodedValueKey(tempC, humidity float64) int {
+	return int(math.Round(tempC*100))*100000 + int(math.Round(humidity*100))
+}
+
+// h5075Decoder handles the H5075, named GVH5075_xxxx, whose manufacturer data is
+// 88EC 00 <temp/humidity, 3 bytes big endian> <battery>
+type h5075Decoder struct{}
+
+func (h5075Decoder) Matches(name string, mfr []byte) bool {
+	return strings.HasPrefix(name, "GVH5075")
+}
+
+func (h5075Decoder) Model(string) string { return "H5075" }
+
+func (h5075Decoder) Decode(mfr []byte) (float64, float64, int, error) {
+	values, battery, err := parseH5075Data(mfr)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	tempC, humidity := decodeTempHumidity(values, 0, 0, -1, -1)
+	return tempC, humidity, battery, nil
+}
+
+// h5074Decoder handles the H5074, named Govee_H5074_xxxx, whose manufacturer data is
+// 88EC 00 <temp*100, int16 little endian> <humidity*100, uint16 little endian> <battery> ...
+type h5074Decoder struct{}
+
+func (h5074Decoder) Matches(name string, mfr []byte) bool {
+	return strings.HasPrefix(name, "Govee_H5074")
+}
+
+func (h5074Decoder) Model(string) string { return "H5074" }
+
+func (h5074Decoder) Decode(mfr []byte) (float64, float64, int, error) {
+	if len(mfr) < 8 {
+		return 0, 0, 0, fmt.Errorf("manufacturer data too short (%d bytes)", len(mfr))
+	}
+	if mfr[0] != 0x88 || mfr[1] != 0xEC {
+		return 0, 0, 0, fmt.Errorf("unexpected manufacturer data header %02x%02x", mfr[0], mfr[1])
+	}
+
+	tempC := float64(int16(binary.LittleEndian.Uint16(mfr[3:5]))) / 100
+	humidity := float64(binary.LittleEndian.Uint16(mfr[5:7])) / 100
+	battery := int(mfr[7])
+	if humidity > 100 {
+		return 0, 0, 0, fmt.Errorf("humidity out of range: %.2f", humidity)
+	}
+	if battery > 100 {
+		return 0, 0, 0, fmt.Errorf("battery level out of range: %d", battery)
+	}
+	return tempC, humidity, battery, nil
+}
+
+// h5101Decoder handles the H5101 and H5102, named GVH5101_xxxx and GVH5102_xxxx, whose
+// manufacturer data is 0100 0101 <temp/humidity, as for the H5075> <battery>
+type h5101Decoder struct{}
+
+func (h5101Decoder) Matches(name string, mfr []byte) bool {
+	return strings.HasPrefix(name, "GVH5101") || strings.HasPrefix(name, "GVH5102")
+}
+
+func (h5101Decoder) Model(name string) string {
+	if strings.HasPrefix(name, "GVH5102") {
+		return "H5102"
+	}
+	return "H5101"
+}
+
+func (h5101Decoder) Decode(mfr []byte) (float64, float64, int, error) {
+	if len(mfr) < 8 {
+		return 0, 0, 0, fmt.Errorf("manufacturer data too short (%d bytes)", len(mfr))
+	}
+	if mfr[0] != 0x01 || mfr[1] != 0x00 {
+		return 0, 0, 0, fmt.Errorf("unexpected manufacturer data header %02x%02x", mfr[0], mfr[1])
+	}
+
+	values, battery, err := parsePackedValues(mfr[4:8])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	tempC, humidity := decodeTempHumidity(values, 0, 0, -1, -1)
+	return tempC, humidity, battery, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/hex"
+	"math"
+	"testing"
+)
+
+// TestFindDecoder tests that advertisements are matched to a decoder and model by device name
+func TestFindDecoder(t *testing.T) {
+	tests := []struct {
+		name  string
+		want  Decoder
+		model string
+	}{
+		{"GVH5075_8F19", h5075Decoder{}, "H5075"},
+		{"Govee_H5074_1A2B", h5074Decoder{}, "H5074"},
+		{"GVH5101_C3D4", h5101Decoder{}, "H5101"},
+		{"GVH5102_E5F6", h5101Decoder{}, "H5102"},
+		{"ihoment_H6199_0A1B", nil, ""},
+		{"", nil, ""},
+	}
+
+	for _, tt := range tests {
+		got := findDecoder(tt.name, nil)
+		if got != tt.want {
+			t.Errorf("findDecoder(%q) = %T, expected %T", tt.name, got, tt.want)
+			continue
+		}
+		if got != nil && got.Model(tt.name) != tt.model {
+			t.Errorf("Model(%q) = %q, expected %q", tt.name, got.Model(tt.name), tt.model)
+		}
+	}
+}
+
+// TestDecoders tests decoding synthetic advertisements, hand-built from each
+// supported model's documented payload format
+func TestDecoders(t *testing.T) {
+	tests := []struct {
+		name             string
+		decoder          Decoder
+		data             string
+		wantErr          bool
+		expectedTemp     float64
+		expectedHumidity float64
+		expectedBattery  int
+	}{
+		{"H5075", h5075Decoder{}, "88ec00036d3764", false, 22.4, 56.7, 100},
+		{"H5075 below freezing", h5075Decoder{}, "88ec0080d12e5a", false, -5.3, 55.0, 90},
+		{"H5075 bad header", h5075Decoder{}, "12340003a55564", true, 0, 0, 0},
+		{"H5074", h5074Decoder{}, "88ec00dd07e1136402", false, 20.13, 50.89, 100},
+		{"H5074 below freezing", h5074Decoder{}, "88ec000cfe88135502", false, -5.0, 50.0, 85},
+		{"H5074 too short", h5074Decoder{}, "88ec00dd07e113", true, 0, 0, 0},
+		{"H5074 bad header", h5074Decoder{}, "0100dd07e1136402", true, 0, 0, 0},
+		{"H5074 humidity out of range", h5074Decoder{}, "88ec00dd07ffff6402", true, 0, 0, 0},
+		{"H5074 battery out of range", h5074Decoder{}, "88ec00dd07e113ff02", true, 0, 0, 0},
+		{"H5101", h5101Decoder{}, "0100010103165e64", false, 20.2, 33.4, 100},
+		{"H5101 below freezing", h5101Decoder{}, "0100010180d12e5a", false, -5.3, 55.0, 90},
+		{"H5101 too short", h5101Decoder{}, "0100010103165e", true, 0, 0, 0},
+		{"H5101 bad header", h5101Decoder{}, "88ec010103165e64", true, 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := hex.DecodeString(tt.data)
+			if err != nil {
+				t.Fatalf("invalid test data %q: %v", tt.data, err)
+			}
+
+			tempC, humidity, battery, err := tt.decoder.Decode(data)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Decode(%s) expected error, got %v°C %v%% %d%%", tt.data, tempC, humidity, battery)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode(%s) unexpected error: %v", tt.data, err)
+			}
+			if math.Abs(tempC-tt.expectedTemp) > 1e-9 {
+				t.Errorf("tempC = %v, expected %v", tempC, tt.expectedTemp)
+			}
+			if math.Abs(humidity-tt.expectedHumidity) > 1e-9 {
+				t.Errorf("humidity = %v, expected %v", humidity, tt.expectedHumidity)
+			}
+			if battery != tt.expectedBattery {
+				t.Errorf("battery = %d, expected %d", battery, tt.expectedBattery)
+			}
+		})
+	}
+}
+
+// TestDecodedValueKey tests that the change-detection key differs whenever a decoded value does
+func TestDecodedValueKey(t *testing.T) {
+	base := decodedValueKey(20.13, 50.89)
+	for _, other := range [][2]float64{{20.14, 50.89}, {20.13, 50.9}, {-20.13, 50.89}, {20.13, 100}} {
+		if decodedValueKey(other[0], other[1]) == base {
+			t.Errorf("Expected %v to change the key", other)
+		}
+	}
+	if decodedValueKey(20.13, 50.89) != base {
+		t.Error("Expected the same values to give the same key")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
@@ -12,19 +13,25 @@ import (
 	"io"
 	"log"
 	"math"
+	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/go-ble/ble"
 	"github.com/go-ble/ble/examples/lib/dev"
+	"github.com/go-ble/ble/linux/hci/cmd"
 	"github.com/pkg/errors"
 )
 
-// GoveeDevice represents a Govee H5075 device
+// GoveeDevice represents a Govee temperature/humidity sensor
 type GoveeDevice struct {
 	Address        string    `json:"address"`
 	Name           string    `json:"name"`
@@ -38,10 +45,16 @@ type GoveeDevice struct {
 	DewPointC      float64   `json:"dew_point_c"`
 	DewPointF      float64   `json:"dew_point_f"`
 	SteamPressure  float64   `json:"steam_pressure"`
+	HeatIndexC     float64   `json:"heat_index_c,omitempty"`
+	HeatIndexF     float64   `json:"heat_index_f,omitempty"`
+	VPD            float64   `json:"vpd"`
+	FrostPointC    float64   `json:"frost_point_c,omitempty"`
+	MixingRatio    float64   `json:"mixing_ratio,omitempty"`
 	Battery        int       `json:"battery"`
 	RawData        string    `json:"raw_data"`
 	LastUpdate     time.Time `json:"last_update"`
 	ClientID       string    `json:"client_id"`
+	Location       string    `json:"location,omitempty"`
 }
 
 // Reading represents a single measurement from a Govee device
@@ -57,23 +70,186 @@ type Reading struct {
 	DewPointC      float64   `json:"dew_point_c"`
 	DewPointF      float64   `json:"dew_point_f"`
 	SteamPressure  float64   `json:"steam_pressure"`
+	HeatIndexC     float64   `json:"heat_index_c,omitempty"`
+	HeatIndexF     float64   `json:"heat_index_f,omitempty"`
+	VPD            float64   `json:"vpd"`
+	FrostPointC    float64   `json:"frost_point_c,omitempty"`
+	MixingRatio    float64   `json:"mixing_ratio,omitempty"`
 	Battery        int       `json:"battery"`
 	RSSI           int       `json:"rssi"`
 	Timestamp      time.Time `json:"timestamp"`
 	ClientID       string    `json:"client_id"`
+	Location       string    `json:"location,omitempty"`
 }
 
-// Scanner tracks last seen values with thread-safety
+// Calibration holds the offset corrections for a single device
+type Calibration struct {
+	TempOffset     float64 `json:"temp_offset"`
+	HumidityOffset float64 `json:"humidity_offset"`
+}
+
+// loadCalibration reads a JSON file mapping device MAC addresses to calibration offsets
+func loadCalibration(path string) (map[string]Calibration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read calibration file: %v", err)
+	}
+
+	var raw map[string]Calibration
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse calibration file: %v", err)
+	}
+
+	// Normalize addresses so lookups are case-insensitive
+	calibrations := make(map[string]Calibration, len(raw))
+	for addr, cal := range raw {
+		calibrations[strings.ToLower(addr)] = cal
+	}
+	return calibrations, nil
+}
+
+// offsetsFor returns the calibration offsets for a device, falling back to the global offsets
+func offsetsFor(calibrations map[string]Calibration, addr string, tempOffset, humidityOffset float64) (float64, float64) {
+	if cal, ok := calibrations[strings.ToLower(addr)]; ok {
+		return cal.TempOffset, cal.HumidityOffset
+	}
+	return tempOffset, humidityOffset
+}
+
+// MACFilter limits which devices are processed: only those on the allowlist, if there is one,
+// and never those on the denylist
+type MACFilter struct {
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+// normalizeMAC lowercases a MAC address and drops its separators, so A4:C1:38:25:A1:E3,
+// a4-c1-38-25-a1-e3 and a4c13825a1e3 compare equal
+func normalizeMAC(addr string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(addr) {
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// parseMACList parses a comma-separated list of MAC addresses, or with a leading @ the name of
+// a file listing them one per line (blank lines and # comments are ignored)
+func parseMACList(value string) (map[string]bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+	entries := strings.Split(value, ",")
+	if path, isFile := strings.CutPrefix(value, "@"); isFile {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MAC list: %v", err)
+		}
+		entries = strings.Split(string(data), "\n")
+	}
+
+	macs := make(map[string]bool)
+	for _, entry := range entries {
+		entry, _, _ = strings.Cut(entry, "#")
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		mac := normalizeMAC(entry)
+		if len(mac) != 12 {
+			return nil, fmt.Errorf("invalid MAC address %q", entry)
+		}
+		macs[mac] = true
+	}
+	return macs, nil
+}
+
+// NewMACFilter builds a filter from -allow-macs and -deny-macs values
+func NewMACFilter(allowMACs, denyMACs string) (*MACFilter, error) {
+	allow, err := parseMACList(allowMACs)
+	if err != nil {
+		return nil, fmt.Errorf("-allow-macs: %v", err)
+	}
+	deny, err := parseMACList(denyMACs)
+	if err != nil {
+		return nil, fmt.Errorf("-deny-macs: %v", err)
+	}
+	return &MACFilter{allow: allow, deny: deny}, nil
+}
+
+// Allowed reports whether a device should be processed; the denylist wins over the allowlist
+func (f *MACFilter) Allowed(addr string) bool {
+	mac := normalizeMAC(addr)
+	if f.deny[mac] {
+		return false
+	}
+	return len(f.allow) == 0 || f.allow[mac]
+}
+
+// Scanner tracks last seen values and send times with thread-safety
 type Scanner struct {
 	lastValues map[string]int
-	mu         sync.Mutex
+	// Send throttling: each device is sent at most once per minSendInterval, and held
+	// marks devices whose latest changed value is waiting for the interval to pass
+	minSendInterval time.Duration
+	lastSent        map[string]time.Time
+	held            map[string]bool
+	mu              sync.Mutex
 }
 
 // NewScanner creates a new scanner
 func NewScanner() *Scanner {
 	return &Scanner{
 		lastValues: make(map[string]int),
+		lastSent:   make(map[string]time.Time),
+		held:       make(map[string]bool),
+	}
+}
+
+// SetMinSendInterval sets the shortest time between sends for one device (0 to send every change)
+func (sc *Scanner) SetMinSendInterval(interval time.Duration) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.minSendInterval = interval
+}
+
+// AllowSend reports whether a reading from a device may be sent at now, recording the send if
+// so. The first reading from a device is always allowed; after that, readings within the minimum
+// send interval are refused and the device is held until one is allowed.
+func (sc *Scanner) AllowSend(addr string, now time.Time) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if !sc.allowSendLocked(addr, now) {
+		sc.held[addr] = true
+		return false
 	}
+	return true
+}
+
+// AllowHeartbeat is AllowSend for re-sending an unchanged reading, which isn't held when refused
+func (sc *Scanner) AllowHeartbeat(addr string, now time.Time) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.allowSendLocked(addr, now)
+}
+
+// allowSendLocked applies the minimum send interval, recording the send if allowed
+func (sc *Scanner) allowSendLocked(addr string, now time.Time) bool {
+	if last, sent := sc.lastSent[addr]; sent && now.Sub(last) < sc.minSendInterval {
+		return false
+	}
+	sc.lastSent[addr] = now
+	delete(sc.held, addr)
+	return true
+}
+
+// Held reports whether a device has a reading that AllowSend refused and none sent since
+func (sc *Scanner) Held(addr string) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.held[addr]
 }
 
 // HasValueChanged checks if a value has changed for a device (thread-safe)
@@ -89,6 +265,274 @@ func (sc *Scanner) HasValueChanged(addr string, value int) bool {
 	return false
 }
 
+// scannerState is the -state-file format, letting change suppression survive restarts
+type scannerState struct {
+	LastValues map[string]int `json:"last_values"`
+}
+
+// SaveState writes the last seen values to path, replacing it atomically
+func (sc *Scanner) SaveState(path string) error {
+	sc.mu.Lock()
+	data, err := json.Marshal(scannerState{LastValues: sc.lastValues})
+	sc.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode scanner state: %v", err)
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write scanner state: %v", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace scanner state: %v", err)
+	}
+	return nil
+}
+
+// LoadState restores last seen values saved by SaveState. A missing file is not an error.
+func (sc *Scanner) LoadState(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to read scanner state: %v", err)
+	}
+
+	var state scannerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return 0, fmt.Errorf("failed to parse scanner state: %v", err)
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for addr, value := range state.LastValues {
+		sc.lastValues[addr] = value
+	}
+	return len(state.LastValues), nil
+}
+
+// DeviceRegistry stores discovered devices with thread-safety, since the BLE
+// scan callback may run on the ble library's goroutines
+type DeviceRegistry struct {
+	devices map[string]*GoveeDevice
+	printed map[string]bool
+	// When each device was last heard from, changed value or not
+	seen map[string]time.Time
+	mu   sync.Mutex
+}
+
+// NewDeviceRegistry creates an empty device registry
+func NewDeviceRegistry() *DeviceRegistry {
+	return &DeviceRegistry{
+		devices: make(map[string]*GoveeDevice),
+		printed: make(map[string]bool),
+		seen:    make(map[string]time.Time),
+	}
+}
+
+// MarkSeen records that a valid advertisement was received from a device
+func (dr *DeviceRegistry) MarkSeen(addr string, now time.Time) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	dr.seen[addr] = now
+}
+
+// SeenSince returns copies of the stored devices heard from at or after t
+func (dr *DeviceRegistry) SeenSince(t time.Time) []GoveeDevice {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	var devices []GoveeDevice
+	for addr, device := range dr.devices {
+		if seen, ok := dr.seen[addr]; ok && !seen.Before(t) {
+			devices = append(devices, *device)
+		}
+	}
+	return devices
+}
+
+// Store adds or replaces a device
+func (dr *DeviceRegistry) Store(device GoveeDevice) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	dr.devices[device.Address] = &device
+}
+
+// UpdateRSSI updates the signal strength of a known device, returning false if the device is unknown
+func (dr *DeviceRegistry) UpdateRSSI(addr string, rssi int) bool {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	device, exists := dr.devices[addr]
+	if exists {
+		device.RSSI = rssi
+	}
+	return exists
+}
+
+// MarkPrinted records that a device has been printed, returning true the first time only
+func (dr *DeviceRegistry) MarkPrinted(addr string) bool {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	if dr.printed[addr] {
+		return false
+	}
+	dr.printed[addr] = true
+	return true
+}
+
+// Snapshot returns copies of all devices
+func (dr *DeviceRegistry) Snapshot() []GoveeDevice {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	devices := make([]GoveeDevice, 0, len(dr.devices))
+	for _, device := range dr.devices {
+		devices = append(devices, *device)
+	}
+	return devices
+}
+
+// Len returns the number of known devices
+func (dr *DeviceRegistry) Len() int {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	return len(dr.devices)
+}
+
+// readingFromDevice builds the reading to send for a device's latest values
+func readingFromDevice(device GoveeDevice, timestamp time.Time) Reading {
+	return Reading{
+		DeviceName:     device.Name,
+		DeviceAddr:     device.Address,
+		TempC:          device.TempC,
+		TempF:          device.TempF,
+		TempOffset:     device.TempOffset,
+		Humidity:       device.Humidity,
+		HumidityOffset: device.HumidityOffset,
+		AbsHumidity:    device.AbsHumidity,
+		DewPointC:      device.DewPointC,
+		DewPointF:      device.DewPointF,
+		SteamPressure:  device.SteamPressure,
+		HeatIndexC:     device.HeatIndexC,
+		HeatIndexF:     device.HeatIndexF,
+		VPD:            device.VPD,
+		FrostPointC:    device.FrostPointC,
+		MixingRatio:    device.MixingRatio,
+		Battery:        device.Battery,
+		RSSI:           device.RSSI,
+		Timestamp:      timestamp,
+		ClientID:       device.ClientID,
+		Location:       device.Location,
+	}
+}
+
+// sendHeartbeats re-sends the latest reading of each device heard from since the previous
+// heartbeat, subject to the minimum send interval, so the server keeps seeing devices whose
+// values don't change. It returns the number of readings sent.
+func sendHeartbeats(devices *DeviceRegistry, scanner *Scanner, enqueue func(Reading), since, now time.Time) int {
+	sent := 0
+	for _, device := range devices.SeenSince(since) {
+		if scanner.AllowHeartbeat(device.Address, now) {
+			enqueue(readingFromDevice(device, now))
+			sent++
+		}
+	}
+	return sent
+}
+
+// runHeartbeats calls sendHeartbeats every interval until ctx is done
+func runHeartbeats(ctx context.Context, interval time.Duration, devices *DeviceRegistry, scanner *Scanner, enqueue func(Reading)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sendHeartbeats(devices, scanner, enqueue, last, now)
+			last = now
+		}
+	}
+}
+
+// maxScanErrors is how many scan failures in a row are taken to mean the adapter has been reset
+const maxScanErrors = 3
+
+// parseBLEAdapter returns the HCI device ID of an adapter given as "hci1" or "1"
+func parseBLEAdapter(name string) (int, error) {
+	id, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "hci"))
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("invalid BLE adapter %q, expected e.g. hci0", name)
+	}
+	return id, nil
+}
+
+// scanParams returns the library's default scan parameters, with the scan type set to passive
+// or active. Active scanning sends a scan request to each advertiser, which costs the sensor
+// battery and airtime; passive scanning only listens, so scan responses are never received.
+func scanParams(passive bool) cmd.LESetScanParameters {
+	scanType := uint8(0x01)
+	if passive {
+		scanType = 0x00
+	}
+	return cmd.LESetScanParameters{
+		LEScanType:           scanType,
+		LEScanInterval:       0x0004, // N * 0.625ms
+		LEScanWindow:         0x0004, // N * 0.625ms
+		OwnAddressType:       0x00,   // public
+		ScanningFilterPolicy: 0x00,   // accept all
+	}
+}
+
+// openBLEDevice opens the BLE adapter with the given HCI device ID, scanning passively if set
+func openBLEDevice(adapterID int, passive bool) (ble.Device, error) {
+	return dev.NewDevice("default", ble.OptDeviceID(adapterID), ble.OptScanParams(scanParams(passive)))
+}
+
+// retryWithBackoff calls fn until it succeeds, waiting initial after the first failure and
+// doubling the wait each time up to max. It gives up with ctx's error once ctx is done.
+func retryWithBackoff(ctx context.Context, initial, max time.Duration, fn func() error) error {
+	wait := initial
+	for {
+		if err := fn(); err == nil {
+			return nil
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		wait *= 2
+		if wait > max {
+			wait = max
+		}
+	}
+}
+
+// reopenBLEDevice replaces the default BLE device with a freshly opened one, retrying with
+// backoff until the adapter comes back or ctx is done
+func reopenBLEDevice(ctx context.Context, adapterID int, passive bool) error {
+	// The old device is most likely dead already, so a failure to stop it doesn't matter
+	ble.Stop()
+
+	attempt := 0
+	return retryWithBackoff(ctx, time.Second, time.Minute, func() error {
+		attempt++
+		d, err := openBLEDevice(adapterID, passive)
+		if err != nil {
+			log.Printf("Failed to reopen BLE adapter hci%d (attempt %d): %v", adapterID, attempt, err)
+			return err
+		}
+		ble.SetDefaultDevice(d)
+		return nil
+	})
+}
+
 // SendQueue manages worker pool for sending readings to server
 type SendQueue struct {
 	queue      chan Reading
@@ -96,25 +540,179 @@ type SendQueue struct {
 	serverURL  string
 	apiKey     string
 	httpClient *http.Client
+	// Gzip request bodies (-compress-requests)
+	compress bool
+	// Retry policy, see SetRetryPolicy
+	retries   int
+	retryBase time.Duration
+	retryMax  time.Duration
+	// Optional disk spool for readings that can't be queued or delivered
+	spool           *Spool
+	spoolDone       chan struct{}
+	spoolWg         sync.WaitGroup
+	serverReachable atomic.Bool
+	// Close guards: closeMu keeps Enqueue from sending while the queue is being closed
+	closeOnce sync.Once
+	closeMu   sync.RWMutex
+	closed    atomic.Bool
+	// Closed when Shutdown times out, so workers stop retrying and spool what's left
+	abandon     chan struct{}
+	abandonOnce sync.Once
+	// Counters for Stats
+	enqueued atomic.Uint64
+	sent     atomic.Uint64
+	spooled  atomic.Uint64
+	dropped  atomic.Uint64
+	retried  atomic.Uint64
 }
 
-// NewSendQueue creates a new send queue with worker pool and reusable HTTP client
-func NewSendQueue(workers int, serverURL, apiKey string, insecure bool, caCertFile string, httpTimeout time.Duration) *SendQueue {
-	// Build TLS config once and reuse
+// SendQueueStats counts readings through the send queue since it was created. Readings
+// spooled to disk are counted as spooled, not dropped.
+type SendQueueStats struct {
+	Enqueued uint64 `json:"enqueued"`
+	Sent     uint64 `json:"sent"`
+	Spooled  uint64 `json:"spooled"`
+	Dropped  uint64 `json:"dropped"`
+	Retried  uint64 `json:"retried"`
+}
+
+// spoolDrainInterval is how often spooled readings are fed back into the queue
+const spoolDrainInterval = 5 * time.Second
+
+// Spool is an append-only NDJSON file holding readings that could not be queued or delivered
+type Spool struct {
+	path     string
+	maxBytes int64
+	mu       sync.Mutex
+}
+
+// NewSpool creates a spool file in dir, bounded to maxBytes (0 for unlimited)
+func NewSpool(dir string, maxBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %v", err)
+	}
+	return &Spool{
+		path:     filepath.Join(dir, "spool.ndjson"),
+		maxBytes: maxBytes,
+	}, nil
+}
+
+// Append writes a reading to the end of the spool, refusing once the size limit is reached
+func (sp *Spool) Append(reading Reading) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	line, err := json.Marshal(reading)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %v", err)
+	}
+	line = append(line, '\n')
+
+	if sp.maxBytes > 0 {
+		if info, err := os.Stat(sp.path); err == nil && info.Size()+int64(len(line)) > sp.maxBytes {
+			return fmt.Errorf("spool full (%d bytes)", sp.maxBytes)
+		}
+	}
+
+	f, err := os.OpenFile(sp.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open spool file: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(line); err != nil {
+		return fmt.Errorf("failed to write spool file: %v", err)
+	}
+	return nil
+}
+
+// Take removes and returns up to n readings from the front of the spool
+func (sp *Spool) Take(n int) ([]Reading, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	data, err := os.ReadFile(sp.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read spool file: %v", err)
+	}
+
+	lines := bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n"))
+	if len(data) == 0 {
+		lines = nil
+	}
+
+	var readings []Reading
+	consumed := 0
+	for consumed < len(lines) && len(readings) < n {
+		var r Reading
+		if err := json.Unmarshal(lines[consumed], &r); err != nil {
+			log.Printf("Skipping corrupt spool entry: %v", err)
+		} else {
+			readings = append(readings, r)
+		}
+		consumed++
+	}
+
+	// Rewrite the remainder, or remove the file once it's empty
+	rest := lines[consumed:]
+	if len(rest) == 0 {
+		if err := os.Remove(sp.path); err != nil && !os.IsNotExist(err) {
+			return readings, fmt.Errorf("failed to remove spool file: %v", err)
+		}
+		return readings, nil
+	}
+
+	tmpPath := sp.path + ".tmp"
+	remaining := append(bytes.Join(rest, []byte("\n")), '\n')
+	if err := os.WriteFile(tmpPath, remaining, 0644); err != nil {
+		return readings, fmt.Errorf("failed to rewrite spool file: %v", err)
+	}
+	if err := os.Rename(tmpPath, sp.path); err != nil {
+		return readings, fmt.Errorf("failed to replace spool file: %v", err)
+	}
+	return readings, nil
+}
+
+// newTLSConfig builds the TLS settings for talking to the server. If clientCertFile and
+// clientKeyFile are set, the client presents that certificate to servers that require one.
+func newTLSConfig(insecure bool, caCertFile, clientCertFile, clientKeyFile string) (*tls.Config, error) {
 	tlsConfig := &tls.Config{}
+	if (clientCertFile == "") != (clientKeyFile == "") {
+		return nil, fmt.Errorf("a client certificate needs both -client-cert and -client-key")
+	}
+	if clientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
 	if insecure {
 		tlsConfig.InsecureSkipVerify = true
 	} else if caCertFile != "" {
 		caCert, err := os.ReadFile(caCertFile)
 		if err != nil {
-			log.Fatalf("Error loading CA certificate: %v", err)
+			return nil, fmt.Errorf("error loading CA certificate: %v", err)
 		}
 		caCertPool := x509.NewCertPool()
 		if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
-			log.Fatalf("Failed to append CA certificate")
+			return nil, fmt.Errorf("failed to append CA certificate")
 		}
 		tlsConfig.RootCAs = caCertPool
 	}
+	return tlsConfig, nil
+}
+
+// NewSendQueue creates a new send queue with worker pool and reusable HTTP client
+func NewSendQueue(workers int, serverURL, apiKey string, insecure bool, caCertFile, clientCertFile, clientKeyFile string, httpTimeout time.Duration) *SendQueue {
+	// Build TLS config once and reuse
+	tlsConfig, err := newTLSConfig(insecure, caCertFile, clientCertFile, clientKeyFile)
+	if err != nil {
+		log.Fatalf("Failed to configure TLS: %v", err)
+	}
 
 	transport := &http.Transport{
 		TLSClientConfig: tlsConfig,
@@ -124,6 +722,10 @@ func NewSendQueue(workers int, serverURL, apiKey string, insecure bool, caCertFi
 
 	sq := &SendQueue{
 		queue:     make(chan Reading, 100),
+		abandon:   make(chan struct{}),
+		retries:   defaultSendRetries,
+		retryBase: defaultRetryBase,
+		retryMax:  defaultRetryMax,
 		serverURL: serverURL,
 		apiKey:    apiKey,
 		httpClient: &http.Client{
@@ -132,6 +734,8 @@ func NewSendQueue(workers int, serverURL, apiKey string, insecure bool, caCertFi
 		},
 	}
 
+	sq.serverReachable.Store(true)
+
 	// Start worker goroutines
 	for i := 0; i < workers; i++ {
 		sq.wg.Add(1)
@@ -141,19 +745,199 @@ func NewSendQueue(workers int, serverURL, apiKey string, insecure bool, caCertFi
 	return sq
 }
 
-// Enqueue adds a reading to the send queue
+// Send retry defaults, overridden by -send-retries, -retry-base and -retry-max
+const (
+	defaultSendRetries = 2
+	defaultRetryBase   = time.Second
+	defaultRetryMax    = 30 * time.Second
+)
+
+// SetRetryPolicy sets how many times a failed send is retried and the backoff between
+// retries. Must be called before the first Enqueue.
+func (sq *SendQueue) SetRetryPolicy(retries int, base, max time.Duration) {
+	sq.retries = retries
+	sq.retryBase = base
+	sq.retryMax = max
+}
+
+// backoffDelay returns how long to wait before a retry (0 for the first) with full jitter: a
+// random duration up to base doubled for each earlier retry, capped at max. The randomness
+// keeps clients that failed together, e.g. when the server restarted, from retrying in lockstep.
+func backoffDelay(base, max time.Duration, retry int) time.Duration {
+	ceiling := base
+	for i := 0; i < retry && ceiling < max; i++ {
+		ceiling *= 2
+	}
+	if ceiling > max {
+		ceiling = max
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// SetCompress turns gzip compression of request bodies on or off. Must be called before the
+// first Enqueue.
+func (sq *SendQueue) SetCompress(compress bool) {
+	sq.compress = compress
+}
+
+// AttachSpool enables overflow to a disk spool and starts replaying any
+// leftover readings into the queue. Must be called before the first Enqueue.
+func (sq *SendQueue) AttachSpool(spool *Spool) {
+	sq.spool = spool
+	sq.spoolDone = make(chan struct{})
+	sq.spoolWg.Add(1)
+	go sq.drainSpool()
+}
+
+// Enqueue adds a reading to the send queue, spooling it to disk if the queue is full
 func (sq *SendQueue) Enqueue(reading Reading) {
+	sq.closeMu.RLock()
+	defer sq.closeMu.RUnlock()
+
+	if sq.closed.Load() {
+		sq.dropped.Add(1)
+		log.Printf("Send queue closed, dropping reading for device %s", reading.DeviceAddr)
+		return
+	}
+
 	select {
 	case sq.queue <- reading:
+		sq.enqueued.Add(1)
 	default:
-		log.Printf("Send queue full, dropping reading for device %s", reading.DeviceAddr)
+		sq.spoolOrDrop(reading, "Send queue full")
+	}
+}
+
+// Depth returns the number of readings waiting to be sent
+func (sq *SendQueue) Depth() int {
+	return len(sq.queue)
+}
+
+// Stats returns a snapshot of the send queue's counters
+func (sq *SendQueue) Stats() SendQueueStats {
+	return SendQueueStats{
+		Enqueued: sq.enqueued.Load(),
+		Sent:     sq.sent.Load(),
+		Spooled:  sq.spooled.Load(),
+		Dropped:  sq.dropped.Load(),
+		Retried:  sq.retried.Load(),
 	}
 }
 
-// Close stops the send queue
+// spoolOrDrop persists a reading to the spool if configured, otherwise drops it
+func (sq *SendQueue) spoolOrDrop(reading Reading, reason string) {
+	if sq.spool != nil {
+		err := sq.spool.Append(reading)
+		if err == nil {
+			sq.spooled.Add(1)
+			return
+		}
+		log.Printf("Failed to spool reading for device %s: %v", reading.DeviceAddr, err)
+	}
+	sq.dropped.Add(1)
+	log.Printf("%s, dropping reading for device %s", reason, reading.DeviceAddr)
+}
+
+// drainSpool periodically moves spooled readings back into the queue
+func (sq *SendQueue) drainSpool() {
+	defer sq.spoolWg.Done()
+
+	ticker := time.NewTicker(spoolDrainInterval)
+	defer ticker.Stop()
+
+	for {
+		sq.refillFromSpool()
+
+		select {
+		case <-ticker.C:
+		case <-sq.spoolDone:
+			return
+		}
+	}
+}
+
+// refillFromSpool feeds spooled readings into free queue slots while the server is reachable
+func (sq *SendQueue) refillFromSpool() {
+	if !sq.serverReachable.Load() {
+		return
+	}
+
+	free := cap(sq.queue) - len(sq.queue)
+	if free <= 0 {
+		return
+	}
+
+	readings, err := sq.spool.Take(free)
+	if err != nil {
+		log.Printf("Failed to read spool: %v", err)
+	}
+	if len(readings) > 0 {
+		log.Printf("Replaying %d spooled readings", len(readings))
+	}
+	for _, reading := range readings {
+		select {
+		case sq.queue <- reading:
+		default:
+			// Queue filled up again in the meantime, put it back
+			sq.spoolOrDrop(reading, "Send queue full")
+		}
+	}
+}
+
+// Close stops the send queue, waiting for every queued reading to be delivered or given up
+// on; it is safe to call more than once
 func (sq *SendQueue) Close() {
-	close(sq.queue)
-	sq.wg.Wait()
+	sq.Shutdown(0)
+}
+
+// Shutdown stops the send queue and waits up to timeout (0 for no limit) for queued readings
+// to be delivered. Readings still queued or being retried when the timeout passes are spooled
+// if a spool is attached, or dropped. It returns how many readings were sent, spooled and
+// dropped while shutting down; later calls return zeros.
+func (sq *SendQueue) Shutdown(timeout time.Duration) (sent, spooled, dropped uint64) {
+	before := sq.Stats()
+	sq.closeOnce.Do(func() {
+		if sq.spool != nil {
+			close(sq.spoolDone)
+			sq.spoolWg.Wait()
+		}
+
+		sq.closeMu.Lock()
+		sq.closed.Store(true)
+		close(sq.queue)
+		sq.closeMu.Unlock()
+
+		done := make(chan struct{})
+		go func() {
+			sq.wg.Wait()
+			close(done)
+		}()
+		if timeout > 0 {
+			select {
+			case <-done:
+			case <-time.After(timeout):
+				log.Printf("Send queue not flushed after %s, giving up on %d queued readings", timeout, len(sq.queue))
+				sq.abandonOnce.Do(func() { close(sq.abandon) })
+			}
+		}
+		// Requests in progress finish within the HTTP timeout
+		<-done
+	})
+	after := sq.Stats()
+	return after.Sent - before.Sent, after.Spooled - before.Spooled, after.Dropped - before.Dropped
+}
+
+// abandoned reports whether Shutdown has timed out
+func (sq *SendQueue) abandoned() bool {
+	select {
+	case <-sq.abandon:
+		return true
+	default:
+		return false
+	}
 }
 
 // worker processes readings from the queue
@@ -161,39 +945,81 @@ func (sq *SendQueue) worker() {
 	defer sq.wg.Done()
 
 	for reading := range sq.queue {
-		// Retry logic with exponential backoff
-		maxRetries := 3
-		backoff := time.Second
+		if sq.abandoned() {
+			sq.spoolOrDrop(reading, "Shutdown timed out")
+			continue
+		}
 
-		for attempt := 0; attempt < maxRetries; attempt++ {
+		// Retry logic with exponential backoff and jitter
+		maxAttempts := sq.retries + 1
+
+		for attempt := 0; attempt < maxAttempts; attempt++ {
 			err := sq.sendReading(reading)
 			if err == nil {
+				sq.sent.Add(1)
+				sq.serverReachable.Store(true)
 				break
 			}
 
-			if attempt < maxRetries-1 {
-				log.Printf("Failed to send reading (attempt %d/%d): %v. Retrying in %v...", attempt+1, maxRetries, err, backoff)
-				time.Sleep(backoff)
-				backoff *= 2
+			if attempt < maxAttempts-1 {
+				sq.retried.Add(1)
+				// Honour the server's Retry-After instead of guessing when rate limited
+				wait := backoffDelay(sq.retryBase, sq.retryMax, attempt)
+				var rle *rateLimitedError
+				if errors.As(err, &rle) && rle.retryAfter > 0 {
+					wait = rle.retryAfter
+				}
+				log.Printf("Failed to send reading (attempt %d/%d): %v. Retrying in %v...", attempt+1, maxAttempts, err, wait.Round(time.Millisecond))
+				select {
+				case <-time.After(wait):
+				case <-sq.abandon:
+				}
+				if sq.abandoned() {
+					sq.spoolOrDrop(reading, "Shutdown timed out")
+					break
+				}
 			} else {
-				log.Printf("Failed to send reading after %d attempts: %v", maxRetries, err)
+				log.Printf("Failed to send reading after %d attempts: %v", maxAttempts, err)
+				sq.serverReachable.Store(false)
+				sq.spoolOrDrop(reading, "Server unreachable")
 			}
 		}
 	}
 }
 
+// gzipBytes compresses data with gzip
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 // sendReading sends a single reading using the shared HTTP client
 func (sq *SendQueue) sendReading(reading Reading) error {
 	jsonData, err := json.Marshal(reading)
 	if err != nil {
 		return fmt.Errorf("error marshaling JSON: %v", err)
 	}
+	if sq.compress {
+		if jsonData, err = gzipBytes(jsonData); err != nil {
+			return fmt.Errorf("error compressing JSON: %v", err)
+		}
+	}
 
 	req, err := http.NewRequest("POST", sq.serverURL, bytes.NewReader(jsonData))
 	if err != nil {
 		return fmt.Errorf("error creating HTTP request: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if sq.compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
 	if sq.apiKey != "" {
 		req.Header.Set("X-API-Key", sq.apiKey)
 	}
@@ -208,6 +1034,8 @@ func (sq *SendQueue) sendReading(reading Reading) error {
 
 	if resp.StatusCode == http.StatusUnauthorized {
 		return fmt.Errorf("authentication failed: Invalid API key")
+	} else if resp.StatusCode == http.StatusTooManyRequests {
+		return &rateLimitedError{retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
 	} else if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
 		return fmt.Errorf("server responded with status %d", resp.StatusCode)
 	}
@@ -215,11 +1043,30 @@ func (sq *SendQueue) sendReading(reading Reading) error {
 	return nil
 }
 
+// rateLimitedError is returned when the server responds with 429 Too Many Requests
+type rateLimitedError struct {
+	retryAfter time.Duration
+}
+
+func (e *rateLimitedError) Error() string {
+	return fmt.Sprintf("rate limited by server (retry after %v)", e.retryAfter)
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds, returning 0 if absent or invalid
+func parseRetryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
 func main() {
 	// Parse command line arguments
 	duration := flag.Duration("duration", 30*time.Second, "scanning duration for each cycle")
 	serverURL := flag.String("server", "http://localhost:8080/readings", "URL of the server API endpoint")
 	clientID := flag.String("id", getDefaultClientID(), "unique ID for this client")
+	location := flag.String("location", "", "where this client's sensors are (e.g., Kitchen), sent with each reading")
 	apiKey := flag.String("apikey", "", "API key for server authentication")
 	continuous := flag.Bool("continuous", false, "continuous scanning")
 	runTime := flag.Duration("runtime", 0, "total running time (0 for unlimited)")
@@ -229,16 +1076,86 @@ func main() {
 	discoveryMode := flag.Bool("discover", false, "discovery mode - only scan for devices and print a list")
 	singleReading := flag.Bool("single", false, "display only a single reading per device during scan")
 	deviceFilter := flag.String("device", "", "filter readings by device name (e.g., GVH5075_8F19)")
+	allowMACs := flag.String("allow-macs", "", "only process these devices: comma-separated MAC addresses, or @file with one per line (empty for all)")
+	denyMACs := flag.String("deny-macs", "", "never process these devices, even if allowed: comma-separated MAC addresses, or @file with one per line")
+	passive := flag.Bool("passive", false, "scan passively, without sending scan requests, to save sensor battery and airtime")
+	bleAdapter := flag.String("ble-adapter", "hci0", "BLE adapter to scan with (e.g., hci1)")
+	compressRequests := flag.Bool("compress-requests", false, "gzip request bodies sent to the server, to save bandwidth on metered links")
+	statusAddr := flag.String("status-addr", "", "address for a local HTTP status server with /status and /healthz (e.g., localhost:8081; empty to disable)")
+	minRSSI := flag.Int("min-rssi", -127, "ignore advertisements weaker than this RSSI in dBm (the default, -127, ignores none)")
 	tempOffset := flag.Float64("temp-offset", 0.0, "temperature offset calibration (°C)")
 	humidityOffset := flag.Float64("humidity-offset", 0.0, "humidity offset calibration (%)")
+	calibrationFile := flag.String("calibration", "", "JSON file mapping device MAC addresses to {temp_offset, humidity_offset}")
+	roundTemp := flag.Int("round-temp", 1, "decimal places to round temperature to (-1 to disable)")
+	roundHumidity := flag.Int("round-humidity", 1, "decimal places to round humidity to (-1 to disable)")
+	pressure := flag.Float64("pressure", defaultPressureHPa, "station air pressure in hPa, for the mixing ratio")
 	// HTTPS flags
 	insecureSkipVerify := flag.Bool("insecure-skip-tls-verify-dangerous", false, "DANGEROUS: skip TLS certificate verification (vulnerable to MITM attacks)")
 	caCertFile := flag.String("ca-cert", "", "path to CA certificate file for TLS verification")
+	clientCertFile := flag.String("client-cert", "", "path to a client certificate to present to servers that require one (its CN is the client ID)")
+	clientKeyFile := flag.String("client-key", "", "path to the private key for -client-cert")
 	httpTimeout := flag.Duration("http-timeout", 10*time.Second, "HTTP request timeout")
+	// Spool flags
+	spoolDir := flag.String("spool-dir", "", "directory for spooling readings that can't be sent (empty to disable)")
+	spoolMaxBytes := flag.Int64("spool-max-bytes", 10<<20, "maximum size of the spool file in bytes (0 for unlimited)")
+	// MQTT flags
+	mqttBroker := flag.String("mqtt-broker", "", "MQTT broker URL to publish readings to (e.g., tcp://localhost:1883; empty to disable)")
+	mqttTopicPrefix := flag.String("mqtt-topic-prefix", "govee", "MQTT topic prefix; readings go to <prefix>/<mac>/state")
+	mqttDiscoveryPrefix := flag.String("mqtt-discovery-prefix", "homeassistant", "Home Assistant MQTT discovery prefix")
+	// Restart flags
+	sendRetries := flag.Int("send-retries", defaultSendRetries, "how many times to retry a failed send before spooling or dropping the reading")
+	retryBase := flag.Duration("retry-base", defaultRetryBase, "backoff before the first retry of a failed send, doubling for each retry up to -retry-max; each wait is randomized between 0 and the backoff")
+	retryMax := flag.Duration("retry-max", defaultRetryMax, "longest backoff between retries of a failed send")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "how long to wait on exit for queued readings to be sent before spooling or dropping them")
+	heartbeatInterval := flag.Duration("heartbeat-interval", 0, "re-send each device's latest reading this often even if unchanged, so the server knows a quiet sensor is alive (0 to disable)")
+	minSendInterval := flag.Duration("min-send-interval", 0, "send at most one reading per device in this interval, even if its value changes more often (0 to send every change)")
+	stateFile := flag.String("state-file", "", "file to save last seen values to on exit and restore on startup, so unchanged readings aren't resent after a restart (empty to disable)")
+
+	// Dry-run flag
+	checkMode := flag.Bool("check", false, "validate configuration, BLE access and server connectivity, print a report and exit")
 	flag.Parse()
 
+	// Dry run: check everything without scanning
+	if *checkMode {
+		passed := runChecks(CheckConfig{
+			ServerURL:         *serverURL,
+			APIKey:            *apiKey,
+			LocalOnly:         *localOnly,
+			Insecure:          *insecureSkipVerify,
+			CACertFile:        *caCertFile,
+			ClientCertFile:    *clientCertFile,
+			ClientKeyFile:     *clientKeyFile,
+			CalibrationFile:   *calibrationFile,
+			AllowMACs:         *allowMACs,
+			DenyMACs:          *denyMACs,
+			SpoolDir:          *spoolDir,
+			SpoolMaxBytes:     *spoolMaxBytes,
+			MinSendInterval:   *minSendInterval,
+			HeartbeatInterval: *heartbeatInterval,
+			ShutdownTimeout:   *shutdownTimeout,
+			SendRetries:       *sendRetries,
+			RetryBase:         *retryBase,
+			RetryMax:          *retryMax,
+			BLEAdapter:        *bleAdapter,
+			MQTTBroker:        *mqttBroker,
+			Duration:          *duration,
+			HTTPTimeout:       *httpTimeout,
+			RoundTemp:         *roundTemp,
+			RoundHumidity:     *roundHumidity,
+			Pressure:          *pressure,
+		})
+		if !passed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := validatePressure(*pressure); err != nil {
+		log.Fatalf("%v", err)
+	}
+
 	// Check if API key is provided when not in local mode
-	if !*localOnly && !*discoveryMode && *apiKey == "" {
+	if !*localOnly && !*discoveryMode && *apiKey == "" && *clientCertFile == "" {
 		log.Println("Warning: No API key provided. Server communications may fail. Use -apikey flag to provide one or use -local=true for local mode.")
 	}
 
@@ -263,39 +1180,128 @@ func main() {
 		log.Printf("Logging data to %s", *logFile)
 	}
 
+	// Load per-device calibration offsets if provided
+	var calibrations map[string]Calibration
+	if *calibrationFile != "" {
+		calibrations, err = loadCalibration(*calibrationFile)
+		if err != nil {
+			log.Fatalf("Failed to load calibration: %v", err)
+		}
+		log.Printf("Loaded calibration for %d devices from %s", len(calibrations), *calibrationFile)
+	}
+
+	// Devices to ignore, e.g. the neighbours'
+	macFilter, err := NewMACFilter(*allowMACs, *denyMACs)
+	if err != nil {
+		log.Fatalf("Invalid device filter: %v", err)
+	}
+
 	// Initialize BLE device
-	d, err := dev.NewDevice("default")
+	adapterID, err := parseBLEAdapter(*bleAdapter)
+	if err != nil {
+		log.Fatalf("Invalid -ble-adapter: %v", err)
+	}
+	d, err := openBLEDevice(adapterID, *passive)
 	if err != nil {
 		log.Fatalf("Failed to open device: %v", err)
 	}
 	ble.SetDefaultDevice(d)
+	if *verbose {
+		if *passive {
+			fmt.Println("Using passive scanning: scan responses won't be requested")
+		} else {
+			fmt.Println("Using active scanning")
+		}
+	}
 
-	// Handle Ctrl-C
+	// Handle Ctrl-C, and SIGTERM from service managers and container runtimes
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go func() {
 		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt)
-		<-c
-		fmt.Println("\nReceived interrupt signal. Shutting down gracefully...")
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		sig := <-c
+		fmt.Printf("\nReceived %v signal. Shutting down gracefully...\n", sig)
 		cancel()
 	}()
 
 	// Create thread-safe scanner
 	scanner := NewScanner()
+	scanner.SetMinSendInterval(*minSendInterval)
+
+	// Carry change suppression over from the previous run. log.Fatal skips deferred calls,
+	// so fatal errors from here on save the state first.
+	saveState := func() {}
+	if *stateFile != "" && !*discoveryMode {
+		count, err := scanner.LoadState(*stateFile)
+		if err != nil {
+			log.Printf("Ignoring scanner state: %v", err)
+		} else if count > 0 {
+			log.Printf("Restored last values for %d devices from %s", count, *stateFile)
+		}
+		saveState = func() {
+			if err := scanner.SaveState(*stateFile); err != nil {
+				log.Printf("Failed to save scanner state: %v", err)
+			}
+		}
+		defer saveState()
+	}
 
 	// Create send queue with worker pool (5 concurrent senders)
 	var sendQueue *SendQueue
 	if !*localOnly {
-		sendQueue = NewSendQueue(5, *serverURL, *apiKey, *insecureSkipVerify, *caCertFile, *httpTimeout)
-		defer sendQueue.Close()
+		sendQueue = NewSendQueue(5, *serverURL, *apiKey, *insecureSkipVerify, *caCertFile, *clientCertFile, *clientKeyFile, *httpTimeout)
+		sendQueue.SetCompress(*compressRequests)
+		sendQueue.SetRetryPolicy(*sendRetries, *retryBase, *retryMax)
+		if *spoolDir != "" {
+			spool, err := NewSpool(*spoolDir, *spoolMaxBytes)
+			if err != nil {
+				saveState()
+				log.Fatalf("Failed to initialize spool: %v", err)
+			}
+			sendQueue.AttachSpool(spool)
+			log.Printf("Spooling undeliverable readings to %s", *spoolDir)
+		}
+		// Runs after scanning has stopped, so the last scan's readings are flushed too
+		defer func() {
+			sent, spooled, dropped := sendQueue.Shutdown(*shutdownTimeout)
+			log.Printf("Send queue flushed: %d readings sent, %d spooled, %d dropped", sent, spooled, dropped)
+		}()
 	}
 
-	// Map to store discovered devices
-	devices := make(map[string]*GoveeDevice)
+	// Publish to MQTT alongside (or, with -local, instead of) the server
+	var mqttPublisher *MQTTPublisher
+	if *mqttBroker != "" && !*discoveryMode {
+		mqttPublisher = NewMQTTPublisher(*mqttBroker, *clientID, *mqttTopicPrefix, *mqttDiscoveryPrefix)
+		defer mqttPublisher.Close()
+		log.Printf("Publishing readings to MQTT broker %s under %s/", *mqttBroker, *mqttTopicPrefix)
+	}
 
-	// Track devices already printed (for -single mode)
-	printedDevices := make(map[string]bool)
+	// Discovered devices, also tracking which were already printed (for -single mode)
+	devices := NewDeviceRegistry()
+
+	// Local status server for debugging, stopped on interrupt or exit
+	if *statusAddr != "" && !*discoveryMode {
+		statusServer := NewStatusServer(*statusAddr, *clientID, devices, sendQueue)
+		if err := statusServer.Start(); err != nil {
+			saveState()
+			log.Fatalf("Failed to start status server: %v", err)
+		}
+		defer statusServer.Shutdown()
+		go func() {
+			<-ctx.Done()
+			statusServer.Shutdown()
+		}()
+		log.Printf("Status server listening on %s", *statusAddr)
+	}
+
+	// Keep quiet devices alive on the server; stopped before the send queue is closed
+	if *heartbeatInterval > 0 && sendQueue != nil && !*discoveryMode {
+		heartbeatCtx, stopHeartbeats := context.WithCancel(ctx)
+		defer stopHeartbeats()
+		go runHeartbeats(heartbeatCtx, *heartbeatInterval, devices, scanner, sendQueue.Enqueue)
+		log.Printf("Sending heartbeats every %s", *heartbeatInterval)
+	}
 
 	// Calculate end time if runtime is specified
 	var endTime time.Time
@@ -306,12 +1312,13 @@ func main() {
 
 	// Start scanning
 	if *discoveryMode {
-		fmt.Printf("Govee Client %s: Discovery mode - scanning for Govee H5075 devices for %s...\n", *clientID, duration.String())
+		fmt.Printf("Govee Client %s: Discovery mode - scanning for Govee H5075/H5074/H5101 devices for %s...\n", *clientID, duration.String())
 	} else {
-		fmt.Printf("Govee Client %s: Scanning for Govee H5075 devices...\n", *clientID)
+		fmt.Printf("Govee Client %s: Scanning for Govee H5075/H5074/H5101 devices...\n", *clientID)
 	}
 
 	scanCount := 0
+	scanErrors := 0
 	startTime := time.Now()
 
 	for {
@@ -335,174 +1342,179 @@ func main() {
 			name := a.LocalName()
 			rssi := a.RSSI()
 
-			// Check if this might be a Govee device by name
-			isGoveeDevice := strings.HasPrefix(name, "GVH5075")
-
-			// Apply device filter if specified
+			// Apply device filters if specified
 			if *deviceFilter != "" && name != *deviceFilter {
 				return
 			}
+			if !macFilter.Allowed(addr) {
+				return
+			}
 
 			// Get the manufacturer data
 			mfrData := a.ManufacturerData()
 			mfrDataHex := hex.EncodeToString(mfrData)
 
-			// Process Govee data if found
-			if isGoveeDevice && len(mfrData) > 6 {
-				// Check if this is a valid Govee format (starting with 88EC)
-				if len(mfrData) >= 2 && mfrData[0] == 0x88 && mfrData[1] == 0xEC {
-					// In discovery mode, just record the device without processing values
-					if *discoveryMode {
-						if _, exists := devices[addr]; !exists {
-							devices[addr] = &GoveeDevice{
-								Address:    addr,
-								Name:       name,
-								RSSI:       rssi,
-								RawData:    mfrDataHex,
-								LastUpdate: time.Now(),
-							}
-						} else {
-							// Update RSSI for existing device
-							devices[addr].RSSI = rssi
-						}
-						return
-					}
-
-					// Make sure we have enough bytes for normal mode
-					if len(mfrData) >= 7 {
-						// Convert bytes 3-5 to an integer in big endian
-						values := uint32(0)
-						for i := 0; i < 3; i++ {
-							values = (values << 8) | uint32(mfrData[i+3])
-						}
-
-						// Only process if the value has changed (thread-safe)
-						if scanner.HasValueChanged(addr, int(values)) {
-
-							// Calculate temperature with offset
-							tempC := float64(values)/10000.0 + *tempOffset
-							humidityRaw := float64(values%1000) / 10.0
-
-							// Calculate humidity with offset
-							humidity := humidityRaw + *humidityOffset
-
-							// Battery is directly from byte 6
-							battery := int(mfrData[6])
-
-							if *verbose {
-								fmt.Printf("DEBUG: Device: %s (%s) RSSI: %d\n", addr, name, rssi)
-								fmt.Printf("  Raw data: %s\n", mfrDataHex)
-								fmt.Printf("  Bytes 3-5: %02x %02x %02x\n", mfrData[3], mfrData[4], mfrData[5])
-								fmt.Printf("  Values int: %d\n", values)
-								fmt.Printf("  Decoded: Temp: %.1f°C, Humidity: %.1f%%, Battery: %d%%\n",
-									tempC, humidity, battery)
-							}
-
-							// Calculate temperature in Fahrenheit
-							tempF := CToF(tempC)
-
-							// Calculate additional values
-							absHumidity, dewPointC, dewPointF, steamPressure := CalculateDerivedValues(tempC, humidity)
-
-							// Store or update device information
-							if _, exists := devices[addr]; !exists {
-								devices[addr] = &GoveeDevice{
-									Address:        addr,
-									Name:           name,
-									RSSI:           rssi,
-									TempC:          tempC,
-									TempF:          tempF,
-									TempOffset:     *tempOffset,
-									Humidity:       humidity,
-									HumidityOffset: *humidityOffset,
-									AbsHumidity:    absHumidity,
-									DewPointC:      dewPointC,
-									DewPointF:      dewPointF,
-									SteamPressure:  steamPressure,
-									Battery:        battery,
-									RawData:        mfrDataHex,
-									LastUpdate:     time.Now(),
-									ClientID:       *clientID,
-								}
-							} else {
-								device := devices[addr]
-								device.RSSI = rssi
-								device.TempC = tempC
-								device.TempF = tempF
-								device.TempOffset = *tempOffset
-								device.Humidity = humidity
-								device.HumidityOffset = *humidityOffset
-								device.AbsHumidity = absHumidity
-								device.DewPointC = dewPointC
-								device.DewPointF = dewPointF
-								device.SteamPressure = steamPressure
-								device.Battery = battery
-								device.RawData = mfrDataHex
-								device.LastUpdate = time.Now()
-							}
-
-							// Create a reading object
-							reading := Reading{
-								DeviceName:     name,
-								DeviceAddr:     addr,
-								TempC:          tempC,
-								TempF:          tempF,
-								TempOffset:     *tempOffset,
-								Humidity:       humidity,
-								HumidityOffset: *humidityOffset,
-								AbsHumidity:    absHumidity,
-								DewPointC:      dewPointC,
-								DewPointF:      dewPointF,
-								SteamPressure:  steamPressure,
-								Battery:        battery,
-								RSSI:           rssi,
-								Timestamp:      time.Now(),
-								ClientID:       *clientID,
-							}
-
-							// Log data if requested
-							if logger != nil {
-								logTime := time.Now().Format("2006-01-02T15:04:05.000")
-								logData := fmt.Sprintf("%s,%s,%s,%.1f,%.1f,%.1f,%.1f,%.1f,%.1f,%.1f,%d,%d,%s\n",
-									logTime, name, addr, tempC, tempF, humidity, absHumidity, dewPointC, dewPointF,
-									steamPressure, battery, rssi, *clientID)
-								if _, err := logger.WriteString(logData); err != nil {
-									log.Printf("Failed to write to log file: %v", err)
-								}
-							}
-
-							// Send to server if not in local mode (using worker pool)
-							if !*localOnly && sendQueue != nil {
-								sendQueue.Enqueue(reading)
-							}
-
-							// Print device information (skip if -single and already printed)
-							if !*singleReading || !printedDevices[addr] {
-								printDeviceText(devices[addr])
-								printedDevices[addr] = true
-							}
-						}
-					}
+			// Only supported Govee sensors are of interest
+			decoder := findDecoder(name, mfrData)
+			if decoder == nil {
+				return
+			}
+
+			// Weak packets from far away or reflected are more likely to be garbled
+			if rssi < *minRSSI {
+				if *verbose {
+					fmt.Printf("DEBUG: Skipping advertisement from %s (%s): RSSI %d dBm is below -min-rssi %d\n", addr, name, rssi, *minRSSI)
+				}
+				return
+			}
+
+			// Validate the payload before using any of it
+			rawTempC, rawHumidity, battery, err := decoder.Decode(mfrData)
+			if err != nil {
+				if *verbose {
+					fmt.Printf("DEBUG: Skipping advertisement from %s (%s): %v (raw data: %s)\n", addr, name, err, mfrDataHex)
+				}
+				return
+			}
+
+			// In discovery mode, just record the device without processing values
+			if *discoveryMode {
+				// Update RSSI for an existing device, otherwise record it
+				if !devices.UpdateRSSI(addr, rssi) {
+					devices.Store(GoveeDevice{
+						Address:    addr,
+						Name:       name,
+						RSSI:       rssi,
+						RawData:    mfrDataHex,
+						LastUpdate: time.Now(),
+					})
+				}
+				return
+			}
+
+			// Heartbeats re-send devices that are still advertising, changed or not
+			devices.MarkSeen(addr, time.Now())
+
+			// Only process if the value has changed (thread-safe), or a changed value is still
+			// waiting out -min-send-interval
+			changed := scanner.HasValueChanged(addr, decodedValueKey(rawTempC, rawHumidity))
+			if !changed && !scanner.Held(addr) {
+				return
+			}
+
+			// Apply this device's offsets and rounding
+			devTempOffset, devHumidityOffset := offsetsFor(calibrations, addr, *tempOffset, *humidityOffset)
+			tempC := roundTo(rawTempC+devTempOffset, *roundTemp)
+			humidity := roundTo(rawHumidity+devHumidityOffset, *roundHumidity)
+
+			if *verbose && changed {
+				fmt.Printf("DEBUG: Device: %s (%s) RSSI: %d\n", addr, name, rssi)
+				fmt.Printf("  Raw data: %s\n", mfrDataHex)
+				fmt.Printf("  Uncalibrated: Temp: %.2f°C, Humidity: %.2f%%\n", rawTempC, rawHumidity)
+				fmt.Printf("  Decoded: Temp: %.1f°C, Humidity: %.1f%%, Battery: %d%%\n",
+					tempC, humidity, battery)
+			}
+
+			// Calculate temperature in Fahrenheit
+			tempF := CToF(tempC)
+
+			// Calculate additional values
+			absHumidity, dewPointC, dewPointF, steamPressure, heatIndexC, heatIndexF, vpd, frostPointC, mixingRatio := CalculateDerivedValues(tempC, humidity, *pressure)
+
+			// Store or update device information
+			device := GoveeDevice{
+				Address:        addr,
+				Name:           name,
+				RSSI:           rssi,
+				TempC:          tempC,
+				TempF:          tempF,
+				TempOffset:     devTempOffset,
+				Humidity:       humidity,
+				HumidityOffset: devHumidityOffset,
+				AbsHumidity:    absHumidity,
+				DewPointC:      dewPointC,
+				DewPointF:      dewPointF,
+				SteamPressure:  steamPressure,
+				HeatIndexC:     heatIndexC,
+				HeatIndexF:     heatIndexF,
+				VPD:            vpd,
+				FrostPointC:    frostPointC,
+				MixingRatio:    mixingRatio,
+				Battery:        battery,
+				RawData:        mfrDataHex,
+				LastUpdate:     time.Now(),
+				ClientID:       *clientID,
+				Location:       *location,
+			}
+			devices.Store(device)
+
+			// Create a reading object
+			reading := readingFromDevice(device, device.LastUpdate)
+
+			// Log data if requested
+			if logger != nil && changed {
+				logTime := time.Now().Format("2006-01-02T15:04:05.000")
+				logData := fmt.Sprintf("%s,%s,%s,%.1f,%.1f,%.1f,%.1f,%.1f,%.1f,%.1f,%d,%d,%s\n",
+					logTime, name, addr, tempC, tempF, humidity, absHumidity, dewPointC, dewPointF,
+					steamPressure, battery, rssi, *clientID)
+				if _, err := logger.WriteString(logData); err != nil {
+					log.Printf("Failed to write to log file: %v", err)
 				}
 			}
-		}, nil); err != nil {
-			// Only log errors that aren't from context deadlines
-			if !errors.Is(err, context.DeadlineExceeded) {
+
+			// Send to server if not in local mode (using worker pool), at most once per -min-send-interval
+			if !*localOnly && sendQueue != nil && scanner.AllowSend(addr, time.Now()) {
+				sendQueue.Enqueue(reading)
+			}
+
+			// Only a send held back by the throttle remains for an unchanged value
+			if !changed {
+				return
+			}
+
+			// Publish to MQTT if configured
+			if mqttPublisher != nil {
+				mqttPublisher.Publish(reading, decoder.Model(name))
+			}
+
+			// Print device information (skip if -single and already printed)
+			if firstPrint := devices.MarkPrinted(addr); !*singleReading || firstPrint {
+				printDeviceText(&device)
+			}
+		}, nil); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			// Only log errors that aren't from context deadlines or Ctrl-C
+			if ctx.Err() == nil {
 				log.Printf("Scan error: %v", errors.Wrap(err, "scanning failed"))
-			} else if *verbose {
+				scanErrors++
+			}
+		} else {
+			scanErrors = 0
+			if *verbose {
 				fmt.Println("Scan cycle completed.")
 			}
 		}
 
+		// Repeated failures usually mean the adapter was reset and has to be opened again
+		if scanErrors >= maxScanErrors {
+			log.Printf("%d scan errors in a row, reinitializing BLE adapter hci%d", scanErrors, adapterID)
+			if err := reopenBLEDevice(ctx, adapterID, *passive); err != nil {
+				scanCancel()
+				return
+			}
+			log.Printf("BLE adapter hci%d reinitialized", adapterID)
+			scanErrors = 0
+		}
+
 		scanCancel() // Clean up the scan context
 
 		// In discovery mode, print device list after scan completes
 		if *discoveryMode {
-			fmt.Printf("\n=== Discovered Govee Devices (%d found) ===\n\n", len(devices))
+			discovered := devices.Snapshot()
+			fmt.Printf("\n=== Discovered Govee Devices (%d found) ===\n\n", len(discovered))
 			fmt.Printf("%-20s %-15s %s\n", "Device Name", "MAC Address", "Signal Strength")
 			fmt.Printf("%-20s %-15s %s\n", "--------------------", "---------------", "---------------")
 
-			for _, device := range devices {
+			for _, device := range discovered {
 				fmt.Printf("%-20s %-15s %ddBm\n",
 					device.Name,
 					device.Address,
@@ -527,16 +1539,16 @@ func main() {
 
 	if !*discoveryMode {
 		fmt.Printf("\nScan completed after %s. Discovered %d devices.\n",
-			time.Since(startTime).Round(time.Second), len(devices))
+			time.Since(startTime).Round(time.Second), devices.Len())
 
 		// Print summary table in single mode
-		if *singleReading && len(devices) > 0 {
+		if summary := devices.Snapshot(); *singleReading && len(summary) > 0 {
 			fmt.Println("\n=== Device Summary ===")
 			fmt.Printf("%-14s %7s %7s %6s %6s %4s %6s\n",
 				"Device", "Temp°C", "Temp°F", "RH%", "DewPt", "Bat", "RSSI")
 			fmt.Printf("%-14s %7s %7s %6s %6s %4s %6s\n",
 				"--------------", "-------", "-------", "------", "------", "----", "------")
-			for _, device := range devices {
+			for _, device := range summary {
 				fmt.Printf("%-14s %7.1f %7.1f %6.1f %6.1f %3d%% %4ddBm\n",
 					device.Name,
 					device.TempC,
@@ -550,13 +1562,76 @@ func main() {
 	}
 }
 
+// parseH5075Data validates H5075 manufacturer data and extracts the combined
+// temperature/humidity value (bytes 3-5, big endian) and the battery level (byte 6).
+// Advertisements that carry the Govee name but no usable payload are rejected.
+func parseH5075Data(mfrData []byte) (uint32, int, error) {
+	if len(mfrData) < 7 {
+		return 0, 0, fmt.Errorf("manufacturer data too short (%d bytes)", len(mfrData))
+	}
+	// Valid Govee format starts with 88EC
+	if mfrData[0] != 0x88 || mfrData[1] != 0xEC {
+		return 0, 0, fmt.Errorf("unexpected manufacturer data header %02x%02x", mfrData[0], mfrData[1])
+	}
+	return parsePackedValues(mfrData[3:7])
+}
+
+// parsePackedValues reads the combined temperature/humidity value (3 bytes, big endian) and
+// battery level (1 byte) that the H5075 and similar sensors send
+func parsePackedValues(data []byte) (uint32, int, error) {
+	values := uint32(0)
+	for i := 0; i < 3; i++ {
+		values = (values << 8) | uint32(data[i])
+	}
+
+	battery := int(data[3])
+	if battery > 100 {
+		return 0, 0, fmt.Errorf("battery level out of range: %d", battery)
+	}
+	if values == 0 && battery == 0 {
+		return 0, 0, fmt.Errorf("empty sensor payload")
+	}
+
+	return values, battery, nil
+}
+
+// h5075SignBit marks a negative temperature in the H5075 temperature/humidity field
+const h5075SignBit = 0x800000
+
+// decodeTempHumidity converts the combined H5075 value (bytes 3-5) into calibrated
+// temperature and humidity, rounded to the configured number of decimal places.
+// The value encodes temperature*10000 + humidity*10, so the last three digits are humidity.
+func decodeTempHumidity(values uint32, tempOffset, humidityOffset float64, tempDecimals, humidityDecimals int) (float64, float64) {
+	// Sub-zero temperatures are flagged by the top bit of the 3-byte field
+	negative := values&h5075SignBit != 0
+	values &^= h5075SignBit
+
+	tempC := float64(values/1000) / 10.0
+	if negative {
+		tempC = -tempC
+	}
+	tempC += tempOffset
+	humidity := float64(values%1000)/10.0 + humidityOffset
+	return roundTo(tempC, tempDecimals), roundTo(humidity, humidityDecimals)
+}
+
+// roundTo rounds a value to the given number of decimal places (negative leaves it unrounded)
+func roundTo(value float64, decimals int) float64 {
+	if decimals < 0 {
+		return value
+	}
+	p := math.Pow(10, float64(decimals))
+	return math.Round(value*p) / p
+}
+
 // CToF converts Celsius to Fahrenheit
 func CToF(celsius float64) float64 {
 	return math.Round((32.0+9.0*celsius/5.0)*100) / 100
 }
 
-// CalculateDerivedValues calculates additional values based on temperature and humidity
-func CalculateDerivedValues(tempC, humidity float64) (float64, float64, float64, float64) {
+// CalculateDerivedValues calculates additional values based on temperature, humidity and the
+// station pressure in hPa
+func CalculateDerivedValues(tempC, humidity, pressureHPa float64) (float64, float64, float64, float64, float64, float64, float64, float64, float64) {
 	// Calculate absolute humidity (g/m³)
 	absHumidity := CalculateAbsoluteHumidity(tempC, humidity)
 
@@ -569,7 +1644,18 @@ func CalculateDerivedValues(tempC, humidity float64) (float64, float64, float64,
 	// Calculate steam pressure (hPa)
 	steamPressure := CalculateSteamPressure(tempC, humidity)
 
-	return absHumidity, dewPointC, dewPointF, steamPressure
+	// Calculate heat index (°C) and convert to Fahrenheit
+	heatIndexC := CalculateHeatIndex(tempC, humidity)
+	heatIndexF := CToF(heatIndexC)
+
+	// Calculate vapor pressure deficit (kPa)
+	vpd := CalculateVPD(tempC, humidity)
+
+	// Calculate frost point (°C) and mixing ratio (g/kg)
+	frostPointC := CalculateFrostPoint(tempC, humidity)
+	mixingRatio := CalculateMixingRatio(tempC, humidity, pressureHPa)
+
+	return absHumidity, dewPointC, dewPointF, steamPressure, heatIndexC, heatIndexF, vpd, frostPointC, mixingRatio
 }
 
 // CalculateAbsoluteHumidity calculates absolute humidity in g/m³
@@ -611,6 +1697,104 @@ func CalculateSteamPressure(tempC, relHumidity float64) float64 {
 	return math.Round(steamPressure*10) / 10 // Round to 1 decimal place
 }
 
+// CalculateVPD calculates the vapor pressure deficit in kPa: how far the air is from saturation
+// Formula: VPD = 0.6108 * exp(17.27*tempC/(tempC+237.3)) * (1 - relHumidity/100)
+func CalculateVPD(tempC, relHumidity float64) float64 {
+	// Saturation vapor pressure (kPa, Tetens equation)
+	satVaporPressure := 0.6108 * math.Exp(17.27*tempC/(tempC+237.3))
+
+	vpd := satVaporPressure * (1 - relHumidity/100.0)
+
+	return math.Round(vpd*100) / 100 // Round to 2 decimal places
+}
+
+// Station pressure assumed for the mixing ratio unless -pressure says otherwise (standard
+// sea-level pressure), and the range -pressure accepts
+const (
+	defaultPressureHPa = 1013.25
+	minPressureHPa     = 300.0
+	maxPressureHPa     = 1100.0
+)
+
+// validatePressure checks a -pressure value
+func validatePressure(pressureHPa float64) error {
+	if !(pressureHPa >= minPressureHPa && pressureHPa <= maxPressureHPa) {
+		return fmt.Errorf("-pressure must be between %g and %g hPa", minPressureHPa, maxPressureHPa)
+	}
+	return nil
+}
+
+// clampHumidity keeps a relative humidity within 0.1-100%, so that logarithms of it stay finite
+func clampHumidity(relHumidity float64) float64 {
+	return math.Min(math.Max(relHumidity, 0.1), 100)
+}
+
+// vaporPressure returns the partial pressure of water vapor in hPa, using the same Magnus
+// formula over water as CalculateSteamPressure
+func vaporPressure(tempC, relHumidity float64) float64 {
+	return relHumidity / 100.0 * 6.112 * math.Exp(17.62*tempC/(243.12+tempC))
+}
+
+// CalculateFrostPoint calculates the frost point in °C: the temperature at which the air
+// becomes saturated over ice, using the Magnus formula over ice. Frost can only form below
+// 0°C; when that temperature isn't below 0°C the air reaches saturation over water first,
+// so the dew point is returned instead.
+// Formula: frostPoint = 272.62 * ln(e/6.112) / (22.46 - ln(e/6.112)), e = vapor pressure (hPa)
+func CalculateFrostPoint(tempC, relHumidity float64) float64 {
+	relHumidity = clampHumidity(relHumidity)
+	gamma := math.Log(vaporPressure(tempC, relHumidity) / 6.112)
+
+	frostPoint := 272.62 * gamma / (22.46 - gamma)
+	if frostPoint >= 0 {
+		return CalculateDewPoint(tempC, relHumidity)
+	}
+
+	return math.Round(frostPoint*10) / 10 // Round to 1 decimal place
+}
+
+// CalculateMixingRatio calculates the mixing ratio in g/kg: grams of water vapor per kilogram
+// of dry air at the given station pressure in hPa. It is 0 if the pressure isn't above the
+// vapor pressure, where there is no dry air to compare against.
+// Formula: mixingRatio = 621.97 * e / (pressure - e), e = vapor pressure (hPa)
+func CalculateMixingRatio(tempC, relHumidity, pressureHPa float64) float64 {
+	e := vaporPressure(tempC, math.Min(math.Max(relHumidity, 0), 100))
+	if !(pressureHPa > e) {
+		return 0
+	}
+
+	mixingRatio := 621.97 * e / (pressureHPa - e)
+
+	return math.Round(mixingRatio*100) / 100 // Round to 2 decimal places
+}
+
+// heatIndexMinTempC is the temperature below which the heat index is just the temperature;
+// the Rothfusz regression is only valid from about 80°F
+const heatIndexMinTempC = 27.0
+
+// CalculateHeatIndex calculates the heat index ("feels like" temperature) in °C using the
+// NWS Rothfusz regression, with its adjustments for very dry and very humid air
+// Formula (°F): HI = -42.379 + 2.04901523*T + 10.14333127*RH - 0.22475541*T*RH - 0.00683783*T² - 0.05481717*RH² + 0.00122874*T²*RH + 0.00085282*T*RH² - 0.00000199*T²*RH²
+func CalculateHeatIndex(tempC, relHumidity float64) float64 {
+	if tempC < heatIndexMinTempC {
+		return math.Round(tempC*10) / 10
+	}
+
+	t := 32.0 + 9.0*tempC/5.0
+	rh := relHumidity
+	hi := -42.379 + 2.04901523*t + 10.14333127*rh - 0.22475541*t*rh - 0.00683783*t*t -
+		0.05481717*rh*rh + 0.00122874*t*t*rh + 0.00085282*t*rh*rh - 0.00000199*t*t*rh*rh
+
+	// Adjustments from the NWS for low humidity in hot air and high humidity in warm air
+	if rh < 13 && t >= 80 && t <= 112 {
+		hi -= (13 - rh) / 4 * math.Sqrt((17-math.Abs(t-95))/17)
+	} else if rh > 85 && t >= 80 && t <= 87 {
+		hi += (rh - 85) / 10 * (87 - t) / 5
+	}
+
+	heatIndexC := (hi - 32.0) * 5.0 / 9.0
+	return math.Round(heatIndexC*10) / 10 // Round to 1 decimal place
+}
+
 func printDeviceText(device *GoveeDevice) {
 	fmt.Printf("%s %s Temp: %.1f°C/%.1f°F, Humidity: %.1f%%, Dew Point: %.1f°C, AH: %.1f g/m³, SP: %.1f hPa, Battery: %d%%, RSSI: %ddBm\n",
 		device.LastUpdate.Format("2006-01-02T15:04:05"),
@@ -626,7 +1810,7 @@ func printDeviceText(device *GoveeDevice) {
 	)
 }
 
-func sendToServer(serverURL string, reading Reading, apiKey string, insecureSkipVerify bool, caCertFile string, httpTimeout time.Duration) error {
+func sendToServer(serverURL string, reading Reading, apiKey string, insecureSkipVerify bool, caCertFile, clientCertFile, clientKeyFile string, httpTimeout time.Duration) error {
 	// Convert reading to JSON
 	jsonData, err := json.Marshal(reading)
 	if err != nil {
@@ -634,24 +1818,9 @@ func sendToServer(serverURL string, reading Reading, apiKey string, insecureSkip
 	}
 
 	// Create HTTP client with TLS configuration
-	tlsConfig := &tls.Config{}
-
-	// Handle certificate verification options
-	if insecureSkipVerify {
-		tlsConfig.InsecureSkipVerify = true
-	} else if caCertFile != "" {
-		// Load CA cert if specified
-		caCert, err := os.ReadFile(caCertFile)
-		if err != nil {
-			return fmt.Errorf("error loading CA certificate: %v", err)
-		}
-
-		caCertPool := x509.NewCertPool()
-		if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
-			return fmt.Errorf("failed to append CA certificate")
-		}
-
-		tlsConfig.RootCAs = caCertPool
+	tlsConfig, err := newTLSConfig(insecureSkipVerify, caCertFile, clientCertFile, clientKeyFile)
+	if err != nil {
+		return err
 	}
 
 	// Create transport and client
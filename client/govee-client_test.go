@@ -1,9 +1,26 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"math"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 )
@@ -114,6 +131,139 @@ func TestCalculateSteamPressure(t *testing.T) {
 	}
 }
 
+// TestCalculateHeatIndex tests heat index calculation against NWS heat index chart values
+func TestCalculateHeatIndex(t *testing.T) {
+	tests := []struct {
+		name        string
+		tempC       float64
+		relHumidity float64
+		minExpected float64
+		maxExpected float64
+	}{
+		{"Below threshold is the temperature", 22.0, 80.0, 22.0, 22.0},
+		{"32°C 70% RH (chart: 104-106°F)", 32.0, 70.0, 40.0, 41.2},
+		{"35°C 60% RH (chart: 113-114°F)", 35.0, 60.0, 44.5, 45.8},
+		{"30°C 50% RH (chart: 88-89°F)", 30.0, 50.0, 30.5, 32.0},
+		{"Hot and very dry", 38.0, 10.0, 34.5, 37.0},
+		{"Warm and very humid (chart: 93-95°F)", 28.0, 95.0, 33.5, 36.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := CalculateHeatIndex(tt.tempC, tt.relHumidity)
+			if result < tt.minExpected || result > tt.maxExpected {
+				t.Errorf("CalculateHeatIndex(%v, %v) = %v, expected between %v and %v",
+					tt.tempC, tt.relHumidity, result, tt.minExpected, tt.maxExpected)
+			}
+		})
+	}
+}
+
+// TestCalculateVPD tests vapor pressure deficit against published VPD chart values
+func TestCalculateVPD(t *testing.T) {
+	tests := []struct {
+		name     string
+		tempC    float64
+		humidity float64
+		expected float64
+	}{
+		{"Saturated air has no deficit", 25.0, 100.0, 0},
+		{"Propagation 20°C 80% RH", 20.0, 80.0, 0.47},
+		{"Vegetative 25°C 70% RH", 25.0, 70.0, 0.95},
+		{"Flowering 25°C 60% RH", 25.0, 60.0, 1.27},
+		{"Hot and dry 30°C 40% RH", 30.0, 40.0, 2.55},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := CalculateVPD(tt.tempC, tt.humidity)
+			if math.Abs(result-tt.expected) > 0.015 {
+				t.Errorf("CalculateVPD(%v, %v) = %v, expected %v", tt.tempC, tt.humidity, result, tt.expected)
+			}
+		})
+	}
+}
+
+// TestCalculateFrostPoint tests frost point against reference values, falling back to the dew
+// point where frost can't form
+func TestCalculateFrostPoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		tempC    float64
+		humidity float64
+		expected float64
+	}{
+		{"Freezer -20°C 50% RH", -20.0, 50.0, -25.0},
+		{"Frosty night -10°C 80% RH", -10.0, 80.0, -11.4},
+		{"Hazy -5°C 90% RH", -5.0, 90.0, -5.7},
+		{"Above freezing 2°C 70% RH", 2.0, 70.0, -2.6},
+		{"Saturated at 0°C", 0.0, 100.0, 0.0},
+		{"Greenhouse 20°C 50% RH is the dew point", 20.0, 50.0, CalculateDewPoint(20.0, 50.0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := CalculateFrostPoint(tt.tempC, tt.humidity)
+			if math.Abs(result-tt.expected) > 0.1 {
+				t.Errorf("CalculateFrostPoint(%v, %v) = %v, expected %v", tt.tempC, tt.humidity, result, tt.expected)
+			}
+			// Below freezing the frost point is above the dew point
+			if tt.tempC < 0 && result < CalculateDewPoint(tt.tempC, tt.humidity) {
+				t.Errorf("Frost point %v should be >= dew point %v", result, CalculateDewPoint(tt.tempC, tt.humidity))
+			}
+		})
+	}
+}
+
+// TestCalculateMixingRatio tests mixing ratio against psychrometric reference values
+func TestCalculateMixingRatio(t *testing.T) {
+	tests := []struct {
+		name     string
+		tempC    float64
+		humidity float64
+		pressure float64
+		expected float64
+	}{
+		{"Room 20°C 50% RH", 20.0, 50.0, 1013.25, 7.24},
+		{"Greenhouse 25°C 60% RH", 25.0, 60.0, 1013.25, 11.86},
+		{"Tropical 30°C 80% RH", 30.0, 80.0, 1013.25, 21.51},
+		{"Saturated at 0°C", 0.0, 100.0, 1013.25, 3.78},
+		{"Cold -10°C 80% RH", -10.0, 80.0, 1013.25, 1.41},
+		{"Altitude 20°C 50% RH at 850 hPa", 20.0, 50.0, 850.0, 8.65},
+		{"Dry air", 20.0, 0.0, 1013.25, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := CalculateMixingRatio(tt.tempC, tt.humidity, tt.pressure)
+			if math.Abs(result-tt.expected) > 0.015 {
+				t.Errorf("CalculateMixingRatio(%v, %v, %v) = %v, expected %v", tt.tempC, tt.humidity, tt.pressure, result, tt.expected)
+			}
+		})
+	}
+}
+
+// TestFrostPointMixingRatioExtremes tests that out-of-range inputs give finite values
+func TestFrostPointMixingRatioExtremes(t *testing.T) {
+	for _, tempC := range []float64{-60, -40, -0.1, 0, 60, 100} {
+		for _, humidity := range []float64{-5, 0, 0.01, 50, 100, 120} {
+			for _, pressure := range []float64{0, 50, minPressureHPa, defaultPressureHPa, maxPressureHPa} {
+				frost := CalculateFrostPoint(tempC, humidity)
+				mixing := CalculateMixingRatio(tempC, humidity, pressure)
+				if math.IsNaN(frost) || math.IsInf(frost, 0) {
+					t.Errorf("CalculateFrostPoint(%v, %v) = %v", tempC, humidity, frost)
+				}
+				if math.IsNaN(mixing) || math.IsInf(mixing, 0) || mixing < 0 {
+					t.Errorf("CalculateMixingRatio(%v, %v, %v) = %v", tempC, humidity, pressure, mixing)
+				}
+			}
+		}
+	}
+	if CalculateMixingRatio(100, 100, minPressureHPa) != 0 {
+		t.Error("Expected no mixing ratio when the vapor pressure exceeds the station pressure")
+	}
+}
+
 // TestCalculateDerivedValues tests the combined derived values calculation
 func TestCalculateDerivedValues(t *testing.T) {
 	tests := []struct {
@@ -130,7 +280,7 @@ func TestCalculateDerivedValues(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			absHumidity, dewPointC, dewPointF, steamPressure := CalculateDerivedValues(tt.tempC, tt.humidity)
+			absHumidity, dewPointC, dewPointF, steamPressure, heatIndexC, heatIndexF, vpd, frostPointC, mixingRatio := CalculateDerivedValues(tt.tempC, tt.humidity, defaultPressureHPa)
 
 			if tt.expectValid {
 				// Check absolute humidity is positive and reasonable
@@ -153,6 +303,27 @@ func TestCalculateDerivedValues(t *testing.T) {
 				if steamPressure <= 0 || steamPressure > 100 {
 					t.Errorf("Steam pressure %v is out of reasonable range", steamPressure)
 				}
+
+				// Check heat index is never below the temperature and F is the converted value
+				if heatIndexC < tt.tempC-0.1 {
+					t.Errorf("Heat index %v should be >= temperature %v", heatIndexC, tt.tempC)
+				}
+				if heatIndexF != CToF(heatIndexC) {
+					t.Errorf("Heat index F %v doesn't match expected %v", heatIndexF, CToF(heatIndexC))
+				}
+
+				// Check VPD matches the individual calculation
+				if vpd != CalculateVPD(tt.tempC, tt.humidity) {
+					t.Errorf("VPD %v doesn't match expected %v", vpd, CalculateVPD(tt.tempC, tt.humidity))
+				}
+
+				// Check frost point and mixing ratio match the individual calculations
+				if frostPointC != CalculateFrostPoint(tt.tempC, tt.humidity) {
+					t.Errorf("Frost point %v doesn't match expected %v", frostPointC, CalculateFrostPoint(tt.tempC, tt.humidity))
+				}
+				if mixingRatio != CalculateMixingRatio(tt.tempC, tt.humidity, defaultPressureHPa) {
+					t.Errorf("Mixing ratio %v doesn't match expected %v", mixingRatio, CalculateMixingRatio(tt.tempC, tt.humidity, defaultPressureHPa))
+				}
 			}
 		})
 	}
@@ -192,6 +363,120 @@ func TestHasValueChanged(t *testing.T) {
 	}
 }
 
+// TestAllowSendThrottle tests that rapid changes within -min-send-interval are sent once per interval
+func TestAllowSendThrottle(t *testing.T) {
+	scanner := NewScanner()
+	scanner.SetMinSendInterval(time.Minute)
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	sent := 0
+	for i := 0; i < 30; i++ {
+		if scanner.HasValueChanged("device1", 215000+i) && scanner.AllowSend("device1", start.Add(time.Duration(i)*time.Second)) {
+			sent++
+		}
+	}
+	if sent != 1 {
+		t.Errorf("Expected 30 changes in 30s to be throttled to 1 send, got %d", sent)
+	}
+	if !scanner.Held("device1") {
+		t.Error("Expected the throttled device to be held")
+	}
+
+	// Once the interval has passed the held value goes out, even though it hasn't changed again
+	if !scanner.AllowSend("device1", start.Add(time.Minute)) {
+		t.Error("Expected a send once the interval passed")
+	}
+	if scanner.Held("device1") {
+		t.Error("Expected the device released after sending")
+	}
+
+	// Other devices are throttled separately, and their first reading always goes
+	if !scanner.AllowSend("device2", start.Add(time.Minute)) {
+		t.Error("Expected the first reading from a new device to be sent")
+	}
+
+	// Without an interval every change is sent
+	unthrottled := NewScanner()
+	for i := 0; i < 3; i++ {
+		if !unthrottled.AllowSend("device1", start) {
+			t.Errorf("Expected send %d allowed without -min-send-interval", i)
+		}
+	}
+}
+
+// TestSendHeartbeats tests re-sending unchanged readings of devices still advertising, within the throttle
+func TestSendHeartbeats(t *testing.T) {
+	devices := NewDeviceRegistry()
+	scanner := NewScanner()
+	scanner.SetMinSendInterval(time.Minute)
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, addr := range []string{"A4:C1:38:25:A1:E3", "A4:C1:38:11:22:33"} {
+		devices.Store(GoveeDevice{Address: addr, Name: "GVH5075_A1E3", TempC: 21.5, Humidity: 45, Battery: 90, ClientID: "test-client"})
+		devices.MarkSeen(addr, start)
+	}
+	// The second device was last sent just now, so its heartbeat is throttled
+	scanner.AllowSend("A4:C1:38:11:22:33", start.Add(4*time.Minute))
+
+	var sent []Reading
+	enqueue := func(r Reading) { sent = append(sent, r) }
+	now := start.Add(4*time.Minute + 30*time.Second)
+	if n := sendHeartbeats(devices, scanner, enqueue, start, now); n != 1 || len(sent) != 1 {
+		t.Fatalf("Expected 1 heartbeat, got %d: %+v", n, sent)
+	}
+	if sent[0].DeviceAddr != "A4:C1:38:25:A1:E3" || sent[0].TempC != 21.5 || !sent[0].Timestamp.Equal(now) || sent[0].ClientID != "test-client" {
+		t.Errorf("Unexpected heartbeat reading: %+v", sent[0])
+	}
+	if scanner.Held("A4:C1:38:11:22:33") {
+		t.Error("A throttled heartbeat should not hold the device")
+	}
+
+	// Devices not heard from since the previous heartbeat are left alone
+	sent = nil
+	if n := sendHeartbeats(devices, scanner, enqueue, now, now.Add(5*time.Minute)); n != 0 {
+		t.Errorf("Expected no heartbeats for devices gone quiet, got %+v", sent)
+	}
+}
+
+// TestScannerStateRestart tests that suppression of unchanged values survives saving and reloading the scanner state
+func TestScannerStateRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	// Nothing saved yet
+	fresh := NewScanner()
+	if count, err := fresh.LoadState(path); err != nil || count != 0 {
+		t.Fatalf("Expected missing state file to be ignored, got %d, %v", count, err)
+	}
+
+	scanner := NewScanner()
+	scanner.HasValueChanged("A4:C1:38:25:A1:E3", 215450)
+	scanner.HasValueChanged("A4:C1:38:11:22:33", 198765)
+	if err := scanner.SaveState(path); err != nil {
+		t.Fatalf("SaveState failed: %v", err)
+	}
+
+	restarted := NewScanner()
+	count, err := restarted.LoadState(path)
+	if err != nil || count != 2 {
+		t.Fatalf("Expected 2 restored devices, got %d, %v", count, err)
+	}
+	if restarted.HasValueChanged("A4:C1:38:25:A1:E3", 215450) {
+		t.Error("Unchanged value should still be suppressed after a restart")
+	}
+	if !restarted.HasValueChanged("A4:C1:38:11:22:33", 198766) {
+		t.Error("Changed value should be reported after a restart")
+	}
+	if !restarted.HasValueChanged("A4:C1:38:44:55:66", 215450) {
+		t.Error("Unknown device should be reported after a restart")
+	}
+
+	// A corrupt state file is reported, not fatal
+	os.WriteFile(path, []byte("{not json"), 0644)
+	if _, err := NewScanner().LoadState(path); err == nil {
+		t.Error("Expected error for corrupt state file")
+	}
+}
+
 // TestNewSendQueue tests send queue creation
 func TestNewSendQueue(t *testing.T) {
 	queue := NewSendQueue(
@@ -200,6 +485,8 @@ func TestNewSendQueue(t *testing.T) {
 		"test-api-key",
 		false, // insecure skip verify
 		"",    // CA cert file
+		"",    // client cert file
+		"",    // client key file
 		10*time.Second,
 	)
 	defer queue.Close()
@@ -224,6 +511,8 @@ func TestSendQueueEnqueue(t *testing.T) {
 		"test-api-key",
 		false,
 		"",
+		"",
+		"",
 		1*time.Second, // 1 second timeout
 	)
 	defer queue.Close()
@@ -278,7 +567,7 @@ func BenchmarkCalculateSteamPressure(b *testing.B) {
 // BenchmarkCalculateDerivedValues benchmarks all derived calculations
 func BenchmarkCalculateDerivedValues(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		CalculateDerivedValues(25.0, 60.0)
+		CalculateDerivedValues(25.0, 60.0, defaultPressureHPa)
 	}
 }
 
@@ -290,12 +579,34 @@ func TestSendQueueClose(t *testing.T) {
 		"test-api-key",
 		false,
 		"",
+		"",
+		"",
 		1*time.Second,
 	)
 
 	// Close should not panic
 	queue.Close()
-	// Note: Double close would panic - the implementation doesn't handle it
+}
+
+// TestSendQueueDoubleClose tests that closing the send queue twice does not panic
+func TestSendQueueDoubleClose(t *testing.T) {
+	queue := NewSendQueue(2, "http://localhost:9999", "test-api-key", false, "", "", "", 1*time.Second)
+
+	queue.Close()
+	queue.Close()
+}
+
+// TestSendQueueEnqueueAfterClose tests that a late reading is dropped instead of panicking
+func TestSendQueueEnqueueAfterClose(t *testing.T) {
+	queue := NewSendQueue(1, "http://localhost:9999", "test-api-key", false, "", "", "", 1*time.Second)
+	queue.Close()
+
+	queue.Enqueue(Reading{
+		DeviceName: "Test Device",
+		DeviceAddr: "AA:BB:CC:DD:EE:FF",
+		ClientID:   "test-client",
+		Timestamp:  time.Now(),
+	})
 }
 
 // TestSendQueueEnqueueNoBlock tests that enqueue doesn't block
@@ -306,6 +617,8 @@ func TestSendQueueEnqueueNoBlock(t *testing.T) {
 		"test-api-key",
 		false,
 		"",
+		"",
+		"",
 		10*time.Millisecond, // Very short timeout
 	)
 
@@ -392,7 +705,7 @@ func TestDerivedValuesConsistency(t *testing.T) {
 	steamPressure := CalculateSteamPressure(tempC, humidity)
 
 	// Calculate using combined function
-	combinedAH, combinedDPC, combinedDPF, combinedSP := CalculateDerivedValues(tempC, humidity)
+	combinedAH, combinedDPC, combinedDPF, combinedSP, _, _, _, _, _ := CalculateDerivedValues(tempC, humidity, defaultPressureHPa)
 
 	// Compare results
 	if math.Abs(absHumidity-combinedAH) > 0.01 {
@@ -506,7 +819,7 @@ func TestSendToServerInvalidURL(t *testing.T) {
 		ClientID:   "test",
 	}
 
-	err := sendToServer("http://invalid-server-name-999.example:9999", reading, "test-key", false, "", 1*time.Second)
+	err := sendToServer("http://invalid-server-name-999.example:9999", reading, "test-key", false, "", "", "", 1*time.Second)
 	if err == nil {
 		t.Error("Expected error for invalid server URL")
 	}
@@ -526,7 +839,7 @@ func TestSendToServerInsecure(t *testing.T) {
 	}
 
 	// This will fail (server doesn't exist) but test insecure path
-	err := sendToServer("https://localhost:9999", reading, "test-key", true, "", 1*time.Second)
+	err := sendToServer("https://localhost:9999", reading, "test-key", true, "", "", "", 1*time.Second)
 	// Error is expected (server doesn't exist)
 	if err == nil {
 		t.Log("Server unexpectedly responded")
@@ -546,7 +859,7 @@ func TestSendToServerWithCACertNotExist(t *testing.T) {
 		ClientID:   "test",
 	}
 
-	err := sendToServer("https://localhost:9999", reading, "test-key", false, "/nonexistent/ca.crt", 1*time.Second)
+	err := sendToServer("https://localhost:9999", reading, "test-key", false, "/nonexistent/ca.crt", "", "", 1*time.Second)
 	if err == nil {
 		t.Error("Expected error for non-existent CA cert")
 	}
@@ -574,12 +887,70 @@ func TestSendToServerWithInvalidCACert(t *testing.T) {
 		ClientID:   "test",
 	}
 
-	err = sendToServer("https://localhost:9999", reading, "test-key", false, tmpFile.Name(), 1*time.Second)
+	err = sendToServer("https://localhost:9999", reading, "test-key", false, tmpFile.Name(), "", "", 1*time.Second)
 	if err == nil {
 		t.Error("Expected error for invalid CA cert")
 	}
 }
 
+// TestSendToServerClientCert tests presenting a client certificate to a server that requires one
+func TestSendToServerClientCert(t *testing.T) {
+	// Self-signed client certificate, trusted by the server as its own CA
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "client-kitchen"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	clientCert, _ := x509.ParseCertificate(der)
+
+	tmpDir := t.TempDir()
+	certFile := filepath.Join(tmpDir, "client.pem")
+	keyFile := filepath.Join(tmpDir, "client-key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	var gotCN string
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotCN = r.TLS.PeerCertificates[0].Subject.CommonName
+		w.WriteHeader(http.StatusCreated)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	ts.TLS = &tls.Config{ClientCAs: clientCAs, ClientAuth: tls.RequireAndVerifyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	serverCA := filepath.Join(tmpDir, "server-ca.pem")
+	os.WriteFile(serverCA, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600)
+
+	reading := Reading{DeviceName: "Test", DeviceAddr: "AA:BB:CC:DD:EE:FF", TempC: 25.0, Humidity: 50.0, Battery: 85, Timestamp: time.Now(), ClientID: "client-kitchen"}
+
+	if err := sendToServer(ts.URL+"/readings", reading, "", false, serverCA, certFile, keyFile, time.Second); err != nil {
+		t.Fatalf("Expected reading to be sent with the client certificate, got %v", err)
+	}
+	if gotCN != "client-kitchen" {
+		t.Errorf("Expected server to see CN client-kitchen, got %q", gotCN)
+	}
+
+	if err := sendToServer(ts.URL+"/readings", reading, "", false, serverCA, "", "", time.Second); err == nil {
+		t.Error("Expected the handshake to fail without a client certificate")
+	}
+	if err := sendToServer(ts.URL+"/readings", reading, "", false, serverCA, certFile, "", time.Second); err == nil {
+		t.Error("Expected an error for a client certificate without its key")
+	}
+}
+
 // TestPrintDeviceText tests printDeviceText doesn't panic
 func TestPrintDeviceText(t *testing.T) {
 	device := &GoveeDevice{
@@ -698,7 +1069,7 @@ func TestCalculateDerivedValuesRange(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		absHum, dewC, dewF, steamP := CalculateDerivedValues(tc.tempC, tc.humidity)
+		absHum, dewC, dewF, steamP, heatC, _, vpd, frostC, mixing := CalculateDerivedValues(tc.tempC, tc.humidity, defaultPressureHPa)
 
 		// All values should be finite and reasonable
 		if math.IsNaN(absHum) || math.IsInf(absHum, 0) {
@@ -713,5 +1084,667 @@ func TestCalculateDerivedValuesRange(t *testing.T) {
 		if math.IsNaN(steamP) || math.IsInf(steamP, 0) {
 			t.Errorf("Invalid steamPressure for temp=%.1f, hum=%.1f", tc.tempC, tc.humidity)
 		}
+		if math.IsNaN(heatC) || math.IsInf(heatC, 0) {
+			t.Errorf("Invalid heatIndexC for temp=%.1f, hum=%.1f", tc.tempC, tc.humidity)
+		}
+		if math.IsNaN(vpd) || math.IsInf(vpd, 0) || vpd < 0 {
+			t.Errorf("Invalid VPD %v for temp=%.1f, hum=%.1f", vpd, tc.tempC, tc.humidity)
+		}
+		if math.IsNaN(frostC) || math.IsInf(frostC, 0) {
+			t.Errorf("Invalid frostPointC for temp=%.1f, hum=%.1f", tc.tempC, tc.humidity)
+		}
+		if math.IsNaN(mixing) || math.IsInf(mixing, 0) || mixing < 0 {
+			t.Errorf("Invalid mixing ratio %v for temp=%.1f, hum=%.1f", mixing, tc.tempC, tc.humidity)
+		}
+	}
+}
+
+// TestSendReadingRateLimited tests that a 429 response surfaces the server's Retry-After
+func TestSendReadingRateLimited(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	queue := NewSendQueue(1, ts.URL, "test-api-key", false, "", "", "", 1*time.Second)
+	defer queue.Close()
+
+	err := queue.sendReading(Reading{DeviceAddr: "AA:BB:CC:DD:EE:FF", Timestamp: time.Now()})
+	rle, ok := err.(*rateLimitedError)
+	if !ok {
+		t.Fatalf("Expected rateLimitedError, got %v", err)
+	}
+	if rle.retryAfter != 7*time.Second {
+		t.Errorf("Expected retry after 7s, got %v", rle.retryAfter)
+	}
+}
+
+// TestParseRetryAfter tests parsing of the Retry-After header
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"1", time.Second},
+		{" 30 ", 30 * time.Second},
+		{"", 0},
+		{"-5", 0},
+		{"Wed, 21 Oct 2015 07:28:00 GMT", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value); got != tt.expected {
+			t.Errorf("parseRetryAfter(%q) = %v, expected %v", tt.value, got, tt.expected)
+		}
+	}
+}
+
+// TestDecodeTempHumidity tests decoding and rounding of temperature and humidity
+func TestDecodeTempHumidity(t *testing.T) {
+	tests := []struct {
+		name             string
+		values           uint32
+		tempOffset       float64
+		humidityOffset   float64
+		tempDecimals     int
+		humidityDecimals int
+		expectedTemp     float64
+		expectedHumidity float64
+	}{
+		{"Plain decode", 224567, 0, 0, 1, 1, 22.4, 56.7},
+		{"Offsets rounded to one decimal", 224567, 0.123, -1.234, 1, 1, 22.5, 55.5},
+		{"Whole numbers", 224567, 0.123, -1.234, 0, 0, 23, 55},
+		{"Two decimals", 224567, 0.123, -1.234, 2, 2, 22.52, 55.47},
+		{"Rounding disabled", 224567, 0.123, 0, -1, -1, 22.523, 56.7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempC, humidity := decodeTempHumidity(tt.values, tt.tempOffset, tt.humidityOffset, tt.tempDecimals, tt.humidityDecimals)
+			if math.Abs(tempC-tt.expectedTemp) > 1e-9 {
+				t.Errorf("tempC = %v, expected %v", tempC, tt.expectedTemp)
+			}
+			if math.Abs(humidity-tt.expectedHumidity) > 1e-9 {
+				t.Errorf("humidity = %v, expected %v", humidity, tt.expectedHumidity)
+			}
+		})
+	}
+}
+
+// TestSpoolAppendAndTake tests that spooled readings come back in order and the file is consumed
+func TestSpoolAppendAndTake(t *testing.T) {
+	spool, err := NewSpool(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("NewSpool failed: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := spool.Append(Reading{DeviceAddr: "AA:BB:CC:DD:EE:FF", Battery: i}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	first, err := spool.Take(3)
+	if err != nil {
+		t.Fatalf("Take failed: %v", err)
+	}
+	if len(first) != 3 || first[0].Battery != 0 || first[2].Battery != 2 {
+		t.Errorf("Unexpected first batch: %+v", first)
+	}
+
+	rest, err := spool.Take(10)
+	if err != nil {
+		t.Fatalf("Take failed: %v", err)
+	}
+	if len(rest) != 2 || rest[0].Battery != 3 || rest[1].Battery != 4 {
+		t.Errorf("Unexpected second batch: %+v", rest)
+	}
+
+	if _, err := os.Stat(spool.path); !os.IsNotExist(err) {
+		t.Error("Expected spool file to be removed once drained")
+	}
+}
+
+// TestSpoolMaxBytes tests that the spool refuses writes beyond its size limit
+func TestSpoolMaxBytes(t *testing.T) {
+	spool, err := NewSpool(t.TempDir(), 300)
+	if err != nil {
+		t.Fatalf("NewSpool failed: %v", err)
+	}
+
+	if err := spool.Append(Reading{DeviceAddr: "AA:BB:CC:DD:EE:FF"}); err != nil {
+		t.Fatalf("First append should fit: %v", err)
+	}
+	if err := spool.Append(Reading{DeviceAddr: "AA:BB:CC:DD:EE:FF"}); err == nil {
+		t.Error("Expected second append to exceed the spool limit")
+	}
+}
+
+// TestSendQueueSpoolOverflowAndReplay tests that overflowing readings are spooled and replayed on startup
+func TestSendQueueSpoolOverflowAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := NewSpool(dir, 0)
+	if err != nil {
+		t.Fatalf("NewSpool failed: %v", err)
+	}
+
+	// A queue with no workers never drains, so the 101st reading overflows
+	queue := NewSendQueue(0, "http://localhost:9999", "test-api-key", false, "", "", "", time.Second)
+	queue.spool = spool
+	for i := 0; i < cap(queue.queue)+1; i++ {
+		queue.Enqueue(Reading{DeviceAddr: "AA:BB:CC:DD:EE:FF", Battery: i % 100})
+	}
+	if _, err := os.Stat(spool.path); err != nil {
+		t.Fatalf("Expected overflow reading to be spooled: %v", err)
+	}
+
+	// A fresh queue replays the leftover spool once a server is reachable
+	received := make(chan Reading, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reading Reading
+		json.NewDecoder(r.Body).Decode(&reading)
+		received <- reading
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	replay := NewSendQueue(1, ts.URL, "test-api-key", false, "", "", "", time.Second)
+	replay.AttachSpool(spool)
+	defer replay.Close()
+
+	select {
+	case r := <-received:
+		if r.Battery != cap(queue.queue)%100 {
+			t.Errorf("Expected replayed overflow reading, got battery %d", r.Battery)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Spooled reading was not replayed")
+	}
+}
+
+// TestMACFilter tests allowlists, denylists and their precedence, with MACs compared case- and separator-insensitively
+func TestMACFilter(t *testing.T) {
+	listFile := filepath.Join(t.TempDir(), "neighbours.txt")
+	os.WriteFile(listFile, []byte("# Next door\nA4:C1:38:11:22:33\n\na4-c1-38-44-55-66  # upstairs\n"), 0644)
+
+	tests := []struct {
+		name        string
+		allow, deny string
+		allowed     []string
+		denied      []string
+	}{
+		{"No lists", "", "", []string{"A4:C1:38:25:A1:E3", "A4:C1:38:11:22:33"}, nil},
+		{"Allow only", "a4:c1:38:25:a1:e3, A4C138AABBCC", "", []string{"A4:C1:38:25:A1:E3", "a4:c1:38:aa:bb:cc"}, []string{"A4:C1:38:11:22:33"}},
+		{"Deny only", "", "@" + listFile, []string{"A4:C1:38:25:A1:E3"}, []string{"A4:C1:38:11:22:33", "A4:C1:38:44:55:66"}},
+		{"Deny wins", "A4:C1:38:25:A1:E3,A4:C1:38:11:22:33", "a4c138112233", []string{"A4:C1:38:25:A1:E3"}, []string{"A4:C1:38:11:22:33", "A4:C1:38:44:55:66"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewMACFilter(tt.allow, tt.deny)
+			if err != nil {
+				t.Fatalf("NewMACFilter failed: %v", err)
+			}
+			for _, addr := range tt.allowed {
+				if !filter.Allowed(addr) {
+					t.Errorf("Expected %s to be allowed", addr)
+				}
+			}
+			for _, addr := range tt.denied {
+				if filter.Allowed(addr) {
+					t.Errorf("Expected %s to be denied", addr)
+				}
+			}
+		})
+	}
+
+	for _, tt := range []struct{ allow, deny string }{
+		{"A4:C1:38:25:A1", ""},
+		{"", "not-a-mac"},
+		{"@" + filepath.Join(t.TempDir(), "missing.txt"), ""},
+	} {
+		if _, err := NewMACFilter(tt.allow, tt.deny); err == nil {
+			t.Errorf("Expected error for allow %q deny %q", tt.allow, tt.deny)
+		}
+	}
+}
+
+// TestLoadCalibration tests loading per-device calibration offsets and falling back to global offsets
+func TestLoadCalibration(t *testing.T) {
+	path := t.TempDir() + "/calibration.json"
+	data := `{
+		"A4:C1:38:25:A1:E3": {"temp_offset": -0.5, "humidity_offset": 2.0},
+		"a4:c1:38:00:00:01": {"temp_offset": 1.2}
+	}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write calibration file: %v", err)
+	}
+
+	calibrations, err := loadCalibration(path)
+	if err != nil {
+		t.Fatalf("loadCalibration failed: %v", err)
+	}
+
+	tests := []struct {
+		name             string
+		addr             string
+		expectedTemp     float64
+		expectedHumidity float64
+	}{
+		{"Listed device (case-insensitive)", "a4:c1:38:25:a1:e3", -0.5, 2.0},
+		{"Listed device with only temp offset", "A4:C1:38:00:00:01", 1.2, 0},
+		{"Unlisted device uses global offsets", "a4:c1:38:99:99:99", 0.3, -1.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempOffset, humidityOffset := offsetsFor(calibrations, tt.addr, 0.3, -1.0)
+			if tempOffset != tt.expectedTemp || humidityOffset != tt.expectedHumidity {
+				t.Errorf("offsetsFor(%s) = (%v, %v), expected (%v, %v)",
+					tt.addr, tempOffset, humidityOffset, tt.expectedTemp, tt.expectedHumidity)
+			}
+		})
+	}
+
+	if _, err := loadCalibration(t.TempDir() + "/missing.json"); err == nil {
+		t.Error("Expected error for missing calibration file")
+	}
+}
+
+// TestParseH5075Data tests validation of raw manufacturer data
+func TestParseH5075Data(t *testing.T) {
+	tests := []struct {
+		name            string
+		data            string
+		wantErr         bool
+		expectedValues  uint32
+		expectedBattery int
+	}{
+		{"Valid payload", "88ec0003a55564", false, 238933, 100},
+		{"Empty data", "", true, 0, 0},
+		{"Too short", "88ec0003a5", true, 0, 0},
+		{"Unexpected header", "12340003a55564", true, 0, 0},
+		{"Battery out of range", "88ec0003a555ff", true, 0, 0},
+		{"All-zero payload", "88ec0000000000", true, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := hex.DecodeString(tt.data)
+			if err != nil {
+				t.Fatalf("invalid test data %q: %v", tt.data, err)
+			}
+
+			values, battery, err := parseH5075Data(data)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseH5075Data(%s) expected error, got values=%d battery=%d", tt.data, values, battery)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseH5075Data(%s) unexpected error: %v", tt.data, err)
+			}
+			if values != tt.expectedValues || battery != tt.expectedBattery {
+				t.Errorf("parseH5075Data(%s) = (%d, %d), expected (%d, %d)",
+					tt.data, values, battery, tt.expectedValues, tt.expectedBattery)
+			}
+		})
+	}
+}
+
+// TestDecodeManufacturerData tests end-to-end decoding of synthetic H5075 payloads,
+// hand-built from the documented format, including sub-zero temperatures carried
+// with the sign bit set
+func TestDecodeManufacturerData(t *testing.T) {
+	tests := []struct {
+		name             string
+		data             string
+		expectedTemp     float64
+		expectedHumidity float64
+		expectedBattery  int
+	}{
+		{"Room temperature", "88ec00036d3764", 22.4, 56.7, 100},
+		{"Just above freezing", "88ec0000125c55", 0.4, 70.0, 85},
+		{"Just below freezing", "88ec0080125c55", -0.4, 70.0, 85},
+		{"Freezer", "88ec0080d12e5a", -5.3, 55.0, 90},
+		{"Deep freezer", "88ec0083150f3c", -20.1, 99.9, 60},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := hex.DecodeString(tt.data)
+			if err != nil {
+				t.Fatalf("invalid test data %q: %v", tt.data, err)
+			}
+
+			values, battery, err := parseH5075Data(data)
+			if err != nil {
+				t.Fatalf("parseH5075Data(%s) unexpected error: %v", tt.data, err)
+			}
+			tempC, humidity := decodeTempHumidity(values, 0, 0, 1, 1)
+			if math.Abs(tempC-tt.expectedTemp) > 1e-9 {
+				t.Errorf("tempC = %v, expected %v", tempC, tt.expectedTemp)
+			}
+			if math.Abs(humidity-tt.expectedHumidity) > 1e-9 {
+				t.Errorf("humidity = %v, expected %v", humidity, tt.expectedHumidity)
+			}
+			if battery != tt.expectedBattery {
+				t.Errorf("battery = %d, expected %d", battery, tt.expectedBattery)
+			}
+		})
+	}
+}
+
+// TestDeviceRegistryConcurrentUpdates tests that concurrent scan callbacks can update
+// the device registry while it is being read (run with -race)
+func TestDeviceRegistryConcurrentUpdates(t *testing.T) {
+	registry := NewDeviceRegistry()
+	addrs := []string{"A4:C1:38:00:00:01", "A4:C1:38:00:00:02", "A4:C1:38:00:00:03"}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 2; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				addr := addrs[i%len(addrs)]
+				if !registry.UpdateRSSI(addr, -60-g) {
+					registry.Store(GoveeDevice{Address: addr, Name: "GVH5075_TEST", RSSI: -60 - g, TempC: float64(i)})
+				}
+				registry.MarkPrinted(addr)
+			}
+		}(g)
+	}
+
+	// Read concurrently, as the discovery and summary printing do
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			for _, device := range registry.Snapshot() {
+				_ = device.RSSI
+			}
+			registry.Len()
+		}
+	}()
+	wg.Wait()
+
+	if registry.Len() != len(addrs) {
+		t.Errorf("Expected %d devices, got %d", len(addrs), registry.Len())
+	}
+	for _, addr := range addrs {
+		if registry.MarkPrinted(addr) {
+			t.Errorf("Expected %s to already be marked as printed", addr)
+		}
+	}
+}
+
+// TestSendQueueStatsDropped tests that readings which don't fit in a full queue are counted as dropped
+func TestSendQueueStatsDropped(t *testing.T) {
+	// No workers, so nothing leaves the queue
+	queue := NewSendQueue(0, "http://localhost:9999", "test-api-key", false, "", "", "", 1*time.Second)
+
+	capacity := cap(queue.queue)
+	for i := 0; i < capacity+5; i++ {
+		queue.Enqueue(Reading{DeviceAddr: "AA:BB:CC:DD:EE:FF", TempC: 25.0})
+	}
+
+	stats := queue.Stats()
+	if stats.Enqueued != uint64(capacity) {
+		t.Errorf("Expected %d enqueued, got %d", capacity, stats.Enqueued)
+	}
+	if stats.Dropped != 5 {
+		t.Errorf("Expected 5 dropped, got %d", stats.Dropped)
+	}
+	if depth := queue.Depth(); depth != capacity {
+		t.Errorf("Expected queue depth %d, got %d", capacity, depth)
+	}
+
+	queue.Close()
+	queue.Enqueue(Reading{DeviceAddr: "AA:BB:CC:DD:EE:FF", TempC: 25.0})
+	if stats := queue.Stats(); stats.Dropped != 6 {
+		t.Errorf("Expected a reading after Close to be dropped, got %d dropped", stats.Dropped)
+	}
+}
+
+// TestSendQueueStatsSentRetried tests that deliveries and retries are counted
+func TestSendQueueStatsSentRetried(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		first := requests == 1
+		mu.Unlock()
+		// Fail the first attempt so the reading is retried once
+		if first {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	queue := NewSendQueue(1, ts.URL, "test-api-key", false, "", "", "", 1*time.Second)
+	queue.Enqueue(Reading{DeviceAddr: "AA:BB:CC:DD:EE:FF", TempC: 25.0})
+	queue.Close()
+
+	stats := queue.Stats()
+	if stats.Enqueued != 1 || stats.Sent != 1 || stats.Retried != 1 || stats.Dropped != 0 {
+		t.Errorf("Expected 1 enqueued, sent and retried with none dropped, got %+v", stats)
+	}
+}
+
+// TestSendQueueCompress tests that -compress-requests gzips the reading and sets Content-Encoding
+func TestSendQueueCompress(t *testing.T) {
+	received := make(chan Reading, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("Expected Content-Encoding: gzip, got %q", r.Header.Get("Content-Encoding"))
+		}
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("Body is not gzipped: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var reading Reading
+		if err := json.NewDecoder(gz).Decode(&reading); err != nil {
+			t.Errorf("Failed to decode reading: %v", err)
+		}
+		received <- reading
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	queue := NewSendQueue(1, ts.URL, "test-api-key", false, "", "", "", 1*time.Second)
+	queue.SetCompress(true)
+	queue.Enqueue(Reading{DeviceAddr: "AA:BB:CC:DD:EE:FF", TempC: 25.0})
+	queue.Close()
+
+	select {
+	case reading := <-received:
+		if reading.DeviceAddr != "AA:BB:CC:DD:EE:FF" || reading.TempC != 25.0 {
+			t.Errorf("Unexpected reading %+v", reading)
+		}
+	default:
+		t.Fatal("Expected the reading to be sent")
+	}
+}
+
+// TestSendQueueShutdown tests flushing a full queue on shutdown, spooling what can't be sent in time
+func TestSendQueueShutdown(t *testing.T) {
+	t.Run("Flushes queued readings", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer ts.Close()
+
+		queue := NewSendQueue(2, ts.URL, "test-api-key", false, "", "", "", 1*time.Second)
+		for i := 0; i < 20; i++ {
+			queue.Enqueue(Reading{DeviceAddr: "AA:BB:CC:DD:EE:FF", TempC: float64(i)})
+		}
+		sent, spooled, dropped := queue.Shutdown(5 * time.Second)
+		if sent != 20 || spooled != 0 || dropped != 0 {
+			t.Errorf("Expected all 20 readings sent, got sent=%d spooled=%d dropped=%d", sent, spooled, dropped)
+		}
+	})
+
+	t.Run("Spools on timeout", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer ts.Close()
+
+		spool, err := NewSpool(t.TempDir(), 0)
+		if err != nil {
+			t.Fatalf("NewSpool failed: %v", err)
+		}
+		queue := NewSendQueue(1, ts.URL, "test-api-key", false, "", "", "", 1*time.Second)
+		queue.AttachSpool(spool)
+
+		capacity := cap(queue.queue)
+		for i := 0; i < capacity; i++ {
+			queue.Enqueue(Reading{DeviceAddr: "AA:BB:CC:DD:EE:FF", TempC: float64(i)})
+		}
+
+		start := time.Now()
+		sent, spooled, dropped := queue.Shutdown(100 * time.Millisecond)
+		if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+			t.Errorf("Shutdown took %s, expected it to stop retrying at the timeout", elapsed)
+		}
+		if sent != 0 || dropped != 0 || spooled != uint64(capacity) {
+			t.Errorf("Expected %d spooled and none sent or dropped, got sent=%d spooled=%d dropped=%d", capacity, sent, spooled, dropped)
+		}
+
+		readings, err := spool.Take(capacity + 1)
+		if err != nil {
+			t.Fatalf("Take failed: %v", err)
+		}
+		if len(readings) != capacity {
+			t.Errorf("Expected %d readings in the spool, got %d", capacity, len(readings))
+		}
+	})
+}
+
+// TestParseBLEAdapter tests parsing -ble-adapter values
+func TestParseBLEAdapter(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected int
+		wantErr  bool
+	}{
+		{"hci0", 0, false},
+		{"hci1", 1, false},
+		{"HCI2", 2, false},
+		{"3", 3, false},
+		{"", 0, true},
+		{"hci", 0, true},
+		{"hci-1", 0, true},
+		{"bluetooth0", 0, true},
+	}
+
+	for _, tt := range tests {
+		id, err := parseBLEAdapter(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseBLEAdapter(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && id != tt.expected {
+			t.Errorf("parseBLEAdapter(%q) = %d, expected %d", tt.name, id, tt.expected)
+		}
+	}
+}
+
+// TestRetryWithBackoff tests retrying a failing adapter initialization until it succeeds or is cancelled
+func TestRetryWithBackoff(t *testing.T) {
+	t.Run("Succeeds after failures", func(t *testing.T) {
+		calls := 0
+		var times []time.Time
+		initDevice := func() error {
+			calls++
+			times = append(times, time.Now())
+			if calls < 4 {
+				return fmt.Errorf("adapter not ready")
+			}
+			return nil
+		}
+
+		if err := retryWithBackoff(context.Background(), 5*time.Millisecond, 12*time.Millisecond, initDevice); err != nil {
+			t.Fatalf("Expected success, got %v", err)
+		}
+		if calls != 4 {
+			t.Errorf("Expected 4 attempts, got %d", calls)
+		}
+		// Waits of 5ms, 10ms, then 12ms (capped)
+		for i, min := range []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 12 * time.Millisecond} {
+			if gap := times[i+1].Sub(times[i]); gap < min {
+				t.Errorf("Wait before attempt %d was %s, expected at least %s", i+2, gap, min)
+			}
+		}
+	})
+
+	t.Run("Stops when cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		calls := 0
+		err := retryWithBackoff(ctx, time.Millisecond, 5*time.Millisecond, func() error {
+			calls++
+			return fmt.Errorf("adapter not ready")
+		})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+		}
+		if calls < 2 {
+			t.Errorf("Expected several attempts before giving up, got %d", calls)
+		}
+	})
+}
+
+// TestScanParams tests that -passive only changes the scan type
+func TestScanParams(t *testing.T) {
+	active, passive := scanParams(false), scanParams(true)
+	if active.LEScanType != 0x01 {
+		t.Errorf("Expected active scan type 0x01, got %#x", active.LEScanType)
+	}
+	if passive.LEScanType != 0x00 {
+		t.Errorf("Expected passive scan type 0x00, got %#x", passive.LEScanType)
+	}
+	passive.LEScanType = active.LEScanType
+	if passive != active {
+		t.Errorf("Expected the other parameters to match, got %+v and %+v", active, passive)
+	}
+}
+
+// TestBackoffDelay tests that jittered retry waits stay between zero and the capped backoff
+func TestBackoffDelay(t *testing.T) {
+	base, max := 100*time.Millisecond, time.Second
+
+	for retry, ceiling := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		var longest time.Duration
+		for i := 0; i < 1000; i++ {
+			wait := backoffDelay(base, max, retry)
+			if wait < 0 || wait > ceiling {
+				t.Fatalf("Retry %d waited %s, expected between 0 and %s", retry, wait, ceiling)
+			}
+			if wait > longest {
+				longest = wait
+			}
+		}
+		// Jitter spreads waits over the whole range rather than always waiting the full backoff
+		if longest < ceiling/2 {
+			t.Errorf("Retry %d never waited more than %s of %s", retry, longest, ceiling)
+		}
+	}
+
+	// A large retry count is still capped instead of overflowing
+	if wait := backoffDelay(base, max, 100); wait < 0 || wait > max {
+		t.Errorf("Retry 100 waited %s, expected at most %s", wait, max)
+	}
+	if wait := backoffDelay(0, 0, 3); wait != 0 {
+		t.Errorf("Expected no wait with a zero backoff, got %s", wait)
 	}
 }
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttPublishTimeout bounds how long a single publish may block the publishing goroutine
+const mqttPublishTimeout = 5 * time.Second
+
+// mqttQueueSize is how many readings may wait to be published before new ones are dropped
+const mqttQueueSize = 100
+
+// mqttMessage is a reading waiting to be published, with the model of the sensor it came from
+type mqttMessage struct {
+	reading Reading
+	model   string
+}
+
+// MQTTPublisher publishes readings to an MQTT broker, announcing each device
+// to Home Assistant via MQTT discovery the first time it is seen
+type MQTTPublisher struct {
+	topicPrefix     string
+	discoveryPrefix string
+	// publish sends a payload; it's a field so tests can run without a broker
+	publish   func(topic string, retained bool, payload []byte) error
+	connected func() bool
+	close     func()
+	announced map[string]bool
+	queue     chan mqttMessage
+	done      chan struct{}
+	closed    bool
+	mu        sync.Mutex
+}
+
+// haSensor describes one Home Assistant sensor derived from a Reading field
+type haSensor struct {
+	key         string
+	name        string
+	unit        string
+	deviceClass string
+}
+
+// haSensors are the Reading fields exposed to Home Assistant
+var haSensors = []haSensor{
+	{"temp_c", "Temperature", "°C", "temperature"},
+	{"humidity", "Humidity", "%", "humidity"},
+	{"dew_point_c", "Dew Point", "°C", "temperature"},
+	{"abs_humidity", "Absolute Humidity", "g/m³", ""},
+	{"battery", "Battery", "%", "battery"},
+	{"rssi", "Signal Strength", "dBm", "signal_strength"},
+}
+
+// NewMQTTPublisher connects to an MQTT broker in the background. The broker being
+// unreachable is not fatal: the client keeps retrying and readings are skipped meanwhile.
+func NewMQTTPublisher(broker, clientID, topicPrefix, discoveryPrefix string) *MQTTPublisher {
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID("govee-client-" + clientID).
+		SetConnectTimeout(mqttPublishTimeout).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(func(mqtt.Client) {
+			log.Printf("Connected to MQTT broker %s", broker)
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("Lost connection to MQTT broker: %v", err)
+		})
+
+	client := mqtt.NewClient(opts)
+	client.Connect()
+
+	mp := newMQTTPublisher(topicPrefix, discoveryPrefix)
+	mp.publish = func(topic string, retained bool, payload []byte) error {
+		token := client.Publish(topic, 0, retained, payload)
+		if !token.WaitTimeout(mqttPublishTimeout) {
+			return fmt.Errorf("timed out publishing to %s", topic)
+		}
+		return token.Error()
+	}
+	mp.connected = client.IsConnectionOpen
+	mp.close = func() { client.Disconnect(250) }
+	return mp
+}
+
+// newMQTTPublisher creates a publisher without a broker connection
+func newMQTTPublisher(topicPrefix, discoveryPrefix string) *MQTTPublisher {
+	mp := &MQTTPublisher{
+		topicPrefix:     strings.TrimSuffix(topicPrefix, "/"),
+		discoveryPrefix: strings.TrimSuffix(discoveryPrefix, "/"),
+		connected:       func() bool { return true },
+		close:           func() {},
+		announced:       make(map[string]bool),
+		queue:           make(chan mqttMessage, mqttQueueSize),
+		done:            make(chan struct{}),
+	}
+	go mp.run()
+	return mp
+}
+
+// run publishes queued readings until the queue is closed
+func (mp *MQTTPublisher) run() {
+	defer close(mp.done)
+	for msg := range mp.queue {
+		mp.publishReading(msg.reading, msg.model)
+	}
+}
+
+// mqttDeviceID turns a MAC address into a topic-safe identifier
+func mqttDeviceID(addr string) string {
+	return strings.ToLower(strings.ReplaceAll(addr, ":", ""))
+}
+
+// stateTopic returns the topic a device's readings are published to
+func (mp *MQTTPublisher) stateTopic(addr string) string {
+	return fmt.Sprintf("%s/%s/state", mp.topicPrefix, mqttDeviceID(addr))
+}
+
+// discoveryConfigs builds the retained Home Assistant discovery config for each sensor of a device
+func (mp *MQTTPublisher) discoveryConfigs(reading Reading, model string) (map[string][]byte, error) {
+	id := mqttDeviceID(reading.DeviceAddr)
+	device := map[string]interface{}{
+		"identifiers":  []string{"govee_" + id},
+		"name":         reading.DeviceName,
+		"manufacturer": "Govee",
+		"model":        model,
+	}
+
+	configs := make(map[string][]byte, len(haSensors))
+	for _, sensor := range haSensors {
+		config := map[string]interface{}{
+			"name":                sensor.name,
+			"unique_id":           fmt.Sprintf("govee_%s_%s", id, sensor.key),
+			"state_topic":         mp.stateTopic(reading.DeviceAddr),
+			"value_template":      fmt.Sprintf("{{ value_json.%s }}", sensor.key),
+			"unit_of_measurement": sensor.unit,
+			"state_class":         "measurement",
+			"device":              device,
+		}
+		if sensor.deviceClass != "" {
+			config["device_class"] = sensor.deviceClass
+		}
+
+		payload, err := json.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+		topic := fmt.Sprintf("%s/sensor/govee_%s/%s/config", mp.discoveryPrefix, id, sensor.key)
+		configs[topic] = payload
+	}
+	return configs, nil
+}
+
+// Publish queues a reading from a sensor of the given model for publishing. It never blocks:
+// if the broker is slow and the queue is full, the reading is dropped so MQTT never holds up the scan.
+func (mp *MQTTPublisher) Publish(reading Reading, model string) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if mp.closed {
+		return
+	}
+	select {
+	case mp.queue <- mqttMessage{reading: reading, model: model}:
+	default:
+		log.Printf("MQTT queue full, dropping reading for device %s", reading.DeviceAddr)
+	}
+}
+
+// publishReading sends a reading to <prefix>/<mac>/state, announcing the device first if needed.
+// Failures are logged and the reading is skipped.
+func (mp *MQTTPublisher) publishReading(reading Reading, model string) {
+	if !mp.connected() {
+		log.Printf("MQTT broker not connected, skipping reading for device %s", reading.DeviceAddr)
+		return
+	}
+
+	mp.mu.Lock()
+	announced := mp.announced[reading.DeviceAddr]
+	mp.mu.Unlock()
+
+	if !announced {
+		configs, err := mp.discoveryConfigs(reading, model)
+		if err != nil {
+			log.Printf("Failed to build MQTT discovery config for device %s: %v", reading.DeviceAddr, err)
+			return
+		}
+		for topic, payload := range configs {
+			if err := mp.publish(topic, true, payload); err != nil {
+				log.Printf("Failed to publish MQTT discovery config: %v", err)
+				return
+			}
+		}
+		mp.mu.Lock()
+		mp.announced[reading.DeviceAddr] = true
+		mp.mu.Unlock()
+	}
+
+	payload, err := json.Marshal(reading)
+	if err != nil {
+		log.Printf("Failed to marshal reading for MQTT: %v", err)
+		return
+	}
+	if err := mp.publish(mp.stateTopic(reading.DeviceAddr), false, payload); err != nil {
+		log.Printf("Failed to publish reading to MQTT: %v", err)
+	}
+}
+
+// Close publishes the readings still queued and disconnects from the broker
+func (mp *MQTTPublisher) Close() {
+	mp.mu.Lock()
+	if !mp.closed {
+		mp.closed = true
+		close(mp.queue)
+	}
+	mp.mu.Unlock()
+	<-mp.done
+	mp.close()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMQTT records published messages in place of a broker
+type fakeMQTT struct {
+	messages map[string][]byte
+	retained map[string]bool
+	fail     bool
+}
+
+func newFakeMQTTPublisher() (*MQTTPublisher, *fakeMQTT) {
+	fake := &fakeMQTT{messages: make(map[string][]byte), retained: make(map[string]bool)}
+	mp := newMQTTPublisher("govee/", "homeassistant")
+	mp.publish = func(topic string, retained bool, payload []byte) error {
+		if fake.fail {
+			return fmt.Errorf("broker unreachable")
+		}
+		fake.messages[topic] = payload
+		fake.retained[topic] = retained
+		return nil
+	}
+	return mp, fake
+}
+
+// TestMQTTPublishStateAndDiscovery tests the state payload and Home Assistant discovery topics
+func TestMQTTPublishStateAndDiscovery(t *testing.T) {
+	mp, fake := newFakeMQTTPublisher()
+	reading := Reading{
+		DeviceName: "GVH5075_8F19",
+		DeviceAddr: "A4:C1:38:25:A1:E3",
+		TempC:      22.4,
+		Humidity:   56.7,
+		Battery:    87,
+		Timestamp:  time.Now(),
+		ClientID:   "test-client",
+	}
+	mp.publishReading(reading, "H5075")
+
+	// State reuses the Reading JSON
+	state, ok := fake.messages["govee/a4c13825a1e3/state"]
+	if !ok {
+		t.Fatalf("Expected state topic to be published, got %v", fake.messages)
+	}
+	var decoded Reading
+	if err := json.Unmarshal(state, &decoded); err != nil {
+		t.Fatalf("Invalid state payload: %v", err)
+	}
+	if decoded.TempC != 22.4 || decoded.DeviceAddr != reading.DeviceAddr {
+		t.Errorf("Unexpected state payload: %+v", decoded)
+	}
+	if fake.retained["govee/a4c13825a1e3/state"] {
+		t.Error("State should not be retained")
+	}
+
+	// Discovery configs are retained and point at the state topic
+	topic := "homeassistant/sensor/govee_a4c13825a1e3/temp_c/config"
+	configData, ok := fake.messages[topic]
+	if !ok {
+		t.Fatalf("Expected discovery topic %s", topic)
+	}
+	if !fake.retained[topic] {
+		t.Error("Discovery config should be retained")
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(configData, &config); err != nil {
+		t.Fatalf("Invalid discovery payload: %v", err)
+	}
+	if config["state_topic"] != "govee/a4c13825a1e3/state" || config["device_class"] != "temperature" ||
+		config["value_template"] != "{{ value_json.temp_c }}" || config["unique_id"] != "govee_a4c13825a1e3_temp_c" {
+		t.Errorf("Unexpected discovery config: %v", config)
+	}
+	if device, _ := config["device"].(map[string]interface{}); device["model"] != "H5075" {
+		t.Errorf("Expected the device model to be H5075, got %v", config["device"])
+	}
+
+	discovery := 0
+	for topic := range fake.messages {
+		if strings.HasPrefix(topic, "homeassistant/") {
+			discovery++
+		}
+	}
+	if discovery != len(haSensors) {
+		t.Errorf("Expected %d discovery configs, got %d", len(haSensors), discovery)
+	}
+
+	// Discovery is only announced once per device
+	delete(fake.messages, topic)
+	mp.publishReading(reading, "H5075")
+	if _, ok := fake.messages[topic]; ok {
+		t.Error("Expected discovery config not to be republished")
+	}
+}
+
+// TestMQTTPublishBrokerUnavailable tests that an unreachable broker doesn't stop later announcements
+func TestMQTTPublishBrokerUnavailable(t *testing.T) {
+	mp, fake := newFakeMQTTPublisher()
+	reading := Reading{DeviceName: "GVH5075_8F19", DeviceAddr: "A4:C1:38:25:A1:E3", Timestamp: time.Now()}
+
+	// Disconnected: nothing is attempted
+	mp.connected = func() bool { return false }
+	mp.publishReading(reading, "H5075")
+	if len(fake.messages) != 0 {
+		t.Errorf("Expected nothing published while disconnected, got %d messages", len(fake.messages))
+	}
+
+	// Publish errors are logged, and discovery is retried on the next reading
+	mp.connected = func() bool { return true }
+	fake.fail = true
+	mp.publishReading(reading, "H5075")
+	fake.fail = false
+	mp.publishReading(reading, "H5075")
+	if _, ok := fake.messages["homeassistant/sensor/govee_a4c13825a1e3/humidity/config"]; !ok {
+		t.Error("Expected discovery to be retried after a failed publish")
+	}
+	if _, ok := fake.messages["govee/a4c13825a1e3/state"]; !ok {
+		t.Error("Expected state to be published once the broker is back")
+	}
+}
+
+// TestMQTTPublishQueue tests that readings are published in the background and a stuck broker doesn't block Publish
+func TestMQTTPublishQueue(t *testing.T) {
+	mp, fake := newFakeMQTTPublisher()
+	reading := Reading{DeviceName: "GVH5102_E5F6", DeviceAddr: "A4:C1:38:25:A1:E3", Timestamp: time.Now()}
+
+	// Close publishes what's still queued
+	mp.Publish(reading, "H5102")
+	mp.Close()
+	if _, ok := fake.messages["govee/a4c13825a1e3/state"]; !ok {
+		t.Fatal("Expected the queued reading to be published before Close returns")
+	}
+	var config map[string]interface{}
+	json.Unmarshal(fake.messages["homeassistant/sensor/govee_a4c13825a1e3/temp_c/config"], &config)
+	if device, _ := config["device"].(map[string]interface{}); device["model"] != "H5102" {
+		t.Errorf("Expected the device model to be H5102, got %v", config["device"])
+	}
+	mp.Publish(reading, "H5102") // ignored after Close
+
+	// With the broker stuck, Publish drops readings instead of waiting
+	mp, _ = newFakeMQTTPublisher()
+	unblock := make(chan struct{})
+	mp.publish = func(string, bool, []byte) error {
+		<-unblock
+		return nil
+	}
+	returned := make(chan struct{})
+	go func() {
+		for i := 0; i < mqttQueueSize+10; i++ {
+			mp.Publish(reading, "H5102")
+		}
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish blocked on a stuck broker")
+	}
+	close(unblock)
+	mp.Close()
+}
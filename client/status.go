@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// StatusServer serves the client's current state over HTTP, for checking on a client in the
+// field without going through the central server
+type StatusServer struct {
+	clientID  string
+	started   time.Time
+	devices   *DeviceRegistry
+	sendQueue *SendQueue // nil in local mode
+	server    *http.Server
+}
+
+// ClientStatus is the /status response
+type ClientStatus struct {
+	ClientID   string        `json:"client_id"`
+	Started    time.Time     `json:"started"`
+	Uptime     string        `json:"uptime"`
+	Devices    []GoveeDevice `json:"devices"`
+	QueueDepth int           `json:"queue_depth"`
+	// Omitted in local mode
+	SendQueue *SendQueueStats `json:"send_queue,omitempty"`
+}
+
+// NewStatusServer creates a status server for addr; sendQueue may be nil
+func NewStatusServer(addr, clientID string, devices *DeviceRegistry, sendQueue *SendQueue) *StatusServer {
+	ss := &StatusServer{
+		clientID:  clientID,
+		started:   time.Now(),
+		devices:   devices,
+		sendQueue: sendQueue,
+	}
+	ss.server = &http.Server{
+		Addr:              addr,
+		Handler:           ss.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return ss
+}
+
+// Handler returns the status server's routes
+func (ss *StatusServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", ss.handleStatus)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	return mux
+}
+
+// Status returns the client's current state, with devices sorted by address
+func (ss *StatusServer) Status() ClientStatus {
+	devices := ss.devices.Snapshot()
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Address < devices[j].Address })
+
+	status := ClientStatus{
+		ClientID: ss.clientID,
+		Started:  ss.started,
+		Uptime:   time.Since(ss.started).Round(time.Second).String(),
+		Devices:  devices,
+	}
+	if ss.sendQueue != nil {
+		status.QueueDepth = ss.sendQueue.Depth()
+		stats := ss.sendQueue.Stats()
+		status.SendQueue = &stats
+	}
+	return status
+}
+
+func (ss *StatusServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ss.Status())
+}
+
+// Start listens on the status address and serves in the background, returning an error if
+// the address can't be bound
+func (ss *StatusServer) Start() error {
+	listener, err := net.Listen("tcp", ss.server.Addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := ss.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Status server error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops the status server, waiting briefly for requests in progress
+func (ss *StatusServer) Shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := ss.server.Shutdown(ctx); err != nil {
+		log.Printf("Status server shutdown error: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestStatusServer tests the /status and /healthz endpoints of the local status server
+func TestStatusServer(t *testing.T) {
+	devices := NewDeviceRegistry()
+	devices.Store(GoveeDevice{Address: "A4:C1:38:25:A1:E3", Name: "GVH5075_A1E3", TempC: 21.5, Humidity: 45, Battery: 90})
+	devices.Store(GoveeDevice{Address: "A4:C1:38:11:22:33", Name: "GVH5075_2233", TempC: 19.0, Humidity: 50, Battery: 80})
+
+	queue := NewSendQueue(1, "http://localhost:9999", "test-api-key", false, "", "", "", 1*time.Second)
+	defer queue.Close()
+
+	ss := NewStatusServer("localhost:0", "test-client", devices, queue)
+	handler := ss.Handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	var status ClientStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	if status.ClientID != "test-client" {
+		t.Errorf("Expected client_id test-client, got %q", status.ClientID)
+	}
+	if len(status.Devices) != 2 || status.Devices[0].Address != "A4:C1:38:11:22:33" {
+		t.Errorf("Expected 2 devices sorted by address, got %+v", status.Devices)
+	}
+	if status.QueueDepth != 0 {
+		t.Errorf("Expected queue depth 0, got %d", status.QueueDepth)
+	}
+	if status.SendQueue == nil || status.SendQueue.Dropped != 0 {
+		t.Errorf("Expected send queue counters, got %+v", status.SendQueue)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/status", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for POST, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok\n" {
+		t.Errorf("Expected healthz ok, got %d %q", rec.Code, rec.Body.String())
+	}
+}
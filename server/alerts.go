@@ -0,0 +1,503 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// AlertRule is a threshold rule evaluated against every reading of a device
+type AlertRule struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	Metric     string    `json:"metric"`
+	Op         string    `json:"op"`
+	Value      float64   `json:"value"`
+	WebhookURL string    `json:"webhook_url"`
+	CreatedAt  time.Time `json:"created_at"`
+	// Breached is the rule's current state; the webhook only fires on the OK -> breached transition
+	Breached bool `json:"breached"`
+}
+
+// AlertEvent is the JSON payload posted to an alert webhook
+type AlertEvent struct {
+	Type        string    `json:"type"`
+	RuleID      string    `json:"rule_id,omitempty"`
+	Device      string    `json:"device"`
+	DisplayName string    `json:"display_name,omitempty"`
+	Metric      string    `json:"metric"`
+	Op          string    `json:"op"`
+	Threshold   float64   `json:"threshold"`
+	Value       float64   `json:"value"`
+	Message     string    `json:"message"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// deviceAlertState tracks which built-in alerts are currently raised for a device
+type deviceAlertState struct {
+	LowBattery bool
+	Offline    bool
+}
+
+// webhookTimeout bounds how long a single webhook delivery may take
+const webhookTimeout = 10 * time.Second
+
+// maxAlertHistory is how many alert events are kept for /alerts/history
+const maxAlertHistory = 200
+
+// readingMetric returns the value of a named metric from a reading
+func readingMetric(r *Reading, metric string) (float64, bool) {
+	switch metric {
+	case "temp_c":
+		return r.TempC, true
+	case "temp_f":
+		return r.TempF, true
+	case "humidity":
+		return r.Humidity, true
+	case "abs_humidity":
+		return r.AbsHumidity, true
+	case "dew_point_c":
+		return r.DewPointC, true
+	case "dew_point_f":
+		return r.DewPointF, true
+	case "steam_pressure":
+		return r.SteamPressure, true
+	case "heat_index_c":
+		return r.HeatIndexC, true
+	case "heat_index_f":
+		return r.HeatIndexF, true
+	case "vpd":
+		return r.VPD, true
+	case "frost_point_c":
+		return r.FrostPointC, true
+	case "mixing_ratio":
+		return r.MixingRatio, true
+	case "battery":
+		return float64(r.Battery), true
+	case "rssi":
+		return float64(r.RSSI), true
+	default:
+		return 0, false
+	}
+}
+
+// compareThreshold applies a comparison operator to a value and a threshold
+func compareThreshold(op string, value, threshold float64) (bool, error) {
+	switch op {
+	case ">":
+		return value > threshold, nil
+	case ">=":
+		return value >= threshold, nil
+	case "<":
+		return value < threshold, nil
+	case "<=":
+		return value <= threshold, nil
+	default:
+		return false, fmt.Errorf("unsupported operator %q", op)
+	}
+}
+
+// validateAlertRule checks that a rule is complete and well-formed
+func validateAlertRule(rule *AlertRule) error {
+	if rule.Device == "" {
+		return fmt.Errorf("device is required")
+	}
+	if _, ok := readingMetric(&Reading{}, rule.Metric); !ok {
+		return fmt.Errorf("unsupported metric %q", rule.Metric)
+	}
+	if _, err := compareThreshold(rule.Op, 0, 0); err != nil {
+		return err
+	}
+	u, err := url.Parse(rule.WebhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook_url must be an http or https URL")
+	}
+	return nil
+}
+
+// readAlertRules reads and validates the alert rules saved in alerts.json; a missing file means no rules
+func (s *Server) readAlertRules() (map[string]*AlertRule, error) {
+	rules := make(map[string]*AlertRule)
+	data, err := os.ReadFile(filepath.Join(s.config.StorageDir, "alerts.json"))
+	if os.IsNotExist(err) {
+		return rules, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse alerts.json: %v", err)
+	}
+	for id, rule := range rules {
+		if rule == nil || rule.ID != id {
+			return nil, fmt.Errorf("alerts.json: rule %s does not match its key", id)
+		}
+		if err := validateAlertRule(rule); err != nil {
+			return nil, fmt.Errorf("alerts.json: rule %s: %v", id, err)
+		}
+	}
+	return rules, nil
+}
+
+// reloadAlertRules replaces the alert rules, logging what changed. A rule that is unchanged
+// keeps its current breached state, so it doesn't fire again.
+func (s *Server) reloadAlertRules(rules map[string]*AlertRule) {
+	s.mu.Lock()
+	added, changed, removed := 0, 0, 0
+	for id, rule := range rules {
+		old, exists := s.alertRules[id]
+		switch {
+		case !exists:
+			added++
+		case old.Device != rule.Device || old.Metric != rule.Metric || old.Op != rule.Op ||
+			old.Value != rule.Value || old.WebhookURL != rule.WebhookURL:
+			changed++
+		default:
+			rule.Breached = old.Breached
+		}
+	}
+	for id := range s.alertRules {
+		if _, exists := rules[id]; !exists {
+			removed++
+		}
+	}
+	s.alertRules = rules
+	s.mu.Unlock()
+
+	if added+changed+removed == 0 {
+		log.Println("Config reload: no alert rules changed")
+	} else {
+		log.Printf("Config reload: alert rules reloaded from alerts.json (%d added, %d changed, %d removed)", added, changed, removed)
+	}
+}
+
+// generateAlertID returns a short random identifier for an alert rule
+func generateAlertID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Failed to generate alert ID: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// evaluateAlerts checks the device's rules against a new reading and fires a webhook
+// for every rule that goes from OK to breached.
+func (s *Server) evaluateAlerts(reading Reading) {
+	// Most readings change no rule's state, so check under the read lock first
+	// rather than making every reading wait for the write lock
+	if !s.alertStateChanges(reading) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, rule := range s.alertRules {
+		if rule.Device != reading.DeviceAddr {
+			continue
+		}
+
+		value, _ := readingMetric(&reading, rule.Metric)
+		breached, err := compareThreshold(rule.Op, value, rule.Value)
+		if err != nil {
+			continue
+		}
+
+		wasBreached := rule.Breached
+		rule.Breached = breached
+		if !breached || wasBreached {
+			continue
+		}
+
+		event := AlertEvent{
+			Type:        "threshold",
+			RuleID:      rule.ID,
+			Device:      rule.Device,
+			DisplayName: s.getDisplayName(rule.Device),
+			Metric:      rule.Metric,
+			Op:          rule.Op,
+			Threshold:   rule.Value,
+			Value:       value,
+			Message:     fmt.Sprintf("%s %s is %g (%s %g)", rule.Device, rule.Metric, value, rule.Op, rule.Value),
+			Timestamp:   reading.Timestamp,
+		}
+		log.Printf("Alert: %s", event.Message)
+		s.recordAlert(event)
+		s.notifyAlert(event, rule.WebhookURL)
+	}
+}
+
+// alertStateChanges reports whether a reading would breach or clear any of its device's rules
+func (s *Server) alertStateChanges(reading Reading) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, rule := range s.alertRules {
+		if rule.Device != reading.DeviceAddr {
+			continue
+		}
+		value, _ := readingMetric(&reading, rule.Metric)
+		if breached, err := compareThreshold(rule.Op, value, rule.Value); err == nil && breached != rule.Breached {
+			return true
+		}
+	}
+	return false
+}
+
+// recordAlert appends an event to the alert history, dropping the oldest beyond maxAlertHistory.
+// Caller must hold s.mu.
+func (s *Server) recordAlert(event AlertEvent) {
+	s.alertHistory = append(s.alertHistory, event)
+	if len(s.alertHistory) > maxAlertHistory {
+		s.alertHistory = s.alertHistory[len(s.alertHistory)-maxAlertHistory:]
+	}
+}
+
+// checkDeviceAlerts periodically raises low-battery and offline alerts
+func (s *Server) checkDeviceAlerts(ctx context.Context) {
+	interval := s.config.TimeoutCheckInterval
+	if interval <= 0 {
+		interval = 1 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.scanDeviceAlerts(time.Now())
+
+		case <-ctx.Done():
+			log.Println("Device alert checker shutting down")
+			return
+		}
+	}
+}
+
+// offlineAlertAfter returns how long a device may go unseen before it's reported offline
+func (rs runtimeSettings) offlineAlertAfter() time.Duration {
+	if rs.OfflineAlertAfter > 0 {
+		return rs.OfflineAlertAfter
+	}
+	return rs.ClientTimeout
+}
+
+// scanDeviceAlerts checks every device for low battery and for not having been seen
+// within the offline window, raising an alert only when a condition starts
+func (s *Server) scanDeviceAlerts(now time.Time) {
+	settings := s.settings()
+	offlineAfter := settings.offlineAlertAfter()
+
+	// Snapshot the devices first: shard locks must not be taken while holding s.mu
+	type deviceSnapshot struct {
+		battery  int
+		lastSeen time.Time
+	}
+	devices := make(map[string]deviceSnapshot)
+	s.rLockShards()
+	for _, shard := range s.shards {
+		for addr, device := range shard.devices {
+			devices[addr] = deviceSnapshot{battery: device.Battery, lastSeen: device.LastSeen}
+		}
+	}
+	s.rUnlockShards()
+
+	s.mu.Lock()
+	var events []AlertEvent
+	for addr, device := range devices {
+		state, exists := s.deviceAlertStates[addr]
+		if !exists {
+			state = &deviceAlertState{}
+			s.deviceAlertStates[addr] = state
+		}
+
+		lowBattery := settings.LowBatteryThreshold > 0 && device.battery < settings.LowBatteryThreshold
+		if lowBattery && !state.LowBattery {
+			events = append(events, AlertEvent{
+				Type:        "low_battery",
+				Device:      addr,
+				DisplayName: s.getDisplayName(addr),
+				Metric:      "battery",
+				Op:          "<",
+				Threshold:   float64(settings.LowBatteryThreshold),
+				Value:       float64(device.battery),
+				Message:     fmt.Sprintf("%s battery is at %d%%", addr, device.battery),
+				Timestamp:   now,
+			})
+		}
+		state.LowBattery = lowBattery
+
+		offlineFor := now.Sub(device.lastSeen)
+		offline := offlineAfter > 0 && offlineFor > offlineAfter
+		if offline && !state.Offline {
+			events = append(events, AlertEvent{
+				Type:        "offline",
+				Device:      addr,
+				DisplayName: s.getDisplayName(addr),
+				Metric:      "offline_seconds",
+				Op:          ">",
+				Threshold:   offlineAfter.Seconds(),
+				Value:       offlineFor.Round(time.Second).Seconds(),
+				Message:     fmt.Sprintf("%s not seen for %s", addr, offlineFor.Round(time.Second)),
+				Timestamp:   now,
+			})
+		}
+		state.Offline = offline
+	}
+
+	// Forget state for devices that have been pruned
+	for addr := range s.deviceAlertStates {
+		if _, exists := devices[addr]; !exists {
+			delete(s.deviceAlertStates, addr)
+		}
+	}
+
+	for _, event := range events {
+		log.Printf("Alert: %s", event.Message)
+		s.recordAlert(event)
+	}
+	s.mu.Unlock()
+
+	for _, event := range events {
+		s.notifyAlert(event, settings.AlertWebhookURL)
+	}
+}
+
+// handleAlertHistory returns recent alert events, newest first
+func (s *Server) handleAlertHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := maxAlertHistory
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	s.mu.RLock()
+	history := make([]AlertEvent, 0, len(s.alertHistory))
+	for i := len(s.alertHistory) - 1; i >= 0 && len(history) < limit; i-- {
+		history = append(history, s.alertHistory[i])
+	}
+	s.mu.RUnlock()
+
+	respondJSON(w, history)
+}
+
+// redactURL returns just the scheme and host of rawURL, leaving out any token in its path,
+// query or user info
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// isAdminRequest reports whether a request carries the admin API key (always true with auth disabled)
+func (s *Server) isAdminRequest(r *http.Request) bool {
+	return !s.auth.EnableAuth || keysEqual(requestAPIKey(r), s.adminKey())
+}
+
+// handleAlerts lists, creates and deletes threshold alert rules
+func (s *Server) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		// Webhook URLs often carry a token, so only the admin sees them in full
+		admin := s.isAdminRequest(r)
+		s.mu.RLock()
+		rules := make([]AlertRule, 0, len(s.alertRules))
+		for _, rule := range s.alertRules {
+			listed := *rule
+			if !admin {
+				listed.WebhookURL = redactURL(listed.WebhookURL)
+			}
+			rules = append(rules, listed)
+		}
+		s.mu.RUnlock()
+
+		sort.Slice(rules, func(i, j int) bool {
+			return rules[i].CreatedAt.Before(rules[j].CreatedAt)
+		})
+		respondJSON(w, rules)
+
+	case "POST":
+		if !s.isAdminRequest(r) {
+			http.Error(w, "Unauthorized: Admin API key required", http.StatusUnauthorized)
+			return
+		}
+		s.limitBody(w, r)
+
+		var rule AlertRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			respondBodyError(w, err)
+			return
+		}
+		if err := validateAlertRule(&rule); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid alert rule: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		rule.ID = generateAlertID()
+		rule.CreatedAt = time.Now()
+		rule.Breached = false
+
+		s.mu.Lock()
+		s.alertRules[rule.ID] = &rule
+		s.mu.Unlock()
+
+		if s.config.PersistenceEnabled {
+			s.saveData()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rule)
+
+	case "DELETE":
+		if !s.isAdminRequest(r) {
+			http.Error(w, "Unauthorized: Admin API key required", http.StatusUnauthorized)
+			return
+		}
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "Missing id parameter", http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		if _, exists := s.alertRules[id]; exists {
+			delete(s.alertRules, id)
+			s.mu.Unlock()
+
+			if s.config.PersistenceEnabled {
+				s.saveData()
+			}
+
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Alert rule deleted"))
+		} else {
+			s.mu.Unlock()
+			http.Error(w, "Alert rule not found", http.StatusNotFound)
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
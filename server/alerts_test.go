@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAlertRuleWebhookDebounce tests that a rule fires only when it goes from OK to breached
+func TestAlertRuleWebhookDebounce(t *testing.T) {
+	events := make(chan AlertEvent, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event AlertEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Invalid webhook payload: %v", err)
+		}
+		events <- event
+	}))
+	defer webhook.Close()
+
+	server := createTestServer(t)
+	deviceAddr := "AA:BB:CC:DD:EE:FF"
+
+	body := fmt.Sprintf(`{"device":%q,"metric":"temp_c","op":">","value":15,"webhook_url":%q}`, deviceAddr, webhook.URL)
+	req := httptest.NewRequest("POST", "/alerts", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	server.handleAlerts(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	addTemp := func(tempC float64) {
+		server.addReading(Reading{
+			DeviceName: "GVH5075_WINE",
+			DeviceAddr: deviceAddr,
+			TempC:      tempC,
+			Humidity:   60.0,
+			Battery:    90,
+			Timestamp:  time.Now(),
+			ClientID:   "test-client",
+		})
+	}
+	expectEvents := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			select {
+			case event := <-events:
+				if event.Type != "threshold" || event.Device != deviceAddr || event.Value <= 15 {
+					t.Errorf("Unexpected alert event: %+v", event)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("Expected %d webhook calls, got %d", n, i)
+			}
+		}
+		select {
+		case event := <-events:
+			t.Fatalf("Unexpected extra webhook call: %+v", event)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// Below threshold: nothing fires
+	addTemp(12.0)
+	expectEvents(0)
+
+	// Crossing the threshold fires once, staying above does not fire again
+	addTemp(16.0)
+	addTemp(17.0)
+	addTemp(18.0)
+	expectEvents(1)
+
+	// Recovering and breaching again fires again
+	addTemp(14.0)
+	addTemp(16.5)
+	expectEvents(1)
+
+	// Other devices don't trigger the rule
+	server.addReading(Reading{
+		DeviceName: "GVH5075_OTHER",
+		DeviceAddr: "11:22:33:44:55:66",
+		TempC:      30.0,
+		Timestamp:  time.Now(),
+		ClientID:   "test-client",
+	})
+	expectEvents(0)
+}
+
+// TestAlertsEndpoint tests listing, validation, admin enforcement and deletion of alert rules
+func TestAlertsEndpoint(t *testing.T) {
+	adminKey := "admin-key-123"
+	server := createTestServerWithAuth(t, adminKey, map[string]string{"client-key": "client-1"})
+
+	valid := `{"device":"AA:BB:CC:DD:EE:FF","metric":"humidity","op":"<","value":30,"webhook_url":"https://example.com/hook?token=secret"}`
+
+	tests := []struct {
+		name           string
+		apiKey         string
+		body           string
+		expectedStatus int
+	}{
+		{"Client key rejected", "client-key", valid, http.StatusUnauthorized},
+		{"Unknown metric", adminKey, `{"device":"AA:BB:CC:DD:EE:FF","metric":"pressure","op":">","value":1,"webhook_url":"https://example.com/hook"}`, http.StatusBadRequest},
+		{"Unknown operator", adminKey, `{"device":"AA:BB:CC:DD:EE:FF","metric":"temp_c","op":"!=","value":1,"webhook_url":"https://example.com/hook"}`, http.StatusBadRequest},
+		{"Invalid webhook", adminKey, `{"device":"AA:BB:CC:DD:EE:FF","metric":"temp_c","op":">","value":1,"webhook_url":"ftp://example.com"}`, http.StatusBadRequest},
+		{"Missing device", adminKey, `{"metric":"temp_c","op":">","value":1,"webhook_url":"https://example.com/hook"}`, http.StatusBadRequest},
+		{"Admin creates rule", adminKey, valid, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/alerts", strings.NewReader(tt.body))
+			req.Header.Set("X-API-Key", tt.apiKey)
+			w := httptest.NewRecorder()
+			server.handleAlerts(w, req)
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// List rules; only the admin sees the webhook's token
+	list := func(apiKey string) []AlertRule {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/alerts", nil)
+		req.Header.Set("X-API-Key", apiKey)
+		w := httptest.NewRecorder()
+		server.handleAlerts(w, req)
+		var rules []AlertRule
+		if err := json.NewDecoder(w.Body).Decode(&rules); err != nil {
+			t.Fatalf("Failed to decode rules: %v", err)
+		}
+		return rules
+	}
+	rules := list(adminKey)
+	if len(rules) != 1 || rules[0].Metric != "humidity" || rules[0].ID == "" {
+		t.Fatalf("Unexpected rules: %+v", rules)
+	}
+	if rules[0].WebhookURL != "https://example.com/hook?token=secret" {
+		t.Errorf("Expected the admin to see the full webhook URL, got %q", rules[0].WebhookURL)
+	}
+	if got := list("client-key"); len(got) != 1 || got[0].WebhookURL != "https://example.com" {
+		t.Errorf("Expected a client key to see only the webhook host, got %+v", got)
+	}
+
+	// Delete it
+	req := httptest.NewRequest("DELETE", "/alerts?id="+rules[0].ID, nil)
+	req.Header.Set("X-API-Key", adminKey)
+	w := httptest.NewRecorder()
+	server.handleAlerts(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 deleting rule, got %d", w.Code)
+	}
+	if len(server.alertRules) != 0 {
+		t.Errorf("Expected no rules after delete, got %d", len(server.alertRules))
+	}
+}
+
+// TestAlertRulesPersistence tests that alert rules survive a save/load cycle
+func TestAlertRulesPersistence(t *testing.T) {
+	server := createTestServer(t)
+	server.alertRules["rule-1"] = &AlertRule{
+		ID:         "rule-1",
+		Device:     "AA:BB:CC:DD:EE:FF",
+		Metric:     "temp_c",
+		Op:         ">",
+		Value:      15,
+		WebhookURL: "https://example.com/hook",
+		CreatedAt:  time.Now(),
+	}
+	server.saveData()
+
+	loaded := createTestServer(t)
+	loaded.config.StorageDir = server.config.StorageDir
+	loaded.loadData()
+
+	rule, exists := loaded.alertRules["rule-1"]
+	if !exists {
+		t.Fatal("Expected alert rule to be loaded")
+	}
+	if rule.Metric != "temp_c" || rule.Value != 15 || rule.WebhookURL != "https://example.com/hook" {
+		t.Errorf("Unexpected loaded rule: %+v", rule)
+	}
+
+	// Deleting the last rule is saved too
+	delete(server.alertRules, "rule-1")
+	server.saveData()
+	reloaded := createTestServer(t)
+	reloaded.config.StorageDir = server.config.StorageDir
+	reloaded.loadData()
+	if len(reloaded.alertRules) != 0 {
+		t.Errorf("Expected the deleted rule to stay deleted, got %d rules", len(reloaded.alertRules))
+	}
+}
+
+// TestLowBatteryAndOfflineAlerts tests that built-in alerts fire once per condition and are recorded in history
+func TestLowBatteryAndOfflineAlerts(t *testing.T) {
+	events := make(chan AlertEvent, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event AlertEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Invalid webhook payload: %v", err)
+		}
+		events <- event
+	}))
+	defer webhook.Close()
+
+	server := createTestServer(t)
+	server.config.LowBatteryThreshold = 20
+	server.config.OfflineAlertAfter = 10 * time.Minute
+	server.config.AlertWebhookURL = webhook.URL
+
+	server.addReading(Reading{
+		DeviceName: "GVH5075_LOW",
+		DeviceAddr: "AA:BB:CC:DD:EE:01",
+		TempC:      20.0,
+		Battery:    12,
+		Timestamp:  time.Now(),
+		ClientID:   "test-client",
+	})
+	server.addReading(Reading{
+		DeviceName: "GVH5075_OK",
+		DeviceAddr: "AA:BB:CC:DD:EE:02",
+		TempC:      20.0,
+		Battery:    95,
+		Timestamp:  time.Now(),
+		ClientID:   "test-client",
+	})
+
+	// First scan: only the low battery fires
+	server.scanDeviceAlerts(time.Now())
+	// Second scan: nothing new
+	server.scanDeviceAlerts(time.Now())
+	// Much later: both devices are offline
+	server.scanDeviceAlerts(time.Now().Add(time.Hour))
+	server.scanDeviceAlerts(time.Now().Add(2 * time.Hour))
+
+	received := map[string]int{}
+	for i := 0; i < 3; i++ {
+		select {
+		case event := <-events:
+			received[event.Type+" "+event.Device]++
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected 3 webhook calls, got %d: %v", i, received)
+		}
+	}
+	expected := map[string]int{
+		"low_battery AA:BB:CC:DD:EE:01": 1,
+		"offline AA:BB:CC:DD:EE:01":     1,
+		"offline AA:BB:CC:DD:EE:02":     1,
+	}
+	for key, count := range expected {
+		if received[key] != count {
+			t.Errorf("Expected %d %q webhook calls, got %d", count, key, received[key])
+		}
+	}
+
+	// History lists the events newest first
+	req := httptest.NewRequest("GET", "/alerts/history", nil)
+	w := httptest.NewRecorder()
+	server.handleAlertHistory(w, req)
+	var history []AlertEvent
+	if err := json.NewDecoder(w.Body).Decode(&history); err != nil {
+		t.Fatalf("Failed to decode history: %v", err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("Expected 3 history entries, got %d", len(history))
+	}
+	if history[0].Type != "offline" || history[2].Type != "low_battery" {
+		t.Errorf("Expected newest first, got %s ... %s", history[0].Type, history[2].Type)
+	}
+	if history[2].Timestamp.IsZero() {
+		t.Error("Expected history entries to carry timestamps")
+	}
+
+	req = httptest.NewRequest("GET", "/alerts/history?limit=1", nil)
+	w = httptest.NewRecorder()
+	server.handleAlertHistory(w, req)
+	history = nil
+	json.NewDecoder(w.Body).Decode(&history)
+	if len(history) != 1 {
+		t.Errorf("Expected 1 history entry with limit=1, got %d", len(history))
+	}
+}
+
+// TestOfflineAlertsAfterRestart tests that devices already offline when the server starts
+// aren't alerted on again, while devices that go offline later still are
+func TestOfflineAlertsAfterRestart(t *testing.T) {
+	server := createTestServer(t)
+	server.config.LowBatteryThreshold = 0
+	server.config.OfflineAlertAfter = 10 * time.Minute
+
+	now := time.Now()
+	devices := map[string]*DeviceStatus{
+		"AA:BB:CC:DD:EE:01": {DeviceName: "GVH5075_GONE", DeviceAddr: "AA:BB:CC:DD:EE:01", LastSeen: now.Add(-2 * time.Hour)},
+		"AA:BB:CC:DD:EE:02": {DeviceName: "GVH5075_HERE", DeviceAddr: "AA:BB:CC:DD:EE:02", LastSeen: now},
+	}
+	data, _ := json.Marshal(devices)
+	if err := os.WriteFile(filepath.Join(server.config.StorageDir, "devices.json"), data, 0644); err != nil {
+		t.Fatalf("Failed to write devices.json: %v", err)
+	}
+	server.loadData()
+
+	server.scanDeviceAlerts(now)
+	if len(server.alertHistory) != 0 {
+		t.Fatalf("Expected no alerts for a device offline before the restart, got %+v", server.alertHistory)
+	}
+
+	server.scanDeviceAlerts(now.Add(time.Hour))
+	if len(server.alertHistory) != 1 || server.alertHistory[0].Device != "AA:BB:CC:DD:EE:02" {
+		t.Errorf("Expected one offline alert for the device seen after the restart, got %+v", server.alertHistory)
+	}
+}
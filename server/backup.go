@@ -0,0 +1,389 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxRestoreBytes bounds both the uploaded archive and the total size unpacked from it
+const maxRestoreBytes = 4 << 30
+
+// restoreDirPrefix names the directories a restore unpacks into and moves the old files to.
+// They live inside the storage directory, which may be a mount point that can't be renamed,
+// so that moving files in and out of them never crosses a filesystem.
+const restoreDirPrefix = ".restore-"
+
+// transferTimeout replaces the server's read and write timeouts for backups, restores and
+// exports, which can take far longer than an API call
+const transferTimeout = 30 * time.Minute
+
+// extendDeadlines gives a long upload or download transferTimeout to finish. Failing to set
+// them (e.g. a writer that can't reach the connection) leaves the server's timeouts in place.
+func extendDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(transferTimeout)
+	if err := rc.SetReadDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("Failed to extend read deadline: %v", err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("Failed to extend write deadline: %v", err)
+	}
+}
+
+// isDatabaseFile reports whether a file in the storage directory belongs to a SQLite database.
+// These are left out of backups (a copy of an open database may be torn; use sqlite3 .backup)
+// and kept in place on restore.
+func isDatabaseFile(name string) bool {
+	for _, suffix := range []string{".db", ".db-wal", ".db-shm", ".db-journal"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleBackup streams a tar.gz of the storage directory, after saving the current state
+func (s *Server) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.isAdminRequest(r) {
+		http.Error(w, "Unauthorized: Admin API key required", http.StatusUnauthorized)
+		return
+	}
+	if !s.config.PersistenceEnabled {
+		http.Error(w, "Persistence is disabled", http.StatusConflict)
+		return
+	}
+
+	s.saveData()
+	extendDeadlines(w)
+
+	// Keep a restore from swapping the files out mid-backup
+	s.restoreMu.RLock()
+	defer s.restoreMu.RUnlock()
+
+	filename := fmt.Sprintf("govee-backup-%s.tar.gz", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	files, err := writeBackupArchive(w, s.config.StorageDir)
+	if err != nil {
+		// Headers are sent; the truncated archive fails to unpack, which is the best we can do
+		log.Printf("Backup failed after %d files: %v", files, err)
+		return
+	}
+	log.Printf("Backup of %d files from %s sent", files, s.config.StorageDir)
+}
+
+// writeBackupArchive writes the files under dir to w as a tar.gz, with paths relative to dir,
+// and returns how many files it wrote
+func writeBackupArchive(w io.Writer, dir string) (int, error) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	files := 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if d.IsDir() && strings.HasPrefix(rel, restoreDirPrefix) {
+			// A restore in progress, or left behind by a crash
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && (!d.Type().IsRegular() || isDatabaseFile(d.Name())) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     filepath.ToSlash(rel) + "/",
+				Mode:     int64(info.Mode().Perm()),
+				ModTime:  info.ModTime(),
+			})
+		}
+
+		// Read the whole file first: a save may rewrite it while we go, and the size in the
+		// header has to match what follows
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     filepath.ToSlash(rel),
+			Mode:     int64(info.Mode().Perm()),
+			Size:     int64(len(data)),
+			ModTime:  info.ModTime(),
+		}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+		files++
+		return nil
+	})
+	if err != nil {
+		return files, err
+	}
+	if err := tw.Close(); err != nil {
+		return files, err
+	}
+	return files, gz.Close()
+}
+
+// handleRestore replaces the storage directory with the contents of a backup archive and
+// reloads the server's state from it
+func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.isAdminRequest(r) {
+		http.Error(w, "Unauthorized: Admin API key required", http.StatusUnauthorized)
+		return
+	}
+	if !s.config.PersistenceEnabled {
+		http.Error(w, "Persistence is disabled", http.StatusConflict)
+		return
+	}
+	extendDeadlines(w)
+	r.Body = http.MaxBytesReader(w, r.Body, maxRestoreBytes)
+
+	storageDir := filepath.Clean(s.config.StorageDir)
+	if err := os.MkdirAll(storageDir, 0755); err != nil {
+		log.Printf("Failed to create storage directory: %v", err)
+		http.Error(w, "Restore failed", http.StatusInternalServerError)
+		return
+	}
+	// Unpack inside the storage directory, so the swap is a rename on the same filesystem
+	tmpDir, err := os.MkdirTemp(storageDir, restoreDirPrefix+"*")
+	if err != nil {
+		log.Printf("Failed to create restore directory: %v", err)
+		http.Error(w, "Restore failed", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files, err := extractBackupArchive(r.Body, tmpDir)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "Backup archive too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Invalid backup archive: %v", err), http.StatusBadRequest)
+		return
+	}
+	if files == 0 {
+		http.Error(w, "Invalid backup archive: no files", http.StatusBadRequest)
+		return
+	}
+
+	// Saves wait until the reload is done, so they can't write the old state over the restored files
+	s.restoreMu.Lock()
+	err = s.swapStorageDir(storageDir, tmpDir)
+	var devices int
+	if err == nil {
+		devices = s.reloadData()
+	}
+	s.restoreMu.Unlock()
+	if err != nil {
+		log.Printf("Restore failed: %v", err)
+		http.Error(w, "Restore failed", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Restored %d files to %s (%d devices)", files, storageDir, devices)
+	respondJSON(w, map[string]int{"files": files, "devices": devices})
+}
+
+// extractBackupArchive unpacks a tar.gz into dir and returns how many files it held. Only
+// plain files and directories are accepted, and every path must stay inside dir.
+func extractBackupArchive(r io.Reader, dir string) (int, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	files := 0
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return files, err
+		}
+
+		name := filepath.FromSlash(strings.TrimSuffix(hdr.Name, "/"))
+		if !filepath.IsLocal(name) {
+			return files, fmt.Errorf("path %q escapes the storage directory", hdr.Name)
+		}
+		if strings.HasPrefix(name, restoreDirPrefix) {
+			return files, fmt.Errorf("unexpected entry %q", hdr.Name)
+		}
+		target := filepath.Join(dir, name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return files, err
+			}
+		case tar.TypeReg:
+			if isDatabaseFile(name) {
+				return files, fmt.Errorf("unexpected database file %q", hdr.Name)
+			}
+			total += hdr.Size
+			if total > maxRestoreBytes {
+				return files, fmt.Errorf("unpacks to more than %d bytes", int64(maxRestoreBytes))
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return files, err
+			}
+			// auth.json holds key hashes and alerts.json webhook URLs, so nothing is world-readable
+			f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				return files, err
+			}
+			_, err = io.CopyN(f, tr, hdr.Size)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return files, err
+			}
+			files++
+		default:
+			return files, fmt.Errorf("unsupported entry %q (only files and directories)", hdr.Name)
+		}
+	}
+}
+
+// swapStorageDir replaces the contents of storageDir with those of newDir, a directory inside
+// it. The SQLite database files, which aren't part of a backup, are left where they are, and so
+// are the restore directories. The storage directory itself stays put, as it may be a mount
+// point. Storage maintenance waits until it's done. Caller must hold s.restoreMu.
+func (s *Server) swapStorageDir(storageDir, newDir string) error {
+	s.storageManager.maintenanceMu.Lock()
+	defer s.storageManager.maintenanceMu.Unlock()
+	s.storageManager.mu.Lock()
+	defer s.storageManager.mu.Unlock()
+
+	oldDir := newDir + ".old"
+	if err := os.Mkdir(oldDir, 0700); err != nil {
+		return err
+	}
+	// Only goes if empty: after a failed swap, anything that couldn't be moved back stays there
+	defer os.Remove(oldDir)
+	entries, err := os.ReadDir(storageDir)
+	if err != nil {
+		return err
+	}
+	var moved []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), restoreDirPrefix) || (e.Type().IsRegular() && isDatabaseFile(e.Name())) {
+			continue
+		}
+		if err := os.Rename(filepath.Join(storageDir, e.Name()), filepath.Join(oldDir, e.Name())); err != nil {
+			moveEntries(oldDir, storageDir, moved)
+			return err
+		}
+		moved = append(moved, e.Name())
+	}
+
+	restored, err := os.ReadDir(newDir)
+	if err != nil {
+		moveEntries(oldDir, storageDir, moved)
+		return err
+	}
+	var placed []string
+	for _, e := range restored {
+		if err := os.Rename(filepath.Join(newDir, e.Name()), filepath.Join(storageDir, e.Name())); err != nil {
+			// Put the old files back so the server keeps running on its data
+			moveEntries(storageDir, newDir, placed)
+			moveEntries(oldDir, storageDir, moved)
+			return err
+		}
+		placed = append(placed, e.Name())
+	}
+
+	if err := os.RemoveAll(oldDir); err != nil {
+		log.Printf("Failed to remove the replaced storage files in %s: %v", oldDir, err)
+	}
+	return nil
+}
+
+// moveEntries renames the named entries of from into to, logging any that can't be moved. It
+// undoes a partial swap, where there's nothing better to do with a failure than report it.
+func moveEntries(from, to string, names []string) {
+	for _, name := range names {
+		if err := os.Rename(filepath.Join(from, name), filepath.Join(to, name)); err != nil {
+			log.Printf("Failed to move %s back to %s: %v", name, to, err)
+		}
+	}
+}
+
+// reloadData discards the in-memory state and loads it again from the storage directory,
+// returning how many devices were loaded
+func (s *Server) reloadData() int {
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+	}
+	s.clientsMu.Lock()
+	s.mu.Lock()
+
+	for _, shard := range s.shards {
+		shard.devices = make(map[string]*DeviceStatus)
+		shard.readings = make(map[string]*readingRing)
+	}
+	s.clients = make(map[string]*ClientStatus)
+	s.alertRules = make(map[string]*AlertRule)
+	s.deviceAliases = make(map[string]string)
+	s.deviceMetadata = make(map[string]*DeviceMetadata)
+	s.deviceAlertStates = make(map[string]*deviceAlertState)
+	s.loadData()
+
+	// Usage tracked since startup belongs to the replaced keys; restored keys carry their own
+	s.keyUsageMu.Lock()
+	s.keyUsage = make(map[string]*keyUsage)
+	s.keyUsageMu.Unlock()
+
+	devices := 0
+	for _, shard := range s.shards {
+		devices += len(shard.devices)
+	}
+
+	s.mu.Unlock()
+	s.clientsMu.Unlock()
+	for _, shard := range s.shards {
+		shard.mu.Unlock()
+	}
+
+	s.dashboardCache.Clear()
+	return devices
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestBackupRestore tests that a backup taken before the data is wiped restores the devices,
+// aliases and partition files, and keeps the SQLite database in place
+func TestBackupRestore(t *testing.T) {
+	server := createTestServer(t)
+	server.config.PersistenceEnabled = true
+	storageDir := server.config.StorageDir
+
+	for _, addr := range []string{"AA:BB:CC:DD:EE:01", "AA:BB:CC:DD:EE:02"} {
+		server.addReading(Reading{
+			DeviceName: "GVH5075_TEST",
+			DeviceAddr: addr,
+			TempC:      21.0,
+			Humidity:   50.0,
+			Battery:    90,
+			Timestamp:  time.Now(),
+			ClientID:   "test-client",
+		})
+	}
+	server.deviceAliases["AA:BB:CC:DD:EE:01"] = "Kitchen"
+	if err := os.WriteFile(filepath.Join(storageDir, "govee.db"), []byte("database"), 0644); err != nil {
+		t.Fatalf("Failed to write database file: %v", err)
+	}
+	// Left behind by a crashed restore; backups skip it
+	os.MkdirAll(filepath.Join(storageDir, restoreDirPrefix+"crashed"), 0755)
+	os.WriteFile(filepath.Join(storageDir, restoreDirPrefix+"crashed", "devices.json"), []byte("{}"), 0644)
+
+	req := httptest.NewRequest("GET", "/admin/backup", nil)
+	w := httptest.NewRecorder()
+	server.handleBackup(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/gzip" {
+		t.Fatalf("Expected a gzip backup, got %d %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	backup := w.Body.Bytes()
+
+	// Lose everything but the database
+	entries, _ := os.ReadDir(storageDir)
+	for _, e := range entries {
+		if e.Name() != "govee.db" {
+			os.RemoveAll(filepath.Join(storageDir, e.Name()))
+		}
+	}
+	server.reloadData()
+	if deviceStatus(server, "AA:BB:CC:DD:EE:01") != nil {
+		t.Fatal("Expected the wiped server to have no devices")
+	}
+	// Files that aren't in the backup are replaced along with the rest
+	os.WriteFile(filepath.Join(storageDir, "stale.json"), []byte("{}"), 0644)
+
+	req = httptest.NewRequest("POST", "/admin/restore", bytes.NewReader(backup))
+	w = httptest.NewRecorder()
+	server.handleRestore(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var result map[string]int
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil || result["devices"] != 2 {
+		t.Errorf("Expected 2 devices restored, got %v (%v)", result, err)
+	}
+
+	for _, addr := range []string{"AA:BB:CC:DD:EE:01", "AA:BB:CC:DD:EE:02"} {
+		if device := deviceStatus(server, addr); device == nil || device.TempC != 21.0 {
+			t.Errorf("Expected device %s to be restored, got %+v", addr, device)
+		}
+	}
+	if alias := server.getDisplayName("AA:BB:CC:DD:EE:01"); alias != "Kitchen" {
+		t.Errorf("Expected the alias to be restored, got %q", alias)
+	}
+	if readings, err := server.storageManager.loadReadings("AA:BB:CC:DD:EE:02", time.Time{}, time.Time{}); err != nil || len(readings) != 1 {
+		t.Errorf("Expected the partition file to be restored, got %d readings (%v)", len(readings), err)
+	}
+	if data, err := os.ReadFile(filepath.Join(storageDir, "govee.db")); err != nil || string(data) != "database" {
+		t.Errorf("Expected the database to be kept, got %q (%v)", data, err)
+	}
+	if info, err := os.Stat(storageDir); err != nil || !info.IsDir() {
+		t.Fatalf("Expected the storage directory to stay in place: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(storageDir, "stale.json")); !os.IsNotExist(err) {
+		t.Error("Expected files missing from the backup to be removed")
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(storageDir, restoreDirPrefix+"*"))
+	if len(leftovers) != 0 {
+		t.Errorf("Expected the restore directories to be removed, found %v", leftovers)
+	}
+}
+
+// TestReloadDataAPIKeys tests that reloading replaces the API keys safely while requests are
+// being authenticated, and that restored keys keep their saved usage
+func TestReloadDataAPIKeys(t *testing.T) {
+	server := createTestServerWithAuth(t, "admin-key", map[string]string{"client-key": "client-1"})
+	handler := server.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	get := func() int {
+		req := httptest.NewRequest("GET", "/devices", nil)
+		req.Header.Set("X-API-Key", "client-key")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("Expected the client key to be accepted, got %d", code)
+	}
+
+	// A backup of the same key with more requests on record
+	keyHash := hashAPIKey("client-key")
+	if err := server.writeAuthFile(&AuthConfig{
+		EnableAuth: true,
+		APIKeys:    map[string]APIKey{keyHash: {ClientID: "client-1", Enabled: true, Requests: 10}},
+	}); err != nil {
+		t.Fatalf("Failed to write auth.json: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			get()
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		server.reloadData()
+	}
+	<-done
+
+	server.reloadData()
+	server.mu.RLock()
+	key := server.withKeyUsage(keyHash, server.auth.APIKeys[keyHash])
+	server.mu.RUnlock()
+	if key.Requests != 10 {
+		t.Errorf("Expected the restored usage of 10 requests, got %d", key.Requests)
+	}
+}
+
+// TestRestoreRejectsUnsafeArchives tests that archives escaping the storage directory, or
+// holding links, are refused without touching the current data
+func TestRestoreRejectsUnsafeArchives(t *testing.T) {
+	server := createTestServer(t)
+	server.config.PersistenceEnabled = true
+	server.addReading(Reading{
+		DeviceName: "GVH5075_TEST",
+		DeviceAddr: "AA:BB:CC:DD:EE:01",
+		TempC:      21.0,
+		Humidity:   50.0,
+		Battery:    90,
+		Timestamp:  time.Now(),
+		ClientID:   "test-client",
+	})
+	server.saveData()
+
+	archive := func(headers ...*tar.Header) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for _, hdr := range headers {
+			tw.WriteHeader(hdr)
+			if hdr.Size > 0 {
+				tw.Write(bytes.Repeat([]byte("x"), int(hdr.Size)))
+			}
+		}
+		tw.Close()
+		gz.Close()
+		return buf.Bytes()
+	}
+
+	tests := map[string][]byte{
+		"parent path":   archive(&tar.Header{Typeflag: tar.TypeReg, Name: "../escaped.json", Size: 2, Mode: 0644}),
+		"absolute path": archive(&tar.Header{Typeflag: tar.TypeReg, Name: "/tmp/escaped.json", Size: 2, Mode: 0644}),
+		"nested parent": archive(&tar.Header{Typeflag: tar.TypeReg, Name: "partitions/../../escaped.json", Size: 2, Mode: 0644}),
+		"symlink":       archive(&tar.Header{Typeflag: tar.TypeSymlink, Name: "devices.json", Linkname: "/etc/passwd"}),
+		"empty":         archive(),
+		"not gzip":      []byte("devices.json"),
+	}
+	for name, body := range tests {
+		req := httptest.NewRequest("POST", "/admin/restore", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		server.handleRestore(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(filepath.Dir(server.config.StorageDir), "escaped.json")); !os.IsNotExist(err) {
+		t.Error("Expected nothing to be written outside the storage directory")
+	}
+	if deviceStatus(server, "AA:BB:CC:DD:EE:01") == nil {
+		t.Error("Expected the current data to be kept after a refused restore")
+	}
+}
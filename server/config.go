@@ -0,0 +1,269 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Default per-IP request rate limit, used unless -rate-limit and -rate-burst say otherwise
+const (
+	defaultRateLimit = 10.0
+	defaultRateBurst = 20
+)
+
+// logLevel filters the server's per-request log messages; startup messages and errors are
+// always logged
+type logLevel int32
+
+const (
+	// logLevelDebug also logs every accepted reading
+	logLevelDebug logLevel = -1
+	// logLevelInfo logs rate limiting and rejected readings; the zero value, so it's the default
+	logLevelInfo logLevel = 0
+	// logLevelWarn leaves out the per-request messages of logLevelInfo
+	logLevelWarn logLevel = 1
+)
+
+// parseLogLevel parses a -log-level value; empty means info
+func parseLogLevel(name string) (logLevel, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return logLevelDebug, nil
+	case "", "info":
+		return logLevelInfo, nil
+	case "warn":
+		return logLevelWarn, nil
+	default:
+		return logLevelInfo, fmt.Errorf("log-level must be debug, info or warn")
+	}
+}
+
+// logf logs a message if the current -log-level lets messages of this level through
+func (s *Server) logf(level logLevel, format string, args ...interface{}) {
+	if level >= logLevel(s.logLevel.Load()) {
+		log.Printf(format, args...)
+	}
+}
+
+// runtimeSettings are the settings that can be changed while the server runs, by editing
+// the -config file and sending SIGHUP. Everything else needs a restart. A reload also
+// re-reads the threshold alert rules from alerts.json (see reloadAlertRules).
+type runtimeSettings struct {
+	ClientTimeout       time.Duration
+	RateLimit           float64
+	RateBurst           int
+	LowBatteryThreshold int
+	OfflineAlertAfter   time.Duration
+	AlertWebhookURL     string
+	LogLevel            string
+}
+
+// registerFlags defines the flags for the runtime settings on fs, with their defaults
+func (rs *runtimeSettings) registerFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&rs.ClientTimeout, "timeout", 5*time.Minute, "client inactivity timeout")
+	fs.Float64Var(&rs.RateLimit, "rate-limit", defaultRateLimit, "requests per second allowed from each client IP")
+	fs.IntVar(&rs.RateBurst, "rate-burst", defaultRateBurst, "requests a client IP may make at once above -rate-limit")
+	fs.IntVar(&rs.LowBatteryThreshold, "alert-battery", 15, "raise a low-battery alert when a device's battery drops below this percent (0 to disable)")
+	fs.DurationVar(&rs.OfflineAlertAfter, "alert-offline-after", 0, "raise an offline alert when a device is not seen for this long (0 uses -timeout)")
+	fs.StringVar(&rs.AlertWebhookURL, "alert-webhook", "", "webhook URL for low-battery and offline alerts (empty to only record them)")
+	fs.StringVar(&rs.LogLevel, "log-level", "info", "per-request logging: debug (also every accepted reading), info (rate limiting and rejected readings) or warn (neither)")
+}
+
+// validate checks the settings are usable
+func (rs runtimeSettings) validate() error {
+	if rs.ClientTimeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	if rs.RateLimit <= 0 || rs.RateBurst < 1 {
+		return fmt.Errorf("rate-limit must be positive and rate-burst at least 1")
+	}
+	if rs.LowBatteryThreshold < 0 || rs.LowBatteryThreshold > 100 {
+		return fmt.Errorf("alert-battery must be between 0 and 100")
+	}
+	if rs.OfflineAlertAfter < 0 {
+		return fmt.Errorf("alert-offline-after must not be negative")
+	}
+	if rs.AlertWebhookURL != "" {
+		u, err := url.Parse(rs.AlertWebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("alert-webhook must be an http or https URL")
+		}
+	}
+	if _, err := parseLogLevel(rs.LogLevel); err != nil {
+		return err
+	}
+	return nil
+}
+
+// settings returns the current runtime settings
+func (s *Server) settings() runtimeSettings {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return runtimeSettings{
+		ClientTimeout:       s.config.ClientTimeout,
+		RateLimit:           s.config.RateLimit,
+		RateBurst:           s.config.RateBurst,
+		LowBatteryThreshold: s.config.LowBatteryThreshold,
+		OfflineAlertAfter:   s.config.OfflineAlertAfter,
+		AlertWebhookURL:     s.config.AlertWebhookURL,
+		LogLevel:            s.config.LogLevel,
+	}
+}
+
+// applySettings switches the server to new runtime settings, logging each one that changed
+func (s *Server) applySettings(next runtimeSettings) {
+	s.configMu.Lock()
+	changes := []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"timeout", s.config.ClientTimeout, next.ClientTimeout},
+		{"rate-limit", s.config.RateLimit, next.RateLimit},
+		{"rate-burst", s.config.RateBurst, next.RateBurst},
+		{"alert-battery", s.config.LowBatteryThreshold, next.LowBatteryThreshold},
+		{"alert-offline-after", s.config.OfflineAlertAfter, next.OfflineAlertAfter},
+		{"alert-webhook", s.config.AlertWebhookURL, next.AlertWebhookURL},
+		{"log-level", s.config.LogLevel, next.LogLevel},
+	}
+	s.config.ClientTimeout = next.ClientTimeout
+	s.config.RateLimit = next.RateLimit
+	s.config.RateBurst = next.RateBurst
+	s.config.LowBatteryThreshold = next.LowBatteryThreshold
+	s.config.OfflineAlertAfter = next.OfflineAlertAfter
+	s.config.AlertWebhookURL = next.AlertWebhookURL
+	s.config.LogLevel = next.LogLevel
+	s.configMu.Unlock()
+
+	s.rateLimiter.SetLimits(next.RateLimit, next.RateBurst)
+	level, _ := parseLogLevel(next.LogLevel)
+	s.logLevel.Store(int32(level))
+
+	changed := 0
+	for _, c := range changes {
+		if c.old != c.new {
+			log.Printf("Config reload: %s changed from %v to %v", c.name, c.old, c.new)
+			changed++
+		}
+	}
+	if changed == 0 {
+		log.Println("Config reload: no runtime settings changed")
+	}
+}
+
+// loadConfigFile reads a YAML config file mapping flag names to values, e.g.
+//
+//	port: 8443
+//	retention: 8760h
+//	cors-origins: [https://dash.example.com]
+//
+// Lists are joined with commas, as the comma-separated flags expect.
+func loadConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		switch v := value.(type) {
+		case nil:
+			values[name] = ""
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[name] = strings.Join(items, ",")
+		case map[string]interface{}:
+			return nil, fmt.Errorf("%s: %s must be a single value or a list, not a mapping", path, name)
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+// applyConfigValues sets flags on fs from config file values, skipping any in skip
+// (the flags given on the command line, which take precedence)
+func applyConfigValues(fs *flag.FlagSet, values map[string]string, skip map[string]bool) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown setting %q", name)
+		}
+		if skip[name] {
+			continue
+		}
+		if err := fs.Set(name, values[name]); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %v", values[name], name, err)
+		}
+	}
+	return nil
+}
+
+// setFlags returns the flags given on the command line with their values
+func setFlags(fs *flag.FlagSet) map[string]string {
+	set := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+	return set
+}
+
+// reloadConfigFile re-reads the runtime settings from the config file, with command line
+// flags still taking precedence, and the alert rules from alerts.json, and applies them if
+// they are all valid. Other settings in the file are ignored until the next restart.
+func (s *Server) reloadConfigFile(path string, cmdline map[string]string) error {
+	values, err := loadConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	var next runtimeSettings
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	next.registerFlags(fs)
+
+	for name, value := range values {
+		if _, given := cmdline[name]; given || fs.Lookup(name) == nil {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %v", value, name, err)
+		}
+	}
+	for name, value := range cmdline {
+		if fs.Lookup(name) != nil {
+			fs.Set(name, value)
+		}
+	}
+
+	if err := next.validate(); err != nil {
+		return err
+	}
+	rules, err := s.readAlertRules()
+	if err != nil {
+		return err
+	}
+	s.applySettings(next)
+	s.reloadAlertRules(rules)
+	return nil
+}
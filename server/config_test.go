@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// writeConfigFile writes a YAML config file to a temp directory and returns its path
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+// TestApplyConfigFile tests that config file values fill in flags not given on the command line
+func TestApplyConfigFile(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	port := fs.Int("port", 8080, "")
+	retention := fs.Duration("retention", 0, "")
+	origins := fs.String("cors-origins", "", "")
+	auth := fs.Bool("auth", true, "")
+	fs.String("config", "", "")
+	if err := fs.Parse([]string{"-port", "9000"}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeConfigFile(t, `
+port: 8443
+retention: 8760h
+cors-origins: [https://a.example.com, https://b.example.com]
+auth: false
+`)
+	values, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatalf("Failed to load config file: %v", err)
+	}
+	skip := map[string]bool{}
+	for name := range setFlags(fs) {
+		skip[name] = true
+	}
+	if err := applyConfigValues(fs, values, skip); err != nil {
+		t.Fatalf("Failed to apply config file: %v", err)
+	}
+
+	if *port != 9000 {
+		t.Errorf("Expected command line port 9000 to win, got %d", *port)
+	}
+	if *retention != 8760*time.Hour {
+		t.Errorf("Expected retention 8760h, got %v", *retention)
+	}
+	if *origins != "https://a.example.com,https://b.example.com" {
+		t.Errorf("Expected list joined with commas, got %q", *origins)
+	}
+	if *auth {
+		t.Error("Expected auth disabled by the config file")
+	}
+
+	for _, tt := range []struct {
+		content string
+		wantErr string
+	}{
+		{"prot: 8080", `unknown setting "prot"`},
+		{"config: other.yaml", `unknown setting "config"`},
+		{"retention: soon", "invalid value"},
+		{"storage:\n  dir: ./data", "not a mapping"},
+	} {
+		values, err := loadConfigFile(writeConfigFile(t, tt.content))
+		if err == nil {
+			err = applyConfigValues(fs, values, nil)
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("For %q expected error containing %q, got %v", tt.content, tt.wantErr, err)
+		}
+	}
+}
+
+// TestReloadConfigFile tests that a reload applies valid runtime settings and keeps the old ones otherwise
+func TestReloadConfigFile(t *testing.T) {
+	server := createTestServer(t)
+	server.config.RateLimit = defaultRateLimit
+	server.config.RateBurst = defaultRateBurst
+	limiter := server.rateLimiter.GetLimiter("192.0.2.1")
+
+	// -timeout was given on the command line, so the file's value is ignored
+	cmdline := map[string]string{"timeout": "7m0s", "port": "9000"}
+	path := writeConfigFile(t, `
+timeout: 1m
+rate-limit: 2.5
+rate-burst: 4
+alert-battery: 30
+alert-webhook: https://hooks.example.com/govee
+log-level: debug
+port: 1234
+`)
+	if err := server.reloadConfigFile(path, cmdline); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	want := runtimeSettings{
+		ClientTimeout:       7 * time.Minute,
+		RateLimit:           2.5,
+		RateBurst:           4,
+		LowBatteryThreshold: 30,
+		AlertWebhookURL:     "https://hooks.example.com/govee",
+		LogLevel:            "debug",
+	}
+	if got := server.settings(); got != want {
+		t.Errorf("Expected settings %+v, got %+v", want, got)
+	}
+	if limiter.Limit() != rate.Limit(2.5) || limiter.Burst() != 4 {
+		t.Errorf("Expected existing limiter updated to 2.5/4, got %v/%d", limiter.Limit(), limiter.Burst())
+	}
+	if logLevel(server.logLevel.Load()) != logLevelDebug {
+		t.Errorf("Expected the log level to switch to debug, got %d", server.logLevel.Load())
+	}
+	if server.config.Port != 8080 {
+		t.Errorf("Expected port to need a restart, got %d", server.config.Port)
+	}
+
+	// An invalid file leaves everything as it was
+	for _, content := range []string{"alert-battery: 150", "rate-burst: many", "alert-webhook: ftp://example.com", "log-level: loud"} {
+		if err := server.reloadConfigFile(writeConfigFile(t, content), cmdline); err == nil {
+			t.Errorf("Expected reload of %q to fail", content)
+		}
+		if got := server.settings(); got != want {
+			t.Errorf("Expected settings unchanged after reloading %q, got %+v", content, got)
+		}
+	}
+}
+
+// TestReloadAlertRules tests that a reload picks up alert rules edited in alerts.json, keeps the
+// state of unchanged rules, and leaves everything as it was if a rule is invalid
+func TestReloadAlertRules(t *testing.T) {
+	server := createTestServer(t)
+	server.alertRules["kept"] = &AlertRule{ID: "kept", Device: "AA:BB:CC:DD:EE:FF", Metric: "temp_c", Op: ">",
+		Value: 30, WebhookURL: "https://hooks.example.com/a", Breached: true}
+	server.alertRules["removed"] = &AlertRule{ID: "removed", Device: "AA:BB:CC:DD:EE:FF", Metric: "humidity", Op: ">",
+		Value: 80, WebhookURL: "https://hooks.example.com/a"}
+	configPath := writeConfigFile(t, "rate-limit: 2\n")
+
+	writeRules := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(server.config.StorageDir, "alerts.json"), []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write alerts.json: %v", err)
+		}
+	}
+	writeRules(`{
+  "kept": {"id": "kept", "device": "AA:BB:CC:DD:EE:FF", "metric": "temp_c", "op": ">", "value": 30, "webhook_url": "https://hooks.example.com/a"},
+  "added": {"id": "added", "device": "AA:BB:CC:DD:EE:FF", "metric": "temp_c", "op": "<", "value": 5, "webhook_url": "https://hooks.example.com/b"}
+}`)
+	if err := server.reloadConfigFile(configPath, nil); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if len(server.alertRules) != 2 || server.alertRules["added"] == nil || server.alertRules["removed"] != nil {
+		t.Fatalf("Expected the rules from alerts.json, got %v", server.alertRules)
+	}
+	if !server.alertRules["kept"].Breached {
+		t.Error("Expected an unchanged rule to keep its breached state")
+	}
+
+	// An invalid rule fails the whole reload
+	writeRules(`{"bad": {"id": "bad", "device": "AA:BB:CC:DD:EE:FF", "metric": "pressure", "op": ">", "value": 1, "webhook_url": "https://hooks.example.com/a"}}`)
+	if err := server.reloadConfigFile(writeConfigFile(t, "rate-limit: 3\n"), nil); err == nil {
+		t.Error("Expected a reload with an invalid alert rule to fail")
+	}
+	if len(server.alertRules) != 2 || server.settings().RateLimit != 2 {
+		t.Errorf("Expected rules and settings unchanged after a failed reload, got %d rules and rate limit %v",
+			len(server.alertRules), server.settings().RateLimit)
+	}
+}
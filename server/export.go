@@ -0,0 +1,367 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportCSVHeader is the column layout of each device's CSV file in an export archive
+var exportCSVHeader = []string{
+	"timestamp", "device_name", "device_addr", "temp_c", "temp_f", "humidity",
+	"abs_humidity", "dew_point_c", "dew_point_f", "steam_pressure", "heat_index_c", "heat_index_f",
+	"vpd", "frost_point_c", "mixing_ratio", "battery", "rssi", "client_id",
+}
+
+// handleExport serves a zip archive with one CSV of readings per device.
+// The archive is written to a temp file first so it can be served with http.ServeContent,
+// which handles Range requests and lets interrupted downloads resume.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var fromTime, toTime time.Time
+	var err error
+	if fromStr := r.URL.Query().Get("from"); fromStr != "" {
+		if fromTime, err = time.Parse(time.RFC3339, fromStr); err != nil {
+			http.Error(w, "Invalid 'from' time format. Use RFC3339 format (e.g., 2023-04-10T15:04:05Z)", http.StatusBadRequest)
+			return
+		}
+	}
+	if toStr := r.URL.Query().Get("to"); toStr != "" {
+		if toTime, err = time.Parse(time.RFC3339, toStr); err != nil {
+			http.Error(w, "Invalid 'to' time format. Use RFC3339 format (e.g., 2023-04-10T15:04:05Z)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Export a single device, or every known device
+	var devices []string
+	if deviceAddr := r.URL.Query().Get("device"); deviceAddr != "" {
+		devices = []string{deviceAddr}
+	} else {
+		s.rLockShards()
+		for _, shard := range s.shards {
+			for addr := range shard.devices {
+				devices = append(devices, addr)
+			}
+		}
+		s.rUnlockShards()
+		sort.Strings(devices)
+	}
+
+	// Building and sending a large archive can outlast the server's timeouts
+	extendDeadlines(w)
+
+	tmp, err := os.CreateTemp("", "govee-export-*.zip")
+	if err != nil {
+		log.Printf("Failed to create export file: %v", err)
+		http.Error(w, "Failed to create export", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	modTime, err := s.writeExportArchive(tmp, devices, fromTime, toTime)
+	if err != nil {
+		log.Printf("Failed to write export: %v", err)
+		http.Error(w, "Failed to create export", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="govee-export.zip"`)
+	http.ServeContent(w, r, "govee-export.zip", modTime, tmp)
+}
+
+// writeExportArchive writes the readings of the given devices to f as a zip archive and
+// returns the newest reading time. The output only depends on the readings, so repeated
+// exports of unchanged data are byte-identical and a resumed download stays consistent.
+func (s *Server) writeExportArchive(f *os.File, devices []string, fromTime, toTime time.Time) (time.Time, error) {
+	var modTime time.Time
+	zw := zip.NewWriter(f)
+
+	for _, deviceAddr := range devices {
+		readings, err := s.getDeviceReadings(deviceAddr, fromTime, toTime)
+		if err != nil {
+			return modTime, fmt.Errorf("failed to load readings for %s: %v", deviceAddr, err)
+		}
+		sortReadings(readings, false)
+
+		var entryTime time.Time
+		if len(readings) > 0 {
+			entryTime = readings[len(readings)-1].Timestamp
+			if entryTime.After(modTime) {
+				modTime = entryTime
+			}
+		}
+
+		entry, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     "readings_" + strings.ReplaceAll(deviceAddr, ":", "") + ".csv",
+			Method:   zip.Deflate,
+			Modified: entryTime.UTC(),
+		})
+		if err != nil {
+			return modTime, fmt.Errorf("failed to add archive entry: %v", err)
+		}
+
+		cw := csv.NewWriter(entry)
+		cw.Write(exportCSVHeader)
+		for _, reading := range readings {
+			cw.Write([]string{
+				// Full precision, so a re-import recognises readings it already has
+				reading.Timestamp.UTC().Format(time.RFC3339Nano),
+				reading.DeviceName,
+				reading.DeviceAddr,
+				strconv.FormatFloat(reading.TempC, 'f', -1, 64),
+				strconv.FormatFloat(reading.TempF, 'f', -1, 64),
+				strconv.FormatFloat(reading.Humidity, 'f', -1, 64),
+				strconv.FormatFloat(reading.AbsHumidity, 'f', -1, 64),
+				strconv.FormatFloat(reading.DewPointC, 'f', -1, 64),
+				strconv.FormatFloat(reading.DewPointF, 'f', -1, 64),
+				strconv.FormatFloat(reading.SteamPressure, 'f', -1, 64),
+				strconv.FormatFloat(reading.HeatIndexC, 'f', -1, 64),
+				strconv.FormatFloat(reading.HeatIndexF, 'f', -1, 64),
+				strconv.FormatFloat(reading.VPD, 'f', -1, 64),
+				strconv.FormatFloat(reading.FrostPointC, 'f', -1, 64),
+				strconv.FormatFloat(reading.MixingRatio, 'f', -1, 64),
+				strconv.Itoa(reading.Battery),
+				strconv.Itoa(reading.RSSI),
+				s.publicClientID(reading.ClientID),
+			})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return modTime, fmt.Errorf("failed to write CSV: %v", err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return modTime, fmt.Errorf("failed to finish archive: %v", err)
+	}
+	return modTime, nil
+}
+
+// importClientID is the client ID given to imported readings without one
+const importClientID = "csv-import"
+
+// CSVImportResult reports the outcome of a CSV import
+type CSVImportResult struct {
+	Imported   int
+	Duplicates int
+	Rejected   []CSVRejectedRow
+}
+
+// importTimestamps holds the timestamps of a device's readings for the duplicate check on import.
+// Exports from older versions and most other tools write whole seconds, so a timestamp without
+// fractional seconds matches any reading in the same second; others must match exactly.
+type importTimestamps struct {
+	exact   map[int64]bool // Unix nanoseconds
+	seconds map[int64]bool // Unix seconds
+}
+
+func newImportTimestamps(exact map[int64]bool) importTimestamps {
+	ts := importTimestamps{exact: exact, seconds: make(map[int64]bool, len(exact))}
+	for nanos := range exact {
+		ts.seconds[time.Unix(0, nanos).Unix()] = true
+	}
+	return ts
+}
+
+func (ts importTimestamps) contains(t time.Time) bool {
+	if t.Nanosecond() == 0 {
+		return ts.seconds[t.Unix()]
+	}
+	return ts.exact[t.UnixNano()]
+}
+
+func (ts importTimestamps) add(t time.Time) {
+	ts.exact[t.UnixNano()] = true
+	ts.seconds[t.Unix()] = true
+}
+
+// CSVRejectedRow is a CSV row that couldn't be imported, by line number in the file
+type CSVRejectedRow struct {
+	Line   int
+	Reason string
+}
+
+// ImportCSV imports the readings in a CSV file with the export archive's columns into the
+// SQLite database at sqlitePath. Readings already in the database are skipped.
+func ImportCSV(csvPath, sqlitePath string) (CSVImportResult, error) {
+	f, err := os.Open(csvPath)
+	if err != nil {
+		return CSVImportResult{}, err
+	}
+	defer f.Close()
+
+	store := NewSQLiteStorage(sqlitePath)
+	if err := store.Initialize(); err != nil {
+		return CSVImportResult{}, fmt.Errorf("failed to initialize SQLite storage: %v", err)
+	}
+	defer store.Close()
+
+	return importCSVReadings(f, store)
+}
+
+// importCSVReadings parses CSV rows into readings, validates them like readings posted by a
+// client (but at any age), and saves the new ones to store. Columns are matched by the
+// header, so they can be in any order; timestamp, device_name, device_addr, temp_c and
+// humidity are required and the other exportCSVHeader columns are optional.
+func importCSVReadings(r io.Reader, store *SQLiteStorage) (CSVImportResult, error) {
+	var result CSVImportResult
+
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return result, fmt.Errorf("failed to read CSV header: %v", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"timestamp", "device_name", "device_addr", "temp_c", "humidity"} {
+		if _, ok := columns[name]; !ok {
+			return result, fmt.Errorf("CSV header has no %s column", name)
+		}
+	}
+
+	// Timestamps already stored for each device address, loaded as each address is first seen
+	stored := make(map[string]importTimestamps)
+	fresh := make(map[string][]Reading)
+	var devices []string
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := cr.FieldPos(0)
+		if err != nil {
+			if errors.Is(err, csv.ErrFieldCount) {
+				result.Rejected = append(result.Rejected, CSVRejectedRow{Line: line, Reason: "wrong number of fields"})
+				continue
+			}
+			return result, fmt.Errorf("failed to read CSV: %v", err)
+		}
+
+		reading, err := parseCSVReading(record, columns)
+		if err == nil {
+			err = validateReading(&reading, 0)
+		}
+		if err != nil {
+			result.Rejected = append(result.Rejected, CSVRejectedRow{Line: line, Reason: err.Error()})
+			continue
+		}
+
+		timestamps, ok := stored[reading.DeviceAddr]
+		if !ok {
+			exact, err := store.readingTimestamps(reading.DeviceAddr)
+			if err != nil {
+				return result, fmt.Errorf("failed to check existing readings for device %s: %v", reading.DeviceAddr, err)
+			}
+			timestamps = newImportTimestamps(exact)
+			stored[reading.DeviceAddr] = timestamps
+			devices = append(devices, reading.DeviceAddr)
+		}
+		if timestamps.contains(reading.Timestamp) {
+			result.Duplicates++
+			continue
+		}
+		timestamps.add(reading.Timestamp)
+		fresh[reading.DeviceAddr] = append(fresh[reading.DeviceAddr], reading)
+	}
+
+	for _, device := range devices {
+		readings := fresh[device]
+		for i := 0; i < len(readings); i += migrateBatchSize {
+			batch := readings[i:min(i+migrateBatchSize, len(readings))]
+			if err := store.SaveReadings(device, batch); err != nil {
+				return result, fmt.Errorf("failed to save readings for device %s: %v", device, err)
+			}
+			result.Imported += len(batch)
+		}
+	}
+	return result, nil
+}
+
+// parseCSVReading builds a reading from a CSV record, given the index of each column.
+// Missing optional columns and empty fields are left at zero, except temp_f, which is
+// calculated from temp_c, and client_id, which defaults to importClientID.
+func parseCSVReading(record []string, columns map[string]int) (Reading, error) {
+	field := func(name string) string {
+		if i, ok := columns[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var reading Reading
+	var err error
+	if reading.Timestamp, err = time.Parse(time.RFC3339, field("timestamp")); err != nil {
+		return reading, fmt.Errorf("invalid timestamp %q (expected RFC 3339)", field("timestamp"))
+	}
+	reading.DeviceName = field("device_name")
+	reading.DeviceAddr = field("device_addr")
+	reading.ClientID = field("client_id")
+	if reading.ClientID == "" {
+		reading.ClientID = importClientID
+	}
+
+	floats := []struct {
+		name     string
+		dst      *float64
+		required bool
+	}{
+		{"temp_c", &reading.TempC, true},
+		{"temp_f", &reading.TempF, false},
+		{"humidity", &reading.Humidity, true},
+		{"abs_humidity", &reading.AbsHumidity, false},
+		{"dew_point_c", &reading.DewPointC, false},
+		{"dew_point_f", &reading.DewPointF, false},
+		{"steam_pressure", &reading.SteamPressure, false},
+		{"heat_index_c", &reading.HeatIndexC, false},
+		{"heat_index_f", &reading.HeatIndexF, false},
+		{"vpd", &reading.VPD, false},
+		{"frost_point_c", &reading.FrostPointC, false},
+		{"mixing_ratio", &reading.MixingRatio, false},
+	}
+	for _, f := range floats {
+		value := field(f.name)
+		if value == "" {
+			if f.required {
+				return reading, fmt.Errorf("missing %s", f.name)
+			}
+			continue
+		}
+		if *f.dst, err = strconv.ParseFloat(value, 64); err != nil {
+			return reading, fmt.Errorf("invalid %s %q", f.name, value)
+		}
+	}
+	if field("temp_f") == "" {
+		reading.TempF = reading.TempC*9/5 + 32
+	}
+
+	for _, f := range []struct {
+		name string
+		dst  *int
+	}{{"battery", &reading.Battery}, {"rssi", &reading.RSSI}} {
+		if value := field(f.name); value != "" {
+			if *f.dst, err = strconv.Atoi(value); err != nil {
+				return reading, fmt.Errorf("invalid %s %q", f.name, value)
+			}
+		}
+	}
+	return reading, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestExportArchive tests that the export is a zip with one CSV per device
+func TestExportArchive(t *testing.T) {
+	server := createTestServer(t)
+	now := time.Now().Truncate(time.Second).Add(123456789 * time.Nanosecond)
+	for i, addr := range []string{"AA:BB:CC:DD:EE:01", "AA:BB:CC:DD:EE:02"} {
+		server.addReading(Reading{
+			DeviceName: "GVH5075_TEST",
+			DeviceAddr: addr,
+			TempC:      20.0 + float64(i),
+			Humidity:   50.0,
+			Battery:    90,
+			Timestamp:  now,
+			ClientID:   "test-client",
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/export", nil)
+	w := httptest.NewRecorder()
+	server.handleExport(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("Expected Accept-Ranges: bytes, got %q", w.Header().Get("Accept-Ranges"))
+	}
+
+	body := w.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("Export is not a valid zip: %v", err)
+	}
+	if len(zr.File) != 2 || zr.File[0].Name != "readings_AABBCCDDEE01.csv" {
+		t.Fatalf("Unexpected archive entries: %v", zr.File)
+	}
+	rc, err := zr.File[1].Open()
+	if err != nil {
+		t.Fatalf("Failed to open archive entry: %v", err)
+	}
+	csvData, _ := io.ReadAll(rc)
+	rc.Close()
+	lines := strings.Split(strings.TrimSpace(string(csvData)), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "timestamp,") || !strings.Contains(lines[1], "AA:BB:CC:DD:EE:02,21,") {
+		t.Errorf("Unexpected CSV content: %q", csvData)
+	}
+	// Timestamps keep their fractional seconds
+	if want := now.UTC().Format(time.RFC3339Nano) + ","; !strings.HasPrefix(lines[1], want) {
+		t.Errorf("Expected the row to start with %q, got %q", want, lines[1])
+	}
+}
+
+// TestExportRangeRequest tests that a byte range of the export can be fetched to resume a download
+func TestExportRangeRequest(t *testing.T) {
+	server := createTestServer(t)
+	for i := 0; i < 50; i++ {
+		server.addReading(Reading{
+			DeviceName: "GVH5075_TEST",
+			DeviceAddr: "AA:BB:CC:DD:EE:FF",
+			TempC:      20.0 + float64(i)/10,
+			Humidity:   50.0,
+			Battery:    90,
+			Timestamp:  time.Now().Add(time.Duration(i) * time.Second),
+			ClientID:   "test-client",
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/export", nil)
+	w := httptest.NewRecorder()
+	server.handleExport(w, req)
+	full := w.Body.Bytes()
+	if len(full) < 100 {
+		t.Fatalf("Export too small for range test: %d bytes", len(full))
+	}
+
+	req = httptest.NewRequest("GET", "/export", nil)
+	req.Header.Set("Range", "bytes=10-99")
+	w = httptest.NewRecorder()
+	server.handleExport(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("Expected status 206, got %d", w.Code)
+	}
+	if got := w.Header().Get("Content-Range"); got != fmt.Sprintf("bytes 10-99/%d", len(full)) {
+		t.Errorf("Unexpected Content-Range: %q", got)
+	}
+	if !bytes.Equal(w.Body.Bytes(), full[10:100]) {
+		t.Error("Partial content does not match the corresponding bytes of the full export")
+	}
+}
+
+// TestImportCSV tests that an exported CSV imports back into SQLite, skipping readings already
+// there and reporting invalid rows by line
+func TestImportCSV(t *testing.T) {
+	server := createTestServer(t)
+	base := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	for i := 0; i < 3; i++ {
+		server.addReading(Reading{
+			DeviceName:  "GVH5075_TEST",
+			DeviceAddr:  "AA:BB:CC:DD:EE:FF",
+			TempC:       20.5 + float64(i),
+			TempF:       68.9 + float64(i)*1.8,
+			Humidity:    50.0,
+			DewPointC:   9.8,
+			FrostPointC: 8.7,
+			MixingRatio: 7.6,
+			Battery:     90,
+			RSSI:        -70,
+			Timestamp:   base.Add(time.Duration(i) * time.Minute),
+			ClientID:    "test-client",
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/export", nil)
+	w := httptest.NewRecorder()
+	server.handleExport(w, req)
+	body := w.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil || len(zr.File) != 1 {
+		t.Fatalf("Expected an export with one CSV, got %v (%v)", zr, err)
+	}
+	rc, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatalf("Failed to open archive entry: %v", err)
+	}
+	exported, _ := io.ReadAll(rc)
+	rc.Close()
+
+	// Append rows the import should reject, and one for a device from another tool
+	// with only the required columns filled in
+	extra := "" +
+		"not-a-time,GVH5075_TEST,AA:BB:CC:DD:EE:FF,20,68,50,0,0,0,0,0,0,0,0,0,90,-70,test-client\n" +
+		base.Format(time.RFC3339) + ",GVH5075_TEST,AA:BB:CC:DD:EE:FF,150,302,50,0,0,0,0,0,0,0,0,0,90,-70,test-client\n" +
+		"too,few,fields\n" +
+		base.Format(time.RFC3339) + ",Other Tool,11:22:33:44:55:66,10,,40,,,,,,,,,,,,\n"
+	csvData := string(exported) + extra
+
+	store := NewSQLiteStorage(t.TempDir() + "/import.db")
+	if err := store.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize SQLite: %v", err)
+	}
+	defer store.Close()
+
+	result, err := importCSVReadings(strings.NewReader(csvData), store)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if result.Imported != 4 || result.Duplicates != 0 {
+		t.Errorf("Expected 4 readings imported and no duplicates, got %+v", result)
+	}
+	wantLines := []int{5, 6, 7}
+	if len(result.Rejected) != len(wantLines) {
+		t.Fatalf("Expected %d rejected rows, got %+v", len(wantLines), result.Rejected)
+	}
+	for i, row := range result.Rejected {
+		if row.Line != wantLines[i] {
+			t.Errorf("Expected rejected row %d on line %d, got line %d (%s)", i, wantLines[i], row.Line, row.Reason)
+		}
+	}
+
+	readings, err := store.LoadAllDeviceReadings("AA:BB:CC:DD:EE:FF")
+	if err != nil || len(readings) != 3 {
+		t.Fatalf("Expected 3 stored readings, got %d (%v)", len(readings), err)
+	}
+	if r := readings[1]; r.TempC != 21.5 || r.DewPointC != 9.8 || r.FrostPointC != 8.7 || r.MixingRatio != 7.6 || r.RSSI != -70 || r.ClientID != "test-client" || !r.Timestamp.Equal(base.Add(time.Minute)) {
+		t.Errorf("Reading did not round-trip: %+v", r)
+	}
+	others, _ := store.LoadAllDeviceReadings("11:22:33:44:55:66")
+	if len(others) != 1 || others[0].TempF != 50 || others[0].ClientID != importClientID {
+		t.Errorf("Expected temp_f and the client ID to be filled in, got %+v", others)
+	}
+
+	// Importing the same file again adds nothing
+	result, err = importCSVReadings(strings.NewReader(csvData), store)
+	if err != nil || result.Imported != 0 || result.Duplicates != 4 {
+		t.Errorf("Expected a repeated import to skip all 4 readings, got %+v (%v)", result, err)
+	}
+
+	if _, err := importCSVReadings(strings.NewReader("timestamp,device_name,device_addr,temp_c\n"), store); err == nil {
+		t.Error("Expected a header without humidity to be rejected")
+	}
+}
+
+func TestImportCSVTimestampPrecision(t *testing.T) {
+	store := NewSQLiteStorage(t.TempDir() + "/import.db")
+	if err := store.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize SQLite: %v", err)
+	}
+	defer store.Close()
+
+	base := time.Now().Add(-48 * time.Hour).Truncate(time.Second).UTC()
+	if err := store.SaveReadings("AA:BB:CC:DD:EE:FF", []Reading{{
+		DeviceName: "GVH5075_TEST",
+		DeviceAddr: "AA:BB:CC:DD:EE:FF",
+		TempC:      20.5,
+		Humidity:   50.0,
+		Timestamp:  base.Add(250 * time.Millisecond),
+		ClientID:   "test-client",
+	}}); err != nil {
+		t.Fatalf("Failed to save reading: %v", err)
+	}
+
+	// A whole-second row, as older exports wrote it, matches the stored reading in that second;
+	// a full-precision row only matches the exact time
+	csvData := "timestamp,device_name,device_addr,temp_c,humidity\n" +
+		base.Format(time.RFC3339) + ",GVH5075_TEST,AA:BB:CC:DD:EE:FF,20.5,50\n" +
+		base.Add(250*time.Millisecond).Format(time.RFC3339Nano) + ",GVH5075_TEST,AA:BB:CC:DD:EE:FF,20.5,50\n" +
+		base.Add(750*time.Millisecond).Format(time.RFC3339Nano) + ",GVH5075_TEST,AA:BB:CC:DD:EE:FF,20.6,50\n"
+
+	result, err := importCSVReadings(strings.NewReader(csvData), store)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if result.Imported != 1 || result.Duplicates != 2 {
+		t.Errorf("Expected 1 reading imported and 2 duplicates, got %+v", result)
+	}
+}
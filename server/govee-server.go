@@ -5,13 +5,20 @@ import (
 	"compress/gzip"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"os"
@@ -20,10 +27,18 @@ import (
 	"regexp"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/klauspost/compress/zstd"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/time/rate"
 )
 
@@ -41,10 +56,19 @@ type Reading struct {
 	DewPointC      float64   `json:"dew_point_c"`
 	DewPointF      float64   `json:"dew_point_f"`
 	SteamPressure  float64   `json:"steam_pressure"`
+	HeatIndexC     float64   `json:"heat_index_c,omitempty"` // omitted by older clients
+	HeatIndexF     float64   `json:"heat_index_f,omitempty"`
+	VPD            float64   `json:"vpd"`                     // kPa; filled in by the server for older clients
+	FrostPointC    float64   `json:"frost_point_c,omitempty"` // omitted by older clients
+	MixingRatio    float64   `json:"mixing_ratio,omitempty"`  // g/kg of dry air
 	Battery        int       `json:"battery"`
 	RSSI           int       `json:"rssi"`
 	Timestamp      time.Time `json:"timestamp"`
 	ClientID       string    `json:"client_id"`
+	// Where the sensor is, as configured on the client with -location
+	Location string `json:"location,omitempty"`
+	// Set by the server: "suspect" if the reading jumped faster than the configured rate, otherwise "ok"
+	Quality string `json:"quality,omitempty"`
 }
 
 // DeviceStatus represents the latest status of a device
@@ -61,12 +85,39 @@ type DeviceStatus struct {
 	DewPointC      float64   `json:"dew_point_c"`
 	DewPointF      float64   `json:"dew_point_f"`
 	SteamPressure  float64   `json:"steam_pressure"`
+	HeatIndexC     float64   `json:"heat_index_c,omitempty"` // omitted by older clients
+	HeatIndexF     float64   `json:"heat_index_f,omitempty"`
+	VPD            float64   `json:"vpd"`                     // kPa; filled in by the server for older clients
+	FrostPointC    float64   `json:"frost_point_c,omitempty"` // omitted by older clients
+	MixingRatio    float64   `json:"mixing_ratio,omitempty"`  // g/kg of dry air
 	Battery        int       `json:"battery"`
 	RSSI           int       `json:"rssi"`
 	LastUpdate     time.Time `json:"last_update"`
 	ClientID       string    `json:"client_id"`
 	LastSeen       time.Time `json:"last_seen"`
-	ReadingCount   int       `json:"reading_count"`
+	// Total readings received since the device was first seen, including any since evicted
+	// from memory; it only goes up
+	ReadingCount int `json:"reading_count"`
+	// Readings currently held in memory, at most -readings; filled in when the device is listed
+	RetainedCount int `json:"retained_count"`
+	// Location from the device's metadata, or else as reported by its client
+	Location string `json:"location,omitempty"`
+	// Tags from the device's metadata, for grouping devices
+	Tags []string `json:"tags,omitempty"`
+	// Display fields resolved from the requested or preferred units
+	Units       string  `json:"units,omitempty"`
+	Temperature float64 `json:"temperature"`
+	DewPoint    float64 `json:"dew_point"`
+}
+
+// DeviceMetadata holds user-assigned per-device settings
+type DeviceMetadata struct {
+	// Preferred display unit for temperatures: "c" or "f"
+	Units string `json:"units,omitempty"`
+	// Where the device is, overriding the location its client reports
+	Location string `json:"location,omitempty"`
+	// Free-form labels for grouping devices, e.g. "kitchen" or "upstairs"
+	Tags []string `json:"tags,omitempty"`
 }
 
 // ClientStatus represents the latest status of a client
@@ -78,15 +129,88 @@ type ClientStatus struct {
 	ConnectedSince  time.Time `json:"connected_since"`
 	IsActive        bool      `json:"is_active"`
 	InactiveTimeout time.Duration
+	// Addresses of the devices whose latest reading came from this client; DeviceCount is its
+	// size. Rebuilt from the devices on load rather than persisted.
+	devices map[string]struct{}
 }
 
 // AuthConfig represents configuration for API keys
 type AuthConfig struct {
 	EnableAuth      bool              `json:"enable_auth"`
-	APIKeys         map[string]string `json:"api_keys"` // Map of API key -> client ID
+	APIKeys         map[string]APIKey `json:"api_keys"` // Map of hashAPIKey(API key) -> key details
 	AdminKey        string            `json:"admin_key"`
 	DefaultAPIKey   string            `json:"default_api_key"`
 	AllowDefaultKey bool              `json:"allow_default_key"`
+	// When the admin key was last replaced through /api/keys/rotate-admin. Once set, the saved
+	// admin key takes precedence over -admin-key on startup.
+	AdminKeyRotatedAt *time.Time `json:"admin_key_rotated_at,omitempty"`
+}
+
+// APIKey describes a client API key; the key itself is only kept as the hash it's stored under
+type APIKey struct {
+	ClientID  string    `json:"client_id"`
+	CreatedAt time.Time `json:"created_at"`
+	// When the key stops working (nil for keys that never expire)
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// What the key may do: APIKeyScopeRead or APIKeyScopeReadWrite (empty means read-write)
+	Scope string `json:"scope,omitempty"`
+	// When the key last authenticated a request (nil if never) and how many it has authenticated.
+	// Tracked live in Server.keyUsage and copied here when keys are listed or saved.
+	LastUsed *time.Time `json:"last_used,omitempty"`
+	Requests uint64     `json:"requests"`
+	// Disabled keys are kept (and listed) but rejected, e.g. while a suspected leak is looked into
+	Enabled bool `json:"enabled"`
+}
+
+// APIKeyUsage is an entry in the /api/keys/usage report
+type APIKeyUsage struct {
+	KeyHash  string     `json:"key_hash"`
+	ClientID string     `json:"client_id"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+	Requests uint64     `json:"requests"`
+}
+
+// keyUsage is the live usage of a client API key
+type keyUsage struct {
+	lastUsed time.Time
+	requests uint64
+}
+
+// API key scopes
+const (
+	APIKeyScopeRead      = "read"      // GET requests only
+	APIKeyScopeReadWrite = "readwrite" // Any request, including POSTing readings
+)
+
+// Expired reports whether the key has expired at now
+func (k APIKey) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// Allows reports whether the key's scope permits a request with the given method.
+// Keys saved before scopes existed have no scope and keep read-write access.
+func (k APIKey) Allows(method string) bool {
+	if k.Scope != APIKeyScopeRead {
+		return true
+	}
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// UnmarshalJSON also accepts a bare client ID string, the form keys were saved in before they
+// carried creation and expiry times. Keys saved before they could be disabled are enabled.
+func (k *APIKey) UnmarshalJSON(data []byte) error {
+	var clientID string
+	if err := json.Unmarshal(data, &clientID); err == nil {
+		*k = APIKey{ClientID: clientID, Enabled: true}
+		return nil
+	}
+	type plainAPIKey APIKey
+	key := plainAPIKey{Enabled: true}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return err
+	}
+	*k = APIKey(key)
+	return nil
 }
 
 // StorageConfig represents configuration for time-based partitioning and retention
@@ -97,6 +221,12 @@ type StorageConfig struct {
 	RetentionPeriod    time.Duration `json:"retention_period"`      // How long to keep data (0 = forever)
 	MaxReadingsPerFile int           `json:"max_readings_per_file"` // Maximum readings per file
 	CompressOldData    bool          `json:"compress_old_data"`     // Compress older partitions
+	Compression        string        `json:"compression"`           // Codec for compressed partitions: "gzip" (default), "zstd" or "none"
+
+	// Per-device retention periods overriding RetentionPeriod, keyed by sanitized address (0 = forever)
+	DeviceRetention map[string]time.Duration `json:"device_retention,omitempty"`
+	// Roll readings up into hourly aggregates files in BaseDir before retention removes them
+	KeepAggregatesForever bool `json:"keep_aggregates_forever"`
 }
 
 // DashboardData represents data for the dashboard UI
@@ -109,34 +239,108 @@ type DashboardData struct {
 	ServerStartTime time.Time            `json:"server_start_time"`
 }
 
-// DashboardCache caches dashboard data to reduce lock contention and improve performance
+// DashboardCache caches dashboard data to reduce lock contention and improve performance.
+// Entries are keyed by the number of recent readings per device they hold.
 type DashboardCache struct {
+	entries map[int]dashboardCacheEntry
+	mu      sync.RWMutex
+	ttl     time.Duration
+	// Bumped whenever the dashboard data changes, for the /dashboard/data ETag
+	version atomic.Uint64
+}
+
+type dashboardCacheEntry struct {
 	data       *DashboardData
 	lastUpdate time.Time
-	mu         sync.RWMutex
-	ttl        time.Duration
 }
 
-// Get returns cached data if still valid, nil otherwise
-func (dc *DashboardCache) Get() *DashboardData {
+// Dashboard recent readings per device: the default and the most a request can ask for
+const (
+	defaultDashboardReadings = 10
+	maxDashboardReadings     = 200
+)
+
+// Get returns cached data with limit recent readings per device if still valid, nil otherwise
+func (dc *DashboardCache) Get(limit int) *DashboardData {
 	dc.mu.RLock()
 	defer dc.mu.RUnlock()
 
-	if dc.data != nil && time.Since(dc.lastUpdate) < dc.ttl {
-		return dc.data
+	if entry, ok := dc.entries[limit]; ok && time.Since(entry.lastUpdate) < dc.ttl {
+		return entry.data
 	}
 	return nil
 }
 
-// Set updates the cache with new data
-func (dc *DashboardCache) Set(data *DashboardData) {
+// Set updates the cache with new data holding limit recent readings per device
+func (dc *DashboardCache) Set(limit int, data *DashboardData) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	if dc.entries == nil {
+		dc.entries = make(map[int]dashboardCacheEntry)
+	}
+	dc.entries[limit] = dashboardCacheEntry{data: data, lastUpdate: time.Now()}
+}
+
+// Update applies a change to a copy of each unexpired entry, so readings show up between full
+// rebuilds without touching data already handed to a response. Entries keep their age, so
+// anything not updated incrementally is still rebuilt once the TTL passes.
+func (dc *DashboardCache) Update(apply func(limit int, data *DashboardData)) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	for limit, entry := range dc.entries {
+		if time.Since(entry.lastUpdate) >= dc.ttl {
+			delete(dc.entries, limit)
+			continue
+		}
+		data := *entry.data
+		data.Devices = append([]*DeviceStatus(nil), entry.data.Devices...)
+		data.Clients = append([]*ClientStatus(nil), entry.data.Clients...)
+		data.RecentReadings = make(map[string][]Reading, len(entry.data.RecentReadings))
+		for addr, recent := range entry.data.RecentReadings {
+			data.RecentReadings[addr] = recent
+		}
+		apply(limit, &data)
+		dc.entries[limit] = dashboardCacheEntry{data: &data, lastUpdate: entry.lastUpdate}
+	}
+}
+
+// empty reports whether nothing is cached
+func (dc *DashboardCache) empty() bool {
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+	return len(dc.entries) == 0
+}
+
+// Clear drops all cached data
+func (dc *DashboardCache) Clear() {
 	dc.mu.Lock()
 	defer dc.mu.Unlock()
 
-	dc.data = data
-	dc.lastUpdate = time.Now()
+	dc.entries = nil
+	dc.version.Add(1)
+}
+
+// Changed records that the dashboard data has changed, so clients holding an old ETag refetch it
+func (dc *DashboardCache) Changed() {
+	dc.version.Add(1)
 }
 
+// Version returns a counter that increases whenever the dashboard data changes
+func (dc *DashboardCache) Version() uint64 {
+	return dc.version.Load()
+}
+
+// Build metadata reported by /version; Version is also reported by /health and the
+// X-Govee-Version header. Release builds set them with -ldflags, e.g.
+// -X main.Version=2.1.0 -X main.Commit=abc1234 -X main.BuildDate=2024-01-01T00:00:00Z
+var (
+	Version   = "2.0.0"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
 // HealthStatus represents the detailed health status of the server
 type HealthStatus struct {
 	Status        string            `json:"status"` // "healthy", "degraded", "unhealthy"
@@ -150,18 +354,29 @@ type HealthStatus struct {
 
 // Server represents the Govee server
 type Server struct {
-	// Maps device address to device status
-	devices map[string]*DeviceStatus
+	// Device statuses and recent readings, spread across shards by device address.
+	// Locks are taken in the order: shards (by index), clientsMu, mu.
+	shards [deviceShardCount]*deviceShard
 	// Maps client ID to client status
-	clients map[string]*ClientStatus
-	// Stores readings for a device
-	readings map[string][]Reading
+	clients   map[string]*ClientStatus
+	clientsMu sync.RWMutex
 	// Maps device address to user-assigned friendly name
 	deviceAliases map[string]string
-	// Mutex for thread safety
+	// Maps device address to user-assigned metadata
+	deviceMetadata map[string]*DeviceMetadata
+	// Maps alert rule ID to threshold alert rule
+	alertRules map[string]*AlertRule
+	// HTTP client used to deliver alert webhooks
+	webhookClient *http.Client
+	// Notified of every alert, in addition to any webhook (e.g. email with -alert-email)
+	alertNotifiers []Notifier
+	// Built-in (low battery, offline) alert state per device, and recent alert events
+	deviceAlertStates map[string]*deviceAlertState
+	alertHistory      []AlertEvent
+	// Guards aliases, metadata, alert rules and history, and API keys
 	mu sync.RWMutex
-	// File logger
-	logger *os.File
+	// Reading logger (JSON lines), rotated by size
+	logger *rotatingFile
 	// Configuration settings
 	config *Config
 	// Authentication configuration
@@ -173,10 +388,413 @@ type Server struct {
 	shutdownCancel context.CancelFunc
 	// Rate limiter
 	rateLimiter *RateLimiter
+	// Locks out IPs after repeated failed authentication (nil when disabled)
+	authLockout *AuthLockout
 	// Dashboard data cache
 	dashboardCache *DashboardCache
 	// Server start time for uptime tracking
 	startTime      time.Time
+	// Custom reading validators run after validateReading
+	validators []ReadingValidator
+	// Rejected reading logger (JSON lines)
+	rejectLog *rotatingFile
+	// Audit log of API key changes and authentication failures (JSON lines, never rotated)
+	auditLog *rotatingFile
+	// Random ID for this process, reported in the X-Govee-Instance header
+	instanceID string
+	// SQLite backend serving hourly aggregates for /stats/all (nil unless -db-path is set)
+	aggregateStore StorageBackend
+	// SQLite backend new readings are queued to for batched inserts (nil unless -db-path is set)
+	readingWriter *SQLiteStorage
+	// Last use and request count of client API keys by key hash. Kept under their own lock
+	// so authenticated requests don't contend on s.mu.
+	keyUsageMu sync.Mutex
+	keyUsage   map[string]*keyUsage
+	// Set once startup has finished loading persisted data; reported by /ready
+	ready atomic.Bool
+	// Held for reading by saves and backups, and for writing while a restore swaps the storage
+	// directory and reloads from it
+	restoreMu sync.RWMutex
+	// Guards the config fields in runtimeSettings, which SIGHUP can change; read them via settings()
+	configMu sync.RWMutex
+	// Current -log-level, a logLevel; set from Config.LogLevel and on reload
+	logLevel atomic.Int32
+}
+
+// deviceShardCount is the number of shards device state is split into, so readings
+// for different devices rarely wait on the same lock
+const deviceShardCount = 16
+
+// deviceShard holds the status and recent readings of the devices hashed to it
+type deviceShard struct {
+	mu       sync.RWMutex
+	devices  map[string]*DeviceStatus
+	readings map[string]*readingRing
+}
+
+// shardFor returns the shard holding a device. The address is hashed (FNV-1a) in its
+// sanitized form, lowercase without colons, so every spelling of it lands in one shard.
+func (s *Server) shardFor(deviceAddr string) *deviceShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(deviceAddr); i++ {
+		c := deviceAddr[i]
+		if c == ':' {
+			continue
+		}
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	return s.shards[h%deviceShardCount]
+}
+
+// rLockShards read-locks every shard in index order, for a consistent view of all devices
+func (s *Server) rLockShards() {
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+	}
+}
+
+// rUnlockShards releases the locks taken by rLockShards
+func (s *Server) rUnlockShards() {
+	for i := len(s.shards) - 1; i >= 0; i-- {
+		s.shards[i].mu.RUnlock()
+	}
+}
+
+// readingRing holds a device's most recent readings in a fixed-capacity circular buffer.
+// Once full, each new reading overwrites the oldest, so memory stays bounded without
+// copying the readings on every insert. It also keeps the count-weighted stats returned
+// by getDeviceStats up to date as readings come and go.
+type readingRing struct {
+	buf   []Reading
+	start int // index of the oldest reading
+	count int
+	// Running sum, min and max of each statMetrics entry over the held readings
+	stats []metricStats
+	// Readings removed from the sums since they were last recomputed from scratch
+	removedSinceResum int
+}
+
+// metricStats is the running sum, min and max of one metric, counting the readings at the
+// min and max so only removing the last of them forces a recompute
+type metricStats struct {
+	sum, min, max      float64
+	minCount, maxCount int
+}
+
+// add includes a value; with first set the stats start over from it
+func (st *metricStats) add(v float64, first bool) {
+	if first {
+		*st = metricStats{sum: v, min: v, max: v, minCount: 1, maxCount: 1}
+		return
+	}
+	st.sum += v
+	if v < st.min {
+		st.min, st.minCount = v, 1
+	} else if v == st.min {
+		st.minCount++
+	}
+	if v > st.max {
+		st.max, st.maxCount = v, 1
+	} else if v == st.max {
+		st.maxCount++
+	}
+}
+
+// remove drops a value, returning false if it was the last one at the min or max,
+// which then has to be recomputed
+func (st *metricStats) remove(v float64) bool {
+	st.sum -= v
+	if v == st.min {
+		st.minCount--
+	}
+	if v == st.max {
+		st.maxCount--
+	}
+	return st.minCount > 0 && st.maxCount > 0
+}
+
+// statMetrics are the reading fields covered by device stats, with their stats key prefix
+var statMetrics = []struct {
+	key   string
+	value func(Reading) float64
+}{
+	{"temp_c", func(r Reading) float64 { return r.TempC }},
+	{"humidity", func(r Reading) float64 { return r.Humidity }},
+	{"dew_point_c", func(r Reading) float64 { return r.DewPointC }},
+	{"abs_humidity", func(r Reading) float64 { return r.AbsHumidity }},
+	{"steam_pressure", func(r Reading) float64 { return r.SteamPressure }},
+	{"vpd", func(r Reading) float64 { return r.VPD }},
+}
+
+// newReadingRing creates a ring holding up to capacity readings
+func newReadingRing(capacity int) *readingRing {
+	if capacity < 0 {
+		capacity = 0
+	}
+	return &readingRing{buf: make([]Reading, capacity), stats: make([]metricStats, len(statMetrics))}
+}
+
+// Len returns the number of readings held; a nil ring holds none
+func (r *readingRing) Len() int {
+	if r == nil {
+		return 0
+	}
+	return r.count
+}
+
+// Cap returns the number of readings the ring can hold
+func (r *readingRing) Cap() int {
+	return len(r.buf)
+}
+
+// Resize changes the ring's capacity, dropping the oldest readings that no longer fit
+func (r *readingRing) Resize(capacity int) {
+	if capacity < 0 {
+		capacity = 0
+	}
+	kept := r.Recent(min(capacity, r.count))
+	r.buf = make([]Reading, capacity)
+	copy(r.buf, kept)
+	r.start, r.count = 0, len(kept)
+	r.recomputeStats()
+}
+
+// Add stores a reading, replacing the oldest one if the ring is full
+func (r *readingRing) Add(reading Reading) {
+	if len(r.buf) == 0 {
+		return
+	}
+	if r.count < len(r.buf) {
+		r.buf[(r.start+r.count)%len(r.buf)] = reading
+		r.count++
+		r.includeStats(reading)
+		return
+	}
+	evicted := r.buf[r.start]
+	r.buf[r.start] = reading
+	r.start = (r.start + 1) % len(r.buf)
+	r.swapStats(evicted, reading)
+}
+
+// ReplaceLast overwrites the newest reading; the ring must not be empty
+func (r *readingRing) ReplaceLast(reading Reading) {
+	i := (r.start + r.count - 1) % len(r.buf)
+	old := r.buf[i]
+	r.buf[i] = reading
+	r.swapStats(old, reading)
+}
+
+// At returns the i-th oldest reading
+func (r *readingRing) At(i int) Reading {
+	return r.buf[(r.start+i)%len(r.buf)]
+}
+
+// Last returns the newest reading; the ring must not be empty
+func (r *readingRing) Last() Reading {
+	return r.At(r.count - 1)
+}
+
+// Recent returns a copy of the newest n readings (or all of them if n < 0), oldest first
+func (r *readingRing) Recent(n int) []Reading {
+	if n < 0 || n > r.count {
+		n = r.count
+	}
+	readings := make([]Reading, n)
+	for i := range readings {
+		readings[i] = r.At(r.count - n + i)
+	}
+	return readings
+}
+
+// Readings returns a copy of all readings, oldest first
+func (r *readingRing) Readings() []Reading {
+	return r.Recent(-1)
+}
+
+// includeStats adds a newly stored reading to the running stats
+func (r *readingRing) includeStats(reading Reading) {
+	for i, m := range statMetrics {
+		r.stats[i].add(m.value(reading), r.count == 1)
+	}
+}
+
+// swapStats replaces old's contribution to the running stats with added's. Sums are adjusted
+// in place, but if old was the last reading at a min or max the stats are recomputed, as is
+// done after a full ring's worth of removals so floating point error from subtracting doesn't
+// accumulate.
+func (r *readingRing) swapStats(old, added Reading) {
+	r.removedSinceResum++
+	if r.removedSinceResum >= len(r.buf) {
+		r.recomputeStats()
+		return
+	}
+	for i, m := range statMetrics {
+		if !r.stats[i].remove(m.value(old)) {
+			r.recomputeStats()
+			return
+		}
+		r.stats[i].add(m.value(added), false)
+	}
+}
+
+// recomputeStats rebuilds the running stats from the held readings
+func (r *readingRing) recomputeStats() {
+	r.removedSinceResum = 0
+	for i, m := range statMetrics {
+		for j := 0; j < r.count; j++ {
+			r.stats[i].add(m.value(r.At(j)), j == 0)
+		}
+	}
+}
+
+// Stats returns the count-weighted stats, in the same form as computeStats. The min, max and
+// average come from the running sums.
+func (r *readingRing) Stats() map[string]interface{} {
+	stats := make(map[string]interface{})
+	if r.count == 0 {
+		return stats
+	}
+
+	stats["count"] = r.count
+	stats["weighting"] = "count"
+	for i, m := range statMetrics {
+		stats[m.key+"_min"] = r.stats[i].min
+		stats[m.key+"_max"] = r.stats[i].max
+		stats[m.key+"_avg"] = r.stats[i].sum / float64(r.count)
+	}
+	stats["first_reading"] = r.At(0).Timestamp
+	stats["last_reading"] = r.Last().Timestamp
+
+	// Fitting a trend needs every reading, so unlike the sums above it isn't cached
+	var temp, humidity trendSums
+	first := r.At(0).Timestamp
+	for i := 0; i < r.count; i++ {
+		reading := r.At(i)
+		hours := reading.Timestamp.Sub(first).Hours()
+		temp.add(hours, reading.TempC)
+		humidity.add(hours, reading.Humidity)
+	}
+	addTrendStats(stats, temp, humidity)
+	return stats
+}
+
+// rotatingFile is an append-only file that is rotated into numbered backups
+// (path.1, path.2, ...) once it grows beyond maxBytes
+type rotatingFile struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+	mu         sync.Mutex
+	file       *os.File
+	size       int64
+	// Set while rotation is failing, so the failure is logged once rather than on every write
+	rotateFailed bool
+}
+
+// openRotatingFile opens path for appending; maxBytes <= 0 disables rotation
+func openRotatingFile(path string, maxBytes int64, maxBackups int) (*rotatingFile, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if maxBackups < 1 {
+		maxBackups = 1
+	}
+	return &rotatingFile{
+		path:       path,
+		maxBytes:   maxBytes,
+		maxBackups: maxBackups,
+		file:       f,
+		size:       info.Size(),
+	}, nil
+}
+
+// Write appends p to the file, rotating first if it would exceed the size limit. If rotation
+// fails, p is appended to the current file anyway and rotation is retried on the next write.
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.maxBytes > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxBytes {
+		if err := rf.rotate(); err != nil {
+			if !rf.rotateFailed {
+				log.Printf("Failed to rotate %s, writing past its size limit: %v", rf.path, err)
+			}
+			rf.rotateFailed = true
+		} else {
+			rf.rotateFailed = false
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// WriteString appends a string to the file
+func (rf *rotatingFile) WriteString(str string) (int, error) {
+	return rf.Write([]byte(str))
+}
+
+// rotate shifts existing backups up by one and starts a fresh file. The current file stays
+// open until the fresh one is, so a failed rotation leaves it usable. Caller must hold rf.mu.
+func (rf *rotatingFile) rotate() error {
+	for i := rf.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+	if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(rf.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		// Put the current file back, so it keeps taking writes at its own path
+		if renameErr := os.Rename(rf.path+".1", rf.path); renameErr != nil {
+			log.Printf("Failed to restore %s after a failed rotation: %v", rf.path, renameErr)
+		}
+		return err
+	}
+	if err := rf.file.Close(); err != nil {
+		log.Printf("Failed to close rotated %s: %v", rf.path, err)
+	}
+	rf.file = f
+	rf.size = 0
+	return nil
+}
+
+// Sync commits the file's contents to disk
+func (rf *rotatingFile) Sync() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.file.Sync()
+}
+
+// Close closes the underlying file. Like (*os.File).Close it returns os.ErrInvalid on a nil file.
+func (rf *rotatingFile) Close() error {
+	if rf == nil {
+		return os.ErrInvalid
+	}
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.file.Close()
+}
+
+// rejectedReading is an entry in the reject log
+type rejectedReading struct {
+	Timestamp time.Time `json:"timestamp"`
+	Reason    string    `json:"reason"`
+	RemoteIP  string    `json:"remote_ip"`
+	ClientID  string    `json:"client_id"`
+	Reading   Reading   `json:"reading"`
 }
 
 // rateLimiterEntry tracks a rate limiter with its last access time
@@ -188,6 +806,8 @@ type rateLimiterEntry struct {
 // RateLimiter tracks rate limits per IP address with automatic cleanup
 type RateLimiter struct {
 	limiters map[string]*rateLimiterEntry
+	limit    rate.Limit
+	burst    int
 	mu       sync.Mutex
 }
 
@@ -195,6 +815,8 @@ type RateLimiter struct {
 func NewRateLimiter() *RateLimiter {
 	rl := &RateLimiter{
 		limiters: make(map[string]*rateLimiterEntry),
+		limit:    defaultRateLimit,
+		burst:    defaultRateBurst,
 	}
 
 	// Periodically clean up stale entries to prevent memory leaks
@@ -222,6 +844,19 @@ func (rl *RateLimiter) cleanup(maxAge time.Duration) {
 	}
 }
 
+// SetLimits changes the requests per second and burst allowed per IP, including for IPs
+// already being tracked
+func (rl *RateLimiter) SetLimits(limit float64, burst int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.limit, rl.burst = rate.Limit(limit), burst
+	for _, entry := range rl.limiters {
+		entry.limiter.SetLimit(rl.limit)
+		entry.limiter.SetBurst(rl.burst)
+	}
+}
+
 // GetLimiter returns the rate limiter for an IP address
 func (rl *RateLimiter) GetLimiter(ip string) *rate.Limiter {
 	rl.mu.Lock()
@@ -230,7 +865,7 @@ func (rl *RateLimiter) GetLimiter(ip string) *rate.Limiter {
 	entry, exists := rl.limiters[ip]
 	if !exists {
 		entry = &rateLimiterEntry{
-			limiter:    rate.NewLimiter(10, 20), // 10 req/sec, burst 20
+			limiter:    rate.NewLimiter(rl.limit, rl.burst),
 			lastAccess: time.Now(),
 		}
 		rl.limiters[ip] = entry
@@ -241,11 +876,113 @@ func (rl *RateLimiter) GetLimiter(ip string) *rate.Limiter {
 	return entry.limiter
 }
 
+// authFailures tracks the failed authentication attempts from one IP address
+type authFailures struct {
+	count       int
+	windowStart time.Time
+	lockedUntil time.Time
+}
+
+// AuthLockout locks out IP addresses that fail authentication too often, with automatic cleanup
+type AuthLockout struct {
+	failures    map[string]*authFailures
+	maxFailures int
+	window      time.Duration
+	cooldown    time.Duration
+	mu          sync.Mutex
+}
+
+// NewAuthLockout creates a lockout that refuses an IP for cooldown once it has failed
+// authentication maxFailures times within window. Its cleanup stops when ctx is done.
+func NewAuthLockout(ctx context.Context, maxFailures int, window, cooldown time.Duration) *AuthLockout {
+	al := &AuthLockout{
+		failures:    make(map[string]*authFailures),
+		maxFailures: maxFailures,
+		window:      window,
+		cooldown:    cooldown,
+	}
+
+	// Periodically forget IPs whose window and lockout have passed
+	go func() {
+		ticker := time.NewTicker(10 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				al.cleanup(time.Now())
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return al
+}
+
+// cleanup removes entries that no longer count towards or hold a lockout
+func (al *AuthLockout) cleanup(now time.Time) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	for ip, f := range al.failures {
+		if now.Sub(f.windowStart) > al.window && !now.Before(f.lockedUntil) {
+			delete(al.failures, ip)
+		}
+	}
+}
+
+// LockedUntil returns when an IP's lockout ends, if it is locked out at now
+func (al *AuthLockout) LockedUntil(ip string, now time.Time) (time.Time, bool) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if f, ok := al.failures[ip]; ok && now.Before(f.lockedUntil) {
+		return f.lockedUntil, true
+	}
+	return time.Time{}, false
+}
+
+// RecordFailure counts a failed attempt from an IP, and reports whether it has just been
+// locked out
+func (al *AuthLockout) RecordFailure(ip string, now time.Time) bool {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	f, ok := al.failures[ip]
+	if !ok {
+		f = &authFailures{windowStart: now}
+		al.failures[ip] = f
+	}
+	if now.Sub(f.windowStart) > al.window {
+		f.count, f.windowStart = 0, now
+	}
+	f.count++
+	if f.count < al.maxFailures {
+		return false
+	}
+	f.count = 0
+	f.windowStart = now
+	f.lockedUntil = now.Add(al.cooldown)
+	return true
+}
+
+// RecordSuccess forgets an IP's earlier failures once it authenticates
+func (al *AuthLockout) RecordSuccess(ip string) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	delete(al.failures, ip)
+}
+
 // Config represents server configuration
 type Config struct {
 	Port               int           `json:"port"`
 	LogFile            string        `json:"log_file"`
+	LogMaxSize         int64         `json:"log_max_size"`    // Rotate the log file after this many bytes (0 = never)
+	LogMaxBackups      int           `json:"log_max_backups"` // Rotated log files to keep
+	LogLevel           string        `json:"log_level"`       // Per-request logging: debug, info or warn
 	ClientTimeout      time.Duration `json:"client_timeout"`
+	RateLimit          float64       `json:"rate_limit"` // Requests per second per client IP (0 = defaultRateLimit)
+	RateBurst          int           `json:"rate_burst"` // Burst per client IP (0 = defaultRateBurst)
 	ReadingsPerDevice  int           `json:"readings_per_device"`
 	StorageDir         string        `json:"storage_dir"`
 	PersistenceEnabled bool          `json:"persistence_enabled"`
@@ -254,6 +991,45 @@ type Config struct {
 	CertFile           string        `json:"cert_file"`
 	KeyFile            string        `json:"key_file"`
 	TrustedProxies     []*net.IPNet  // CIDR ranges of trusted reverse proxies
+	TrustedCIDRs       []*net.IPNet  // CIDR ranges whose clients need no API key outside admin endpoints
+	MergeWindow        time.Duration `json:"merge_window"` // Merge readings of one device from different clients within this window (0 = disabled)
+	RejectLogFile      string        `json:"reject_log_file"`
+	RejectLogMaxSize   int64         `json:"reject_log_max_size"`
+	AuditLogFile       string        `json:"audit_log_file"`
+	// Refuse an IP for AuthLockoutCooldown after AuthLockoutFailures failed authentication
+	// attempts within AuthLockoutWindow (0 failures = disabled)
+	AuthLockoutFailures int           `json:"auth_lockout_failures"`
+	AuthLockoutWindow   time.Duration `json:"auth_lockout_window"`
+	AuthLockoutCooldown time.Duration `json:"auth_lockout_cooldown"`
+	// Remove devices not seen for this long (0 = never)
+	DevicePruneAfter     time.Duration `json:"device_prune_after"`
+	TimeoutCheckInterval time.Duration `json:"timeout_check_interval"`
+	// Replace client IDs in API responses with a stable salted hash
+	PrivacyMode bool   `json:"privacy_mode"`
+	PrivacySalt string `json:"-"`
+	// Built-in device alerts: battery below this percent (0 = disabled), not seen for
+	// longer than OfflineAlertAfter (0 = the client timeout), posted to AlertWebhookURL
+	LowBatteryThreshold int           `json:"low_battery_threshold"`
+	OfflineAlertAfter   time.Duration `json:"offline_alert_after"`
+	AlertWebhookURL     string        `json:"alert_webhook_url"`
+	// Flag a reading as suspect when temperature (°C) or humidity (%) changes faster than this per minute (0 = disabled)
+	SuspectTempDeltaPerMin     float64 `json:"suspect_temp_delta_per_min"`
+	SuspectHumidityDeltaPerMin float64 `json:"suspect_humidity_delta_per_min"`
+	// Reject readings timestamped more than this long ago (0 = accept any age)
+	MaxReadingAge time.Duration `json:"max_reading_age"`
+	// Add X-Govee-Instance and X-Govee-Version headers to every response
+	InstanceHeaders bool `json:"instance_headers"`
+	// Origins allowed to call the API from a browser ("*" for any; empty = no CORS headers)
+	CORSOrigins []string `json:"cors_origins"`
+	// Largest request body accepted, in bytes (0 = defaultMaxBodyBytes)
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	// Compute /stats over a from/to range in the SQLite database rather than from loaded readings
+	DBStats bool `json:"db_stats"`
+	// Size each device's in-memory readings to hold about this much history at its observed
+	// cadence, within MemoryWindowMin and MemoryWindowMax readings (0 = ReadingsPerDevice for all)
+	MemoryWindowDuration time.Duration `json:"memory_window_duration"`
+	MemoryWindowMin      int           `json:"memory_window_min"`
+	MemoryWindowMax      int           `json:"memory_window_max"`
 }
 
 // StorageManager handles reading/writing data with partitioning and retention policies
@@ -261,6 +1037,8 @@ type StorageManager struct {
 	config      *StorageConfig
 	mu          sync.RWMutex
 	currentTime time.Time // Used for determining partition boundaries
+
+	maintenanceMu sync.Mutex // Serialises retention and compaction runs
 }
 
 // NewStorageManager creates a storage manager with the given configuration
@@ -297,20 +1075,84 @@ func sanitizeDeviceAddr(addr string) (string, error) {
 	return sanitized, nil
 }
 
-// sanitizeDeviceName validates and sanitizes device names to prevent XSS
+// maxDeviceNameLength is the longest device name accepted, in characters
+const maxDeviceNameLength = 100
+
+// sanitizeDeviceName trims surrounding whitespace from a device name and rejects names that are
+// empty, too long, or contain anything but letters, digits, spaces and _-.() so HTML and
+// control characters never reach the dashboard
 func sanitizeDeviceName(name string) (string, error) {
-	// Device name should only contain alphanumeric, spaces, hyphens, underscores, and basic punctuation
-	// This prevents XSS attacks through device names
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("device name required")
+	}
+	if n := utf8.RuneCountInString(name); n > maxDeviceNameLength {
+		return "", fmt.Errorf("device name too long (%d characters, max %d)", n, maxDeviceNameLength)
+	}
+	for _, c := range name {
+		switch {
+		case strings.ContainsRune("<>&\"'", c):
+			return "", fmt.Errorf("device name contains HTML character %q", c)
+		case unicode.IsControl(c):
+			return "", fmt.Errorf("device name contains control character %U", c)
+		}
+	}
 	if !deviceNameRegex.MatchString(name) {
-		return "", fmt.Errorf("device name contains invalid characters")
+		return "", fmt.Errorf("device name contains invalid characters (allowed: letters, digits, spaces and _-.())")
 	}
-	if len(name) == 0 {
-		return "", fmt.Errorf("device name required")
+	return name, nil
+}
+
+// maxLabelLength is the longest location or tag accepted, in characters
+const maxLabelLength = 64
+
+// maxDeviceTags is the most tags a device can have
+const maxDeviceTags = 20
+
+// sanitizeLabel trims a location or tag and checks it is short and uses only the characters
+// allowed in device names; what names the field in errors
+func sanitizeLabel(what, label string) (string, error) {
+	label = strings.TrimSpace(label)
+	if label == "" {
+		return "", fmt.Errorf("%s required", what)
+	}
+	if n := utf8.RuneCountInString(label); n > maxLabelLength {
+		return "", fmt.Errorf("%s too long (%d characters, max %d)", what, n, maxLabelLength)
+	}
+	if !deviceNameRegex.MatchString(label) {
+		return "", fmt.Errorf("%s contains invalid characters (allowed: letters, digits, spaces and _-.())", what)
+	}
+	return label, nil
+}
+
+// normalizeTags sanitizes tags and removes duplicates, which are compared case-insensitively
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) > maxDeviceTags {
+		return nil, fmt.Errorf("too many tags (%d, max %d)", len(tags), maxDeviceTags)
+	}
+	var normalized []string
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag, err := sanitizeLabel("tag", tag)
+		if err != nil {
+			return nil, err
+		}
+		if key := strings.ToLower(tag); !seen[key] {
+			seen[key] = true
+			normalized = append(normalized, tag)
+		}
 	}
-	if len(name) > 100 {
-		return "", fmt.Errorf("device name too long (max 100 characters)")
+	return normalized, nil
+}
+
+// hasTag reports whether tags contains tag, ignoring case
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
 	}
-	return strings.TrimSpace(name), nil
+	return false
 }
 
 // sanitizeClientID validates client IDs to prevent injection via map keys or persisted JSON
@@ -328,7 +1170,22 @@ func sanitizeClientID(id string) (string, error) {
 }
 
 // validateReading validates sensor reading values
-func validateReading(r *Reading) error {
+// calculateVPD returns the vapor pressure deficit in kPa, matching the client's CalculateVPD
+func calculateVPD(tempC, relHumidity float64) float64 {
+	satVaporPressure := 0.6108 * math.Exp(17.27*tempC/(tempC+237.3))
+	return math.Round(satVaporPressure*(1-relHumidity/100.0)*100) / 100
+}
+
+// Plausible BLE signal strengths, in dBm. Clients that don't report RSSI send 0.
+const (
+	minRSSI = -120
+	maxRSSI = 0
+)
+
+// defaultMaxReadingAge is how far back readings may be timestamped unless -max-reading-age says otherwise
+const defaultMaxReadingAge = 24 * time.Hour
+
+func validateReading(r *Reading, maxAge time.Duration) error {
 	// Validate and sanitize device name to prevent XSS
 	sanitized, err := sanitizeDeviceName(r.DeviceName)
 	if err != nil {
@@ -336,6 +1193,22 @@ func validateReading(r *Reading) error {
 	}
 	r.DeviceName = sanitized
 
+	// NaN and Inf slip past the range checks below and can't be encoded as JSON
+	for _, f := range []struct {
+		name  string
+		value float64
+	}{
+		{"temp_c", r.TempC}, {"temp_f", r.TempF}, {"temp_offset", r.TempOffset},
+		{"humidity", r.Humidity}, {"humidity_offset", r.HumidityOffset}, {"abs_humidity", r.AbsHumidity},
+		{"dew_point_c", r.DewPointC}, {"dew_point_f", r.DewPointF}, {"steam_pressure", r.SteamPressure},
+		{"heat_index_c", r.HeatIndexC}, {"heat_index_f", r.HeatIndexF}, {"vpd", r.VPD},
+		{"frost_point_c", r.FrostPointC}, {"mixing_ratio", r.MixingRatio},
+	} {
+		if math.IsNaN(f.value) || math.IsInf(f.value, 0) {
+			return fmt.Errorf("%s is not a finite number: %v", f.name, f.value)
+		}
+	}
+
 	if r.TempC < -50 || r.TempC > 100 {
 		return fmt.Errorf("temperature out of range: %.1f°C", r.TempC)
 	}
@@ -345,25 +1218,136 @@ func validateReading(r *Reading) error {
 	if r.Battery < 0 || r.Battery > 100 {
 		return fmt.Errorf("battery out of range: %d%%", r.Battery)
 	}
+	if r.RSSI < minRSSI || r.RSSI > maxRSSI {
+		return fmt.Errorf("RSSI out of range: %d dBm (expected %d to %d)", r.RSSI, minRSSI, maxRSSI)
+	}
 	if len(r.DeviceAddr) == 0 {
 		return fmt.Errorf("device address required")
 	}
+	if _, err := sanitizeDeviceAddr(r.DeviceAddr); err != nil {
+		return fmt.Errorf("invalid device address %q: %v", r.DeviceAddr, err)
+	}
 	sanitizedClientID, err := sanitizeClientID(r.ClientID)
 	if err != nil {
 		return fmt.Errorf("invalid client ID: %v", err)
 	}
 	r.ClientID = sanitizedClientID
-	// Timestamp should be recent (within 24 hours)
-	now := time.Now()
-	if r.Timestamp.After(now.Add(time.Hour)) {
+	if r.Location != "" {
+		location, err := sanitizeLabel("location", r.Location)
+		if err != nil {
+			return fmt.Errorf("invalid location: %v", err)
+		}
+		r.Location = location
+	}
+	// Timestamp should be recent (within maxAge, unless it is 0)
+	now := time.Now()
+	if r.Timestamp.After(now.Add(time.Hour)) {
 		return fmt.Errorf("timestamp in future")
 	}
-	if r.Timestamp.Before(now.Add(-24 * time.Hour)) {
-		return fmt.Errorf("timestamp too old")
+	if maxAge > 0 && r.Timestamp.Before(now.Add(-maxAge)) {
+		return fmt.Errorf("timestamp too old (more than %v ago)", maxAge)
+	}
+	return nil
+}
+
+// ReadingValidator is a hook for custom, domain-specific validation of incoming
+// readings. Validators run in registration order after validateReading and may
+// reject a reading by returning an error.
+type ReadingValidator interface {
+	Validate(r *Reading) error
+}
+
+// ReadingValidatorFunc adapts an ordinary function to the ReadingValidator interface
+type ReadingValidatorFunc func(r *Reading) error
+
+// Validate calls f(r)
+func (f ReadingValidatorFunc) Validate(r *Reading) error {
+	return f(r)
+}
+
+// registeredValidators holds validators added at init time by custom builds
+var registeredValidators []ReadingValidator
+
+// RegisterReadingValidator adds a validator to every server created afterwards.
+// Custom builds can call this from an init() function in an extra source file
+// without modifying core logic.
+func RegisterReadingValidator(v ReadingValidator) {
+	registeredValidators = append(registeredValidators, v)
+}
+
+// AddValidator appends a validator to this server's chain.
+// Must be called before the server starts handling requests.
+func (s *Server) AddValidator(v ReadingValidator) {
+	s.validators = append(s.validators, v)
+}
+
+// runValidators runs the custom validator chain, stopping at the first error
+func (s *Server) runValidators(r *Reading) error {
+	for _, v := range s.validators {
+		if err := v.Validate(r); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// Reading quality flags
+const (
+	qualityOK      = "ok"
+	qualitySuspect = "suspect"
+)
+
+// initialRingCapacity is the number of readings a new device's ring holds: ReadingsPerDevice,
+// kept within the memory window bounds when the window is enabled
+func (s *Server) initialRingCapacity() int {
+	if s.config.MemoryWindowDuration <= 0 {
+		return s.config.ReadingsPerDevice
+	}
+	return max(s.config.MemoryWindowMin, min(s.config.MemoryWindowMax, s.config.ReadingsPerDevice))
+}
+
+// tuneRingCapacity resizes a device's ring to hold about MemoryWindowDuration of history at the
+// average interval between its held readings, within MemoryWindowMin and MemoryWindowMax. The
+// ring is only resized once the target is more than 10% away, so cadence jitter doesn't keep
+// copying it.
+func (s *Server) tuneRingCapacity(ring *readingRing) {
+	window := s.config.MemoryWindowDuration
+	if window <= 0 || ring.Len() < 2 {
+		return
+	}
+	cadence := ring.Last().Timestamp.Sub(ring.At(0).Timestamp) / time.Duration(ring.Len()-1)
+	if cadence <= 0 {
+		return
+	}
+
+	target := max(s.config.MemoryWindowMin, min(s.config.MemoryWindowMax, int(window/cadence)))
+	if diff := target - ring.Cap(); diff*10 > ring.Cap() || -diff*10 > ring.Cap() {
+		ring.Resize(target)
+	}
+}
+
+// evaluateQuality flags a reading as suspect if it moved away from the device's previous reading
+// faster than the configured rate. Caller must hold the device's shard lock.
+func (s *Server) evaluateQuality(shard *deviceShard, reading *Reading) {
+	reading.Quality = qualityOK
+
+	ring, exists := shard.readings[reading.DeviceAddr]
+	if !exists || ring.Len() == 0 {
+		return
+	}
+	prev := ring.Last()
+
+	// Readings seconds apart may still differ by a sensor step, so allow at least one minute's change
+	minutes := math.Max(math.Abs(reading.Timestamp.Sub(prev.Timestamp).Minutes()), 1)
+
+	if s.config.SuspectTempDeltaPerMin > 0 && math.Abs(reading.TempC-prev.TempC) > s.config.SuspectTempDeltaPerMin*minutes {
+		reading.Quality = qualitySuspect
+	}
+	if s.config.SuspectHumidityDeltaPerMin > 0 && math.Abs(reading.Humidity-prev.Humidity) > s.config.SuspectHumidityDeltaPerMin*minutes {
+		reading.Quality = qualitySuspect
+	}
+}
+
 // getPartitionDirForTime returns the directory path for a specific time
 func (sm *StorageManager) getPartitionDirForTime(t time.Time) string {
 	if !sm.config.TimePartitioning {
@@ -394,7 +1378,10 @@ func (sm *StorageManager) getCurrentPartitionDir() string {
 }
 
 // saveReadings saves readings for a device to the appropriate partition
-func (sm *StorageManager) saveReadings(deviceAddr string, readings []Reading) error {
+func (sm *StorageManager) saveReadings(deviceAddr string, readings []Reading) (err error) {
+	_, span := startSpan(context.Background(), "storage.saveReadings", deviceAttr(deviceAddr), attribute.Int("govee.readings", len(readings)))
+	defer func() { endSpan(span, err) }()
+
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
@@ -412,24 +1399,172 @@ func (sm *StorageManager) saveReadings(deviceAddr string, readings []Reading) er
 		return fmt.Errorf("failed to create partition directory: %v", err)
 	}
 
-	// Create the device file path with sanitized address
-	deviceFile := filepath.Join(partitionDir, fmt.Sprintf("readings_%s.json", sanitizedAddr))
+	// Split the readings into files of at most MaxReadingsPerFile; a single file keeps the unnumbered name
+	chunks := [][]Reading{readings}
+	if limit := sm.config.MaxReadingsPerFile; limit > 0 && len(readings) > limit {
+		chunks = nil
+		for start := 0; start < len(readings); start += limit {
+			chunks = append(chunks, readings[start:min(start+limit, len(readings))])
+		}
+	}
+
+	written := make(map[string]bool, len(chunks))
+	for i, chunk := range chunks {
+		index := i
+		if len(chunks) == 1 {
+			index = -1
+		}
+		deviceFile := filepath.Join(partitionDir, readingsFileName(sanitizedAddr, index))
 
-	// Serialize and save the readings (compact JSON for efficiency)
-	readingsData, err := json.Marshal(readings)
-	if err != nil {
-		return fmt.Errorf("failed to marshal readings for device %s: %v", deviceAddr, err)
+		// Serialize and save the readings (compact JSON for efficiency)
+		readingsData, err := json.Marshal(chunk)
+		if err != nil {
+			return fmt.Errorf("failed to marshal readings for device %s: %v", deviceAddr, err)
+		}
+
+		if err := os.WriteFile(deviceFile, readingsData, 0644); err != nil {
+			return fmt.Errorf("failed to save readings for device %s: %v", deviceAddr, err)
+		}
+		written[deviceFile] = true
 	}
 
-	if err := os.WriteFile(deviceFile, readingsData, 0644); err != nil {
-		return fmt.Errorf("failed to save readings for device %s: %v", deviceAddr, err)
+	// Remove files left over from an earlier save that had more (or fewer) chunks
+	existing, err := readingsFiles(partitionDir, sanitizedAddr)
+	if err != nil {
+		return fmt.Errorf("failed to list readings files for device %s: %v", deviceAddr, err)
+	}
+	for _, file := range existing {
+		if !written[file] {
+			os.Remove(file)
+			for _, codec := range partitionCodecs {
+				os.Remove(file + codec.ext)
+			}
+		}
 	}
 
 	return nil
 }
 
+// readingsFileName returns the name of a device's readings file. Index -1 is the unnumbered
+// readings_<addr>.json; readings that exceed MaxReadingsPerFile go to readings_<addr>.000.json, .001.json, ...
+func readingsFileName(sanitizedAddr string, index int) string {
+	if index < 0 {
+		return fmt.Sprintf("readings_%s.json", sanitizedAddr)
+	}
+	return fmt.Sprintf("readings_%s.%03d.json", sanitizedAddr, index)
+}
+
+// readingsFileAddr returns the sanitized device address from the name of a readings file,
+// readings_<addr>[.<index>].json[.gz|.zst], and whether it is one
+func readingsFileAddr(name string) (string, bool) {
+	name = trimCompressedExt(name)
+	if !strings.HasPrefix(name, "readings_") || !strings.HasSuffix(name, ".json") {
+		return "", false
+	}
+	addr, _, _ := strings.Cut(strings.TrimPrefix(name, "readings_"), ".")
+	return addr, true
+}
+
+// readingsFiles returns the paths of a device's readings files in dir, unnumbered first and then
+// by chunk index. Compressed files are listed without their .gz or .zst suffix, as
+// loadReadingsFromFile checks for the compressed version itself.
+func readingsFiles(dir, sanitizedAddr string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	prefix := "readings_" + sanitizedAddr + "."
+	indexes := make(map[int]bool)
+	for _, entry := range entries {
+		name := trimCompressedExt(entry.Name())
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		// What follows the prefix is either "json" or "<index>.json"
+		rest := strings.TrimPrefix(name, prefix)
+		if rest == "json" {
+			indexes[-1] = true
+		} else if digits, ok := strings.CutSuffix(rest, ".json"); ok {
+			if index, err := strconv.Atoi(digits); err == nil && index >= 0 {
+				indexes[index] = true
+			}
+		}
+	}
+
+	sorted := make([]int, 0, len(indexes))
+	for index := range indexes {
+		sorted = append(sorted, index)
+	}
+	sort.Ints(sorted)
+
+	files := make([]string, len(sorted))
+	for i, index := range sorted {
+		files[i] = filepath.Join(dir, readingsFileName(sanitizedAddr, index))
+	}
+	return files, nil
+}
+
+// loadDeviceFiles loads and concatenates all readings files of a device in dir
+func loadDeviceFiles(dir, sanitizedAddr string) ([]Reading, error) {
+	files, err := readingsFiles(dir, sanitizedAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	var readings []Reading
+	for _, file := range files {
+		fileReadings, err := loadReadingsFromFile(file)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		readings = append(readings, fileReadings...)
+	}
+	return readings, nil
+}
+
+// loadPartitions loads a device's readings files from each partition using up to workers
+// goroutines, returning the readings in partition order. If any partitions fail, the error
+// of the first of them is returned.
+func loadPartitions(partitions []string, sanitizedAddr string, workers int) ([]Reading, error) {
+	results := make([][]Reading, len(partitions))
+	errs := make([]error, len(partitions))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, len(partitions)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], errs[i] = loadDeviceFiles(partitions[i], sanitizedAddr)
+			}
+		}()
+	}
+	for i := range partitions {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var readings []Reading
+	for i := range partitions {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		readings = append(readings, results[i]...)
+	}
+	return readings, nil
+}
+
 // loadReadings loads readings for a specific device across all relevant partitions
-func (sm *StorageManager) loadReadings(deviceAddr string, fromTime, toTime time.Time) ([]Reading, error) {
+func (sm *StorageManager) loadReadings(deviceAddr string, fromTime, toTime time.Time) (_ []Reading, err error) {
+	_, span := startSpan(context.Background(), "storage.loadReadings", deviceAttr(deviceAddr))
+	defer func() { endSpan(span, err) }()
+
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
@@ -443,9 +1578,8 @@ func (sm *StorageManager) loadReadings(deviceAddr string, fromTime, toTime time.
 
 	// If not using time partitioning, just load from the base directory
 	if !sm.config.TimePartitioning {
-		deviceFile := filepath.Join(sm.config.BaseDir, fmt.Sprintf("readings_%s.json", sanitizedAddr))
-		readings, err := sm.loadReadingsFromFile(deviceFile)
-		if err != nil && !os.IsNotExist(err) {
+		readings, err := loadDeviceFiles(sm.config.BaseDir, sanitizedAddr)
+		if err != nil {
 			return nil, err
 		}
 		allReadings = append(allReadings, readings...)
@@ -461,18 +1595,18 @@ func (sm *StorageManager) loadReadings(deviceAddr string, fromTime, toTime time.
 		}
 
 		// Load readings from relevant partitions
+		var selected []string
 		for _, partition := range partitions {
 			// Only include partitions in the time range
 			if (fromTime.IsZero() || partition >= startPartition) &&
 				(toTime.IsZero() || partition <= endPartition) {
-				deviceFile := filepath.Join(partition, fmt.Sprintf("readings_%s.json", sanitizedAddr))
-				readings, err := sm.loadReadingsFromFile(deviceFile)
-				if err != nil && !os.IsNotExist(err) {
-					return nil, err
-				}
-				allReadings = append(allReadings, readings...)
+				selected = append(selected, partition)
 			}
 		}
+		allReadings, err = loadPartitions(selected, sanitizedAddr, runtime.GOMAXPROCS(0))
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Filter readings by time range if specified
@@ -495,11 +1629,17 @@ func (sm *StorageManager) loadReadings(deviceAddr string, fromTime, toTime time.
 	return allReadings, nil
 }
 
-// loadReadingsFromFile loads readings from a specific file
-func (sm *StorageManager) loadReadingsFromFile(filePath string) ([]Reading, error) {
+// loadReadingsFromFile loads readings from a specific file, or from its compressed version
+// with any supported codec
+func loadReadingsFromFile(filePath string) ([]Reading, error) {
 	// Check for compressed file first
-	compressedPath := filePath + ".gz"
-	if _, err := os.Stat(compressedPath); err == nil {
+	for _, name := range sortedCodecNames() {
+		codec := partitionCodecs[name]
+		compressedPath := filePath + codec.ext
+		if _, err := os.Stat(compressedPath); err != nil {
+			continue
+		}
+
 		// File is compressed, decompress it
 		f, err := os.Open(compressedPath)
 		if err != nil {
@@ -507,13 +1647,13 @@ func (sm *StorageManager) loadReadingsFromFile(filePath string) ([]Reading, erro
 		}
 		defer f.Close()
 
-		gz, err := gzip.NewReader(f)
+		dec, err := codec.newReader(f)
 		if err != nil {
 			return nil, err
 		}
-		defer gz.Close()
+		defer dec.Close()
 
-		data, err := io.ReadAll(gz)
+		data, err := io.ReadAll(dec)
 		if err != nil {
 			return nil, err
 		}
@@ -566,199 +1706,689 @@ func (sm *StorageManager) listPartitionDirs() ([]string, error) {
 	return partitions, nil
 }
 
-// enforceRetention enforces the retention policy by removing old partitions
-func (sm *StorageManager) enforceRetention() error {
-	// No retention policy if retention period is 0
-	if sm.config.RetentionPeriod == 0 {
-		return nil
-	}
+// DevicePartition describes one partition holding readings files for a device
+type DevicePartition struct {
+	Partition string    `json:"partition"`
+	Files     int       `json:"files"`
+	Readings  int       `json:"readings"`
+	First     time.Time `json:"first"`
+	Last      time.Time `json:"last"`
+}
 
-	// Calculate the cutoff time
-	cutoffTime := time.Now().Add(-sm.config.RetentionPeriod)
+// devicePartitions lists the partitions containing readings files for a device, oldest first,
+// with the number of readings and time span in each. Without time partitioning the base
+// directory is the only partition.
+func (sm *StorageManager) devicePartitions(deviceAddr string) ([]DevicePartition, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	sanitizedAddr, err := sanitizeDeviceAddr(deviceAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid device address: %v", err)
+	}
 
-	// Get all partition directories
 	partitions, err := sm.listPartitionDirs()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Remove partitions older than the retention period
+	result := []DevicePartition{}
 	for _, partition := range partitions {
-		// Skip if it's the base directory (not a partition)
-		if partition == sm.config.BaseDir {
+		files, err := readingsFiles(partition, sanitizedAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list readings files in %s: %v", partition, err)
+		}
+		if len(files) == 0 {
 			continue
 		}
 
-		// Extract the time from the partition name
-		partitionTime, err := sm.parsePartitionTime(filepath.Base(partition))
+		info := DevicePartition{Partition: filepath.Base(partition), Files: len(files)}
+		readings, err := loadDeviceFiles(partition, sanitizedAddr)
 		if err != nil {
-			log.Printf("Warning: Couldn't parse partition time from %s: %v", partition, err)
-			continue
+			return nil, fmt.Errorf("failed to load readings from %s: %v", partition, err)
 		}
-
-		// If the partition is older than the cutoff, remove it
-		if partitionTime.Before(cutoffTime) {
-			log.Printf("Removing old partition: %s (older than %s)", partition, cutoffTime.Format("2006-01-02"))
-			if err := os.RemoveAll(partition); err != nil {
-				return fmt.Errorf("failed to remove old partition %s: %v", partition, err)
+		for _, r := range readings {
+			if info.First.IsZero() || r.Timestamp.Before(info.First) {
+				info.First = r.Timestamp
 			}
-		} else if sm.config.CompressOldData {
-			// Compress old partitions that are within retention but not current
-			currentPartitionDir := sm.getCurrentPartitionDir()
-			if partition != currentPartitionDir && !isCompressed(partition) {
-				if err := sm.compressPartition(partition); err != nil {
-					log.Printf("Warning: Failed to compress partition %s: %v", partition, err)
-				}
+			if r.Timestamp.After(info.Last) {
+				info.Last = r.Timestamp
 			}
 		}
+		info.Readings = len(readings)
+		result = append(result, info)
 	}
-
-	return nil
+	return result, nil
 }
 
-// parsePartitionTime parses a time from a partition directory name
-func (sm *StorageManager) parsePartitionTime(partitionName string) (time.Time, error) {
-	// Try different formats based on the partition interval
-
-	if strings.Contains(partitionName, "-W") {
-		// Weekly format: 2023-W01
-		year, week := 0, 0
-		if _, err := fmt.Sscanf(partitionName, "%d-W%02d", &year, &week); err != nil {
-			return time.Time{}, err
-		}
-		// Create a time.Time for the first day of the given week
-		jan1 := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
-		daysSinceJan1 := (week - 1) * 7
-		return jan1.AddDate(0, 0, daysSinceJan1), nil
-	} else if len(partitionName) == 7 {
-		// Monthly format: 2023-01
-		return time.Parse("2006-01", partitionName)
-	} else if len(partitionName) == 10 {
-		// Daily format: 2023-01-01
-		return time.Parse("2006-01-02", partitionName)
-	}
+// PartitionUsage is the disk space taken by the files in one directory of the storage directory
+type PartitionUsage struct {
+	Partition string `json:"partition"`
+	Files     int    `json:"files"`
+	Bytes     int64  `json:"bytes"`
+}
 
-	return time.Time{}, fmt.Errorf("unknown partition format: %s", partitionName)
+// DiskUsage is the disk space taken by the storage directory, in total and by partition
+type DiskUsage struct {
+	TotalBytes int64            `json:"total_bytes"`
+	Partitions []PartitionUsage `json:"partitions"`
 }
 
-// isCompressed checks if a partition is already compressed
-func isCompressed(partitionDir string) bool {
-	// Check if there are any .gz files in the directory
-	entries, err := os.ReadDir(partitionDir)
+// DiskUsage sums the sizes of the files in the storage directory and in each directory
+// directly under it, sorted by name. Files in the storage directory itself (devices.json,
+// the SQLite database if it's kept there, ...) are reported as partition ".". Partitions
+// hold no subdirectories, so one os.ReadDir per directory covers everything.
+func (sm *StorageManager) DiskUsage() (DiskUsage, error) {
+	usage := DiskUsage{Partitions: []PartitionUsage{}}
+
+	entries, err := os.ReadDir(sm.config.BaseDir)
 	if err != nil {
-		return false
+		if os.IsNotExist(err) {
+			return usage, nil
+		}
+		return usage, fmt.Errorf("failed to read storage directory: %v", err)
 	}
 
+	base := PartitionUsage{Partition: "."}
+	var partitions []PartitionUsage
 	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".gz") {
-			return true
+		if !entry.IsDir() {
+			addFileUsage(&base, entry)
+			continue
 		}
+		partition := PartitionUsage{Partition: entry.Name()}
+		files, err := os.ReadDir(filepath.Join(sm.config.BaseDir, entry.Name()))
+		if err != nil {
+			// Removed by retention since the listing
+			continue
+		}
+		for _, file := range files {
+			if !file.IsDir() {
+				addFileUsage(&partition, file)
+			}
+		}
+		partitions = append(partitions, partition)
 	}
 
-	return false
+	usage.Partitions = append([]PartitionUsage{base}, partitions...)
+	for _, p := range usage.Partitions {
+		usage.TotalBytes += p.Bytes
+	}
+	return usage, nil
 }
 
-// compressPartition compresses all JSON files in a partition
-func (sm *StorageManager) compressPartition(partitionDir string) error {
-	// Get all JSON files in the partition
-	entries, err := os.ReadDir(partitionDir)
+// addFileUsage adds a directory entry's size to usage, skipping files that have gone since
+// the directory was read
+func addFileUsage(usage *PartitionUsage, entry os.DirEntry) {
+	info, err := entry.Info()
 	if err != nil {
-		return err
+		return
 	}
+	usage.Files++
+	usage.Bytes += info.Size()
+}
 
-	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
-			filePath := filepath.Join(partitionDir, entry.Name())
-			compressedPath := filePath + ".gz"
+// MaintenanceSummary reports what a storage maintenance run did
+type MaintenanceSummary struct {
+	Action            string `json:"action,omitempty"`
+	PartitionsRemoved int    `json:"partitions_removed"`
+	FilesRemoved      int    `json:"files_removed"`
+	FilesCompressed   int    `json:"files_compressed"`
+	BytesReclaimed    int64  `json:"bytes_reclaimed"`
+}
 
-			// Skip if already compressed
-			if _, err := os.Stat(compressedPath); err == nil {
-				continue
-			}
+// enforceRetention enforces the retention policy by removing old partitions, or the files of
+// devices with a shorter retention period in them, compressing the rest (except the current
+// one) if CompressOldData is set. It stops between partitions once ctx is done.
+func (sm *StorageManager) enforceRetention(ctx context.Context) (MaintenanceSummary, error) {
+	var summary MaintenanceSummary
 
-			// Open the source file
-			sourceFile, err := os.Open(filePath)
-			if err != nil {
-				return err
-			}
+	// No retention policy if no device has a retention period
+	if sm.config.RetentionPeriod == 0 && len(sm.config.DeviceRetention) == 0 {
+		return summary, nil
+	}
 
-			// Create the compressed file
-			compressedFile, err := os.Create(compressedPath)
-			if err != nil {
-				sourceFile.Close()
-				return err
-			}
+	sm.maintenanceMu.Lock()
+	defer sm.maintenanceMu.Unlock()
 
-			// Create a gzip writer
-			gzipWriter := gzip.NewWriter(compressedFile)
+	now := time.Now()
 
-			// Copy data from source to compressed file
-			_, err = io.Copy(gzipWriter, sourceFile)
+	// Get all partition directories
+	partitions, err := sm.listPartitionDirs()
+	if err != nil {
+		return summary, err
+	}
 
-			// Close all resources
-			gzipWriter.Close()
-			compressedFile.Close()
-			sourceFile.Close()
+	// Remove partitions older than the retention period
+	for _, partition := range partitions {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
 
-			if err != nil {
-				return err
-			}
+		// Skip if it's the base directory (not a partition)
+		if partition == sm.config.BaseDir {
+			continue
+		}
 
-			// Remove the original file
-			if err := os.Remove(filePath); err != nil {
-				return err
-			}
+		// Extract the time from the partition name
+		partitionTime, err := sm.parsePartitionTime(filepath.Base(partition))
+		if err != nil {
+			log.Printf("Warning: Couldn't parse partition time from %s: %v", partition, err)
+			continue
+		}
 
-			log.Printf("Compressed file: %s", filePath)
+		removed, err := sm.expirePartition(partition, partitionTime, now, &summary)
+		if err != nil {
+			return summary, err
+		}
+		if !removed && sm.config.CompressOldData {
+			// Compress old partitions that are within retention but not current
+			sm.compressOldPartition(partition, &summary)
 		}
 	}
 
-	return nil
+	return summary, nil
 }
 
-// NewServer creates a new Govee server instance
-func NewServer(config *Config, auth *AuthConfig, storageManager *StorageManager) *Server {
-	ctx, cancel := context.WithCancel(context.Background())
-
-	s := &Server{
-		devices:        make(map[string]*DeviceStatus),
-		clients:        make(map[string]*ClientStatus),
-		readings:       make(map[string][]Reading),
-		deviceAliases:  make(map[string]string),
-		config:         config,
-		auth:           auth,
-		storageManager: storageManager,
-		shutdownCtx:    ctx,
-		shutdownCancel: cancel,
-		rateLimiter:    NewRateLimiter(),
-		dashboardCache: &DashboardCache{ttl: 30 * time.Second}, // Cache for 30 seconds
-		startTime:      time.Now(),
+// expirePartition removes the files in partition whose device's retention period it is
+// older than, or the whole partition if that is all of them, adding what it did to summary.
+// It reports whether the partition was removed.
+func (sm *StorageManager) expirePartition(partition string, partitionTime, now time.Time, summary *MaintenanceSummary) (bool, error) {
+	entries, err := os.ReadDir(partition)
+	if err != nil {
+		return false, err
 	}
 
-	// Initialize logging if configured
-	if config.LogFile != "" {
-		logger, err := os.OpenFile(config.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			log.Printf("Failed to open log file: %v", err)
+	globalCutoff := sm.retentionCutoff("", now)
+	// An empty partition goes by the global retention period
+	expireAll := len(entries) > 0 || (!globalCutoff.IsZero() && partitionTime.Before(globalCutoff))
+	var expired []string
+	for _, entry := range entries {
+		cutoff := globalCutoff
+		if addr, ok := readingsFileAddr(entry.Name()); ok && !entry.IsDir() {
+			cutoff = sm.retentionCutoff(addr, now)
+		}
+		if !cutoff.IsZero() && partitionTime.Before(cutoff) {
+			expired = append(expired, entry.Name())
 		} else {
-			s.logger = logger
-			log.Printf("Logging data to %s", config.LogFile)
+			expireAll = false
 		}
 	}
 
-	// Start persistence if enabled
-	if config.PersistenceEnabled {
-		// Create storage directory if it doesn't exist
-		if err := os.MkdirAll(config.StorageDir, 0755); err != nil {
+	if sm.config.KeepAggregatesForever {
+		toArchive := expired
+		if expireAll {
+			toArchive = make([]string, 0, len(entries))
+			for _, entry := range entries {
+				toArchive = append(toArchive, entry.Name())
+			}
+		}
+		if err := sm.archivePartitionAggregates(partition, toArchive); err != nil {
+			return false, fmt.Errorf("failed to keep aggregates from partition %s: %v", partition, err)
+		}
+	}
+
+	if expireAll {
+		log.Printf("Removing old partition: %s (past retention)", partition)
+		size := dirSize(partition)
+		if err := os.RemoveAll(partition); err != nil {
+			return false, fmt.Errorf("failed to remove old partition %s: %v", partition, err)
+		}
+		summary.PartitionsRemoved++
+		summary.BytesReclaimed += size
+		return true, nil
+	}
+
+	for _, name := range expired {
+		path := filepath.Join(partition, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		if err := os.Remove(path); err != nil {
+			return false, fmt.Errorf("failed to remove expired file %s: %v", path, err)
+		}
+		summary.FilesRemoved++
+		summary.BytesReclaimed += info.Size()
+	}
+	if len(expired) > 0 {
+		log.Printf("Removed %d files past their device's retention from partition %s", len(expired), partition)
+	}
+	return false, nil
+}
+
+// archivePartitionAggregates rolls up the readings of the devices with files among names in
+// partition, adding them to the devices' aggregates files in BaseDir
+func (sm *StorageManager) archivePartitionAggregates(partition string, names []string) error {
+	archived := make(map[string]bool)
+	for _, name := range names {
+		addr, ok := readingsFileAddr(name)
+		if !ok || archived[addr] {
+			continue
+		}
+		archived[addr] = true
+
+		readings, err := loadDeviceFiles(partition, addr)
+		if err != nil {
+			return err
+		}
+		if len(readings) == 0 {
+			continue
+		}
+		sm.mu.Lock()
+		err = archiveAggregates(filepath.Join(sm.config.BaseDir, aggregatesFileName(addr)), hourlyAggregates(readings[0].DeviceAddr, readings))
+		sm.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadKeptAggregates returns a device's hourly aggregates from the hours from fromTime to
+// toTime that were kept after retention removed their readings
+func (sm *StorageManager) loadKeptAggregates(deviceAddr string, fromTime, toTime time.Time) ([]AggregateReading, error) {
+	sanitizedAddr, err := sanitizeDeviceAddr(deviceAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid device address: %v", err)
+	}
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	aggregates, err := loadAggregatesFile(filepath.Join(sm.config.BaseDir, aggregatesFileName(sanitizedAddr)))
+	if err != nil {
+		return nil, err
+	}
+	return aggregatesInRange(aggregates, fromTime, toTime), nil
+}
+
+// retentionCutoff returns the time before which a device's readings expire, or the zero time
+// if they are kept forever. An empty sanitizedAddr gives the global cutoff.
+func (sm *StorageManager) retentionCutoff(sanitizedAddr string, now time.Time) time.Time {
+	period := sm.config.RetentionPeriod
+	if p, ok := sm.config.DeviceRetention[sanitizedAddr]; ok {
+		period = p
+	}
+	if period == 0 {
+		return time.Time{}
+	}
+	return now.Add(-period)
+}
+
+// parseDeviceRetention parses a comma-separated list of per-device retention periods, e.g.
+// "A4:C1:38:00:00:01=17520h,A4:C1:38:00:00:02=720h", keyed by sanitized address
+func parseDeviceRetention(list string) (map[string]time.Duration, error) {
+	periods := make(map[string]time.Duration)
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		addr, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not address=duration", field)
+		}
+		sanitized, err := sanitizeDeviceAddr(strings.TrimSpace(addr))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", addr, err)
+		}
+		period, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || period < 0 {
+			return nil, fmt.Errorf("invalid retention period %q for %s", value, addr)
+		}
+		periods[sanitized] = period
+	}
+	return periods, nil
+}
+
+// compressOldPartitions compresses every partition except the current one, whatever
+// CompressOldData says. It stops between partitions once ctx is done.
+func (sm *StorageManager) compressOldPartitions(ctx context.Context) (MaintenanceSummary, error) {
+	var summary MaintenanceSummary
+
+	sm.maintenanceMu.Lock()
+	defer sm.maintenanceMu.Unlock()
+
+	partitions, err := sm.listPartitionDirs()
+	if err != nil {
+		return summary, err
+	}
+
+	for _, partition := range partitions {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
+		if partition == sm.config.BaseDir {
+			continue
+		}
+		sm.compressOldPartition(partition, &summary)
+	}
+
+	return summary, nil
+}
+
+// compressOldPartition compresses partition unless it is the current one or already
+// compressed, adding what it did to summary
+func (sm *StorageManager) compressOldPartition(partition string, summary *MaintenanceSummary) {
+	if partition == sm.getCurrentPartitionDir() || isCompressed(partition) {
+		return
+	}
+	files, saved, err := sm.compressPartition(partition)
+	summary.FilesCompressed += files
+	summary.BytesReclaimed += saved
+	if err != nil {
+		log.Printf("Warning: Failed to compress partition %s: %v", partition, err)
+	}
+}
+
+// compressedExists reports whether a compressed version of filePath exists, with any codec
+func compressedExists(filePath string) bool {
+	for _, codec := range partitionCodecs {
+		if _, err := os.Stat(filePath + codec.ext); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// dirSize returns the total size of the files under dir, ignoring any it can't stat
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// parsePartitionTime parses a time from a partition directory name
+func (sm *StorageManager) parsePartitionTime(partitionName string) (time.Time, error) {
+	// Try different formats based on the partition interval
+
+	if strings.Contains(partitionName, "-W") {
+		// Weekly format: 2023-W01
+		year, week := 0, 0
+		if _, err := fmt.Sscanf(partitionName, "%d-W%02d", &year, &week); err != nil {
+			return time.Time{}, err
+		}
+		return isoWeekStart(year, week), nil
+	} else if len(partitionName) == 7 {
+		// Monthly format: 2023-01
+		return time.Parse("2006-01", partitionName)
+	} else if len(partitionName) == 10 {
+		// Daily format: 2023-01-01
+		return time.Parse("2006-01-02", partitionName)
+	}
+
+	return time.Time{}, fmt.Errorf("unknown partition format: %s", partitionName)
+}
+
+// isoWeekStart returns the Monday (UTC) that starts the given ISO week, the inverse of time.ISOWeek.
+// Week 1 is the week containing January 4th, so it can start in the previous calendar year.
+func isoWeekStart(year, week int) time.Time {
+	jan4 := time.Date(year, 1, 4, 0, 0, 0, 0, time.UTC)
+	// Days back from January 4th to the Monday of its week (Sunday counts as day 7)
+	sinceMonday := (int(jan4.Weekday()) + 6) % 7
+	return jan4.AddDate(0, 0, -sinceMonday+(week-1)*7)
+}
+
+// partitionCodec is a compression format for the readings files of old partitions
+type partitionCodec struct {
+	ext       string
+	newWriter func(w io.Writer) (io.WriteCloser, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// partitionCodecs are the codecs -compression can pick, by name. Files compressed with any of
+// them can be read, whichever is configured.
+var partitionCodecs = map[string]partitionCodec{
+	"gzip": {
+		ext:       ".gz",
+		newWriter: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		newReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	},
+	"zstd": {
+		ext:       ".zst",
+		newWriter: func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) },
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	},
+}
+
+// sortedCodecNames returns the names of partitionCodecs in a fixed order
+func sortedCodecNames() []string {
+	names := make([]string, 0, len(partitionCodecs))
+	for name := range partitionCodecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateCompression checks a -compression value
+func validateCompression(name string) error {
+	if _, ok := partitionCodecs[name]; ok || name == "none" {
+		return nil
+	}
+	return fmt.Errorf("unknown compression %q (expected gzip, zstd or none)", name)
+}
+
+// trimCompressedExt returns a file name without its compressed extension, if it has one
+func trimCompressedExt(name string) string {
+	for _, codec := range partitionCodecs {
+		if trimmed, ok := strings.CutSuffix(name, codec.ext); ok {
+			return trimmed
+		}
+	}
+	return name
+}
+
+// isCompressed checks if a partition is already compressed, with any codec
+func isCompressed(partitionDir string) bool {
+	entries, err := os.ReadDir(partitionDir)
+	if err != nil {
+		return false
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() && trimCompressedExt(entry.Name()) != entry.Name() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// codec returns the configured partition codec; false for "none". Gzip is the default.
+func (sm *StorageManager) codec() (partitionCodec, bool) {
+	name := sm.config.Compression
+	if name == "" {
+		name = "gzip"
+	}
+	codec, ok := partitionCodecs[name]
+	return codec, ok
+}
+
+// compressPartition compresses all JSON files in a partition with the configured codec,
+// returning how many it compressed and the bytes that saved
+func (sm *StorageManager) compressPartition(partitionDir string) (int, int64, error) {
+	codec, ok := sm.codec()
+	if !ok {
+		return 0, 0, nil
+	}
+
+	// Get all JSON files in the partition
+	entries, err := os.ReadDir(partitionDir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	files, saved := 0, int64(0)
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			filePath := filepath.Join(partitionDir, entry.Name())
+			compressedPath := filePath + codec.ext
+
+			// Skip if already compressed, with this codec or another
+			if compressedExists(filePath) {
+				continue
+			}
+
+			// Open the source file
+			sourceFile, err := os.Open(filePath)
+			if err != nil {
+				return files, saved, err
+			}
+
+			// Create the compressed file
+			compressedFile, err := os.Create(compressedPath)
+			if err != nil {
+				sourceFile.Close()
+				return files, saved, err
+			}
+
+			// Copy data from source to compressed file
+			encoder, err := codec.newWriter(compressedFile)
+			var written int64
+			if err == nil {
+				written, err = io.Copy(encoder, sourceFile)
+				if closeErr := encoder.Close(); err == nil {
+					err = closeErr
+				}
+			}
+
+			// Close all resources
+			compressedFile.Close()
+			sourceFile.Close()
+
+			if err != nil {
+				// A partial compressed file would be read in place of the original
+				os.Remove(compressedPath)
+				return files, saved, err
+			}
+
+			// Remove the original file
+			if err := os.Remove(filePath); err != nil {
+				return files, saved, err
+			}
+
+			files++
+			if info, err := os.Stat(compressedPath); err == nil {
+				saved += written - info.Size()
+			}
+			log.Printf("Compressed file: %s", filePath)
+		}
+	}
+
+	return files, saved, nil
+}
+
+// NewServer creates a new Govee server instance
+func NewServer(config *Config, auth *AuthConfig, storageManager *StorageManager) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &Server{
+		clients:        make(map[string]*ClientStatus),
+		deviceAliases:  make(map[string]string),
+		deviceMetadata: make(map[string]*DeviceMetadata),
+		alertRules:     make(map[string]*AlertRule),
+		webhookClient:  &http.Client{Timeout: webhookTimeout},
+		config:         config,
+		auth:           auth,
+		storageManager: storageManager,
+		shutdownCtx:    ctx,
+		shutdownCancel: cancel,
+		rateLimiter:    NewRateLimiter(),
+		dashboardCache: &DashboardCache{ttl: 30 * time.Second}, // Cache for 30 seconds
+		startTime:      time.Now(),
+		validators:     append([]ReadingValidator(nil), registeredValidators...),
+		// Built-in device alerts
+		deviceAlertStates: make(map[string]*deviceAlertState),
+		instanceID:        generateInstanceID(),
+		keyUsage:          make(map[string]*keyUsage),
+	}
+	if auth != nil {
+		hashPlaintextAPIKeys(auth.APIKeys)
+	}
+	if config.RateLimit > 0 && config.RateBurst > 0 {
+		s.rateLimiter.SetLimits(config.RateLimit, config.RateBurst)
+	}
+	if level, err := parseLogLevel(config.LogLevel); err == nil {
+		s.logLevel.Store(int32(level))
+	}
+	if config.AuthLockoutFailures > 0 {
+		s.authLockout = NewAuthLockout(ctx, config.AuthLockoutFailures, config.AuthLockoutWindow, config.AuthLockoutCooldown)
+	}
+	for i := range s.shards {
+		s.shards[i] = &deviceShard{
+			devices:  make(map[string]*DeviceStatus),
+			readings: make(map[string]*readingRing),
+		}
+	}
+
+	// Initialize logging if configured
+	if config.LogFile != "" {
+		logger, err := openRotatingFile(config.LogFile, config.LogMaxSize, config.LogMaxBackups)
+		if err != nil {
+			log.Printf("Failed to open log file: %v", err)
+		} else {
+			s.logger = logger
+			log.Printf("Logging data to %s", config.LogFile)
+		}
+	}
+
+	// Open the reject log if configured
+	if config.RejectLogFile != "" {
+		rejectLog, err := openRotatingFile(config.RejectLogFile, config.RejectLogMaxSize, 1)
+		if err != nil {
+			log.Printf("Failed to open reject log: %v", err)
+		} else {
+			s.rejectLog = rejectLog
+			log.Printf("Logging rejected readings to %s", config.RejectLogFile)
+		}
+	}
+
+	// Open the audit log if configured. It is append-only, so rotation is disabled.
+	if config.AuditLogFile != "" {
+		auditLog, err := openRotatingFile(config.AuditLogFile, 0, 1)
+		if err != nil {
+			log.Printf("Failed to open audit log: %v", err)
+		} else {
+			s.auditLog = auditLog
+			log.Printf("Writing audit log to %s", config.AuditLogFile)
+		}
+	}
+
+	// Privacy mode needs a salt that stays the same across restarts
+	if config.PrivacyMode && config.PrivacySalt == "" {
+		config.PrivacySalt = loadOrCreatePrivacySalt(config.StorageDir, config.PersistenceEnabled)
+	}
+
+	// Start persistence if enabled
+	if config.PersistenceEnabled {
+		// Create storage directory if it doesn't exist
+		if err := os.MkdirAll(config.StorageDir, 0755); err != nil {
 			log.Printf("Failed to create storage directory: %v", err)
 		}
 
 		// Start background save routine
 		go s.startPersistence(ctx)
+	} else {
+		// Nothing to load, so the server is ready as soon as it exists
+		s.ready.Store(true)
 	}
 
 	// Start client timeout check routine
 	go s.checkClientTimeouts(ctx)
+	go s.checkDeviceAlerts(ctx)
 
 	return s
 }
@@ -781,31 +2411,40 @@ func (s *Server) startPersistence(ctx context.Context) {
 
 // saveData saves current server state to disk (optimized to minimize lock time)
 func (s *Server) saveData() {
-	// Take deep snapshot under read lock to prevent data races during I/O
-	s.mu.RLock()
-	devicesCopy := make(map[string]*DeviceStatus, len(s.devices))
-	for k, v := range s.devices {
-		copied := *v
-		devicesCopy[k] = &copied
+	s.restoreMu.RLock()
+	defer s.restoreMu.RUnlock()
+
+	// Take deep snapshot under read locks to prevent data races during I/O
+	devicesCopy := make(map[string]*DeviceStatus)
+	readingsCopy := make(map[string][]Reading)
+	s.rLockShards()
+	for _, shard := range s.shards {
+		for k, v := range shard.devices {
+			copied := *v
+			devicesCopy[k] = &copied
+		}
+		for k, v := range shard.readings {
+			readingsCopy[k] = v.Readings()
+		}
 	}
+	s.rUnlockShards()
+
+	s.clientsMu.RLock()
 	clientsCopy := make(map[string]*ClientStatus, len(s.clients))
 	for k, v := range s.clients {
 		copied := *v
 		clientsCopy[k] = &copied
 	}
-	readingsCopy := make(map[string][]Reading, len(s.readings))
-	for k, v := range s.readings {
-		sliceCopy := make([]Reading, len(v))
-		copy(sliceCopy, v)
-		readingsCopy[k] = sliceCopy
-	}
+	s.clientsMu.RUnlock()
+
+	s.mu.RLock()
 	enableAuth := s.auth.EnableAuth
 	var authCopy *AuthConfig
 	if s.auth != nil {
 		ac := *s.auth
-		ac.APIKeys = make(map[string]string, len(s.auth.APIKeys))
+		ac.APIKeys = make(map[string]APIKey, len(s.auth.APIKeys))
 		for k, v := range s.auth.APIKeys {
-			ac.APIKeys[k] = v
+			ac.APIKeys[k] = s.withKeyUsage(k, v)
 		}
 		authCopy = &ac
 	}
@@ -813,6 +2452,14 @@ func (s *Server) saveData() {
 	for k, v := range s.deviceAliases {
 		aliasesCopy[k] = v
 	}
+	metadataCopy := make(map[string]DeviceMetadata, len(s.deviceMetadata))
+	for k, v := range s.deviceMetadata {
+		metadataCopy[k] = *v
+	}
+	alertsCopy := make(map[string]AlertRule, len(s.alertRules))
+	for k, v := range s.alertRules {
+		alertsCopy[k] = *v
+	}
 	s.mu.RUnlock()
 
 	// Now perform all I/O operations without holding the lock
@@ -839,19 +2486,25 @@ func (s *Server) saveData() {
 
 	// Save API keys if auth is enabled
 	if enableAuth {
-		authData, err := json.MarshalIndent(authCopy, "", "  ")
-		if err != nil {
-			log.Printf("Failed to marshal auth data: %v", err)
-		} else {
-			if err := os.WriteFile(fmt.Sprintf("%s/auth.json", s.config.StorageDir), authData, 0600); err != nil {
-				log.Printf("Failed to save auth data: %v", err)
-			}
+		if err := s.writeAuthFile(authCopy); err != nil {
+			log.Printf("Failed to save auth data: %v", err)
 		}
 	}
 
-	// Save device aliases
-	if len(aliasesCopy) > 0 {
-		aliasData, err := json.MarshalIndent(aliasesCopy, "", "  ")
+	// Save alert rules (webhook URLs may embed tokens, so keep them private like auth). Written
+	// even when empty, so a deleted last rule doesn't come back on restart.
+	alertsData, err := json.MarshalIndent(alertsCopy, "", "  ")
+	if err != nil {
+		log.Printf("Failed to marshal alert rules: %v", err)
+	} else {
+		if err := os.WriteFile(fmt.Sprintf("%s/alerts.json", s.config.StorageDir), alertsData, 0600); err != nil {
+			log.Printf("Failed to save alert rules: %v", err)
+		}
+	}
+
+	// Save device aliases
+	if len(aliasesCopy) > 0 {
+		aliasData, err := json.MarshalIndent(aliasesCopy, "", "  ")
 		if err != nil {
 			log.Printf("Failed to marshal device aliases: %v", err)
 		} else {
@@ -861,6 +2514,16 @@ func (s *Server) saveData() {
 		}
 	}
 
+	// Save device metadata, even when empty, so deleted entries don't come back on restart
+	metadataData, err := json.MarshalIndent(metadataCopy, "", "  ")
+	if err != nil {
+		log.Printf("Failed to marshal device metadata: %v", err)
+	} else {
+		if err := os.WriteFile(fmt.Sprintf("%s/metadata.json", s.config.StorageDir), metadataData, 0644); err != nil {
+			log.Printf("Failed to save device metadata: %v", err)
+		}
+	}
+
 	// Save recent readings for each device using the storage manager
 	for deviceAddr, deviceReadings := range readingsCopy {
 		if len(deviceReadings) > 0 {
@@ -879,10 +2542,19 @@ func (s *Server) loadData() {
 	// Load device statuses
 	devicesData, err := os.ReadFile(fmt.Sprintf("%s/devices.json", s.config.StorageDir))
 	if err == nil {
-		if err := json.Unmarshal(devicesData, &s.devices); err != nil {
+		var devices map[string]*DeviceStatus
+		if err := json.Unmarshal(devicesData, &devices); err != nil {
 			log.Printf("Failed to unmarshal devices data: %v", err)
 		} else {
-			log.Printf("Loaded %d devices from storage", len(s.devices))
+			// Devices that were already offline were alerted on before the restart
+			offlineAfter := s.settings().offlineAlertAfter()
+			for addr, device := range devices {
+				s.shardFor(addr).devices[addr] = device
+				if offlineAfter > 0 && time.Since(device.LastSeen) > offlineAfter {
+					s.deviceAlertStates[addr] = &deviceAlertState{Offline: true}
+				}
+			}
+			log.Printf("Loaded %d devices from storage", len(devices))
 		}
 	}
 
@@ -892,6 +2564,7 @@ func (s *Server) loadData() {
 		if err := json.Unmarshal(clientsData, &s.clients); err != nil {
 			log.Printf("Failed to unmarshal clients data: %v", err)
 		} else {
+			s.rebuildClientDevices()
 			log.Printf("Loaded %d clients from storage", len(s.clients))
 		}
 	}
@@ -906,11 +2579,41 @@ func (s *Server) loadData() {
 			} else {
 				// Only update the API keys, preserve other settings from command line
 				s.auth.APIKeys = loadedAuth.APIKeys
+				if s.auth.APIKeys == nil {
+					s.auth.APIKeys = make(map[string]APIKey)
+				}
 				log.Printf("Loaded %d API keys from storage", len(s.auth.APIKeys))
+
+				// An admin key rotated through the API replaces the one from the command line,
+				// which is the key it was rotated away from
+				if loadedAuth.AdminKeyRotatedAt != nil && loadedAuth.AdminKey != "" {
+					s.auth.AdminKey = loadedAuth.AdminKey
+					s.auth.AdminKeyRotatedAt = loadedAuth.AdminKeyRotatedAt
+					log.Printf("Using the admin API key rotated at %s from auth.json", loadedAuth.AdminKeyRotatedAt.Format(time.RFC3339))
+				}
+
+				// Keys saved before they were hashed are hashed now and the file rewritten
+				if replaced := hashPlaintextAPIKeys(s.auth.APIKeys); replaced > 0 {
+					if err := s.writeAuthFile(s.auth); err != nil {
+						log.Printf("Failed to save hashed API keys: %v", err)
+					} else {
+						log.Printf("Replaced %d plaintext API keys in auth.json with hashes", replaced)
+					}
+				}
 			}
 		}
 	}
 
+	// Load alert rules
+	alertsData, err := os.ReadFile(fmt.Sprintf("%s/alerts.json", s.config.StorageDir))
+	if err == nil {
+		if err := json.Unmarshal(alertsData, &s.alertRules); err != nil {
+			log.Printf("Failed to unmarshal alert rules: %v", err)
+		} else {
+			log.Printf("Loaded %d alert rules from storage", len(s.alertRules))
+		}
+	}
+
 	// Load device aliases
 	aliasData, err := os.ReadFile(fmt.Sprintf("%s/aliases.json", s.config.StorageDir))
 	if err == nil {
@@ -921,85 +2624,152 @@ func (s *Server) loadData() {
 		}
 	}
 
+	// Load device metadata
+	metadataData, err := os.ReadFile(fmt.Sprintf("%s/metadata.json", s.config.StorageDir))
+	if err == nil {
+		if err := json.Unmarshal(metadataData, &s.deviceMetadata); err != nil {
+			log.Printf("Failed to unmarshal device metadata: %v", err)
+		} else {
+			log.Printf("Loaded metadata for %d devices from storage", len(s.deviceMetadata))
+		}
+	}
+
 	// Mark all clients as inactive initially
 	for _, client := range s.clients {
 		client.IsActive = false
 	}
+
+	s.ready.Store(true)
+}
+
+// writeAuthFile saves the auth configuration to auth.json, readable only by the server's user
+func (s *Server) writeAuthFile(auth *AuthConfig) error {
+	authData, err := json.MarshalIndent(auth, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal auth data: %v", err)
+	}
+	return os.WriteFile(fmt.Sprintf("%s/auth.json", s.config.StorageDir), authData, 0600)
 }
 
 // checkClientTimeouts periodically checks for inactive clients and cleans up old data
 func (s *Server) checkClientTimeouts(ctx context.Context) {
-	ticker := time.NewTicker(1 * time.Minute)
+	interval := s.config.TimeoutCheckInterval
+	if interval <= 0 {
+		interval = 1 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			s.mu.Lock()
-			now := time.Now()
-
-			// Mark inactive clients
-			for clientID, client := range s.clients {
-				if now.Sub(client.LastSeen) > s.config.ClientTimeout {
-					client.IsActive = false
-					log.Printf("Client %s marked as inactive (timeout: %v)", clientID, s.config.ClientTimeout)
-				}
+			s.cleanupStale(time.Now())
 
-				// Remove very old inactive clients (10x timeout)
-				if now.Sub(client.LastSeen) > s.config.ClientTimeout*10 {
-					delete(s.clients, clientID)
-					log.Printf("Removed stale client: %s", clientID)
-				}
-			}
+		case <-ctx.Done():
+			log.Println("Client timeout checker shutting down")
+			return
+		}
+	}
+}
+
+// cleanupStale marks timed-out clients inactive and removes long-gone clients and devices
+func (s *Server) cleanupStale(now time.Time) {
+	clientTimeout := s.settings().ClientTimeout
+
+	// The cached dashboard is only updated incrementally for new readings, so it's dropped
+	// whenever a client or device changes here
+	changed := false
+
+	s.clientsMu.Lock()
+	// Mark inactive clients
+	for clientID, client := range s.clients {
+		if now.Sub(client.LastSeen) > clientTimeout {
+			changed = changed || client.IsActive
+			client.IsActive = false
+			log.Printf("Client %s marked as inactive (timeout: %v)", clientID, clientTimeout)
+		}
 
-			// Clean up very old devices (30 days)
-			for deviceAddr, device := range s.devices {
-				if now.Sub(device.LastSeen) > 30*24*time.Hour {
-					delete(s.devices, deviceAddr)
-					delete(s.readings, deviceAddr)
+		// Remove very old inactive clients (10x timeout)
+		if now.Sub(client.LastSeen) > clientTimeout*10 {
+			delete(s.clients, clientID)
+			changed = true
+			log.Printf("Removed stale client: %s", clientID)
+		}
+	}
+	s.clientsMu.Unlock()
+
+	// Clean up devices not seen within the prune window
+	if s.config.DevicePruneAfter > 0 {
+		for _, shard := range s.shards {
+			shard.mu.Lock()
+			for deviceAddr, device := range shard.devices {
+				if now.Sub(device.LastSeen) > s.config.DevicePruneAfter {
+					delete(shard.devices, deviceAddr)
+					delete(shard.readings, deviceAddr)
+					s.clientsMu.Lock()
+					s.removeClientDevice(device.ClientID, deviceAddr)
+					s.clientsMu.Unlock()
+					changed = true
 					log.Printf("Removed stale device: %s", deviceAddr)
 				}
 			}
-
-			s.mu.Unlock()
-
-		case <-ctx.Done():
-			log.Println("Client timeout checker shutting down")
-			return
+			shard.mu.Unlock()
 		}
 	}
+
+	if changed {
+		s.dashboardCache.Clear()
+	}
 }
 
 // addReading adds a new reading to the server
 func (s *Server) addReading(reading Reading) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	deviceAddr := reading.DeviceAddr
 	clientID := reading.ClientID
 
-	// Track if this is a new device
-	_, deviceExists := s.devices[deviceAddr]
+	// Only this device's shard is locked, so readings of other devices proceed in parallel
+	shard := s.shardFor(deviceAddr)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	// Readings posted over HTTP were already validated, but an unusable name from any other
+	// source keeps the device's current name (or its address, for a new device)
+	name, err := sanitizeDeviceName(reading.DeviceName)
+	if err != nil {
+		log.Printf("Ignoring name of device %s: %v", deviceAddr, err)
+		name = deviceAddr
+		if device, exists := shard.devices[deviceAddr]; exists {
+			name = device.DeviceName
+		}
+	}
+	reading.DeviceName = name
+
+	// Flag implausible jumps before the reading is stored or merged
+	s.evaluateQuality(shard, &reading)
+
+	// Merge near-simultaneous readings of the same device from redundant clients. When the new
+	// reading wins, it also takes the place of the one already queued for SQLite.
+	if merged, replaced := s.mergeRedundantReading(shard, reading); merged {
+		if replaced != nil && s.readingWriter != nil {
+			if err := s.readingWriter.EnqueueReplacement(reading, replaced.Timestamp); err != nil {
+				log.Printf("Error queueing merged reading for SQLite: %v", err)
+			}
+		}
+		return
+	}
+
+	// The client the device last reported through, if any
+	previousClientID := ""
+	if device, exists := shard.devices[deviceAddr]; exists {
+		previousClientID = device.ClientID
+	}
 
 	// Update device status
-	if device, exists := s.devices[deviceAddr]; exists {
-		device.TempC = reading.TempC
-		device.TempF = reading.TempF
-		device.TempOffset = reading.TempOffset
-		device.Humidity = reading.Humidity
-		device.HumidityOffset = reading.HumidityOffset
-		device.AbsHumidity = reading.AbsHumidity
-		device.DewPointC = reading.DewPointC
-		device.DewPointF = reading.DewPointF
-		device.SteamPressure = reading.SteamPressure
-		device.Battery = reading.Battery
-		device.RSSI = reading.RSSI
-		device.LastUpdate = reading.Timestamp
-		device.LastSeen = time.Now()
-		device.ClientID = clientID
+	if device, exists := shard.devices[deviceAddr]; exists {
+		applyReadingToDevice(device, reading)
 		device.ReadingCount++
 	} else {
-		s.devices[deviceAddr] = &DeviceStatus{
+		shard.devices[deviceAddr] = &DeviceStatus{
 			DeviceName:     reading.DeviceName,
 			DeviceAddr:     deviceAddr,
 			TempC:          reading.TempC,
@@ -1011,16 +2781,129 @@ func (s *Server) addReading(reading Reading) {
 			DewPointC:      reading.DewPointC,
 			DewPointF:      reading.DewPointF,
 			SteamPressure:  reading.SteamPressure,
+			HeatIndexC:     reading.HeatIndexC,
+			HeatIndexF:     reading.HeatIndexF,
+			VPD:            reading.VPD,
+			FrostPointC:    reading.FrostPointC,
+			MixingRatio:    reading.MixingRatio,
 			Battery:        reading.Battery,
 			RSSI:           reading.RSSI,
 			LastUpdate:     reading.Timestamp,
 			LastSeen:       time.Now(),
 			ClientID:       clientID,
 			ReadingCount:   1,
+			Location:       reading.Location,
+		}
+	}
+
+	// Update or create client status, moving the device over if another client reported it before
+	s.clientsMu.Lock()
+	s.updateClientStatus(clientID)
+	if previousClientID != clientID {
+		s.removeClientDevice(previousClientID, deviceAddr)
+	}
+	s.addClientDevice(clientID, deviceAddr)
+	s.clientsMu.Unlock()
+
+	// Store reading, dropping the oldest once the device's ring is full
+	ring, exists := shard.readings[deviceAddr]
+	if !exists {
+		ring = newReadingRing(s.initialRingCapacity())
+		shard.readings[deviceAddr] = ring
+	}
+	ring.Add(reading)
+	s.tuneRingCapacity(ring)
+	if previousClientID != "" && previousClientID != clientID {
+		s.updateDashboardCache(shard, deviceAddr, true, clientID, previousClientID)
+	} else {
+		s.updateDashboardCache(shard, deviceAddr, true, clientID)
+	}
+
+	// Queue the reading for the next batched SQLite insert
+	if s.readingWriter != nil {
+		if err := s.readingWriter.Enqueue(reading); err != nil {
+			log.Printf("Error queueing reading for SQLite: %v", err)
+		}
+	}
+
+	// Fire threshold alerts for this device
+	s.evaluateAlerts(reading)
+
+	// Log reading if logger is available
+	if s.logger != nil {
+		logEntry, _ := json.Marshal(reading)
+		s.logger.WriteString(string(logEntry) + "\n")
+	}
+}
+
+// applyReadingToDevice copies the name and measurement values of a reading onto a device status
+func applyReadingToDevice(device *DeviceStatus, reading Reading) {
+	device.DeviceName = reading.DeviceName
+	device.TempC = reading.TempC
+	device.TempF = reading.TempF
+	device.TempOffset = reading.TempOffset
+	device.Humidity = reading.Humidity
+	device.HumidityOffset = reading.HumidityOffset
+	device.AbsHumidity = reading.AbsHumidity
+	device.DewPointC = reading.DewPointC
+	device.DewPointF = reading.DewPointF
+	device.SteamPressure = reading.SteamPressure
+	device.HeatIndexC = reading.HeatIndexC
+	device.HeatIndexF = reading.HeatIndexF
+	device.VPD = reading.VPD
+	device.FrostPointC = reading.FrostPointC
+	device.MixingRatio = reading.MixingRatio
+	device.Battery = reading.Battery
+	device.RSSI = reading.RSSI
+	device.LastUpdate = reading.Timestamp
+	device.LastSeen = time.Now()
+	device.ClientID = reading.ClientID
+	device.Location = reading.Location
+}
+
+// addClientDevice records that a client reports a device and updates its DeviceCount.
+// Caller must hold s.clientsMu.
+func (s *Server) addClientDevice(clientID, deviceAddr string) {
+	client, exists := s.clients[clientID]
+	if !exists {
+		return
+	}
+	if client.devices == nil {
+		client.devices = make(map[string]struct{})
+	}
+	client.devices[deviceAddr] = struct{}{}
+	client.DeviceCount = len(client.devices)
+}
+
+// removeClientDevice records that a client no longer reports a device and updates its
+// DeviceCount. Caller must hold s.clientsMu.
+func (s *Server) removeClientDevice(clientID, deviceAddr string) {
+	client, exists := s.clients[clientID]
+	if !exists {
+		return
+	}
+	delete(client.devices, deviceAddr)
+	client.DeviceCount = len(client.devices)
+}
+
+// rebuildClientDevices recomputes every client's device set and DeviceCount from the clients
+// the devices last reported through. Caller must hold s.clientsMu and all shard locks, or
+// have exclusive access during loading.
+func (s *Server) rebuildClientDevices() {
+	for _, client := range s.clients {
+		client.devices = make(map[string]struct{})
+		client.DeviceCount = 0
+	}
+	for _, shard := range s.shards {
+		for addr, device := range shard.devices {
+			s.addClientDevice(device.ClientID, addr)
 		}
 	}
+}
 
-	// Update or create client status
+// updateClientStatus records activity from a client, creating its status if needed.
+// Caller must hold s.clientsMu.
+func (s *Server) updateClientStatus(clientID string) {
 	if client, exists := s.clients[clientID]; exists {
 		client.LastSeen = time.Now()
 		client.ReadingCount++
@@ -1029,106 +2912,408 @@ func (s *Server) addReading(reading Reading) {
 		s.clients[clientID] = &ClientStatus{
 			ClientID:        clientID,
 			LastSeen:        time.Now(),
-			DeviceCount:     1,
 			ReadingCount:    1,
 			ConnectedSince:  time.Now(),
 			IsActive:        true,
-			InactiveTimeout: s.config.ClientTimeout,
+			InactiveTimeout: s.settings().ClientTimeout,
 		}
 	}
+}
 
-	// Increment device count only when adding a new device
-	// This is much more efficient than recalculating all counts every time
-	if !deviceExists && clientID != "" {
-		if client, exists := s.clients[clientID]; exists {
-			client.DeviceCount++
-		}
+// mergeRedundantReading merges a reading into the device's latest stored reading when it
+// comes from a different client within the merge window, keeping the one with the
+// strongest RSSI. Returns true if the reading was merged and should not be stored, and the
+// stored reading it replaced if it had the stronger RSSI. Caller must hold the device's shard lock.
+func (s *Server) mergeRedundantReading(shard *deviceShard, reading Reading) (bool, *Reading) {
+	if s.config.MergeWindow <= 0 {
+		return false, nil
 	}
 
-	// Store reading
-	if _, exists := s.readings[deviceAddr]; !exists {
-		s.readings[deviceAddr] = make([]Reading, 0)
+	ring, exists := shard.readings[reading.DeviceAddr]
+	if !exists || ring.Len() == 0 {
+		return false, nil
 	}
 
-	// Append reading and maintain maximum size
-	readings := append(s.readings[deviceAddr], reading)
-	if len(readings) > s.config.ReadingsPerDevice {
-		readings = readings[len(readings)-s.config.ReadingsPerDevice:]
+	last := ring.Last()
+	delta := reading.Timestamp.Sub(last.Timestamp)
+	if delta < 0 {
+		delta = -delta
+	}
+	if last.ClientID == reading.ClientID || delta > s.config.MergeWindow {
+		return false, nil
 	}
-	s.readings[deviceAddr] = readings
 
-	// Log reading if logger is available
-	if s.logger != nil {
-		logEntry, _ := json.Marshal(reading)
-		s.logger.WriteString(string(logEntry) + "\n")
+	// The redundant client is still alive even if its reading is discarded
+	s.clientsMu.Lock()
+	s.updateClientStatus(reading.ClientID)
+	s.clientsMu.Unlock()
+
+	if reading.RSSI <= last.RSSI {
+		return true, nil
 	}
+	ring.ReplaceLast(reading)
+	if device, exists := shard.devices[reading.DeviceAddr]; exists {
+		previousClientID := device.ClientID
+		applyReadingToDevice(device, reading)
+
+		// The device now reports through the client whose reading was kept
+		if previousClientID != reading.ClientID {
+			s.clientsMu.Lock()
+			s.removeClientDevice(previousClientID, reading.DeviceAddr)
+			s.addClientDevice(reading.ClientID, reading.DeviceAddr)
+			s.clientsMu.Unlock()
+		}
+		s.updateDashboardCache(shard, reading.DeviceAddr, false, reading.ClientID, previousClientID)
+	}
+	s.evaluateAlerts(reading)
+	return true, &last
 }
 
-// getDevices returns all device statuses
+// getDevices returns all device statuses in each device's preferred units
 func (s *Server) getDevices() []*DeviceStatus {
+	return s.getDevicesInUnits("")
+}
+
+// getDevicesInUnits returns all device statuses; a non-empty units overrides
+// each device's preferred display unit
+func (s *Server) getDevicesInUnits(units string) []*DeviceStatus {
+	s.rLockShards()
+	defer s.rUnlockShards()
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	devices := make([]*DeviceStatus, 0, len(s.devices))
-	for _, device := range s.devices {
-		d := *device // shallow copy to avoid mutating stored data
-		if alias := s.getDisplayName(d.DeviceAddr); alias != "" {
-			d.DisplayName = alias
+	devices := make([]*DeviceStatus, 0)
+	for _, shard := range s.shards {
+		for _, device := range shard.devices {
+			d := *device // shallow copy to avoid mutating stored data
+			if alias := s.getDisplayName(d.DeviceAddr); alias != "" {
+				d.DisplayName = alias
+			}
+			d.ClientID = s.publicClientID(d.ClientID)
+			s.applyDisplayUnits(&d, units)
+			s.applyDeviceLabels(&d)
+			d.RetainedCount = shard.readings[d.DeviceAddr].Len()
+			devices = append(devices, &d)
 		}
-		devices = append(devices, &d)
 	}
 	return devices
 }
 
-// getClients returns all client statuses
-func (s *Server) getClients() []*ClientStatus {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// clientFilter narrows the clients getClients returns; the zero value matches every client
+type clientFilter struct {
+	// activeOnly keeps only clients marked active
+	activeOnly bool
+	// seenSince, if set, keeps only clients last seen at or after it
+	seenSince time.Time
+}
+
+// getClients returns copies of the client statuses that match filter
+func (s *Server) getClients(filter clientFilter) []*ClientStatus {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
 
 	clients := make([]*ClientStatus, 0, len(s.clients))
 	for _, client := range s.clients {
-		clients = append(clients, client)
+		if filter.activeOnly && !client.IsActive {
+			continue
+		}
+		if !filter.seenSince.IsZero() && client.LastSeen.Before(filter.seenSince) {
+			continue
+		}
+		c := *client
+		c.ClientID = s.publicClientID(c.ClientID)
+		clients = append(clients, &c)
 	}
 	return clients
 }
 
-// getDeviceReadings returns readings for a specific device with optional time range
+// publicClientID returns the client ID to expose in API responses: the ID itself,
+// or a stable salted hash of it in privacy mode
+func (s *Server) publicClientID(clientID string) string {
+	if !s.config.PrivacyMode || clientID == "" {
+		return clientID
+	}
+	sum := sha256.Sum256([]byte(s.config.PrivacySalt + "\x00" + clientID))
+	return "client-" + hex.EncodeToString(sum[:6])
+}
+
+// loadOrCreatePrivacySalt reads the privacy salt from the storage directory,
+// generating a new one (saved if persistence is enabled) when none exists
+func loadOrCreatePrivacySalt(storageDir string, persist bool) string {
+	path := filepath.Join(storageDir, "privacy_salt")
+	if data, err := os.ReadFile(path); err == nil && len(data) > 0 {
+		return strings.TrimSpace(string(data))
+	}
+
+	salt := generateAPIKey()
+	if persist {
+		if err := os.MkdirAll(storageDir, 0755); err != nil {
+			log.Printf("Failed to create storage directory for privacy salt: %v", err)
+		} else if err := os.WriteFile(path, []byte(salt), 0600); err != nil {
+			log.Printf("Failed to save privacy salt: %v", err)
+		}
+	}
+	return salt
+}
+
+// getDeviceReadings returns a copy of the readings for a specific device with optional time range
 func (s *Server) getDeviceReadings(deviceAddr string, fromTime, toTime time.Time) ([]Reading, error) {
 	// First try to get from in-memory store
-	s.mu.RLock()
-	inMemoryReadings, exists := s.readings[deviceAddr]
-	s.mu.RUnlock()
-
+	shard := s.shardFor(deviceAddr)
+	shard.mu.RLock()
+	ring, exists := shard.readings[deviceAddr]
 	if exists && (fromTime.IsZero() && toTime.IsZero()) {
 		// If no time range is specified and readings exist in memory, return those
-		return inMemoryReadings, nil
+		readings := ring.Readings()
+		shard.mu.RUnlock()
+		return readings, nil
 	}
+	shard.mu.RUnlock()
 
 	// Otherwise, use the storage manager to get readings
 	return s.storageManager.loadReadings(deviceAddr, fromTime, toTime)
 }
 
-// getDeviceStats returns statistics for a specific device
-func (s *Server) getDeviceStats(deviceAddr string) map[string]interface{} {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// Gap is a stretch of time in which a device sent no readings
+type Gap struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration string    `json:"duration"`
+}
+
+// findGaps returns the intervals longer than threshold between consecutive readings, which
+// must be oldest first. If from or to is set, the time from from to the first reading and from
+// the last reading to to count too, so a device that stopped reporting (or reported nothing at
+// all) within the range shows a gap.
+func findGaps(readings []Reading, from, to time.Time, threshold time.Duration) []Gap {
+	gaps := []Gap{}
+	add := func(start, end time.Time) {
+		if end.Sub(start) > threshold {
+			gaps = append(gaps, Gap{Start: start, End: end, Duration: end.Sub(start).String()})
+		}
+	}
+
+	if len(readings) == 0 {
+		if !from.IsZero() && !to.IsZero() {
+			add(from, to)
+		}
+		return gaps
+	}
+
+	if !from.IsZero() {
+		add(from, readings[0].Timestamp)
+	}
+	for i := 1; i < len(readings); i++ {
+		add(readings[i-1].Timestamp, readings[i].Timestamp)
+	}
+	if !to.IsZero() {
+		add(readings[len(readings)-1].Timestamp, to)
+	}
+	return gaps
+}
+
+// sortReadings orders readings by timestamp, newest first if descending
+func sortReadings(readings []Reading, descending bool) {
+	sort.SliceStable(readings, func(i, j int) bool {
+		if descending {
+			return readings[i].Timestamp.After(readings[j].Timestamp)
+		}
+		return readings[i].Timestamp.Before(readings[j].Timestamp)
+	})
+}
+
+// downsample averages readings, which must be oldest first, into fixed time buckets aligned
+// to multiples of bucket since the zero time (so 15m buckets start on the quarter hour). It
+// returns one reading per non-empty bucket, oldest first and timestamped at the bucket's start.
+// Measurements are averaged; battery, RSSI, offsets, names and client come from the bucket's
+// last reading, and a bucket holding any suspect reading is suspect.
+func downsample(readings []Reading, bucket time.Duration) []Reading {
+	var result []Reading
+	var sum Reading
+	var count float64
+	var start time.Time
+
+	flush := func(last Reading) {
+		avg := last
+		avg.Timestamp = start
+		avg.TempC = sum.TempC / count
+		avg.TempF = sum.TempF / count
+		avg.Humidity = sum.Humidity / count
+		avg.AbsHumidity = sum.AbsHumidity / count
+		avg.DewPointC = sum.DewPointC / count
+		avg.DewPointF = sum.DewPointF / count
+		avg.SteamPressure = sum.SteamPressure / count
+		avg.HeatIndexC = sum.HeatIndexC / count
+		avg.HeatIndexF = sum.HeatIndexF / count
+		avg.VPD = sum.VPD / count
+		avg.FrostPointC = sum.FrostPointC / count
+		avg.MixingRatio = sum.MixingRatio / count
+		if sum.Quality != "" {
+			avg.Quality = sum.Quality
+		}
+		result = append(result, avg)
+	}
+
+	for i, r := range readings {
+		if rStart := r.Timestamp.Truncate(bucket); count == 0 || !rStart.Equal(start) {
+			if count > 0 {
+				flush(readings[i-1])
+			}
+			sum, count, start = Reading{}, 0, rStart
+		}
+		sum.TempC += r.TempC
+		sum.TempF += r.TempF
+		sum.Humidity += r.Humidity
+		sum.AbsHumidity += r.AbsHumidity
+		sum.DewPointC += r.DewPointC
+		sum.DewPointF += r.DewPointF
+		sum.SteamPressure += r.SteamPressure
+		sum.HeatIndexC += r.HeatIndexC
+		sum.HeatIndexF += r.HeatIndexF
+		sum.VPD += r.VPD
+		sum.FrostPointC += r.FrostPointC
+		sum.MixingRatio += r.MixingRatio
+		if r.Quality == qualitySuspect {
+			sum.Quality = qualitySuspect
+		}
+		count++
+	}
+	if count > 0 {
+		flush(readings[len(readings)-1])
+	}
+	return result
+}
+
+// getDeviceStats returns statistics for a specific device, including the given percentiles
+func (s *Server) getDeviceStats(deviceAddr string, percentiles []float64) map[string]interface{} {
+	shard := s.shardFor(deviceAddr)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return s.deviceStatsLocked(deviceAddr, percentiles)
+}
+
+// deviceStatsLocked is getDeviceStats for a caller already holding the device's shard lock
+func (s *Server) deviceStatsLocked(deviceAddr string, percentiles []float64) map[string]interface{} {
+	ring, exists := s.shardFor(deviceAddr).readings[deviceAddr]
+	if !exists {
+		return computeStats(nil, false)
+	}
+	stats := ring.Stats()
+	addPercentileStats(stats, ring.Len(), ring.At, percentiles)
+	return stats
+}
+
+// maxStatsDevices caps how many devices one /stats request may ask for
+const maxStatsDevices = 100
+
+// getMultiDeviceStats returns the stats of each of deviceAddrs, or of every device with
+// readings if all is set, keyed by address and taken under one lock so they are consistent.
+// It returns nil if that would be more than maxStatsDevices devices.
+func (s *Server) getMultiDeviceStats(deviceAddrs []string, all bool, percentiles []float64) map[string]map[string]interface{} {
+	s.rLockShards()
+	defer s.rUnlockShards()
+
+	if all {
+		deviceAddrs = nil
+		for _, shard := range s.shards {
+			for deviceAddr := range shard.readings {
+				deviceAddrs = append(deviceAddrs, deviceAddr)
+			}
+		}
+	}
+	if len(deviceAddrs) > maxStatsDevices {
+		return nil
+	}
+
+	stats := make(map[string]map[string]interface{}, len(deviceAddrs))
+	for _, deviceAddr := range deviceAddrs {
+		stats[deviceAddr] = s.deviceStatsLocked(deviceAddr, percentiles)
+	}
+	return stats
+}
+
+// defaultPercentiles are the percentiles /stats includes unless a percentiles parameter is given
+var defaultPercentiles = []float64{50, 95}
+
+// maxPercentiles caps how many percentiles one /stats request may ask for
+const maxPercentiles = 10
+
+// parsePercentiles parses a comma-separated list of percentiles between 0 and 100
+func parsePercentiles(list string) ([]float64, error) {
+	var percentiles []float64
+	for _, field := range strings.Split(list, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || p < 0 || p > 100 {
+			return nil, fmt.Errorf("invalid percentile %q", field)
+		}
+		percentiles = append(percentiles, p)
+	}
+	if len(percentiles) > maxPercentiles {
+		return nil, fmt.Errorf("at most %d percentiles are allowed", maxPercentiles)
+	}
+	return percentiles, nil
+}
+
+// percentile returns the p-th percentile of sorted values, interpolating linearly between
+// the closest ranks; values must not be empty
+func percentile(sorted []float64, p float64) float64 {
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (rank-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
+
+// addPercentileStats adds the given percentiles of temperature and humidity over n readings
+// (e.g. temp_c_p95), plus their medians. Percentiles are count-based whatever the weighting.
+func addPercentileStats(stats map[string]interface{}, n int, at func(int) Reading, percentiles []float64) {
+	if n == 0 {
+		return
+	}
+	temps, humidities := make([]float64, n), make([]float64, n)
+	for i := 0; i < n; i++ {
+		reading := at(i)
+		temps[i], humidities[i] = reading.TempC, reading.Humidity
+	}
+	for _, metric := range []struct {
+		key    string
+		values []float64
+	}{{"temp_c", temps}, {"humidity", humidities}} {
+		sort.Float64s(metric.values)
+		stats[metric.key+"_median"] = percentile(metric.values, 50)
+		for _, p := range percentiles {
+			stats[metric.key+"_p"+strconv.FormatFloat(p, 'f', -1, 64)] = percentile(metric.values, p)
+		}
+	}
+}
 
+// computeStats returns min, max and average of the primary metrics of readings, which must be
+// oldest first. With timeWeighted, each reading's average weight is the time it represents,
+// so a burst of dense readings doesn't outweigh a longer, sparsely sampled stretch.
+func computeStats(readings []Reading, timeWeighted bool) map[string]interface{} {
 	stats := make(map[string]interface{})
-	if readings, exists := s.readings[deviceAddr]; exists && len(readings) > 0 {
+	if len(readings) > 0 {
+		weights := readingWeights(readings, timeWeighted)
+
 		// Calculate min, max, avg for primary metrics
-		var sumTempC, sumHumidity, sumAbsHumidity, sumDewPointC, sumSteamPressure float64
+		var sumTempC, sumHumidity, sumAbsHumidity, sumDewPointC, sumSteamPressure, sumVPD, sumWeights float64
 		var minTempC, maxTempC = readings[0].TempC, readings[0].TempC
 		var minHumidity, maxHumidity = readings[0].Humidity, readings[0].Humidity
 		var minDewPointC, maxDewPointC = readings[0].DewPointC, readings[0].DewPointC
 		var minAbsHumidity, maxAbsHumidity = readings[0].AbsHumidity, readings[0].AbsHumidity
 		var minSteamPressure, maxSteamPressure = readings[0].SteamPressure, readings[0].SteamPressure
-
-		for _, r := range readings {
-			sumTempC += r.TempC
-			sumHumidity += r.Humidity
-			sumDewPointC += r.DewPointC
-			sumAbsHumidity += r.AbsHumidity
-			sumSteamPressure += r.SteamPressure
+		var minVPD, maxVPD = readings[0].VPD, readings[0].VPD
+
+		for i, r := range readings {
+			w := weights[i]
+			sumWeights += w
+			sumTempC += w * r.TempC
+			sumHumidity += w * r.Humidity
+			sumDewPointC += w * r.DewPointC
+			sumAbsHumidity += w * r.AbsHumidity
+			sumSteamPressure += w * r.SteamPressure
+			sumVPD += w * r.VPD
 
 			if r.TempC < minTempC {
 				minTempC = r.TempC
@@ -1160,101 +3345,283 @@ func (s *Server) getDeviceStats(deviceAddr string) map[string]interface{} {
 			if r.SteamPressure > maxSteamPressure {
 				maxSteamPressure = r.SteamPressure
 			}
+			if r.VPD < minVPD {
+				minVPD = r.VPD
+			}
+			if r.VPD > maxVPD {
+				maxVPD = r.VPD
+			}
 		}
 
-		count := float64(len(readings))
 		stats["count"] = len(readings)
+		stats["weighting"] = "count"
+		if timeWeighted {
+			stats["weighting"] = "time"
+		}
 
 		// Temperature stats
 		stats["temp_c_min"] = minTempC
 		stats["temp_c_max"] = maxTempC
-		stats["temp_c_avg"] = sumTempC / count
+		stats["temp_c_avg"] = sumTempC / sumWeights
 
 		// Humidity stats
 		stats["humidity_min"] = minHumidity
 		stats["humidity_max"] = maxHumidity
-		stats["humidity_avg"] = sumHumidity / count
+		stats["humidity_avg"] = sumHumidity / sumWeights
 
 		// Dew point stats
 		stats["dew_point_c_min"] = minDewPointC
 		stats["dew_point_c_max"] = maxDewPointC
-		stats["dew_point_c_avg"] = sumDewPointC / count
+		stats["dew_point_c_avg"] = sumDewPointC / sumWeights
 
 		// Absolute humidity stats
 		stats["abs_humidity_min"] = minAbsHumidity
 		stats["abs_humidity_max"] = maxAbsHumidity
-		stats["abs_humidity_avg"] = sumAbsHumidity / count
+		stats["abs_humidity_avg"] = sumAbsHumidity / sumWeights
 
 		// Steam pressure stats
 		stats["steam_pressure_min"] = minSteamPressure
 		stats["steam_pressure_max"] = maxSteamPressure
-		stats["steam_pressure_avg"] = sumSteamPressure / count
+		stats["steam_pressure_avg"] = sumSteamPressure / sumWeights
+
+		// Vapor pressure deficit stats
+		stats["vpd_min"] = minVPD
+		stats["vpd_max"] = maxVPD
+		stats["vpd_avg"] = sumVPD / sumWeights
 
 		// Add first and last readings timestamps
 		stats["first_reading"] = readings[0].Timestamp
 		stats["last_reading"] = readings[len(readings)-1].Timestamp
+
+		addTrendStats(stats,
+			readingTrend(readings, func(r Reading) float64 { return r.TempC }),
+			readingTrend(readings, func(r Reading) float64 { return r.Humidity }))
 	}
 	return stats
 }
 
-// getClientIP extracts the real client IP, only trusting X-Forwarded-For
-// from configured trusted proxy addresses to prevent IP spoofing.
-func (s *Server) getClientIP(r *http.Request) string {
-	// Strip port from RemoteAddr
-	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		remoteIP = r.RemoteAddr
+// trendSums accumulates a least-squares fit of a value against time, in hours since the
+// first reading, along with what's needed for the value's standard deviation
+type trendSums struct {
+	n                               float64
+	sumT, sumTT, sumY, sumTY, sumYY float64
+}
+
+// add includes a value taken hours after the first reading
+func (ts *trendSums) add(hours, y float64) {
+	ts.n++
+	ts.sumT += hours
+	ts.sumTT += hours * hours
+	ts.sumY += y
+	ts.sumTY += hours * y
+	ts.sumYY += y * y
+}
+
+// slope returns the fitted change per hour; ok is false with fewer than two readings or
+// when they were all taken at the same time
+func (ts trendSums) slope() (perHour float64, ok bool) {
+	denominator := ts.n*ts.sumTT - ts.sumT*ts.sumT
+	if ts.n < 2 || denominator <= 0 {
+		return 0, false
 	}
+	return (ts.n*ts.sumTY - ts.sumT*ts.sumY) / denominator, true
+}
 
-	// Only trust X-Forwarded-For if the direct connection is from a trusted proxy
-	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" && len(s.config.TrustedProxies) > 0 {
-		ip := net.ParseIP(remoteIP)
-		if ip != nil {
-			for _, cidr := range s.config.TrustedProxies {
-				if cidr.Contains(ip) {
-					// Trusted proxy: use the first (leftmost) client IP
-					return strings.TrimSpace(strings.Split(forwarded, ",")[0])
-				}
-			}
-		}
+// stddev returns the population standard deviation of the values
+func (ts trendSums) stddev() float64 {
+	if ts.n == 0 {
+		return 0
 	}
+	mean := ts.sumY / ts.n
+	// Rounding can leave a flat series' variance just below zero
+	return math.Sqrt(math.Max(0, ts.sumYY/ts.n-mean*mean))
+}
 
-	return remoteIP
+// readingTrend accumulates value over readings, which must be oldest first
+func readingTrend(readings []Reading, value func(Reading) float64) trendSums {
+	var ts trendSums
+	for _, r := range readings {
+		ts.add(r.Timestamp.Sub(readings[0].Timestamp).Hours(), value(r))
+	}
+	return ts
 }
 
-// rateLimitMiddleware enforces rate limiting per IP address
-func (s *Server) rateLimitMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Skip rate limiting for health checks
-		if r.URL.Path == "/health" {
-			next.ServeHTTP(w, r)
-			return
-		}
+// addTrendStats adds the temperature spread and the temperature and humidity trends to stats.
+// They are count-weighted whatever the weighting, and the trends are left out when there
+// aren't two readings at different times to fit a line through.
+func addTrendStats(stats map[string]interface{}, temp, humidity trendSums) {
+	stats["temp_c_stddev"] = temp.stddev()
+	if slope, ok := temp.slope(); ok {
+		stats["temp_c_trend_per_hour"] = slope
+	}
+	if slope, ok := humidity.slope(); ok {
+		stats["humidity_trend_per_hour"] = slope
+	}
+}
 
-		ip := s.getClientIP(r)
+// readingWeights returns the weight of each reading in an average: 1 each, or with timeWeighted
+// the interval a reading represents, i.e. half the gap to each neighbour. Falls back to equal
+// weights when the readings span no time.
+func readingWeights(readings []Reading, timeWeighted bool) []float64 {
+	weights := make([]float64, len(readings))
+	for i := range weights {
+		weights[i] = 1
+	}
+	if !timeWeighted || len(readings) < 2 {
+		return weights
+	}
 
-		limiter := s.rateLimiter.GetLimiter(ip)
-		if !limiter.Allow() {
-			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
-			log.Printf("Rate limit exceeded for IP: %s", ip)
-			return
+	var total float64
+	for i := range readings {
+		var w float64
+		if i > 0 {
+			w += readings[i].Timestamp.Sub(readings[i-1].Timestamp).Seconds() / 2
 		}
-
-		next.ServeHTTP(w, r)
-	})
+		if i < len(readings)-1 {
+			w += readings[i+1].Timestamp.Sub(readings[i].Timestamp).Seconds() / 2
+		}
+		weights[i] = w
+		total += w
+	}
+	if total <= 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+	return weights
 }
 
-// gzipResponseWriter wraps http.ResponseWriter to defer gzip headers until
-// the first Write, so error responses are not incorrectly marked as gzip.
-type gzipResponseWriter struct {
-	http.ResponseWriter
+// parseCIDRList parses a comma-separated list of CIDR ranges, skipping empty entries
+func parseCIDRList(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(list, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %v", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// fromTrustedNetwork reports whether the request's client IP (see getClientIP) is in one of
+// the -trusted-cidrs ranges
+func (s *Server) fromTrustedNetwork(r *http.Request) bool {
+	if len(s.config.TrustedCIDRs) == 0 {
+		return false
+	}
+	ip := net.ParseIP(s.getClientIP(r))
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range s.config.TrustedCIDRs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAdminPath reports whether path is an admin endpoint, which always needs the admin key
+func isAdminPath(path string) bool {
+	return path == "/api/keys" || strings.HasPrefix(path, "/api/keys/") || strings.HasPrefix(path, "/admin/")
+}
+
+// getClientIP extracts the real client IP, only trusting X-Forwarded-For
+// from configured trusted proxy addresses to prevent IP spoofing.
+func (s *Server) getClientIP(r *http.Request) string {
+	// Strip port from RemoteAddr
+	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteIP = r.RemoteAddr
+	}
+
+	// Only trust X-Forwarded-For if the direct connection is from a trusted proxy
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" && s.isTrustedProxy(remoteIP) {
+		// Each proxy appends the address it received the request from, so the client is the
+		// rightmost hop that isn't one of our proxies. Hops further left are whatever the
+		// client chose to send.
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break
+			}
+			// In canonical form, so that spelling an IPv6 address differently doesn't make
+			// it a new client to the rate limiter and auth lockout
+			if hop := ip.String(); i == 0 || !s.isTrustedProxy(hop) {
+				return hop
+			}
+		}
+	}
+
+	return remoteIP
+}
+
+// isTrustedProxy reports whether ip is in one of the -trusted-proxies ranges
+func (s *Server) isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, cidr := range s.config.TrustedProxies {
+		if cidr.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitMiddleware enforces rate limiting per IP address
+func (s *Server) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Skip rate limiting for health and readiness checks
+		if r.URL.Path == "/health" || r.URL.Path == "/ready" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := s.getClientIP(r)
+
+		limiter := s.rateLimiter.GetLimiter(ip)
+		reservation := limiter.Reserve()
+		if delay := reservation.Delay(); !reservation.OK() || delay > 0 {
+			// Give the token back so rejected requests don't push the wait further out
+			reservation.Cancel()
+			retryAfter := int(math.Ceil(delay.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":               "rate_limited",
+				"retry_after_seconds": retryAfter,
+			})
+			s.logf(logLevelInfo, "Rate limit exceeded for IP: %s (retry after %ds)", ip, retryAfter)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// gzipResponseWriter wraps http.ResponseWriter to defer gzip headers until
+// the first Write, so error responses are not incorrectly marked as gzip.
+type gzipResponseWriter struct {
+	http.ResponseWriter
 	gz          *gzip.Writer
 	wroteHeader bool
 }
 
 func (w *gzipResponseWriter) WriteHeader(code int) {
-	if code >= 400 {
-		// Error responses: don't compress, let the plain writer handle it
+	if code >= 400 || code == http.StatusNotModified || code == http.StatusNoContent {
+		// Error and bodiless responses: don't compress, let the plain writer handle it
 		w.wroteHeader = true
 		w.ResponseWriter.WriteHeader(code)
 		return
@@ -1288,10 +3655,152 @@ func (s *Server) compressionMiddleware(next http.Handler) http.Handler {
 		}
 
 		gz := gzip.NewWriter(w)
-		defer gz.Close()
-
 		gzw := &gzipResponseWriter{ResponseWriter: w, gz: gz}
 		next.ServeHTTP(gzw, r)
+
+		// Only finish the gzip stream if the response was compressed; closing it otherwise
+		// would append an empty gzip stream to a plain body
+		if w.Header().Get("Content-Encoding") == "gzip" {
+			gz.Close()
+		}
+	})
+}
+
+// gzipRequestBody reads a gzipped request body, closing the original body with it
+type gzipRequestBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b *gzipRequestBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
+
+// decompressionMiddleware transparently decompresses request bodies sent with
+// Content-Encoding: gzip, so the auth check, the handler and the body size limit all see the
+// decoded JSON. Other encodings are rejected with 415.
+func (s *Server) decompressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+		case "", "identity":
+		case "gzip":
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, "Invalid gzip request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = &gzipRequestBody{Reader: gz, body: r.Body}
+			r.Header.Del("Content-Encoding")
+			r.ContentLength = -1
+		default:
+			http.Error(w, "Unsupported Content-Encoding", http.StatusUnsupportedMediaType)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loggingResponseWriter records the status code and body size written by the handler
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *loggingResponseWriter) WriteHeader(code int) {
+	// Only the first call reaches the client, so only it is logged
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *loggingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// loggingMiddleware logs the method, path, client IP, status, response size and duration of
+// each request. Health and readiness checks are skipped since load balancers poll them constantly.
+func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/ready" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		lw := &loggingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(lw, r)
+
+		status := lw.status
+		if status == 0 {
+			// Nothing was written, which net/http sends as an empty 200
+			status = http.StatusOK
+		}
+		log.Printf("%s %s from %s: %d (%d bytes) in %v", r.Method, r.URL.Path, s.getClientIP(r), status, lw.bytes, time.Since(start))
+	})
+}
+
+// instanceHeadersMiddleware tags every response with the server version and process instance ID,
+// so requests behind a load balancer can be traced to the instance that served them
+func (s *Server) instanceHeadersMiddleware(next http.Handler) http.Handler {
+	if !s.config.InstanceHeaders {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Govee-Instance", s.instanceID)
+		w.Header().Set("X-Govee-Version", Version)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsMiddleware lets browser dashboards on the configured origins call the API. Preflight
+// requests are answered here, ahead of auth, since browsers send them without the API key.
+func (s *Server) corsMiddleware(next http.Handler) http.Handler {
+	if len(s.config.CORSOrigins) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" {
+			allowed := ""
+			for _, o := range s.config.CORSOrigins {
+				if o == "*" || o == origin {
+					allowed = o
+					break
+				}
+			}
+			if allowed != "*" {
+				// The response depends on the Origin header, so caches must not share it across origins
+				w.Header().Add("Vary", "Origin")
+			}
+			if allowed != "" {
+				w.Header().Set("Access-Control-Allow-Origin", allowed)
+				w.Header().Set("Access-Control-Expose-Headers", "Retry-After, X-Govee-Instance, X-Govee-Version")
+			}
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				if allowed != "" {
+					w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+					w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-API-Key")
+					w.Header().Set("Access-Control-Max-Age", "600")
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
 	})
 }
 
@@ -1332,6 +3841,17 @@ func (s *Server) securityHeadersMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// requestAPIKey returns the API key from an "Authorization: Bearer <key>" header, falling back
+// to X-API-Key. Other authorization schemes and empty or malformed bearer tokens are ignored.
+func requestAPIKey(r *http.Request) string {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		if token = strings.TrimSpace(token); token != "" && !strings.ContainsAny(token, " \t") {
+			return token
+		}
+	}
+	return r.Header.Get("X-API-Key")
+}
+
 // Authentication middleware
 func (s *Server) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -1347,97 +3867,256 @@ func (s *Server) authMiddleware(next http.Handler) http.Handler {
 			strings.HasPrefix(r.URL.Path, "/css/") ||
 			strings.HasPrefix(r.URL.Path, "/img/") ||
 			r.URL.Path == "/health" ||
+			r.URL.Path == "/ready" ||
+			r.URL.Path == "/version" ||
 			r.URL.Path == "/dashboard/data") {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Check for API key in header
-		apiKey := r.Header.Get("X-API-Key")
-		if apiKey == "" {
-			http.Error(w, "Unauthorized: API key required", http.StatusUnauthorized)
-			log.Printf("Authentication failed: No API key provided from %s", r.RemoteAddr)
-			return
+		// An IP that failed too often gets no further keys checked until its cooldown ends
+		if s.authLockout != nil {
+			if until, locked := s.authLockout.LockedUntil(s.getClientIP(r), time.Now()); locked {
+				retryAfter := int(math.Ceil(time.Until(until).Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusTooManyRequests)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"error":               "locked_out",
+					"retry_after_seconds": retryAfter,
+				})
+				return
+			}
 		}
 
+		// Check for API key in the Authorization or X-API-Key header
+		apiKey := requestAPIKey(r)
+
 		// Check if it's the admin key
-		if apiKey == s.auth.AdminKey {
+		if apiKey != "" && keysEqual(apiKey, s.adminKey()) {
 			// Admin key has access to everything
+			if s.authLockout != nil {
+				s.authLockout.RecordSuccess(s.getClientIP(r))
+			}
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Check if it's the default key (if allowed)
-		if s.auth.AllowDefaultKey && apiKey == s.auth.DefaultAPIKey {
-			next.ServeHTTP(w, r)
-			return
-		}
-
-		// Check if the API key is valid
-		clientID, valid := s.auth.APIKeys[apiKey]
-		if !valid {
-			http.Error(w, "Unauthorized: Invalid API key", http.StatusUnauthorized)
-			log.Printf("Authentication failed from %s", r.RemoteAddr)
+		// Admin endpoints take nothing else: not client keys, the default key, client
+		// certificates or a trusted network
+		if isAdminPath(r.URL.Path) {
+			reason := "admin API key required"
+			if apiKey == "" {
+				reason = "no API key"
+			}
+			http.Error(w, "Unauthorized: Admin API key required", http.StatusUnauthorized)
+			log.Printf("Rejected %s %s from %s: %s", r.Method, r.URL.Path, r.RemoteAddr, reason)
+			s.authFailed(r, auditEntry{Reason: reason})
 			return
 		}
 
-		// For POST to /readings, validate client ID and preserve request body
-		if r.Method == "POST" && r.URL.Path == "/readings" {
-			// Read body once (limited to 1MB)
-			bodyBytes, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
-			r.Body.Close()
+		// A verified client certificate identifies the client by its CN in place of an API key
+		if cn, ok := verifiedClientCertCN(r); ok {
+			clientID, err := sanitizeClientID(cn)
 			if err != nil {
-				http.Error(w, "Failed to read request body", http.StatusBadRequest)
-				log.Printf("Failed to read request body: %v", err)
+				http.Error(w, "Unauthorized: Client certificate CN is not a valid client ID", http.StatusUnauthorized)
+				log.Printf("Authentication failed from %s: client certificate CN %q: %v", r.RemoteAddr, cn, err)
+				s.authFailed(r, auditEntry{Reason: "invalid client certificate CN"})
 				return
 			}
-
-			// Parse JSON
-			var reading Reading
-			if err := json.Unmarshal(bodyBytes, &reading); err != nil {
-				http.Error(w, "Invalid JSON in request body", http.StatusBadRequest)
-				log.Printf("Invalid JSON from %s: %v", r.RemoteAddr, err)
-				return
+			if s.checkReadingClientID(w, r, clientID, "") {
+				next.ServeHTTP(w, r)
 			}
+			return
+		}
 
-			// Validate client ID matches API key
-			if reading.ClientID != clientID {
-				http.Error(w, "Unauthorized: Client ID mismatch", http.StatusUnauthorized)
-				log.Printf("Client ID mismatch from %s", r.RemoteAddr)
+		if apiKey == "" {
+			// Clients on a trusted network may leave out the key. A key that is sent is still
+			// checked below.
+			if s.fromTrustedNetwork(r) {
+				next.ServeHTTP(w, r)
 				return
 			}
+			http.Error(w, "Unauthorized: API key required", http.StatusUnauthorized)
+			log.Printf("Authentication failed: No API key provided from %s", r.RemoteAddr)
+			s.authFailed(r, auditEntry{Reason: "no API key"})
+			return
+		}
+
+		// Check if it's the default key (if allowed)
+		if s.auth.AllowDefaultKey && keysEqual(apiKey, s.auth.DefaultAPIKey) {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-			// Restore body for handler
-			r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+		// Check if the API key is valid. The map is keyed by hash, so how long a lookup takes
+		// says nothing about how close the presented key is to a real one. The map is replaced
+		// by a restore and changed through the API, so it's only read under s.mu.
+		keyHash := hashAPIKey(apiKey)
+		s.mu.RLock()
+		key, valid := s.auth.APIKeys[keyHash]
+		s.mu.RUnlock()
+		if !valid {
+			http.Error(w, "Unauthorized: Invalid API key", http.StatusUnauthorized)
+			log.Printf("Authentication failed from %s", r.RemoteAddr)
+			s.authFailed(r, auditEntry{Reason: "invalid API key"})
+			return
+		}
+		if !key.Enabled {
+			http.Error(w, "Unauthorized: API key is disabled", http.StatusUnauthorized)
+			log.Printf("Authentication failed from %s: API key for %s is disabled", r.RemoteAddr, key.ClientID)
+			s.authFailed(r, auditEntry{ClientID: key.ClientID, KeyHash: keyHash, Reason: "API key disabled"})
+			return
+		}
+		if key.Expired(time.Now()) {
+			http.Error(w, fmt.Sprintf("Unauthorized: API key expired at %s", key.ExpiresAt.Format(time.RFC3339)), http.StatusUnauthorized)
+			log.Printf("Authentication failed from %s: API key for %s expired", r.RemoteAddr, key.ClientID)
+			s.authFailed(r, auditEntry{ClientID: key.ClientID, KeyHash: keyHash, Reason: "API key expired"})
+			return
+		}
+		if !key.Allows(r.Method) {
+			http.Error(w, "Forbidden: API key is read-only", http.StatusForbidden)
+			log.Printf("Rejected %s %s from %s: API key for %s is read-only", r.Method, r.URL.Path, r.RemoteAddr, key.ClientID)
+			s.audit(r, auditEntry{Event: auditAuthFailed, ClientID: key.ClientID, KeyHash: keyHash, Reason: "API key is read-only"})
+			return
+		}
+		if !s.checkReadingClientID(w, r, key.ClientID, keyHash) {
+			return
 		}
 
 		// API key is valid
+		s.recordKeyUse(keyHash, key)
+		if s.authLockout != nil {
+			s.authLockout.RecordSuccess(s.getClientIP(r))
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// verifiedClientCertCN returns the common name of the client certificate the TLS handshake
+// verified, if any. Certificates are only verified when the server requires them
+// (-require-client-cert), so an unverified certificate never authenticates a request.
+func verifiedClientCertCN(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName, true
+}
+
+// defaultMaxBodyBytes is the request body limit when none is configured
+const defaultMaxBodyBytes = 1 << 20
+
+// limitBody caps how much of the request body handlers can read at the configured limit
+func (s *Server) limitBody(w http.ResponseWriter, r *http.Request) {
+	limit := s.config.MaxBodyBytes
+	if limit <= 0 {
+		limit = defaultMaxBodyBytes
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+}
+
+// respondBodyError reports a failure to read or decode a request body limited by limitBody:
+// 413 if it was too large, 400 otherwise
+func respondBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("Request body too large (limit %d bytes)", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "Invalid request body", http.StatusBadRequest)
+}
+
+// loadCertPool reads the PEM certificates in path into a pool for verifying client certificates
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// checkReadingClientID makes sure a POST to /readings is for clientID, the client the request
+// authenticated as, and leaves the body in place for the handler. It writes the error response
+// and returns false if the reading can't be read or belongs to another client.
+func (s *Server) checkReadingClientID(w http.ResponseWriter, r *http.Request, clientID, keyHash string) bool {
+	if r.Method != "POST" || r.URL.Path != "/readings" {
+		return true
+	}
+
+	// Read body once, within the same size limit as the handler
+	s.limitBody(w, r)
+	bodyBytes, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		respondBodyError(w, err)
+		log.Printf("Failed to read request body from %s: %v", r.RemoteAddr, err)
+		return false
+	}
+
+	// Parse JSON
+	var reading Reading
+	if err := json.Unmarshal(bodyBytes, &reading); err != nil {
+		http.Error(w, "Invalid JSON in request body", http.StatusBadRequest)
+		log.Printf("Invalid JSON from %s: %v", r.RemoteAddr, err)
+		return false
+	}
+
+	// Validate client ID matches the API key or certificate
+	if reading.ClientID != clientID {
+		http.Error(w, "Unauthorized: Client ID mismatch", http.StatusUnauthorized)
+		log.Printf("Client ID mismatch from %s", r.RemoteAddr)
+		s.authFailed(r, auditEntry{ClientID: clientID, KeyHash: keyHash, Reason: "client ID mismatch"})
+		return false
+	}
+
+	// Restore body for handler
+	r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	return true
+}
+
 // handlers for HTTP endpoints
 
 func (s *Server) handleReadings(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "POST":
-		// Limit request body size to 1MB to prevent DoS
-		r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+		// Limit request body size to prevent DoS
+		s.limitBody(w, r)
 
 		// Add a new reading
 		var reading Reading
 		if err := json.NewDecoder(r.Body).Decode(&reading); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			respondBodyError(w, err)
 			return
 		}
 
+		trace.SpanFromContext(r.Context()).SetAttributes(deviceAttr(reading.DeviceAddr), clientAttr(reading.ClientID))
+
 		// Validate reading
-		if err := validateReading(&reading); err != nil {
+		if err := validateReading(&reading, s.config.MaxReadingAge); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid reading: %v", err), http.StatusBadRequest)
+			s.logf(logLevelInfo, "Invalid reading from %s: %v", r.RemoteAddr, err)
+			s.logRejection(r, reading, err)
+			return
+		}
+
+		// Older clients don't send VPD; it depends only on temperature and humidity, so work it out here
+		if reading.VPD == 0 {
+			reading.VPD = calculateVPD(reading.TempC, reading.Humidity)
+		}
+
+		// Run custom validators
+		if err := s.runValidators(&reading); err != nil {
 			http.Error(w, fmt.Sprintf("Invalid reading: %v", err), http.StatusBadRequest)
-			log.Printf("Invalid reading from %s: %v", r.RemoteAddr, err)
+			s.logf(logLevelInfo, "Reading rejected by validator from %s: %v", r.RemoteAddr, err)
+			s.logRejection(r, reading, err)
 			return
 		}
 
+		s.logf(logLevelDebug, "Accepted reading for %s from client %s", reading.DeviceAddr, reading.ClientID)
 		s.addReading(reading)
 		w.WriteHeader(http.StatusCreated)
 
@@ -1472,99 +4151,657 @@ func (s *Server) handleReadings(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		// Parse sort order (chronological by default)
+		order := r.URL.Query().Get("order")
+		if order != "" && order != "asc" && order != "desc" {
+			http.Error(w, "Invalid 'order' parameter. Use 'asc' or 'desc'", http.StatusBadRequest)
+			return
+		}
+
+		// Optionally average readings into fixed time buckets, e.g. bucket=15m for charting
+		var bucket time.Duration
+		if bucketStr := r.URL.Query().Get("bucket"); bucketStr != "" {
+			bucket, err = time.ParseDuration(bucketStr)
+			if err != nil || bucket <= 0 {
+				http.Error(w, "Invalid 'bucket' parameter. Use a positive duration (e.g., 15m)", http.StatusBadRequest)
+				return
+			}
+		}
+
+		trace.SpanFromContext(r.Context()).SetAttributes(deviceAttr(deviceAddr))
 		readings, err := s.getDeviceReadings(deviceAddr, fromTime, toTime)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Error loading readings: %v", err), http.StatusInternalServerError)
 			return
 		}
+		if bucket > 0 {
+			sortReadings(readings, false)
+			readings = downsample(readings, bucket)
+		}
+		sortReadings(readings, order == "desc")
 
-		// Inject display name if alias is set
+		// Inject display name if alias is set, and hide client IDs in privacy mode
 		s.mu.RLock()
 		alias := s.getDisplayName(deviceAddr)
 		s.mu.RUnlock()
-		if alias != "" {
-			for i := range readings {
+		for i := range readings {
+			if alias != "" {
 				readings[i].DisplayName = alias
 			}
+			readings[i].ClientID = s.publicClientID(readings[i].ClientID)
 		}
 
-		respondJSON(w, readings)
+		respondJSONReadings(w, readings)
 
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// logRejection appends a rejected reading with its reason and source to the reject log
+func (s *Server) logRejection(r *http.Request, reading Reading, reason error) {
+	if s.rejectLog == nil {
 		return
 	}
-	devices := s.getDevices()
-	respondJSON(w, devices)
-}
 
-func (s *Server) handleClients(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	entry, err := json.Marshal(rejectedReading{
+		Timestamp: time.Now(),
+		Reason:    reason.Error(),
+		RemoteIP:  s.getClientIP(r),
+		ClientID:  reading.ClientID,
+		Reading:   reading,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal rejected reading: %v", err)
 		return
 	}
-	clients := s.getClients()
-	respondJSON(w, clients)
+	if _, err := s.rejectLog.Write(append(entry, '\n')); err != nil {
+		log.Printf("Failed to write reject log: %v", err)
+	}
 }
 
-func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+// Audit log events
+const (
+	auditKeyCreated  = "key_created"
+	auditKeyDeleted  = "key_deleted"
+	auditKeyEnabled  = "key_enabled"
+	auditKeyDisabled = "key_disabled"
+	auditAdminRotate = "admin_key_rotated"
+	auditLockout     = "auth_lockout"
+	auditAuthFailed  = "auth_failed"
+)
 
-	deviceAddr := r.URL.Query().Get("device")
-	if deviceAddr == "" {
-		http.Error(w, "Missing device parameter", http.StatusBadRequest)
+// auditEntry is an entry in the audit log
+type auditEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Event     string    `json:"event"`
+	RemoteIP  string    `json:"remote_ip"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	ClientID  string    `json:"client_id,omitempty"`
+	KeyHash   string    `json:"key_hash,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// authFailed records a request rejected for bad credentials in the audit log and counts it
+// towards locking out the client IP
+func (s *Server) authFailed(r *http.Request, entry auditEntry) {
+	entry.Event = auditAuthFailed
+	s.audit(r, entry)
+	if s.authLockout == nil {
 		return
 	}
-
-	stats := s.getDeviceStats(deviceAddr)
-	respondJSON(w, stats)
+	ip := s.getClientIP(r)
+	if s.authLockout.RecordFailure(ip, time.Now()) {
+		log.Printf("Locking out %s for %s after %d failed authentication attempts", ip, s.authLockout.cooldown, s.authLockout.maxFailures)
+		s.audit(r, auditEntry{Event: auditLockout, Reason: fmt.Sprintf("%d failed attempts", s.authLockout.maxFailures)})
+	}
 }
 
-func (s *Server) handleDashboardData(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// audit appends an event for request r to the audit log, syncing it to disk so entries
+// survive a crash right after the action they record
+func (s *Server) audit(r *http.Request, entry auditEntry) {
+	if s.auditLog == nil {
 		return
 	}
 
-	// Try to get cached data first
-	if cached := s.dashboardCache.Get(); cached != nil {
-		respondJSON(w, cached)
+	entry.Timestamp = time.Now().UTC()
+	entry.RemoteIP = s.getClientIP(r)
+	entry.Method = r.Method
+	entry.Path = r.URL.Path
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Failed to marshal audit entry: %v", err)
 		return
 	}
-
+	if _, err := s.auditLog.Write(append(line, '\n')); err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+		return
+	}
+	if err := s.auditLog.Sync(); err != nil {
+		log.Printf("Failed to sync audit log: %v", err)
+	}
+}
+
+func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	units, err := normalizeUnits(r.URL.Query().Get("units"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	devices := s.getDevicesInUnits(units)
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		tagged := make([]*DeviceStatus, 0, len(devices))
+		for _, d := range devices {
+			if hasTag(d.Tags, tag) {
+				tagged = append(tagged, d)
+			}
+		}
+		devices = tagged
+	}
+	respondJSON(w, devices)
+}
+
+func (s *Server) handleClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Clients loaded from disk stay listed (as inactive) long after they stop reporting
+	var filter clientFilter
+	if activeStr := r.URL.Query().Get("active"); activeStr != "" {
+		active, err := strconv.ParseBool(activeStr)
+		if err != nil {
+			http.Error(w, "Invalid 'active' parameter. Use true or false", http.StatusBadRequest)
+			return
+		}
+		filter.activeOnly = active
+	}
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		since, err := time.ParseDuration(sinceStr)
+		if err != nil || since <= 0 {
+			http.Error(w, "Invalid 'since' parameter. Use a positive duration (e.g., 1h)", http.StatusBadRequest)
+			return
+		}
+		filter.seenSince = time.Now().Add(-since)
+	}
+
+	clients := s.getClients(filter)
+	respondJSON(w, clients)
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deviceAddr := r.URL.Query().Get("device")
+	if deviceAddr == "" {
+		http.Error(w, "Missing device parameter", http.StatusBadRequest)
+		return
+	}
+
+	// Averages are per reading by default; weighting=time weights each reading by the time it covers
+	weighting := r.URL.Query().Get("weighting")
+	if weighting != "" && weighting != "count" && weighting != "time" {
+		http.Error(w, "Invalid 'weighting' parameter. Use 'count' or 'time'", http.StatusBadRequest)
+		return
+	}
+
+	percentiles := defaultPercentiles
+	if list := r.URL.Query().Get("percentiles"); list != "" {
+		var err error
+		if percentiles, err = parsePercentiles(list); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid 'percentiles' parameter: %v. Use comma-separated numbers between 0 and 100 (e.g., 50,95,99)", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	fromTimeStr := r.URL.Query().Get("from")
+	toTimeStr := r.URL.Query().Get("to")
+
+	// device=addr1,addr2,... or device=all returns a map of address to stats
+	if deviceAddr == "all" || strings.Contains(deviceAddr, ",") {
+		if fromTimeStr != "" || toTimeStr != "" || weighting == "time" {
+			http.Error(w, "Time ranges and weighting=time need a single device; use /stats/all for range stats of every device", http.StatusBadRequest)
+			return
+		}
+		var deviceAddrs []string
+		seen := make(map[string]bool)
+		for _, addr := range strings.Split(deviceAddr, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" && !seen[addr] {
+				seen[addr] = true
+				deviceAddrs = append(deviceAddrs, addr)
+			}
+		}
+		stats := s.getMultiDeviceStats(deviceAddrs, deviceAddr == "all", percentiles)
+		if stats == nil {
+			http.Error(w, fmt.Sprintf("Too many devices, maximum is %d per request", maxStatsDevices), http.StatusBadRequest)
+			return
+		}
+		respondJSON(w, stats)
+		return
+	}
+
+	if fromTimeStr == "" && toTimeStr == "" && weighting != "time" {
+		respondJSON(w, s.getDeviceStats(deviceAddr, percentiles))
+		return
+	}
+
+	// Range-based stats read from storage, which may span several partitions
+	var fromTime, toTime time.Time
+	var err error
+	if fromTimeStr != "" {
+		if fromTime, err = time.Parse(time.RFC3339, fromTimeStr); err != nil {
+			http.Error(w, "Invalid 'from' time format. Use RFC3339 format (e.g., 2023-04-10T15:04:05Z)", http.StatusBadRequest)
+			return
+		}
+	}
+	if toTimeStr != "" {
+		if toTime, err = time.Parse(time.RFC3339, toTimeStr); err != nil {
+			http.Error(w, "Invalid 'to' time format. Use RFC3339 format (e.g., 2023-04-10T15:04:05Z)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// With -db-stats, count-weighted range stats are aggregated by the database, without percentiles
+	if s.config.DBStats && s.aggregateStore != nil && weighting != "time" && (fromTimeStr != "" || toTimeStr != "") {
+		stats, err := s.aggregateStore.GetDeviceStats(deviceAddr, fromTime, toTime)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error computing stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, stats)
+		return
+	}
+
+	readings, err := s.getDeviceReadings(deviceAddr, fromTime, toTime)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error loading readings: %v", err), http.StatusInternalServerError)
+		return
+	}
+	sortReadings(readings, false)
+
+	stats := computeStats(readings, weighting == "time")
+	addPercentileStats(stats, len(readings), func(i int) Reading { return readings[i] }, percentiles)
+	respondJSON(w, stats)
+}
+
+// defaultGapThreshold is the longest silence /gaps ignores unless a threshold is given
+const defaultGapThreshold = 10 * time.Minute
+
+// handleGaps reports when a device stopped reporting: the intervals in a time range (the last
+// 24 hours by default) longer than threshold without a reading
+func (s *Server) handleGaps(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deviceAddr := r.URL.Query().Get("device")
+	if deviceAddr == "" {
+		http.Error(w, "Missing device parameter", http.StatusBadRequest)
+		return
+	}
+
+	threshold := defaultGapThreshold
+	var err error
+	if thresholdStr := r.URL.Query().Get("threshold"); thresholdStr != "" {
+		if threshold, err = time.ParseDuration(thresholdStr); err != nil || threshold <= 0 {
+			http.Error(w, "Invalid 'threshold' parameter. Use a positive duration (e.g., 10m)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	toTime := time.Now()
+	if toTimeStr := r.URL.Query().Get("to"); toTimeStr != "" {
+		if toTime, err = time.Parse(time.RFC3339, toTimeStr); err != nil {
+			http.Error(w, "Invalid 'to' time format. Use RFC3339 format (e.g., 2023-04-10T15:04:05Z)", http.StatusBadRequest)
+			return
+		}
+	}
+	fromTime := toTime.Add(-24 * time.Hour)
+	if fromTimeStr := r.URL.Query().Get("from"); fromTimeStr != "" {
+		if fromTime, err = time.Parse(time.RFC3339, fromTimeStr); err != nil {
+			http.Error(w, "Invalid 'from' time format. Use RFC3339 format (e.g., 2023-04-10T15:04:05Z)", http.StatusBadRequest)
+			return
+		}
+	}
+	if !fromTime.Before(toTime) {
+		http.Error(w, "'from' must be before 'to'", http.StatusBadRequest)
+		return
+	}
+
+	readings, err := s.getDeviceReadings(deviceAddr, fromTime, toTime)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error loading readings: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Readings received since the last save are only in memory
+	seen := make(map[int64]bool, len(readings))
+	for _, reading := range readings {
+		seen[reading.Timestamp.UnixNano()] = true
+	}
+	shard := s.shardFor(deviceAddr)
+	shard.mu.RLock()
+	if ring, exists := shard.readings[deviceAddr]; exists {
+		for _, reading := range ring.Readings() {
+			if !reading.Timestamp.Before(fromTime) && !reading.Timestamp.After(toTime) && !seen[reading.Timestamp.UnixNano()] {
+				readings = append(readings, reading)
+			}
+		}
+	}
+	shard.mu.RUnlock()
+	sortReadings(readings, false)
+
+	respondJSON(w, findGaps(readings, fromTime, toTime, threshold))
+}
+
+// handleReadingsLatest returns a device's most recent reading, from memory or else from the
+// SQLite database, without the history /readings would send
+func (s *Server) handleReadingsLatest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deviceAddr := r.URL.Query().Get("device")
+	if deviceAddr == "" {
+		http.Error(w, "Missing device parameter", http.StatusBadRequest)
+		return
+	}
+
+	shard := s.shardFor(deviceAddr)
+	shard.mu.RLock()
+	ring, exists := shard.readings[deviceAddr]
+	if exists && ring.Len() > 0 {
+		latest := ring.Last()
+		shard.mu.RUnlock()
+		s.respondLatestReading(w, latest)
+		return
+	}
+	shard.mu.RUnlock()
+
+	if s.aggregateStore != nil {
+		// A one-reading page, newest first
+		readings, _, err := s.aggregateStore.GetReadingsPage(0, 1, deviceAddr, "", time.Time{}, time.Time{})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error loading readings: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if len(readings) > 0 {
+			s.respondLatestReading(w, readings[0])
+			return
+		}
+	}
+	http.Error(w, "No readings for device", http.StatusNotFound)
+}
+
+// respondLatestReading sends a reading as /readings would: with the device's display name,
+// and its client ID hidden in privacy mode
+func (s *Server) respondLatestReading(w http.ResponseWriter, reading Reading) {
+	s.mu.RLock()
+	if alias := s.getDisplayName(reading.DeviceAddr); alias != "" {
+		reading.DisplayName = alias
+	}
+	s.mu.RUnlock()
+	reading.ClientID = s.publicClientID(reading.ClientID)
+	respondJSON(w, reading)
+}
+
+// Limits on /stats/all, which reads one hourly bucket per device and hour in the range
+const (
+	maxStatsAllDevices = 500
+	maxStatsAllRange   = 366 * 24 * time.Hour
+)
+
+// handleStatsAll returns range stats for every device from the hourly aggregates in storage,
+// so history beyond the in-memory readings is covered without loading raw readings
+func (s *Server) handleStatsAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.aggregateStore == nil {
+		http.Error(w, "Stats for all devices require the SQLite backend (-db-path)", http.StatusNotImplemented)
+		return
+	}
+
+	fromTime, toTime, err := parseAggregateRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	devices, err := s.aggregateStore.GetDevices()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error listing devices: %v", err), http.StatusInternalServerError)
+		return
+	}
+	sort.Strings(devices)
+	truncated := len(devices) > maxStatsAllDevices
+	if truncated {
+		devices = devices[:maxStatsAllDevices]
+	}
+
+	stats := make(map[string]map[string]interface{}, len(devices))
+	for _, deviceAddr := range devices {
+		aggregates, err := s.aggregateStore.GetHourlyAggregates(deviceAddr, fromTime, toTime)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error loading aggregates for %s: %v", deviceAddr, err), http.StatusInternalServerError)
+			return
+		}
+		if len(aggregates) > 0 {
+			stats[deviceAddr] = computeAggregateStats(aggregates)
+		}
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"from":      fromTime,
+		"to":        toTime,
+		"devices":   stats,
+		"truncated": truncated,
+	})
+}
+
+// parseAggregateRange parses the from and to parameters of the aggregate endpoints, which
+// default to the 24 hours up to now (or up to 'to') and may span at most maxStatsAllRange
+func parseAggregateRange(r *http.Request) (time.Time, time.Time, error) {
+	toTime := time.Now()
+	fromTime := toTime.Add(-24 * time.Hour)
+	var err error
+	if toTimeStr := r.URL.Query().Get("to"); toTimeStr != "" {
+		if toTime, err = time.Parse(time.RFC3339, toTimeStr); err != nil {
+			return fromTime, toTime, fmt.Errorf("Invalid 'to' time format. Use RFC3339 format (e.g., 2023-04-10T15:04:05Z)")
+		}
+		fromTime = toTime.Add(-24 * time.Hour)
+	}
+	if fromTimeStr := r.URL.Query().Get("from"); fromTimeStr != "" {
+		if fromTime, err = time.Parse(time.RFC3339, fromTimeStr); err != nil {
+			return fromTime, toTime, fmt.Errorf("Invalid 'from' time format. Use RFC3339 format (e.g., 2023-04-10T15:04:05Z)")
+		}
+	}
+	if !fromTime.Before(toTime) {
+		return fromTime, toTime, fmt.Errorf("'from' must be before 'to'")
+	}
+	if toTime.Sub(fromTime) > maxStatsAllRange {
+		return fromTime, toTime, fmt.Errorf("Range too large, maximum is %d days", int(maxStatsAllRange.Hours()/24))
+	}
+	return fromTime, toTime, nil
+}
+
+// deviceHourlyAggregates returns a device's hourly aggregates over a time range, newest hour
+// first. They come from the SQLite database with -db-path, and otherwise are computed from
+// the stored readings plus the aggregates kept after retention removed readings.
+func (s *Server) deviceHourlyAggregates(deviceAddr string, fromTime, toTime time.Time) ([]AggregateReading, error) {
+	if s.aggregateStore != nil {
+		return s.aggregateStore.GetHourlyAggregates(deviceAddr, fromTime, toTime)
+	}
+	readings, err := s.getDeviceReadings(deviceAddr, fromTime, toTime)
+	if err != nil {
+		return nil, err
+	}
+	kept, err := s.storageManager.loadKeptAggregates(deviceAddr, fromTime, toTime)
+	if err != nil {
+		return nil, err
+	}
+	return mergeAggregates(hourlyAggregates(deviceAddr, readings), kept), nil
+}
+
+// handleHourlyAggregates returns a device's hourly aggregates over a time range, newest hour
+// first (see deviceHourlyAggregates)
+func (s *Server) handleHourlyAggregates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deviceAddr := r.URL.Query().Get("device")
+	if deviceAddr == "" {
+		http.Error(w, "Missing device parameter", http.StatusBadRequest)
+		return
+	}
+	if _, err := sanitizeDeviceAddr(deviceAddr); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid device address: %v", err), http.StatusBadRequest)
+		return
+	}
+	fromTime, toTime, err := parseAggregateRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	aggregates, err := s.deviceHourlyAggregates(deviceAddr, fromTime, toTime)
+	if err != nil {
+		http.Error(w, "Error loading aggregates", http.StatusInternalServerError)
+		log.Printf("Failed to load hourly aggregates for %s: %v", deviceAddr, err)
+		return
+	}
+	if aggregates == nil {
+		aggregates = []AggregateReading{}
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"device_addr": deviceAddr,
+		"from":        fromTime,
+		"to":          toTime,
+		"aggregates":  aggregates,
+	})
+}
+
+// computeAggregateStats combines hourly aggregates into min, max and a per-reading average,
+// using the same keys as computeStats for the metrics aggregates carry
+func computeAggregateStats(aggregates []AggregateReading) map[string]interface{} {
+	count := 0
+	sumTempC, sumHumidity := 0.0, 0.0
+	minTempC, maxTempC := math.Inf(1), math.Inf(-1)
+	minHumidity, maxHumidity := math.Inf(1), math.Inf(-1)
+	first, last := aggregates[0].Timestamp, aggregates[0].Timestamp
+
+	for _, a := range aggregates {
+		count += a.Count
+		sumTempC += a.AvgTempC * float64(a.Count)
+		sumHumidity += a.AvgHumidity * float64(a.Count)
+		minTempC = math.Min(minTempC, a.MinTempC)
+		maxTempC = math.Max(maxTempC, a.MaxTempC)
+		minHumidity = math.Min(minHumidity, a.MinHumidity)
+		maxHumidity = math.Max(maxHumidity, a.MaxHumidity)
+		if a.Timestamp.Before(first) {
+			first = a.Timestamp
+		}
+		if a.Timestamp.After(last) {
+			last = a.Timestamp
+		}
+	}
+
+	stats := map[string]interface{}{
+		"count":        count,
+		"hours":        len(aggregates),
+		"temp_c_min":   minTempC,
+		"temp_c_max":   maxTempC,
+		"humidity_min": minHumidity,
+		"humidity_max": maxHumidity,
+		"first_hour":   first,
+		"last_hour":    last,
+	}
+	if count > 0 {
+		stats["temp_c_avg"] = sumTempC / float64(count)
+		stats["humidity_avg"] = sumHumidity / float64(count)
+	}
+	return stats
+}
+
+func (s *Server) handleDashboardData(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	units, err := normalizeUnits(r.URL.Query().Get("units"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Number of recent readings per device, capped so sparklines can't pull whole histories
+	limit := defaultDashboardReadings
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxDashboardReadings)
+	}
+
+	// Dashboards poll this, so let them revalidate cheaply. The version is read before the data,
+	// so a change made while building the response only ever makes the ETag stale, never too new.
+	etag := dashboardETag(s.instanceID, s.dashboardCache.Version(), limit, units)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Try to get cached data first (the cache holds each device's preferred units)
+	if cached := s.dashboardCache.Get(limit); cached != nil {
+		respondJSON(w, withDeviceUnits(cached, units))
+		return
+	}
+
 	// Cache miss - generate fresh data
+	s.rLockShards()
+	s.clientsMu.RLock()
 	s.mu.RLock()
 
 	// Prepare dashboard data
 	dashboardData := &DashboardData{
-		Devices:         make([]*DeviceStatus, 0, len(s.devices)),
+		Devices:         make([]*DeviceStatus, 0),
 		Clients:         make([]*ClientStatus, 0, len(s.clients)),
 		RecentReadings:  make(map[string][]Reading),
 		ServerStartTime: s.startTime,
 	}
 
 	// Add devices with display names
-	for _, device := range s.devices {
-		d := *device
-		if alias := s.getDisplayName(d.DeviceAddr); alias != "" {
-			d.DisplayName = alias
+	for _, shard := range s.shards {
+		for _, device := range shard.devices {
+			dashboardData.Devices = append(dashboardData.Devices, s.dashboardDevice(shard, device))
 		}
-		dashboardData.Devices = append(dashboardData.Devices, &d)
 	}
 
 	// Add clients and count active ones
 	totalReadings := 0
 	for _, client := range s.clients {
-		dashboardData.Clients = append(dashboardData.Clients, client)
+		dashboardData.Clients = append(dashboardData.Clients, s.dashboardClient(client))
 		if client.IsActive {
 			dashboardData.ActiveClients++
 		}
@@ -1572,160 +4809,794 @@ func (s *Server) handleDashboardData(w http.ResponseWriter, r *http.Request) {
 	}
 	dashboardData.TotalReadings = totalReadings
 
-	// Add recent readings (last 10 for each device) with display names
-	for addr, readings := range s.readings {
-		if len(readings) > 0 {
-			end := len(readings)
-			start := end - 10
-			if start < 0 {
-				start = 0
-			}
-			alias := s.getDisplayName(addr)
-			slice := readings[start:end]
-			if alias != "" {
-				// Copy readings to inject display name without mutating stored data
-				copied := make([]Reading, len(slice))
-				copy(copied, slice)
-				for i := range copied {
-					copied[i].DisplayName = alias
-				}
-				dashboardData.RecentReadings[addr] = copied
-			} else {
-				dashboardData.RecentReadings[addr] = slice
+	// Add recent readings (last limit for each device) with display names
+	for _, shard := range s.shards {
+		for addr, ring := range shard.readings {
+			if ring.Len() == 0 {
+				continue
 			}
+			dashboardData.RecentReadings[addr] = s.dashboardRecent(addr, ring, limit)
 		}
 	}
 
 	s.mu.RUnlock()
+	s.clientsMu.RUnlock()
+	s.rUnlockShards()
 
 	// Update cache before responding
-	s.dashboardCache.Set(dashboardData)
+	s.dashboardCache.Set(limit, dashboardData)
+
+	respondJSON(w, withDeviceUnits(dashboardData, units))
+}
+
+// dashboardETag returns a weak ETag for the dashboard data at a version. The instance ID
+// keeps ETags from a previous run, whose version counter started over, from matching.
+func dashboardETag(instanceID string, version uint64, limit int, units string) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s:%d:%d:%s", instanceID, version, limit, units)
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using the weak
+// comparison RFC 9110 requires for If-None-Match
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// dashboardDevice returns a copy of a device for the dashboard, with its display name, public
+// client ID, preferred units and labels. Caller must hold the shard lock and s.mu for reading.
+func (s *Server) dashboardDevice(shard *deviceShard, device *DeviceStatus) *DeviceStatus {
+	d := *device
+	if alias := s.getDisplayName(d.DeviceAddr); alias != "" {
+		d.DisplayName = alias
+	}
+	d.ClientID = s.publicClientID(d.ClientID)
+	s.applyDisplayUnits(&d, "")
+	s.applyDeviceLabels(&d)
+	d.RetainedCount = shard.readings[d.DeviceAddr].Len()
+	return &d
+}
+
+// dashboardClient returns a copy of a client for the dashboard, with its public client ID.
+// Caller must hold s.clientsMu for reading.
+func (s *Server) dashboardClient(client *ClientStatus) *ClientStatus {
+	c := *client
+	c.ClientID = s.publicClientID(c.ClientID)
+	return &c
+}
+
+// dashboardRecent returns a device's last limit readings for the dashboard, with its display
+// name and public client IDs. Caller must hold the shard lock and s.mu for reading.
+func (s *Server) dashboardRecent(addr string, ring *readingRing, limit int) []Reading {
+	alias := s.getDisplayName(addr)
+	// Recent returns a copy, so display names and public client IDs don't mutate stored data
+	recent := ring.Recent(limit)
+	if alias != "" || s.config.PrivacyMode {
+		for i := range recent {
+			if alias != "" {
+				recent[i].DisplayName = alias
+			}
+			recent[i].ClientID = s.publicClientID(recent[i].ClientID)
+		}
+	}
+	return recent
+}
+
+// updateDashboardCache applies a newly stored reading to the cached dashboard data: the
+// device's status and recent readings, the given clients (the reading's, and the one the
+// device moved from, if any), and the totals. added is false when the reading replaced the
+// device's latest one in a merge, which leaves the reading total unchanged. Caller must hold
+// the device's shard lock.
+func (s *Server) updateDashboardCache(shard *deviceShard, deviceAddr string, added bool, clientIDs ...string) {
+	s.dashboardCache.Changed()
+	if s.dashboardCache.empty() {
+		return
+	}
+
+	s.clientsMu.RLock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	defer s.clientsMu.RUnlock()
+
+	device := s.dashboardDevice(shard, shard.devices[deviceAddr])
+	var clients []*ClientStatus
+	for _, clientID := range clientIDs {
+		if c, ok := s.clients[clientID]; ok {
+			clients = append(clients, s.dashboardClient(c))
+		}
+	}
+	ring := shard.readings[deviceAddr]
+
+	s.dashboardCache.Update(func(limit int, data *DashboardData) {
+		replaced := false
+		for i, d := range data.Devices {
+			if d.DeviceAddr == deviceAddr {
+				data.Devices[i], replaced = device, true
+				break
+			}
+		}
+		if !replaced {
+			data.Devices = append(data.Devices, device)
+		}
+
+		for _, client := range clients {
+			replaced = false
+			for i, c := range data.Clients {
+				if c.ClientID == client.ClientID {
+					data.Clients[i], replaced = client, true
+					break
+				}
+			}
+			if !replaced {
+				data.Clients = append(data.Clients, client)
+			}
+		}
+		data.ActiveClients = 0
+		for _, c := range data.Clients {
+			if c.IsActive {
+				data.ActiveClients++
+			}
+		}
+
+		if added {
+			data.TotalReadings++
+		}
+		data.RecentReadings[deviceAddr] = s.dashboardRecent(deviceAddr, ring, limit)
+	})
+}
+
+// normalizeUnits validates a temperature unit ("c" or "f", case-insensitive);
+// an empty value is returned unchanged
+func normalizeUnits(units string) (string, error) {
+	switch u := strings.ToLower(strings.TrimSpace(units)); u {
+	case "", "c", "f":
+		return u, nil
+	default:
+		return "", fmt.Errorf("Invalid units %q: must be c or f", units)
+	}
+}
+
+// applyDisplayUnits fills in the unit-dependent display fields of a device copy.
+// A non-empty override wins over the device's preferred units, which default to Celsius.
+// Caller must hold s.mu (read or write).
+func (s *Server) applyDisplayUnits(d *DeviceStatus, override string) {
+	units := override
+	if units == "" {
+		if meta, ok := s.deviceMetadata[d.DeviceAddr]; ok {
+			units = meta.Units
+		}
+	}
+	if units == "" {
+		units = "c"
+	}
+	setDisplayUnits(d, units)
+}
+
+// applyDeviceLabels sets a device's tags, and its location if one is assigned, from its
+// metadata. The caller must hold s.mu for reading.
+func (s *Server) applyDeviceLabels(d *DeviceStatus) {
+	meta, ok := s.deviceMetadata[d.DeviceAddr]
+	if !ok {
+		return
+	}
+	if meta.Location != "" {
+		d.Location = meta.Location
+	}
+	d.Tags = append([]string(nil), meta.Tags...)
+}
 
-	respondJSON(w, dashboardData)
+// setDisplayUnits sets the display temperature and dew point of a device in the given units
+func setDisplayUnits(d *DeviceStatus, units string) {
+	d.Units = units
+	if units == "f" {
+		d.Temperature = d.TempF
+		d.DewPoint = d.DewPointF
+	} else {
+		d.Temperature = d.TempC
+		d.DewPoint = d.DewPointC
+	}
+}
+
+// withDeviceUnits returns dashboard data with every device rendered in the given
+// units, leaving the (possibly cached) original untouched. Empty units return data as-is.
+func withDeviceUnits(data *DashboardData, units string) *DashboardData {
+	if units == "" {
+		return data
+	}
+	out := *data
+	out.Devices = make([]*DeviceStatus, len(data.Devices))
+	for i, device := range data.Devices {
+		d := *device
+		setDisplayUnits(&d, units)
+		out.Devices[i] = &d
+	}
+	return &out
 }
 
 // handleAPIKeys handles API key management
 func (s *Server) handleAPIKeys(w http.ResponseWriter, r *http.Request) {
-	// This endpoint requires admin API key (checked in middleware)
+	if !s.isAdminRequest(r) {
+		http.Error(w, "Unauthorized: Admin API key required", http.StatusUnauthorized)
+		return
+	}
+
 	switch r.Method {
 	case "GET":
-		// List all API keys (except admin key)
-		keys := make(map[string]string)
+		// List the hashes of all API keys (except admin key) with their details; the keys
+		// themselves aren't kept
+		s.mu.RLock()
+		keys := make(map[string]APIKey, len(s.auth.APIKeys))
 		for k, v := range s.auth.APIKeys {
-			keys[k] = v
+			if v.Scope == "" {
+				v.Scope = APIKeyScopeReadWrite
+			}
+			keys[k] = s.withKeyUsage(k, v)
 		}
+		s.mu.RUnlock()
 		respondJSON(w, keys)
 
 	case "POST":
-		// Create new API key
+		// Create new API key, optionally expiring after ttl (a duration such as "720h")
+		// and limited to reads by scope
 		var keyData struct {
 			ClientID string `json:"client_id"`
+			TTL      string `json:"ttl"`
+			Scope    string `json:"scope"`
 		}
+		s.limitBody(w, r)
 		if err := json.NewDecoder(r.Body).Decode(&keyData); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			respondBodyError(w, err)
+			return
+		}
+
+		sanitizedID, err := sanitizeClientID(keyData.ClientID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid client ID: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		details := APIKey{ClientID: sanitizedID, CreatedAt: time.Now().UTC(), Scope: APIKeyScopeReadWrite, Enabled: true}
+		switch keyData.Scope {
+		case "", APIKeyScopeReadWrite:
+		case APIKeyScopeRead:
+			details.Scope = APIKeyScopeRead
+		default:
+			http.Error(w, "Invalid 'scope'. Use 'read' or 'readwrite'", http.StatusBadRequest)
+			return
+		}
+		if keyData.TTL != "" {
+			ttl, err := time.ParseDuration(keyData.TTL)
+			if err != nil || ttl <= 0 {
+				http.Error(w, "Invalid 'ttl'. Use a positive duration (e.g., 24h or 720h)", http.StatusBadRequest)
+				return
+			}
+			expiresAt := details.CreatedAt.Add(ttl)
+			details.ExpiresAt = &expiresAt
+		}
+
+		// Generate a new API key
+		newKey := generateAPIKey()
+
+		s.mu.Lock()
+		s.auth.APIKeys[hashAPIKey(newKey)] = details
+		s.mu.Unlock()
+		s.audit(r, auditEntry{Event: auditKeyCreated, ClientID: details.ClientID, KeyHash: hashAPIKey(newKey)})
+
+		// Save auth data if persistence is enabled
+		if s.config.PersistenceEnabled {
+			s.saveData()
+		}
+
+		// Return the new key; this is the only time it's available in plaintext
+		w.WriteHeader(http.StatusCreated)
+		response := map[string]interface{}{
+			"api_key":    newKey,
+			"client_id":  details.ClientID,
+			"created_at": details.CreatedAt,
+			"scope":      details.Scope,
+		}
+		if details.ExpiresAt != nil {
+			response["expires_at"] = details.ExpiresAt
+		}
+		respondJSON(w, response)
+
+	case "PATCH":
+		// Disable or re-enable an API key, given the key or its hash, keeping its details
+		keyHash := r.URL.Query().Get("key")
+		if keyHash == "" {
+			http.Error(w, "Missing key parameter", http.StatusBadRequest)
+			return
+		}
+		if !strings.HasPrefix(keyHash, apiKeyHashPrefix) {
+			keyHash = hashAPIKey(keyHash)
+		}
+		var patch struct {
+			Enabled *bool `json:"enabled"`
+		}
+		s.limitBody(w, r)
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			respondBodyError(w, err)
+			return
+		}
+		if patch.Enabled == nil {
+			http.Error(w, "Missing 'enabled' field", http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		details, exists := s.auth.APIKeys[keyHash]
+		if !exists {
+			s.mu.Unlock()
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		details.Enabled = *patch.Enabled
+		s.auth.APIKeys[keyHash] = details
+		s.mu.Unlock()
+
+		event := auditKeyDisabled
+		if details.Enabled {
+			event = auditKeyEnabled
+		}
+		s.audit(r, auditEntry{Event: event, ClientID: details.ClientID, KeyHash: keyHash})
+
+		// Save auth data if persistence is enabled
+		if s.config.PersistenceEnabled {
+			s.saveData()
+		}
+		respondJSON(w, s.withKeyUsage(keyHash, details))
+
+	case "DELETE":
+		// Delete API key, given either the key itself or its hash as listed by GET
+		apiKeyToDelete := r.URL.Query().Get("key")
+		if apiKeyToDelete == "" {
+			http.Error(w, "Missing key parameter", http.StatusBadRequest)
 			return
 		}
+		if !strings.HasPrefix(apiKeyToDelete, apiKeyHashPrefix) {
+			apiKeyToDelete = hashAPIKey(apiKeyToDelete)
+		}
+
+		s.mu.Lock()
+		if details, exists := s.auth.APIKeys[apiKeyToDelete]; exists {
+			delete(s.auth.APIKeys, apiKeyToDelete)
+			s.mu.Unlock()
+			s.keyUsageMu.Lock()
+			delete(s.keyUsage, apiKeyToDelete)
+			s.keyUsageMu.Unlock()
+			s.audit(r, auditEntry{Event: auditKeyDeleted, ClientID: details.ClientID, KeyHash: apiKeyToDelete})
+
+			// Save auth data if persistence is enabled
+			if s.config.PersistenceEnabled {
+				s.saveData()
+			}
+
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("API key deleted"))
+		} else {
+			s.mu.Unlock()
+			http.Error(w, "API key not found", http.StatusNotFound)
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// recordKeyUse notes a request authenticated by the client key stored under keyHash. The first
+// use since startup picks up from the usage last saved with the key.
+func (s *Server) recordKeyUse(keyHash string, key APIKey) {
+	now := time.Now().UTC()
+	s.keyUsageMu.Lock()
+	defer s.keyUsageMu.Unlock()
+	usage, ok := s.keyUsage[keyHash]
+	if !ok {
+		usage = &keyUsage{requests: key.Requests}
+		s.keyUsage[keyHash] = usage
+	}
+	usage.lastUsed = now
+	usage.requests++
+}
+
+// withKeyUsage returns key with its live usage applied
+func (s *Server) withKeyUsage(keyHash string, key APIKey) APIKey {
+	s.keyUsageMu.Lock()
+	defer s.keyUsageMu.Unlock()
+	if usage, ok := s.keyUsage[keyHash]; ok {
+		lastUsed := usage.lastUsed
+		key.LastUsed = &lastUsed
+		key.Requests = usage.requests
+	}
+	return key
+}
+
+// adminKey returns the current admin API key, which /api/keys/rotate-admin may replace
+func (s *Server) adminKey() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.auth.AdminKey
+}
+
+// handleRotateAdminKey replaces the admin API key with a newly generated one and returns it.
+// The key used to make the request stops working at once; client keys are unaffected.
+func (s *Server) handleRotateAdminKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.auth.EnableAuth {
+		http.Error(w, "Authentication is disabled", http.StatusConflict)
+		return
+	}
+	if !s.isAdminRequest(r) {
+		http.Error(w, "Unauthorized: Admin API key required", http.StatusUnauthorized)
+		return
+	}
+
+	newKey := generateAPIKey()
+	rotatedAt := time.Now().UTC()
+	s.mu.Lock()
+	s.auth.AdminKey = newKey
+	s.auth.AdminKeyRotatedAt = &rotatedAt
+	s.mu.Unlock()
+	s.audit(r, auditEntry{Event: auditAdminRotate, KeyHash: hashAPIKey(newKey)})
+	log.Printf("Admin API key rotated by %s", s.getClientIP(r))
+
+	// Without persistence the new key only lasts until a restart brings back -admin-key
+	if s.config.PersistenceEnabled {
+		s.saveData()
+	}
+
+	// The caller's own key has just stopped working, so make sure the response isn't cached
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, map[string]interface{}{
+		"admin_key":  newKey,
+		"rotated_at": rotatedAt,
+		"message":    "The previous admin key no longer works. Store this key now; it is not shown again.",
+	})
+}
+
+// handleAPIKeyUsage reports when each client API key was last used and how many requests it
+// has made, least recently used first, to find stale keys to revoke (admin only)
+func (s *Server) handleAPIKeyUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.isAdminRequest(r) {
+		http.Error(w, "Unauthorized: Admin API key required", http.StatusUnauthorized)
+		return
+	}
+
+	s.mu.RLock()
+	usage := make([]APIKeyUsage, 0, len(s.auth.APIKeys))
+	for hash, key := range s.auth.APIKeys {
+		key = s.withKeyUsage(hash, key)
+		usage = append(usage, APIKeyUsage{KeyHash: hash, ClientID: key.ClientID, LastUsed: key.LastUsed, Requests: key.Requests})
+	}
+	s.mu.RUnlock()
+
+	// Never-used keys first, then oldest use first
+	sort.Slice(usage, func(i, j int) bool {
+		a, b := usage[i].LastUsed, usage[j].LastUsed
+		if a == nil || b == nil {
+			if (a == nil) != (b == nil) {
+				return a == nil
+			}
+			return usage[i].KeyHash < usage[j].KeyHash
+		}
+		if !a.Equal(*b) {
+			return a.Before(*b)
+		}
+		return usage[i].KeyHash < usage[j].KeyHash
+	})
+	respondJSON(w, usage)
+}
+
+// getDisplayName returns the alias for a device if set, otherwise empty string.
+// Caller must hold s.mu (read or write).
+func (s *Server) getDisplayName(deviceAddr string) string {
+	return s.deviceAliases[deviceAddr]
+}
+
+// handleDeviceAliases manages device friendly name aliases (admin only)
+func (s *Server) handleDeviceAliases(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		// List all aliases, or get a specific one
+		deviceAddr := r.URL.Query().Get("device")
+		s.mu.RLock()
+		if deviceAddr != "" {
+			alias, exists := s.deviceAliases[deviceAddr]
+			s.mu.RUnlock()
+			if !exists {
+				http.Error(w, "No alias set for device", http.StatusNotFound)
+				return
+			}
+			respondJSON(w, map[string]string{
+				"device_addr":  deviceAddr,
+				"display_name": alias,
+			})
+		} else {
+			// Return all aliases
+			aliases := make(map[string]string, len(s.deviceAliases))
+			for k, v := range s.deviceAliases {
+				aliases[k] = v
+			}
+			s.mu.RUnlock()
+			respondJSON(w, aliases)
+		}
+
+	case "PUT":
+		// Set or update an alias
+		s.limitBody(w, r)
+
+		var req struct {
+			DeviceAddr  string `json:"device_addr"`
+			DisplayName string `json:"display_name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondBodyError(w, err)
+			return
+		}
+
+		if req.DeviceAddr == "" {
+			http.Error(w, "device_addr is required", http.StatusBadRequest)
+			return
+		}
+
+		// Validate the display name using existing sanitization
+		if req.DisplayName == "" {
+			http.Error(w, "display_name is required", http.StatusBadRequest)
+			return
+		}
+		sanitized, err := sanitizeDeviceName(req.DisplayName)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid display_name: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		s.deviceAliases[req.DeviceAddr] = sanitized
+		s.mu.Unlock()
+
+		if s.config.PersistenceEnabled {
+			s.saveData()
+		}
+
+		// Invalidate dashboard cache so the new name appears immediately
+		s.dashboardCache.Clear()
+
+		respondJSON(w, map[string]string{
+			"device_addr":  req.DeviceAddr,
+			"display_name": sanitized,
+		})
+
+	case "DELETE":
+		// Remove an alias
+		deviceAddr := r.URL.Query().Get("device")
+		if deviceAddr == "" {
+			http.Error(w, "Missing device parameter", http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		if _, exists := s.deviceAliases[deviceAddr]; exists {
+			delete(s.deviceAliases, deviceAddr)
+			s.mu.Unlock()
+
+			if s.config.PersistenceEnabled {
+				s.saveData()
+			}
+
+			s.dashboardCache.Clear()
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Alias deleted"))
+		} else {
+			s.mu.Unlock()
+			http.Error(w, "No alias set for device", http.StatusNotFound)
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleDevicePartitions reports which storage partitions hold a device's readings (admin only)
+func (s *Server) handleDevicePartitions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.isAdminRequest(r) {
+		http.Error(w, "Unauthorized: Admin API key required", http.StatusUnauthorized)
+		return
+	}
+
+	deviceAddr := r.URL.Query().Get("device")
+	if deviceAddr == "" {
+		http.Error(w, "Missing device parameter", http.StatusBadRequest)
+		return
+	}
+	if _, err := sanitizeDeviceAddr(deviceAddr); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid device address: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	partitions, err := s.storageManager.devicePartitions(deviceAddr)
+	if err != nil {
+		http.Error(w, "Failed to list partitions", http.StatusInternalServerError)
+		log.Printf("Failed to list partitions for %s: %v", deviceAddr, err)
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"device_addr": deviceAddr,
+		"partitions":  partitions,
+	})
+}
+
+// handleStorageUsage returns the disk space taken by the storage directory, by partition (admin only)
+func (s *Server) handleStorageUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.isAdminRequest(r) {
+		http.Error(w, "Unauthorized: Admin API key required", http.StatusUnauthorized)
+		return
+	}
+
+	usage, err := s.storageManager.DiskUsage()
+	if err != nil {
+		http.Error(w, "Failed to measure storage", http.StatusInternalServerError)
+		log.Printf("Failed to measure storage usage: %v", err)
+		return
+	}
+	respondJSON(w, usage)
+}
 
-		sanitizedID, err := sanitizeClientID(keyData.ClientID)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Invalid client ID: %v", err), http.StatusBadRequest)
-			return
-		}
-		keyData.ClientID = sanitizedID
+// prometheusLabel escapes a Prometheus label value
+var prometheusLabel = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
 
-		// Generate a new API key
-		newKey := generateAPIKey()
+// handleMetrics serves metrics in the Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-		s.mu.Lock()
-		s.auth.APIKeys[newKey] = keyData.ClientID
-		s.mu.Unlock()
+	usage, err := s.storageManager.DiskUsage()
+	if err != nil {
+		http.Error(w, "Failed to measure storage", http.StatusInternalServerError)
+		log.Printf("Failed to measure storage usage: %v", err)
+		return
+	}
 
-		// Save auth data if persistence is enabled
-		if s.config.PersistenceEnabled {
-			s.saveData()
-		}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprintln(w, "# HELP govee_storage_bytes Bytes used by the files in each partition of the storage directory (\".\" for the directory itself).")
+	fmt.Fprintln(w, "# TYPE govee_storage_bytes gauge")
+	for _, p := range usage.Partitions {
+		fmt.Fprintf(w, "govee_storage_bytes{partition=\"%s\"} %d\n", prometheusLabel.Replace(p.Partition), p.Bytes)
+	}
+	fmt.Fprintln(w, "# HELP govee_storage_files Files in each partition of the storage directory.")
+	fmt.Fprintln(w, "# TYPE govee_storage_files gauge")
+	for _, p := range usage.Partitions {
+		fmt.Fprintf(w, "govee_storage_files{partition=\"%s\"} %d\n", prometheusLabel.Replace(p.Partition), p.Files)
+	}
+}
 
-		// Return the new key
-		w.WriteHeader(http.StatusCreated)
-		respondJSON(w, map[string]string{
-			"api_key":   newKey,
-			"client_id": keyData.ClientID,
-		})
+// handleMaintenance runs a storage maintenance action on demand rather than waiting for the
+// daily retention run: "retention" removes expired partitions (and compresses older ones if
+// -compress is on), "compact" compresses every partition but the current one, and "save"
+// writes the in-memory state to disk (admin only)
+func (s *Server) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.isAdminRequest(r) {
+		http.Error(w, "Unauthorized: Admin API key required", http.StatusUnauthorized)
+		return
+	}
 
-	case "DELETE":
-		// Delete API key
-		apiKeyToDelete := r.URL.Query().Get("key")
-		if apiKeyToDelete == "" {
-			http.Error(w, "Missing key parameter", http.StatusBadRequest)
+	s.limitBody(w, r)
+	var req struct {
+		Action string `json:"action"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, err)
+		return
+	}
+
+	var summary MaintenanceSummary
+	var err error
+	switch req.Action {
+	case "retention", "compact", "save":
+		if !s.config.PersistenceEnabled {
+			http.Error(w, "Persistence is disabled", http.StatusConflict)
 			return
 		}
+	default:
+		http.Error(w, `action must be "retention", "compact" or "save"`, http.StatusBadRequest)
+		return
+	}
 
-		s.mu.Lock()
-		if _, exists := s.auth.APIKeys[apiKeyToDelete]; exists {
-			delete(s.auth.APIKeys, apiKeyToDelete)
-			s.mu.Unlock()
-
-			// Save auth data if persistence is enabled
-			if s.config.PersistenceEnabled {
-				s.saveData()
-			}
+	start := time.Now()
+	switch req.Action {
+	case "retention":
+		summary, err = s.storageManager.enforceRetention(s.shutdownCtx)
+	case "compact":
+		summary, err = s.storageManager.compressOldPartitions(s.shutdownCtx)
+	case "save":
+		s.saveData()
+	}
+	summary.Action = req.Action
 
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("API key deleted"))
+	if err != nil {
+		log.Printf("Maintenance %s failed after removing %d partitions and compressing %d files: %v",
+			req.Action, summary.PartitionsRemoved, summary.FilesCompressed, err)
+		if s.shutdownCtx.Err() != nil {
+			http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
 		} else {
-			s.mu.Unlock()
-			http.Error(w, "API key not found", http.StatusNotFound)
+			http.Error(w, "Maintenance failed", http.StatusInternalServerError)
 		}
-
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
-}
 
-// getDisplayName returns the alias for a device if set, otherwise empty string.
-// Caller must hold s.mu (read or write).
-func (s *Server) getDisplayName(deviceAddr string) string {
-	return s.deviceAliases[deviceAddr]
+	log.Printf("Maintenance %s: removed %d partitions, compressed %d files, reclaimed %d bytes in %v",
+		req.Action, summary.PartitionsRemoved, summary.FilesCompressed, summary.BytesReclaimed, time.Since(start).Round(time.Millisecond))
+	respondJSON(w, summary)
 }
 
-// handleDeviceAliases manages device friendly name aliases (admin only)
-func (s *Server) handleDeviceAliases(w http.ResponseWriter, r *http.Request) {
+// handleDeviceMetadata manages per-device metadata such as preferred units
+func (s *Server) handleDeviceMetadata(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
-		// List all aliases, or get a specific one
+		// List all metadata, or get a specific device's
 		deviceAddr := r.URL.Query().Get("device")
 		s.mu.RLock()
 		if deviceAddr != "" {
-			alias, exists := s.deviceAliases[deviceAddr]
-			s.mu.RUnlock()
+			meta, exists := s.deviceMetadata[deviceAddr]
 			if !exists {
-				http.Error(w, "No alias set for device", http.StatusNotFound)
+				s.mu.RUnlock()
+				http.Error(w, "No metadata set for device", http.StatusNotFound)
 				return
 			}
-			respondJSON(w, map[string]string{
-				"device_addr":  deviceAddr,
-				"display_name": alias,
-			})
+			m := *meta
+			s.mu.RUnlock()
+			respondJSON(w, m)
 		} else {
-			// Return all aliases
-			aliases := make(map[string]string, len(s.deviceAliases))
-			for k, v := range s.deviceAliases {
-				aliases[k] = v
+			metadata := make(map[string]DeviceMetadata, len(s.deviceMetadata))
+			for k, v := range s.deviceMetadata {
+				metadata[k] = *v
 			}
 			s.mu.RUnlock()
-			respondJSON(w, aliases)
+			respondJSON(w, metadata)
 		}
 
-	case "PUT":
-		// Set or update an alias
-		r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	case "PUT", "PATCH":
+		// PUT replaces a device's metadata; PATCH only changes the fields given
+		s.limitBody(w, r)
 
 		var req struct {
-			DeviceAddr  string `json:"device_addr"`
-			DisplayName string `json:"display_name"`
+			DeviceAddr string    `json:"device_addr"`
+			Units      *string   `json:"units"`
+			Location   *string   `json:"location"`
+			Tags       *[]string `json:"tags"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			respondBodyError(w, err)
 			return
 		}
 
@@ -1734,35 +5605,60 @@ func (s *Server) handleDeviceAliases(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Validate the display name using existing sanitization
-		if req.DisplayName == "" {
-			http.Error(w, "display_name is required", http.StatusBadRequest)
-			return
+		var update DeviceMetadata
+		if req.Units != nil {
+			units, err := normalizeUnits(*req.Units)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			update.Units = units
 		}
-		sanitized, err := sanitizeDeviceName(req.DisplayName)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Invalid display_name: %v", err), http.StatusBadRequest)
-			return
+		if req.Location != nil && *req.Location != "" {
+			location, err := sanitizeLabel("location", *req.Location)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid location: %v", err), http.StatusBadRequest)
+				return
+			}
+			update.Location = location
+		}
+		if req.Tags != nil {
+			tags, err := normalizeTags(*req.Tags)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid tags: %v", err), http.StatusBadRequest)
+				return
+			}
+			update.Tags = tags
 		}
 
 		s.mu.Lock()
-		s.deviceAliases[req.DeviceAddr] = sanitized
+		meta := update
+		if existing, ok := s.deviceMetadata[req.DeviceAddr]; ok && r.Method == "PATCH" {
+			meta = *existing
+			if req.Units != nil {
+				meta.Units = update.Units
+			}
+			if req.Location != nil {
+				meta.Location = update.Location
+			}
+			if req.Tags != nil {
+				meta.Tags = update.Tags
+			}
+		}
+		s.deviceMetadata[req.DeviceAddr] = &meta
 		s.mu.Unlock()
 
 		if s.config.PersistenceEnabled {
 			s.saveData()
 		}
 
-		// Invalidate dashboard cache so the new name appears immediately
-		s.dashboardCache.Set(nil)
+		// Invalidate dashboard cache so the new units appear immediately
+		s.dashboardCache.Clear()
 
-		respondJSON(w, map[string]string{
-			"device_addr":  req.DeviceAddr,
-			"display_name": sanitized,
-		})
+		respondJSON(w, meta)
 
 	case "DELETE":
-		// Remove an alias
+		// Remove a device's metadata
 		deviceAddr := r.URL.Query().Get("device")
 		if deviceAddr == "" {
 			http.Error(w, "Missing device parameter", http.StatusBadRequest)
@@ -1770,20 +5666,20 @@ func (s *Server) handleDeviceAliases(w http.ResponseWriter, r *http.Request) {
 		}
 
 		s.mu.Lock()
-		if _, exists := s.deviceAliases[deviceAddr]; exists {
-			delete(s.deviceAliases, deviceAddr)
+		if _, exists := s.deviceMetadata[deviceAddr]; exists {
+			delete(s.deviceMetadata, deviceAddr)
 			s.mu.Unlock()
 
 			if s.config.PersistenceEnabled {
 				s.saveData()
 			}
 
-			s.dashboardCache.Set(nil)
+			s.dashboardCache.Clear()
 			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("Alias deleted"))
+			w.Write([]byte("Metadata deleted"))
 		} else {
 			s.mu.Unlock()
-			http.Error(w, "No alias set for device", http.StatusNotFound)
+			http.Error(w, "No metadata set for device", http.StatusNotFound)
 		}
 
 	default:
@@ -1799,8 +5695,14 @@ func (s *Server) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Build detailed health status
-	s.mu.RLock()
-	deviceCount := len(s.devices)
+	s.rLockShards()
+	deviceCount := 0
+	for _, shard := range s.shards {
+		deviceCount += len(shard.devices)
+	}
+	s.rUnlockShards()
+
+	s.clientsMu.RLock()
 	clientCount := len(s.clients)
 	activeClients := 0
 	for _, client := range s.clients {
@@ -1808,7 +5710,7 @@ func (s *Server) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 			activeClients++
 		}
 	}
-	s.mu.RUnlock()
+	s.clientsMu.RUnlock()
 
 	uptime := time.Since(s.startTime)
 
@@ -1816,7 +5718,7 @@ func (s *Server) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 		Status:     "healthy",
 		Timestamp:  time.Now(),
 		Uptime:     uptime.String(),
-		Version:    "2.0.0",
+		Version:    Version,
 		Goroutines: runtime.NumGoroutine(),
 		Checks: map[string]bool{
 			"storage_writable": true, // Could add actual check here
@@ -1850,6 +5752,66 @@ func (s *Server) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, health)
 }
 
+// ReadinessStatus is the response body of /ready
+type ReadinessStatus struct {
+	Ready  bool   `json:"ready"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// handleReadiness reports whether the server can take traffic: persisted data has been
+// loaded and, with persistence on, the storage directory can be written to. Unlike /health,
+// which only shows the process is up, it returns 503 until both hold.
+func (s *Server) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := ReadinessStatus{Ready: true}
+	if !s.ready.Load() {
+		status = ReadinessStatus{Reason: "loading persisted data"}
+	} else if s.config.PersistenceEnabled {
+		if err := checkDirWritable(s.config.StorageDir); err != nil {
+			status = ReadinessStatus{Reason: fmt.Sprintf("storage directory not writable: %v", err)}
+		}
+	}
+
+	if !status.Ready {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(status)
+		return
+	}
+	respondJSON(w, status)
+}
+
+// VersionInfo is the response body of /version
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+}
+
+// handleVersion reports which build of the server is running
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	respondJSON(w, VersionInfo{Version: Version, Commit: Commit, BuildDate: BuildDate})
+}
+
+// checkDirWritable creates and removes a temporary file in dir
+func checkDirWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".ready-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
 // generateAPIKey creates a new cryptographically secure random API key
 func generateAPIKey() string {
 	b := make([]byte, 32)
@@ -1859,6 +5821,50 @@ func generateAPIKey() string {
 	return base64.URLEncoding.EncodeToString(b)
 }
 
+// apiKeyHashPrefix marks an APIKeys entry as a hash, telling it apart from a plaintext key
+// saved before keys were hashed
+const apiKeyHashPrefix = "sha256:"
+
+// hashAPIKey returns the form client API keys are stored and looked up in, so auth.json never
+// holds a usable credential. Keys are 32 random bytes, so an unsalted hash is enough.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return apiKeyHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// keysEqual compares a presented key with a configured one in constant time. Both are hashed
+// first so the comparison doesn't leak the configured key's length; an unset key never matches.
+func keysEqual(presented, configured string) bool {
+	if configured == "" {
+		return false
+	}
+	a, b := sha256.Sum256([]byte(presented)), sha256.Sum256([]byte(configured))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+// hashPlaintextAPIKeys replaces any plaintext keys in an APIKeys map with their hashes,
+// returning how many were replaced
+func hashPlaintextAPIKeys(keys map[string]APIKey) int {
+	replaced := 0
+	for key, details := range keys {
+		if !strings.HasPrefix(key, apiKeyHashPrefix) {
+			delete(keys, key)
+			keys[hashAPIKey(key)] = details
+			replaced++
+		}
+	}
+	return replaced
+}
+
+// generateInstanceID returns a short random ID identifying this server process
+func generateInstanceID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Failed to generate instance ID: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
 // respondJSON encodes data as JSON and handles errors properly
 func respondJSON(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -1868,6 +5874,30 @@ func respondJSON(w http.ResponseWriter, data interface{}) {
 	}
 }
 
+// respondJSONReadings streams readings as a JSON array one reading at a time, so memory
+// use stays flat however many readings a device has instead of marshaling the whole slice
+func respondJSONReadings(w http.ResponseWriter, readings []Reading) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return
+	}
+	for i := range readings {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return
+			}
+		}
+		if err := enc.Encode(&readings[i]); err != nil {
+			log.Printf("Failed to encode JSON response: %v", err)
+			// Response already started, can't change status code
+			return
+		}
+	}
+	io.WriteString(w, "]\n")
+}
+
 // handleStaticFiles serves the static files for the dashboard
 func handleStaticFiles(dir string) http.Handler {
 	return http.FileServer(http.Dir(dir))
@@ -1875,12 +5905,17 @@ func handleStaticFiles(dir string) http.Handler {
 
 func main() {
 	// Parse command-line flags
+	configFile := flag.String("config", "", "YAML file of settings keyed by flag name; flags given on the command line override it, and SIGHUP reloads the runtime settings from it")
 	port := flag.Int("port", 8080, "server port")
 	logFile := flag.String("log", "govee_server.log", "log file path")
+	logMaxSize := flag.Int64("log-max-size", 100<<20, "rotate the log file after this many bytes (0 to disable rotation)")
+	logMaxBackups := flag.Int("log-max-backups", 5, "number of rotated log files to keep")
 	staticDir := flag.String("static", "./static", "static files directory")
 	storageDir := flag.String("storage", "./data", "data storage directory")
-	clientTimeout := flag.Duration("timeout", 5*time.Minute, "client inactivity timeout")
 	readingsPerDevice := flag.Int("readings", 1000, "max readings to store per device")
+	memoryWindow := flag.Duration("memory-window-duration", 0, "size each device's in-memory readings to hold about this much history at its observed cadence, overriding -readings (0 to disable)")
+	memoryWindowMin := flag.Int("memory-window-min", 100, "fewest in-memory readings per device with -memory-window-duration")
+	memoryWindowMax := flag.Int("memory-window-max", 10000, "most in-memory readings per device with -memory-window-duration")
 	persistenceEnabled := flag.Bool("persist", true, "enable data persistence")
 	saveInterval := flag.Duration("save-interval", 5*time.Minute, "interval for saving data")
 
@@ -1894,6 +5929,8 @@ func main() {
 	enableHTTPS := flag.Bool("https", false, "enable HTTPS")
 	certFile := flag.String("cert", "cert.pem", "path to TLS certificate file")
 	keyFile := flag.String("key", "key.pem", "path to TLS key file")
+	requireClientCert := flag.Bool("require-client-cert", false, "require HTTPS clients to present a certificate signed by -client-ca; its CN is used as the client ID in place of an API key")
+	clientCAFile := flag.String("client-ca", "", "path to the CA certificate(s) that sign client certificates (PEM, for -require-client-cert)")
 
 	// Storage and retention flags
 	timePartitioning := flag.Bool("time-partition", true, "enable time-based partitioning of data")
@@ -1901,35 +5938,169 @@ func main() {
 	retentionPeriod := flag.Duration("retention", 0, "data retention period, 0 for unlimited (e.g., 8760h for 1 year)")
 	maxReadingsPerFile := flag.Int("max-file-readings", 1000, "maximum readings per file")
 	compressOldData := flag.Bool("compress", true, "compress older partitions to save space")
+	keepAggregates := flag.Bool("keep-aggregates", false, "roll readings up into hourly aggregates before retention removes them, and keep the aggregates forever")
+	deviceRetention := flag.String("device-retention", "", "comma-separated per-device retention periods overriding -retention, as address=duration (e.g., A4:C1:38:00:00:01=17520h; 0 keeps a device's data forever)")
+	compression := flag.String("compression", "gzip", "codec for compressing older partitions: gzip, zstd or none (none also turns off -compress)")
 
 	// Proxy flags
 	trustedProxies := flag.String("trusted-proxies", "", "comma-separated CIDR ranges of trusted reverse proxies (e.g., 10.0.0.0/8,172.16.0.0/12)")
+	trustedCIDRs := flag.String("trusted-cidrs", "", "comma-separated CIDR ranges whose clients may omit the API key, except for admin endpoints (e.g., 192.168.1.0/24)")
+
+	// Redundant client flags
+	mergeWindow := flag.Duration("merge-window", 0, "merge readings of the same device from different clients within this window, keeping the strongest RSSI (0 to disable)")
+
+	// Reject log flags
+	rejectLogFile := flag.String("reject-log", "", "file to log rejected readings to as JSON lines (empty to disable)")
+	rejectLogMaxSize := flag.Int64("reject-log-max-size", 10<<20, "rotate the reject log after this many bytes (0 to disable rotation)")
+
+	// Authentication lockout flags
+	authLockoutFailures := flag.Int("auth-lockout-failures", 10, "failed authentication attempts from one IP within -auth-lockout-window before it is locked out (0 to disable)")
+	authLockoutWindow := flag.Duration("auth-lockout-window", 5*time.Minute, "window in which failed authentication attempts are counted")
+	authLockoutCooldown := flag.Duration("auth-lockout-cooldown", 15*time.Minute, "how long an IP is refused after too many failed authentication attempts")
+
+	// Audit log flags
+	auditLogFile := flag.String("audit-log", "", "append-only file to record API key changes and authentication failures to as JSON lines (empty to disable)")
+
+	// Cleanup flags
+	devicePruneAfter := flag.Duration("device-prune-after", 30*24*time.Hour, "remove devices not seen for this long (0 to never remove)")
+	timeoutCheckInterval := flag.Duration("timeout-check-interval", 1*time.Minute, "interval between client timeout and device pruning checks")
+
+	// Privacy flags
+	privacyMode := flag.Bool("privacy", false, "replace client IDs in API responses with a stable salted hash")
+	privacySalt := flag.String("privacy-salt", "", "salt for hashing client IDs in privacy mode (generated and kept in the storage directory if empty)")
+
+	// Settings that can be reloaded with SIGHUP: -timeout, -rate-limit, -rate-burst, -log-level and the built-in alert flags
+	var settings runtimeSettings
+	settings.registerFlags(flag.CommandLine)
+
+	// Reading quality flags
+	suspectTempDelta := flag.Float64("suspect-temp-delta-per-min", 2.0, "flag readings whose temperature changes by more than this many °C per minute as suspect (0 to disable)")
+	suspectHumidityDelta := flag.Float64("suspect-humidity-delta-per-min", 10.0, "flag readings whose humidity changes by more than this many percentage points per minute as suspect (0 to disable)")
+	maxReadingAge := flag.Duration("max-reading-age", defaultMaxReadingAge, "reject readings timestamped more than this long ago, e.g. 168h to accept a week of spooled readings (0 to accept any age)")
+
+	// Tracing flags
+	otelEndpoint := flag.String("otel-endpoint", "", "OTLP/HTTP endpoint to export traces to, e.g. http://localhost:4318 (empty to disable)")
+
+	// SQLite flags
+	sqlitePath := flag.String("db-path", "", "SQLite database (e.g. created by the JSON migration) to write readings to and serve /stats/all from (empty to disable)")
+	aggregateInterval := flag.Duration("aggregate-interval", 10*time.Minute, "interval for rolling up hourly aggregates in the SQLite database")
+	dbBatchSize := flag.Int("db-batch-size", 500, "insert queued readings into the SQLite database once this many are waiting")
+	dbFlushInterval := flag.Duration("db-flush-interval", 5*time.Second, "interval for inserting queued readings into the SQLite database")
+	dbStats := flag.Bool("db-stats", false, "compute /stats over a from/to range in the SQLite database instead of loading readings (it should hold the device's full history, e.g. after -migrate-from=json)")
+	migrateFrom := flag.String("migrate-from", "", "copy all readings from this storage backend and exit without starting the server (json: the -storage directory; use with -migrate-to)")
+	importCSV := flag.String("import-csv", "", "import readings from a CSV file with the export columns into the -db-path database and exit without starting the server")
+	migrateTo := flag.String("migrate-to", "", "storage backend to copy readings into with -migrate-from (sqlite: the -db-path database)")
+
+	// Response header flags
+	instanceHeaders := flag.Bool("instance-headers", true, "add X-Govee-Instance and X-Govee-Version headers to every response")
+	maxBodyBytes := flag.Int64("max-body-bytes", defaultMaxBodyBytes, "largest request body to accept, in bytes; larger bodies get 413")
+
+	// Email alert flags
+	smtpHost := flag.String("smtp-host", "", "SMTP server to send alert email through, as host or host:port (port 25 if omitted)")
+	smtpFrom := flag.String("smtp-from", "", "sender address for alert email")
+	alertEmail := flag.String("alert-email", "", "comma-separated addresses to email every alert to (empty to disable)")
+	alertSlack := flag.String("alert-slack-webhook", "", "Slack incoming webhook URL to post every alert to (empty to disable)")
+	alertDiscord := flag.String("alert-discord-webhook", "", "Discord webhook URL to post every alert to (empty to disable)")
+	alertChatTemplate := flag.String("alert-chat-template", "", "Go text/template for Slack and Discord alert messages, with .Name, .Kind, .Metric, .Op, .Value, .Threshold, .Time, .Message and bold (empty for the default)")
+	corsOrigins := flag.String("cors-origins", "", "comma-separated origins allowed to call the API from a browser, or * for any (empty to send no CORS headers)")
 
 	flag.Parse()
 
-	// Parse trusted proxy CIDRs
-	var parsedProxies []*net.IPNet
-	if *trustedProxies != "" {
-		for _, cidr := range strings.Split(*trustedProxies, ",") {
-			cidr = strings.TrimSpace(cidr)
-			if cidr == "" {
-				continue
-			}
-			_, network, err := net.ParseCIDR(cidr)
-			if err != nil {
-				log.Fatalf("Invalid trusted proxy CIDR %q: %v", cidr, err)
-			}
-			parsedProxies = append(parsedProxies, network)
+	// Fill in settings from the config file that weren't given on the command line
+	cmdlineFlags := setFlags(flag.CommandLine)
+	if *configFile != "" {
+		values, err := loadConfigFile(*configFile)
+		if err != nil {
+			log.Fatalf("Failed to load config file: %v", err)
+		}
+		skip := make(map[string]bool, len(cmdlineFlags))
+		for name := range cmdlineFlags {
+			skip[name] = true
+		}
+		if err := applyConfigValues(flag.CommandLine, values, skip); err != nil {
+			log.Fatalf("Invalid config file %s: %v", *configFile, err)
+		}
+		log.Printf("Loaded %d settings from %s", len(values), *configFile)
+	}
+	if err := settings.validate(); err != nil {
+		log.Fatalf("Invalid settings: %v", err)
+	}
+
+	// One-shot migration between storage backends; the server isn't started
+	if *migrateFrom != "" || *migrateTo != "" {
+		if *migrateFrom != "json" || *migrateTo != "sqlite" {
+			log.Fatalf("Only -migrate-from=json -migrate-to=sqlite is supported")
+		}
+		if *sqlitePath == "" {
+			log.Fatalf("-migrate-to=sqlite requires -db-path")
+		}
+		if err := MigrateJSONToSQLite(*storageDir, *sqlitePath); err != nil {
+			log.Fatalf("Migration failed: %v", err)
 		}
-		if len(parsedProxies) > 0 {
-			log.Printf("Trusted proxies configured: %s", *trustedProxies)
+		return
+	}
+
+	// One-shot CSV import; the server isn't started
+	if *importCSV != "" {
+		if *sqlitePath == "" {
+			log.Fatalf("-import-csv requires -db-path")
+		}
+		result, err := ImportCSV(*importCSV, *sqlitePath)
+		for _, row := range result.Rejected {
+			log.Printf("Rejected line %d: %s", row.Line, row.Reason)
+		}
+		if err != nil {
+			log.Fatalf("Import failed after %d readings: %v", result.Imported, err)
+		}
+		log.Printf("Imported %d readings from %s (%d already in the database, %d rejected)",
+			result.Imported, *importCSV, result.Duplicates, len(result.Rejected))
+		return
+	}
+
+	// Set up tracing (a no-op unless an endpoint is given)
+	shutdownTracing, err := setupTracing(context.Background(), *otelEndpoint)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	if *otelEndpoint != "" {
+		log.Printf("Exporting traces to %s", *otelEndpoint)
+	}
+
+	// Parse trusted proxy CIDRs
+	parsedProxies, err := parseCIDRList(*trustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid -trusted-proxies: %v", err)
+	}
+	if len(parsedProxies) > 0 {
+		log.Printf("Trusted proxies configured: %s", *trustedProxies)
+	}
+	parsedTrusted, err := parseCIDRList(*trustedCIDRs)
+	if err != nil {
+		log.Fatalf("Invalid -trusted-cidrs: %v", err)
+	}
+	if len(parsedTrusted) > 0 {
+		log.Printf("Requests from %s need no API key, except for admin endpoints", *trustedCIDRs)
+	}
+
+	if *requireClientCert && (!*enableHTTPS || *clientCAFile == "") {
+		log.Fatalf("-require-client-cert needs -https and -client-ca")
+	}
+
+	// Parse CORS origins
+	var parsedOrigins []string
+	for _, origin := range strings.Split(*corsOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			parsedOrigins = append(parsedOrigins, strings.TrimSuffix(origin, "/"))
 		}
 	}
+	if len(parsedOrigins) > 0 {
+		log.Printf("CORS allowed origins: %s", strings.Join(parsedOrigins, ", "))
+	}
 
 	// Create authentication configuration
 	auth := &AuthConfig{
 		EnableAuth:      *enableAuth,
-		APIKeys:         make(map[string]string),
+		APIKeys:         make(map[string]APIKey),
 		AllowDefaultKey: *allowDefaultKey,
 	}
 
@@ -1955,11 +6126,39 @@ func main() {
 		}
 	}
 
+	var emailAlerts *emailNotifier
+	if *alertEmail != "" {
+		n, err := newEmailNotifier(*smtpHost, *smtpFrom, *alertEmail)
+		if err != nil {
+			log.Fatalf("Invalid email alert settings: %v", err)
+		}
+		emailAlerts = n
+	}
+	var chatAlerts []Notifier
+	for _, chat := range []struct{ platform, url string }{{"slack", *alertSlack}, {"discord", *alertDiscord}} {
+		if chat.url == "" {
+			continue
+		}
+		n, err := newChatNotifier(&http.Client{Timeout: webhookTimeout}, chat.platform, chat.url, *alertChatTemplate)
+		if err != nil {
+			log.Fatalf("Invalid %s alert settings: %v", chat.platform, err)
+		}
+		chatAlerts = append(chatAlerts, n)
+	}
+
+	if *memoryWindow > 0 && (*memoryWindowMin < 1 || *memoryWindowMax < *memoryWindowMin) {
+		log.Fatalf("-memory-window-min must be at least 1 and no more than -memory-window-max")
+	}
+
 	// Create server configuration
 	config := &Config{
 		Port:               *port,
 		LogFile:            *logFile,
-		ClientTimeout:      *clientTimeout,
+		LogMaxSize:         *logMaxSize,
+		LogMaxBackups:      *logMaxBackups,
+		ClientTimeout:      settings.ClientTimeout,
+		RateLimit:          settings.RateLimit,
+		RateBurst:          settings.RateBurst,
 		ReadingsPerDevice:  *readingsPerDevice,
 		StorageDir:         *storageDir,
 		PersistenceEnabled: *persistenceEnabled,
@@ -1968,16 +6167,60 @@ func main() {
 		CertFile:           *certFile,
 		KeyFile:            *keyFile,
 		TrustedProxies:     parsedProxies,
+		TrustedCIDRs:       parsedTrusted,
+		MergeWindow:        *mergeWindow,
+		RejectLogFile:      *rejectLogFile,
+		RejectLogMaxSize:   *rejectLogMaxSize,
+		AuditLogFile:       *auditLogFile,
+		// Failed authentication lockout
+		AuthLockoutFailures: *authLockoutFailures,
+		AuthLockoutWindow:   *authLockoutWindow,
+		AuthLockoutCooldown: *authLockoutCooldown,
+		// Cleanup settings
+		DevicePruneAfter:     *devicePruneAfter,
+		TimeoutCheckInterval: *timeoutCheckInterval,
+		// Privacy settings
+		PrivacyMode: *privacyMode,
+		PrivacySalt: *privacySalt,
+		// Built-in alert settings
+		LowBatteryThreshold: settings.LowBatteryThreshold,
+		OfflineAlertAfter:   settings.OfflineAlertAfter,
+		AlertWebhookURL:     settings.AlertWebhookURL,
+		LogLevel:            settings.LogLevel,
+		// Reading quality settings
+		SuspectTempDeltaPerMin:     *suspectTempDelta,
+		SuspectHumidityDeltaPerMin: *suspectHumidityDelta,
+		MaxReadingAge:              *maxReadingAge,
+		// Response header settings
+		InstanceHeaders: *instanceHeaders,
+		CORSOrigins:     parsedOrigins,
+		MaxBodyBytes:    *maxBodyBytes,
+		DBStats:         *dbStats,
+		// In-memory history settings
+		MemoryWindowDuration: *memoryWindow,
+		MemoryWindowMin:      *memoryWindowMin,
+		MemoryWindowMax:      *memoryWindowMax,
+	}
+
+	if err := validateCompression(*compression); err != nil {
+		log.Fatalf("Invalid -compression: %v", err)
+	}
+	deviceRetentionPeriods, err := parseDeviceRetention(*deviceRetention)
+	if err != nil {
+		log.Fatalf("Invalid -device-retention: %v", err)
 	}
 
 	// Create storage configuration
 	storageConfig := &StorageConfig{
-		BaseDir:            *storageDir,
-		TimePartitioning:   *timePartitioning,
-		PartitionInterval:  *partitionInterval,
-		RetentionPeriod:    *retentionPeriod,
-		MaxReadingsPerFile: *maxReadingsPerFile,
-		CompressOldData:    *compressOldData,
+		BaseDir:               *storageDir,
+		TimePartitioning:      *timePartitioning,
+		PartitionInterval:     *partitionInterval,
+		RetentionPeriod:       *retentionPeriod,
+		DeviceRetention:       deviceRetentionPeriods,
+		KeepAggregatesForever: *keepAggregates,
+		MaxReadingsPerFile:    *maxReadingsPerFile,
+		CompressOldData:       *compressOldData && *compression != "none",
+		Compression:           *compression,
 	}
 
 	// Create storage manager
@@ -1985,12 +6228,42 @@ func main() {
 
 	// Create and initialize server
 	server := NewServer(config, auth, storageManager)
+	log.Printf("Server version %s (commit %s, built %s), instance %s", Version, Commit, BuildDate, server.instanceID)
+	if emailAlerts != nil {
+		server.alertNotifiers = append(server.alertNotifiers, emailAlerts)
+		log.Printf("Emailing alerts via %s: %s", emailAlerts.addr, strings.Join(emailAlerts.to, ", "))
+	}
+	for _, n := range chatAlerts {
+		server.alertNotifiers = append(server.alertNotifiers, n)
+		log.Printf("Posting alerts to %s", n)
+	}
 
 	// Load data from storage if enabled
 	if config.PersistenceEnabled {
 		server.loadData()
 	}
 
+	// Open the SQLite database, queue new readings to it and keep its hourly aggregates rolled up
+	var sqliteStorage *SQLiteStorage
+	if *dbStats && *sqlitePath == "" {
+		log.Fatalf("-db-stats requires -db-path")
+	}
+	if *sqlitePath != "" {
+		if *dbBatchSize <= 0 || *dbFlushInterval <= 0 {
+			log.Fatalf("-db-batch-size and -db-flush-interval must be positive")
+		}
+		sqliteStorage = NewSQLiteStorage(*sqlitePath)
+		if err := sqliteStorage.Initialize(); err != nil {
+			log.Fatalf("Failed to open SQLite database: %v", err)
+		}
+		sqliteStorage.StartWriteBuffer(*dbBatchSize, *dbFlushInterval)
+		sqliteStorage.SetKeepAggregatesForever(*keepAggregates)
+		server.aggregateStore = sqliteStorage
+		server.readingWriter = sqliteStorage
+		go sqliteStorage.RunAggregateRollup(server.shutdownCtx, *aggregateInterval)
+		log.Printf("Serving aggregate stats from %s", *sqlitePath)
+	}
+
 	// Start a routine to periodically enforce retention
 	go func() {
 		retentionTicker := time.NewTicker(24 * time.Hour) // Check retention daily
@@ -1999,7 +6272,7 @@ func main() {
 		for {
 			select {
 			case <-retentionTicker.C:
-				if err := storageManager.enforceRetention(); err != nil {
+				if _, err := storageManager.enforceRetention(server.shutdownCtx); err != nil {
 					log.Printf("Error enforcing retention: %v", err)
 				}
 			case <-server.shutdownCtx.Done():
@@ -2012,21 +6285,48 @@ func main() {
 	// Create HTTP server
 	mux := http.NewServeMux()
 
-	// Create middleware chain: compression -> security headers -> rate limit -> auth
+	// Create middleware chain: compression -> security headers -> rate limit -> auth, with
+	// request decompression before auth on /readings since the auth check reads the body.
+	// Request logging and CORS wrap the whole mux (see the http.Server handlers below), so
+	// requests rejected by any of these are logged too and preflights never reach auth.
 	compressionMiddleware := server.compressionMiddleware
 	securityMiddleware := server.securityHeadersMiddleware
 	rateLimitMiddleware := server.rateLimitMiddleware
 	authMiddleware := server.authMiddleware
+	decompressionMiddleware := server.decompressionMiddleware
 
 	// API endpoints with full middleware chain
-	mux.Handle("/readings", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleReadings))))))
+	mux.Handle("/readings", compressionMiddleware(securityMiddleware(rateLimitMiddleware(decompressionMiddleware(authMiddleware(http.HandlerFunc(server.handleReadings)))))))
+	mux.Handle("/readings/latest", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleReadingsLatest))))))
 	mux.Handle("/devices", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleDevices))))))
 	mux.Handle("/clients", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleClients))))))
 	mux.Handle("/stats", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleStats))))))
+	mux.Handle("/stats/all", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleStatsAll))))))
+	mux.Handle("/grafana/", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleGrafanaRoot))))))
+	mux.Handle("/grafana/search", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleGrafanaSearch))))))
+	mux.Handle("/grafana/query", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleGrafanaQuery))))))
+	mux.Handle("/aggregates/hourly", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleHourlyAggregates))))))
+	mux.Handle("/gaps", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleGaps))))))
 	mux.Handle("/dashboard/data", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleDashboardData))))))
 	mux.Handle("/api/keys", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleAPIKeys))))))
+	mux.Handle("/api/keys/rotate-admin", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleRotateAdminKey))))))
+	mux.Handle("/api/keys/usage", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleAPIKeyUsage))))))
 	mux.Handle("/api/aliases", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleDeviceAliases))))))
+	mux.Handle("/alerts", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleAlerts))))))
+	mux.Handle("/alerts/history", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleAlertHistory))))))
+	mux.Handle("/api/metadata", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleDeviceMetadata))))))
+	mux.Handle("/admin/device-partitions", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleDevicePartitions))))))
+	mux.Handle("/admin/backup", securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleBackup)))))
+	mux.Handle("/admin/restore", securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleRestore)))))
+	mux.Handle("/admin/storage", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleStorageUsage))))))
+	mux.Handle("/metrics", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleMetrics))))))
+	mux.Handle("/admin/maintenance", compressionMiddleware(securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleMaintenance))))))
+	// Export downloads skip compression: the archive is already compressed and Range offsets must match the file
+	mux.Handle("/export", securityMiddleware(rateLimitMiddleware(authMiddleware(http.HandlerFunc(server.handleExport)))))
 	mux.Handle("/health", compressionMiddleware(securityMiddleware(rateLimitMiddleware(http.HandlerFunc(server.handleHealthCheck)))))
+	mux.Handle("/ready", compressionMiddleware(securityMiddleware(rateLimitMiddleware(http.HandlerFunc(server.handleReadiness)))))
+	mux.Handle("/version", compressionMiddleware(securityMiddleware(rateLimitMiddleware(http.HandlerFunc(server.handleVersion)))))
+	mux.Handle("/openapi.json", compressionMiddleware(securityMiddleware(rateLimitMiddleware(http.HandlerFunc(server.handleOpenAPI)))))
 
 	// Serve static files for dashboard (with security headers, but skip compression for pre-compressed assets)
 	mux.Handle("/", securityMiddleware(handleStaticFiles(*staticDir)))
@@ -2046,17 +6346,32 @@ func main() {
 			keyPath = filepath.Join(*storageDir, keyPath)
 		}
 
+		tlsConfig := &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		}
+		if *requireClientCert {
+			caPath := *clientCAFile
+			if !filepath.IsAbs(caPath) {
+				caPath = filepath.Join(*storageDir, caPath)
+			}
+			clientCAs, err := loadCertPool(caPath)
+			if err != nil {
+				log.Fatalf("Failed to load client CA: %v", err)
+			}
+			tlsConfig.ClientCAs = clientCAs
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			log.Printf("Requiring client certificates signed by %s", caPath)
+		}
+
 		// Create HTTPS server
 		httpServer = &http.Server{
 			Addr:           fmt.Sprintf(":%d", config.Port),
-			Handler:        mux,
+			Handler:        server.loggingMiddleware(server.corsMiddleware(server.instanceHeadersMiddleware(tracingMiddleware(mux)))),
 			ReadTimeout:    10 * time.Second,
 			WriteTimeout:   10 * time.Second,
 			IdleTimeout:    120 * time.Second,
 			MaxHeaderBytes: 1 << 20, // 1MB
-			TLSConfig: &tls.Config{
-				MinVersion: tls.VersionTLS12,
-			},
+			TLSConfig:      tlsConfig,
 		}
 
 		log.Printf("Starting Govee Server with HTTPS on port %d", config.Port)
@@ -2073,7 +6388,7 @@ func main() {
 		// Create HTTP server
 		httpServer = &http.Server{
 			Addr:           fmt.Sprintf(":%d", config.Port),
-			Handler:        mux,
+			Handler:        server.loggingMiddleware(server.corsMiddleware(server.instanceHeadersMiddleware(tracingMiddleware(mux)))),
 			ReadTimeout:    10 * time.Second,
 			WriteTimeout:   10 * time.Second,
 			IdleTimeout:    120 * time.Second,
@@ -2089,6 +6404,20 @@ func main() {
 		}()
 	}
 
+	// Reload the runtime settings from the config file on SIGHUP
+	if *configFile != "" {
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+		go func() {
+			for range reload {
+				log.Printf("Reloading %s", *configFile)
+				if err := server.reloadConfigFile(*configFile, cmdlineFlags); err != nil {
+					log.Printf("Config reload failed, keeping current settings: %v", err)
+				}
+			}
+		}()
+	}
+
 	// Handle graceful shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt)
@@ -2112,5 +6441,17 @@ func main() {
 		log.Fatalf("Server shutdown failed: %v", err)
 	}
 
+	// Close drains the write buffer, so this comes after the HTTP server stops accepting readings
+	if sqliteStorage != nil {
+		if err := sqliteStorage.Close(); err != nil {
+			log.Printf("Error closing SQLite database: %v", err)
+		}
+	}
+
+	// Flush any buffered spans
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Error shutting down tracing: %v", err)
+	}
+
 	log.Println("Server shutdown complete")
 }
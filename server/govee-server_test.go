@@ -2,8 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -140,6 +143,30 @@ func TestSanitizeDeviceName(t *testing.T) {
 			expected:  "",
 			wantError: true,
 		},
+		{
+			name:      "Surrounding whitespace is trimmed",
+			input:     "  Kitchen Sensor \n",
+			expected:  "Kitchen Sensor",
+			wantError: false,
+		},
+		{
+			name:      "Whitespace only",
+			input:     " \t ",
+			expected:  "",
+			wantError: true,
+		},
+		{
+			name:      "Longest allowed",
+			input:     strings.Repeat("a", 100),
+			expected:  strings.Repeat("a", 100),
+			wantError: false,
+		},
+		{
+			name:      "Too long after trimming",
+			input:     " " + strings.Repeat("a", 101) + " ",
+			expected:  "",
+			wantError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -162,6 +189,34 @@ func TestSanitizeDeviceName(t *testing.T) {
 	}
 }
 
+// TestSanitizeDeviceNameErrors tests that rejected device names report what was wrong
+func TestSanitizeDeviceNameErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"Kitchen <b>", "HTML character '<'"},
+		{"Kitchen>", "HTML character '>'"},
+		{"Tom & Jerry", "HTML character '&'"},
+		{`Say "hi"`, "HTML character '\"'"},
+		{"Kitchen\tSensor", "control character U+0009"},
+		{"Kitchen\x1b[31m", "control character U+001B"},
+		{"Kitchen\x7f", "control character U+007F"},
+		{"Kitchen\u0085Sensor", "control character U+0085"},
+		{"Küche", "invalid characters"},
+		{"Sensor;1", "invalid characters"},
+		{strings.Repeat("a", 150), "too long (150 characters, max 100)"},
+		{"", "required"},
+	}
+
+	for _, tt := range tests {
+		_, err := sanitizeDeviceName(tt.input)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("sanitizeDeviceName(%q): expected error containing %q, got %v", tt.input, tt.expected, err)
+		}
+	}
+}
+
 // TestValidateReading tests reading validation
 func TestValidateReading(t *testing.T) {
 	tests := []struct {
@@ -277,7 +332,7 @@ func TestValidateReading(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateReading(&tt.reading)
+			err := validateReading(&tt.reading, defaultMaxReadingAge)
 
 			if tt.wantError {
 				if err == nil {
@@ -292,6 +347,228 @@ func TestValidateReading(t *testing.T) {
 	}
 }
 
+// TestValidateReadingRejects tests the RSSI, device address and non-finite number checks
+func TestValidateReadingRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(r *Reading)
+		wantErr string
+	}{
+		{"RSSI too low", func(r *Reading) { r.RSSI = -121 }, "RSSI out of range"},
+		{"RSSI positive", func(r *Reading) { r.RSSI = 5 }, "RSSI out of range"},
+		{"Device address not a MAC", func(r *Reading) { r.DeviceAddr = "../../etc" }, "invalid device address"},
+		{"Device address too short", func(r *Reading) { r.DeviceAddr = "AA:BB:CC" }, "invalid device address"},
+		{"NaN temperature", func(r *Reading) { r.TempC = math.NaN() }, "temp_c is not a finite number"},
+		{"Inf humidity", func(r *Reading) { r.Humidity = math.Inf(1) }, "humidity is not a finite number"},
+		{"NaN dew point", func(r *Reading) { r.DewPointF = math.NaN() }, "dew_point_f is not a finite number"},
+		{"-Inf steam pressure", func(r *Reading) { r.SteamPressure = math.Inf(-1) }, "steam_pressure is not a finite number"},
+		{"NaN offset", func(r *Reading) { r.HumidityOffset = math.NaN() }, "humidity_offset is not a finite number"},
+		{"Inf mixing ratio", func(r *Reading) { r.MixingRatio = math.Inf(1) }, "mixing_ratio is not a finite number"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reading := Reading{
+				DeviceName: "Test Sensor",
+				DeviceAddr: "AA:BB:CC:DD:EE:FF",
+				TempC:      25.0,
+				Humidity:   60.0,
+				Battery:    85,
+				RSSI:       -70,
+				Timestamp:  time.Now(),
+				ClientID:   "test-client",
+			}
+			tt.modify(&reading)
+
+			err := validateReading(&reading, defaultMaxReadingAge)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestDownsample tests averaging readings into fixed time buckets
+func TestDownsample(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(offset time.Duration, tempC float64, battery int) Reading {
+		return Reading{DeviceAddr: "AA:BB:CC:DD:EE:FF", TempC: tempC, Humidity: 2 * tempC, Battery: battery, RSSI: -50 - battery, Timestamp: start.Add(offset)}
+	}
+
+	t.Run("Empty input", func(t *testing.T) {
+		if got := downsample(nil, 15*time.Minute); len(got) != 0 {
+			t.Errorf("Expected no readings, got %d", len(got))
+		}
+	})
+
+	t.Run("Partial buckets", func(t *testing.T) {
+		// Three readings in the first quarter hour, none in the second, one in the third
+		readings := []Reading{
+			at(1*time.Minute, 20, 90),
+			at(5*time.Minute, 21, 89),
+			at(14*time.Minute, 25, 88),
+			at(40*time.Minute, 30, 87),
+		}
+		readings[1].Quality = qualitySuspect
+		got := downsample(readings, 15*time.Minute)
+		if len(got) != 2 {
+			t.Fatalf("Expected 2 buckets, got %d: %+v", len(got), got)
+		}
+		first, second := got[0], got[1]
+		if !first.Timestamp.Equal(start) || first.TempC != 22 || first.Humidity != 44 {
+			t.Errorf("Unexpected first bucket: %v, %.2f°C, %.2f%%", first.Timestamp, first.TempC, first.Humidity)
+		}
+		if first.Battery != 88 || first.RSSI != -138 || first.Quality != qualitySuspect {
+			t.Errorf("Expected battery, RSSI and quality from the bucket's last reading, got %d, %d, %q", first.Battery, first.RSSI, first.Quality)
+		}
+		if !second.Timestamp.Equal(start.Add(30*time.Minute)) || second.TempC != 30 || second.Battery != 87 || second.Quality != "" {
+			t.Errorf("Unexpected second bucket: %+v", second)
+		}
+	})
+
+	t.Run("Exact boundaries", func(t *testing.T) {
+		// A reading exactly on a boundary starts the next bucket
+		readings := []Reading{
+			at(0, 20, 90),
+			at(15*time.Minute-time.Nanosecond, 22, 90),
+			at(15*time.Minute, 30, 90),
+			at(30*time.Minute, 40, 90),
+		}
+		got := downsample(readings, 15*time.Minute)
+		want := []struct {
+			offset time.Duration
+			tempC  float64
+		}{{0, 21}, {15 * time.Minute, 30}, {30 * time.Minute, 40}}
+		if len(got) != len(want) {
+			t.Fatalf("Expected %d buckets, got %d: %+v", len(want), len(got), got)
+		}
+		for i, w := range want {
+			if !got[i].Timestamp.Equal(start.Add(w.offset)) || got[i].TempC != w.tempC {
+				t.Errorf("Bucket %d: expected %v at %.1f°C, got %v at %.1f°C", i, start.Add(w.offset), w.tempC, got[i].Timestamp, got[i].TempC)
+			}
+		}
+	})
+}
+
+// TestFindGaps tests finding silences between readings, including at the edges of the range
+func TestFindGaps(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minutes ...int) []Reading {
+		var readings []Reading
+		for _, m := range minutes {
+			readings = append(readings, Reading{Timestamp: start.Add(time.Duration(m) * time.Minute)})
+		}
+		return readings
+	}
+	minute := func(m int) time.Time { return start.Add(time.Duration(m) * time.Minute) }
+
+	tests := []struct {
+		name     string
+		readings []Reading
+		from, to time.Time
+		want     []Gap
+	}{
+		{"No gaps", at(0, 5, 10, 15), time.Time{}, time.Time{}, nil},
+		{"Gap in the middle", at(0, 5, 30, 35), time.Time{}, time.Time{}, []Gap{{minute(5), minute(30), "25m0s"}}},
+		{"Exactly the threshold is not a gap", at(0, 10, 20), time.Time{}, time.Time{}, nil},
+		{"Gap at the start", at(30, 35), minute(0), minute(40), []Gap{{minute(0), minute(30), "30m0s"}}},
+		{"Gap at the end", at(0, 5), minute(0), minute(60), []Gap{{minute(5), minute(60), "55m0s"}}},
+		{"No readings in the range", nil, minute(0), minute(60), []Gap{{minute(0), minute(60), "1h0m0s"}}},
+		{"No readings and no range", nil, time.Time{}, time.Time{}, nil},
+		{"Single reading", at(30), minute(0), minute(60), []Gap{{minute(0), minute(30), "30m0s"}, {minute(30), minute(60), "30m0s"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := findGaps(tt.readings, tt.from, tt.to, 10*time.Minute)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected %d gaps, got %d: %+v", len(tt.want), len(got), got)
+			}
+			for i, want := range tt.want {
+				if !got[i].Start.Equal(want.Start) || !got[i].End.Equal(want.End) || got[i].Duration != want.Duration {
+					t.Errorf("Gap %d: expected %+v, got %+v", i, want, got[i])
+				}
+			}
+		})
+	}
+}
+
+// TestReadingTrend tests the per-hour trend and standard deviation fitted over readings
+func TestReadingTrend(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	series := func(step time.Duration, values ...float64) []Reading {
+		readings := make([]Reading, len(values))
+		for i, v := range values {
+			readings[i] = Reading{TempC: v, Timestamp: start.Add(time.Duration(i) * step)}
+		}
+		return readings
+	}
+	tempC := func(r Reading) float64 { return r.TempC }
+
+	tests := []struct {
+		name       string
+		readings   []Reading
+		wantOK     bool
+		wantSlope  float64
+		wantStddev float64
+	}{
+		{"Rising", series(30*time.Minute, 20, 21, 22, 23, 24), true, 2, math.Sqrt(2)},
+		{"Falling", series(time.Hour, 30, 27, 24, 21), true, -3, math.Sqrt(11.25)},
+		{"Flat", series(10*time.Minute, 21.5, 21.5, 21.5, 21.5), true, 0, 0},
+		{"Single reading", series(time.Hour, 20), false, 0, 0},
+		{"No readings", nil, false, 0, 0},
+		{"Same timestamp", series(0, 20, 25), false, 0, 2.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trend := readingTrend(tt.readings, tempC)
+			slope, ok := trend.slope()
+			if ok != tt.wantOK || math.Abs(slope-tt.wantSlope) > 1e-9 {
+				t.Errorf("Expected slope %v (ok %v), got %v (ok %v)", tt.wantSlope, tt.wantOK, slope, ok)
+			}
+			if stddev := trend.stddev(); math.Abs(stddev-tt.wantStddev) > 1e-9 {
+				t.Errorf("Expected stddev %v, got %v", tt.wantStddev, stddev)
+			}
+		})
+	}
+}
+
+// TestPercentiles tests percentiles of a small known dataset and parsing the percentiles parameter
+func TestPercentiles(t *testing.T) {
+	sorted := []float64{15, 20, 35, 40, 50}
+	for p, want := range map[float64]float64{0: 15, 25: 20, 50: 35, 95: 48, 100: 50} {
+		if got := percentile(sorted, p); math.Abs(got-want) > 1e-9 {
+			t.Errorf("p%v: expected %v, got %v", p, want, got)
+		}
+	}
+	if got := percentile([]float64{21.5}, 95); got != 21.5 {
+		t.Errorf("Expected the only value for a single reading, got %v", got)
+	}
+
+	readings := []Reading{{TempC: 40, Humidity: 60}, {TempC: 15, Humidity: 50}, {TempC: 50, Humidity: 55}, {TempC: 20, Humidity: 45}, {TempC: 35, Humidity: 65}}
+	stats := map[string]interface{}{}
+	addPercentileStats(stats, len(readings), func(i int) Reading { return readings[i] }, []float64{95, 99.5})
+	want := map[string]float64{"temp_c_median": 35, "temp_c_p95": 48, "temp_c_p99.5": 49.8, "humidity_median": 55, "humidity_p95": 64, "humidity_p99.5": 64.9}
+	if len(stats) != len(want) {
+		t.Errorf("Expected %d stats, got %v", len(want), stats)
+	}
+	for key, w := range want {
+		if got, ok := stats[key].(float64); !ok || math.Abs(got-w) > 1e-9 {
+			t.Errorf("%s: expected %v, got %v", key, w, stats[key])
+		}
+	}
+
+	if got, err := parsePercentiles("50, 90,99.9"); err != nil || len(got) != 3 || got[2] != 99.9 {
+		t.Errorf("Expected [50 90 99.9], got %v (%v)", got, err)
+	}
+	for _, list := range []string{"95,", "abc", "-1", "101", "1,2,3,4,5,6,7,8,9,10,11"} {
+		if _, err := parsePercentiles(list); err == nil {
+			t.Errorf("Expected %q to be rejected", list)
+		}
+	}
+}
+
 // TestHealthCheckEndpoint tests the health check HTTP endpoint
 func TestHealthCheckEndpoint(t *testing.T) {
 	// Create test server
@@ -361,7 +638,7 @@ func TestDashboardCache(t *testing.T) {
 	}
 
 	// Initially should be empty
-	if data := cache.Get(); data != nil {
+	if data := cache.Get(defaultDashboardReadings); data != nil {
 		t.Error("Expected nil from empty cache")
 	}
 
@@ -371,10 +648,10 @@ func TestDashboardCache(t *testing.T) {
 		Clients:       make([]*ClientStatus, 0),
 		ActiveClients: 5,
 	}
-	cache.Set(testData)
+	cache.Set(defaultDashboardReadings, testData)
 
 	// Should retrieve the same data
-	if data := cache.Get(); data == nil {
+	if data := cache.Get(defaultDashboardReadings); data == nil {
 		t.Error("Expected data from cache")
 	} else if data.ActiveClients != 5 {
 		t.Errorf("Expected ActiveClients=5, got %d", data.ActiveClients)
@@ -384,7 +661,7 @@ func TestDashboardCache(t *testing.T) {
 	time.Sleep(150 * time.Millisecond)
 
 	// Should be expired
-	if data := cache.Get(); data != nil {
+	if data := cache.Get(defaultDashboardReadings); data != nil {
 		t.Error("Expected nil from expired cache")
 	}
 }
@@ -492,7 +769,7 @@ func BenchmarkValidateReading(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		validateReading(&reading)
+		validateReading(&reading, defaultMaxReadingAge)
 	}
 }
 
@@ -507,10 +784,52 @@ func BenchmarkDashboardCache(b *testing.B) {
 		Clients:       make([]*ClientStatus, 0),
 		ActiveClients: 5,
 	}
-	cache.Set(testData)
+	cache.Set(defaultDashboardReadings, testData)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		cache.Get()
+		cache.Get(defaultDashboardReadings)
+	}
+}
+
+// TestNormalizeTags tests tag sanitizing and case-insensitive de-duplication
+func TestNormalizeTags(t *testing.T) {
+	tags, err := normalizeTags([]string{" kitchen ", "Upstairs", "KITCHEN", "north (window)"})
+	if err != nil {
+		t.Fatalf("normalizeTags failed: %v", err)
+	}
+	if expected := []string{"kitchen", "Upstairs", "north (window)"}; !reflect.DeepEqual(tags, expected) {
+		t.Errorf("Expected %v, got %v", expected, tags)
+	}
+
+	for _, bad := range [][]string{{""}, {"a&b"}, {strings.Repeat("x", maxLabelLength+1)}, make([]string, maxDeviceTags+1)} {
+		if _, err := normalizeTags(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+
+	if tags, err := normalizeTags(nil); err != nil || tags != nil {
+		t.Errorf("Expected no tags for nil, got %v, %v", tags, err)
+	}
+}
+
+// TestETagMatches tests If-None-Match parsing with weak comparison and lists
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{`W/"abc"`, true},
+		{`"abc"`, true},
+		{`"abd"`, false},
+		{`"x", W/"abc"`, true},
+		{`"x","y"`, false},
+		{"*", true},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, `W/"abc"`); got != tt.want {
+			t.Errorf("etagMatches(%q) = %v, expected %v", tt.header, got, tt.want)
+		}
 	}
 }
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Grafana JSON datasource (the SimpleJSON / Infinity API): a target is a device address and a
+// metric joined by a slash, e.g. "A4:C1:38:25:A1:E3/temp_c", and each one is returned as a
+// time series of [value, unix milliseconds] pairs.
+
+// grafanaMetrics are the metrics /grafana/search offers for each device
+var grafanaMetrics = []string{"temp_c", "temp_f", "humidity", "dew_point_c", "dew_point_f", "abs_humidity", "vpd", "frost_point_c", "mixing_ratio", "battery", "rssi"}
+
+// grafanaAggregateMetrics are the metrics served from hourly averages when Grafana asks for
+// an interval of an hour or more, so long ranges don't load every reading
+var grafanaAggregateMetrics = map[string]func(AggregateReading) float64{
+	"temp_c":   func(a AggregateReading) float64 { return a.AvgTempC },
+	"humidity": func(a AggregateReading) float64 { return a.AvgHumidity },
+}
+
+// maxGrafanaTargets caps how many series one /grafana/query request may ask for
+const maxGrafanaTargets = 50
+
+// grafanaQuery is the body of a /grafana/query request; fields Grafana sends that aren't
+// needed are left out
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	IntervalMs int64 `json:"intervalMs"`
+	Targets    []struct {
+		Target string `json:"target"`
+		Type   string `json:"type"`
+	} `json:"targets"`
+}
+
+// grafanaSeries is one time series in a /grafana/query response
+type grafanaSeries struct {
+	Target string `json:"target"`
+	// Datapoints are [value, unix milliseconds] pairs, oldest first
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// parseGrafanaTarget splits a target into a device address and metric, checking both
+func parseGrafanaTarget(target string) (string, string, error) {
+	i := strings.LastIndex(target, "/")
+	if i < 0 {
+		return "", "", fmt.Errorf("target %q is not device/metric", target)
+	}
+	deviceAddr, metric := target[:i], target[i+1:]
+	if _, err := sanitizeDeviceAddr(deviceAddr); err != nil {
+		return "", "", fmt.Errorf("target %q: %v", target, err)
+	}
+	if _, ok := readingMetric(&Reading{}, metric); !ok {
+		return "", "", fmt.Errorf("target %q: unknown metric %q", target, metric)
+	}
+	return deviceAddr, metric, nil
+}
+
+// handleGrafanaRoot answers the datasource's connection test
+func (s *Server) handleGrafanaRoot(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/grafana/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write([]byte("OK\n"))
+}
+
+// handleGrafanaSearch lists the targets a query can ask for, optionally filtered by the
+// substring in the request's "target"
+func (s *Server) handleGrafanaSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var search struct {
+		Target string `json:"target"`
+	}
+	if r.Method == "POST" && r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&search); err != nil {
+			http.Error(w, "Invalid search body", http.StatusBadRequest)
+			return
+		}
+	}
+	filter := strings.ToLower(search.Target)
+
+	targets := []string{}
+	for _, device := range s.getDevices() {
+		for _, metric := range grafanaMetrics {
+			target := device.DeviceAddr + "/" + metric
+			if strings.Contains(strings.ToLower(target), filter) {
+				targets = append(targets, target)
+			}
+		}
+	}
+	sort.Strings(targets)
+	respondJSON(w, targets)
+}
+
+// handleGrafanaQuery returns a time series for each target over the requested range. With an
+// interval under an hour readings are averaged per interval; from an hour up, temperature and
+// humidity come from the hourly aggregates.
+func (s *Server) handleGrafanaQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var query grafanaQuery
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&query); err != nil {
+		http.Error(w, "Invalid query body", http.StatusBadRequest)
+		return
+	}
+	fromTime, toTime := query.Range.From, query.Range.To
+	if !fromTime.Before(toTime) {
+		http.Error(w, "'from' must be before 'to'", http.StatusBadRequest)
+		return
+	}
+	if toTime.Sub(fromTime) > maxStatsAllRange {
+		http.Error(w, fmt.Sprintf("Range too large, maximum is %d days", int(maxStatsAllRange.Hours()/24)), http.StatusBadRequest)
+		return
+	}
+	if len(query.Targets) > maxGrafanaTargets {
+		http.Error(w, fmt.Sprintf("Too many targets, maximum is %d", maxGrafanaTargets), http.StatusBadRequest)
+		return
+	}
+	interval := time.Duration(query.IntervalMs) * time.Millisecond
+
+	series := []grafanaSeries{}
+	for _, t := range query.Targets {
+		if t.Target == "" {
+			// Grafana sends a target for a query row that hasn't been filled in yet
+			continue
+		}
+		if t.Type != "" && t.Type != "timeserie" {
+			http.Error(w, fmt.Sprintf("Unsupported target type %q, only timeserie", t.Type), http.StatusBadRequest)
+			return
+		}
+		deviceAddr, metric, err := parseGrafanaTarget(t.Target)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var datapoints [][2]float64
+		if value, ok := grafanaAggregateMetrics[metric]; ok && interval >= time.Hour {
+			datapoints, err = s.grafanaAggregatePoints(deviceAddr, fromTime, toTime, value)
+		} else {
+			datapoints, err = s.grafanaReadingPoints(deviceAddr, metric, fromTime, toTime, interval)
+		}
+		if err != nil {
+			http.Error(w, "Error loading readings", http.StatusInternalServerError)
+			log.Printf("Grafana query for %s failed: %v", t.Target, err)
+			return
+		}
+		series = append(series, grafanaSeries{Target: t.Target, Datapoints: datapoints})
+	}
+	respondJSON(w, series)
+}
+
+// grafanaAggregatePoints returns hourly averages of a device's metric, oldest first
+func (s *Server) grafanaAggregatePoints(deviceAddr string, fromTime, toTime time.Time, value func(AggregateReading) float64) ([][2]float64, error) {
+	aggregates, err := s.deviceHourlyAggregates(deviceAddr, fromTime, toTime)
+	if err != nil {
+		return nil, err
+	}
+	datapoints := make([][2]float64, 0, len(aggregates))
+	for i := len(aggregates) - 1; i >= 0; i-- {
+		datapoints = append(datapoints, [2]float64{value(aggregates[i]), float64(aggregates[i].Timestamp.UnixMilli())})
+	}
+	return datapoints, nil
+}
+
+// grafanaReadingPoints returns a device's metric from its readings, oldest first, averaged
+// per interval if one is given
+func (s *Server) grafanaReadingPoints(deviceAddr, metric string, fromTime, toTime time.Time, interval time.Duration) ([][2]float64, error) {
+	readings, err := s.getDeviceReadings(deviceAddr, fromTime, toTime)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(readings, func(i, j int) bool { return readings[i].Timestamp.Before(readings[j].Timestamp) })
+
+	datapoints := [][2]float64{}
+	var bucket time.Time
+	sum, count := 0.0, 0
+	flush := func() {
+		if count > 0 {
+			datapoints = append(datapoints, [2]float64{sum / float64(count), float64(bucket.UnixMilli())})
+		}
+	}
+	for i := range readings {
+		r := &readings[i]
+		if r.Timestamp.Before(fromTime) || r.Timestamp.After(toTime) {
+			continue
+		}
+		value, _ := readingMetric(r, metric)
+		t := r.Timestamp
+		if interval > 0 {
+			t = t.Truncate(interval)
+		}
+		if count > 0 && !t.Equal(bucket) {
+			flush()
+			sum, count = 0, 0
+		}
+		bucket = t
+		sum += value
+		count++
+	}
+	flush()
+	return datapoints, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestGrafanaSearch tests that every device's metrics are listed, filtered by the search target
+func TestGrafanaSearch(t *testing.T) {
+	server := createTestServer(t)
+	for _, addr := range []string{"AA:BB:CC:DD:EE:01", "AA:BB:CC:DD:EE:02"} {
+		server.addReading(Reading{
+			DeviceName: "GVH5075_TEST",
+			DeviceAddr: addr,
+			TempC:      21.0,
+			Humidity:   50.0,
+			Battery:    90,
+			Timestamp:  time.Now(),
+			ClientID:   "test-client",
+		})
+	}
+
+	search := func(body string) []string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.handleGrafanaSearch(w, httptest.NewRequest("POST", "/grafana/search", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var targets []string
+		if err := json.NewDecoder(w.Body).Decode(&targets); err != nil {
+			t.Fatalf("Failed to decode targets: %v", err)
+		}
+		return targets
+	}
+
+	if targets := search(`{"target":""}`); len(targets) != 2*len(grafanaMetrics) || targets[0] != "AA:BB:CC:DD:EE:01/abs_humidity" {
+		t.Errorf("Expected every metric of both devices, got %v", targets)
+	}
+	if targets := search(`{"target":"ee:02/hum"}`); len(targets) != 1 || targets[0] != "AA:BB:CC:DD:EE:02/humidity" {
+		t.Errorf("Expected only the second device's humidity, got %v", targets)
+	}
+}
+
+// TestGrafanaQuery tests time series over seeded readings, averaged per interval or taken
+// from the hourly aggregates, and rejected targets
+func TestGrafanaQuery(t *testing.T) {
+	server := createTestServer(t)
+	deviceAddr := "AA:BB:CC:DD:EE:FF"
+	base := time.Now().UTC().Truncate(time.Hour).Add(-3 * time.Hour)
+	var readings []Reading
+	for i, offset := range []time.Duration{0, 10 * time.Minute, 20 * time.Minute, 70 * time.Minute} {
+		readings = append(readings, Reading{
+			DeviceName: "GVH5075_TEST",
+			DeviceAddr: deviceAddr,
+			TempC:      20.0 + float64(i)*2,
+			Humidity:   40.0 + float64(i)*10,
+			Timestamp:  base.Add(offset),
+			ClientID:   "test-client",
+		})
+	}
+	readings[3].TempC = 30.0
+	if err := server.storageManager.saveReadings(deviceAddr, readings); err != nil {
+		t.Fatalf("Failed to save readings: %v", err)
+	}
+
+	query := func(target string, interval time.Duration) (int, []grafanaSeries) {
+		t.Helper()
+		body := fmt.Sprintf(`{"range":{"from":%q,"to":%q},"intervalMs":%d,"targets":[{"target":%q,"refId":"A","type":"timeserie"},{"target":"","refId":"B"}]}`,
+			base.Add(-time.Hour).Format(time.RFC3339), base.Add(3*time.Hour).Format(time.RFC3339), interval.Milliseconds(), target)
+		w := httptest.NewRecorder()
+		server.handleGrafanaQuery(w, httptest.NewRequest("POST", "/grafana/query", strings.NewReader(body)))
+		var series []grafanaSeries
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&series); err != nil {
+				t.Fatalf("Failed to decode series: %v", err)
+			}
+		}
+		return w.Code, series
+	}
+	ms := func(t time.Time) float64 { return float64(t.UnixMilli()) }
+
+	// 30 minute buckets of raw readings
+	code, series := query(deviceAddr+"/temp_c", 30*time.Minute)
+	want := [][2]float64{{22.0, ms(base)}, {30.0, ms(base.Add(time.Hour))}}
+	if code != http.StatusOK || len(series) != 1 || series[0].Target != deviceAddr+"/temp_c" || fmt.Sprint(series[0].Datapoints) != fmt.Sprint(want) {
+		t.Errorf("Expected temp_c datapoints %v, got %d %+v", want, code, series)
+	}
+
+	// Hourly aggregates
+	code, series = query(deviceAddr+"/humidity", time.Hour)
+	want = [][2]float64{{50.0, ms(base)}, {70.0, ms(base.Add(time.Hour))}}
+	if code != http.StatusOK || len(series) != 1 || fmt.Sprint(series[0].Datapoints) != fmt.Sprint(want) {
+		t.Errorf("Expected hourly humidity datapoints %v, got %d %+v", want, code, series)
+	}
+
+	for _, target := range []string{deviceAddr, deviceAddr + "/pressure", "not-a-device/temp_c"} {
+		if code, _ := query(target, time.Minute); code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for target %q, got %d", target, code)
+		}
+	}
+}
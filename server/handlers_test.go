@@ -3,13 +3,30 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
+	"log"
+	"math"
+	"math/big"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -50,6 +67,22 @@ func createTestServer(t *testing.T) *Server {
 	return server
 }
 
+// deviceRing returns a device's in-memory readings, or nil if it has none
+func deviceRing(server *Server, deviceAddr string) *readingRing {
+	shard := server.shardFor(deviceAddr)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.readings[deviceAddr]
+}
+
+// deviceStatus returns a device's stored status, or nil if it's unknown
+func deviceStatus(server *Server, deviceAddr string) *DeviceStatus {
+	shard := server.shardFor(deviceAddr)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.devices[deviceAddr]
+}
+
 // createTestServerWithAuth creates a server with authentication enabled
 func createTestServerWithAuth(t *testing.T, adminKey string, clientKeys map[string]string) *Server {
 	t.Helper()
@@ -64,10 +97,15 @@ func createTestServerWithAuth(t *testing.T, adminKey string, clientKeys map[stri
 		SaveInterval:       1 * time.Hour,
 	}
 
+	apiKeys := make(map[string]APIKey, len(clientKeys))
+	for key, clientID := range clientKeys {
+		apiKeys[key] = APIKey{ClientID: clientID, CreatedAt: time.Now(), Enabled: true}
+	}
+
 	auth := &AuthConfig{
 		EnableAuth:      true,
 		AdminKey:        adminKey,
-		APIKeys:         clientKeys,
+		APIKeys:         apiKeys,
 		DefaultAPIKey:   "",
 		AllowDefaultKey: false,
 	}
@@ -243,14 +281,68 @@ func TestHandleReadingsPOSTInvalidJSON(t *testing.T) {
 	}
 }
 
+// TestRequestBodyTooLarge tests that oversized bodies get 413 from the handlers and the auth middleware
+func TestRequestBodyTooLarge(t *testing.T) {
+	adminKey := "test-admin-key"
+	server := createTestServerWithAuth(t, adminKey, map[string]string{"client-key": "test-client"})
+	server.config.MaxBodyBytes = 1024
+
+	// Valid JSON padded past the limit with whitespace
+	oversized := `{"client_id":"test-client"` + strings.Repeat(" ", 2048) + `}`
+
+	tests := []struct {
+		name    string
+		path    string
+		key     string
+		handler http.HandlerFunc
+	}{
+		{"readings handler", "/readings", "", server.handleReadings},
+		{"readings through auth", "/readings", "client-key", server.authMiddleware(http.HandlerFunc(server.handleReadings)).ServeHTTP},
+		{"api keys", "/api/keys", adminKey, server.handleAPIKeys},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(oversized))
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			w := httptest.NewRecorder()
+			tt.handler(w, req)
+			if w.Code != http.StatusRequestEntityTooLarge {
+				t.Errorf("Expected status 413, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// Bodies within the limit are still accepted
+	body, _ := json.Marshal(Reading{
+		DeviceName: "Test Sensor",
+		DeviceAddr: "AA:BB:CC:DD:EE:FF",
+		TempC:      22.5,
+		Humidity:   45.0,
+		Battery:    85,
+		Timestamp:  time.Now(),
+		ClientID:   "test-client",
+	})
+	req := httptest.NewRequest("POST", "/readings", bytes.NewReader(body))
+	req.Header.Set("X-API-Key", "client-key")
+	w := httptest.NewRecorder()
+	server.authMiddleware(http.HandlerFunc(server.handleReadings)).ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected status 201 for a body within the limit, got %d", w.Code)
+	}
+}
+
 // TestHandleReadingsGET tests the GET /readings endpoint
 func TestHandleReadingsGET(t *testing.T) {
 	server := createTestServer(t)
 
 	// Add test data directly to the in-memory store
 	deviceAddr := "aabbccddeeff"
-	server.mu.Lock()
-	server.readings[deviceAddr] = []Reading{
+	shard := server.shardFor(deviceAddr)
+	shard.mu.Lock()
+	ring := newReadingRing(server.config.ReadingsPerDevice)
+	for _, reading := range []Reading{
 		{
 			DeviceName: "Test Sensor",
 			DeviceAddr: "AA:BB:CC:DD:EE:FF",
@@ -271,8 +363,11 @@ func TestHandleReadingsGET(t *testing.T) {
 			Timestamp:  time.Now(),
 			ClientID:   "test-client",
 		},
+	} {
+		ring.Add(reading)
 	}
-	server.mu.Unlock()
+	shard.readings[deviceAddr] = ring
+	shard.mu.Unlock()
 
 	tests := []struct {
 		name           string
@@ -464,6 +559,57 @@ func TestHandleClientsInvalidMethod(t *testing.T) {
 	}
 }
 
+// TestHandleClientsFilters tests ?active= and ?since= against active and inactive clients
+func TestHandleClientsFilters(t *testing.T) {
+	server := createTestServer(t)
+	now := time.Now()
+	server.clients = map[string]*ClientStatus{
+		"live":     {ClientID: "live", LastSeen: now.Add(-time.Minute), IsActive: true},
+		"quiet":    {ClientID: "quiet", LastSeen: now.Add(-3 * time.Hour), IsActive: true},
+		"old":      {ClientID: "old", LastSeen: now.Add(-30 * time.Minute), IsActive: false},
+		"long-ago": {ClientID: "long-ago", LastSeen: now.Add(-30 * 24 * time.Hour), IsActive: false},
+	}
+
+	tests := []struct {
+		query          string
+		expectedStatus int
+		expectedIDs    []string
+	}{
+		{"", http.StatusOK, []string{"live", "long-ago", "old", "quiet"}},
+		{"?active=true", http.StatusOK, []string{"live", "quiet"}},
+		{"?active=false", http.StatusOK, []string{"live", "long-ago", "old", "quiet"}},
+		{"?since=1h", http.StatusOK, []string{"live", "old"}},
+		{"?active=true&since=1h", http.StatusOK, []string{"live"}},
+		{"?active=yes", http.StatusBadRequest, nil},
+		{"?since=-1h", http.StatusBadRequest, nil},
+		{"?since=soon", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/clients"+tt.query, nil)
+		w := httptest.NewRecorder()
+		server.handleClients(w, req)
+		if w.Code != tt.expectedStatus {
+			t.Errorf("%q: expected status %d, got %d", tt.query, tt.expectedStatus, w.Code)
+			continue
+		}
+		if tt.expectedStatus != http.StatusOK {
+			continue
+		}
+		var clients []*ClientStatus
+		if err := json.NewDecoder(w.Body).Decode(&clients); err != nil {
+			t.Fatalf("%q: failed to decode response: %v", tt.query, err)
+		}
+		var ids []string
+		for _, c := range clients {
+			ids = append(ids, c.ClientID)
+		}
+		sort.Strings(ids)
+		if strings.Join(ids, ",") != strings.Join(tt.expectedIDs, ",") {
+			t.Errorf("%q: expected clients %v, got %v", tt.query, tt.expectedIDs, ids)
+		}
+	}
+}
+
 // TestHandleStats tests the GET /stats endpoint
 func TestHandleStats(t *testing.T) {
 	server := createTestServer(t)
@@ -529,6 +675,80 @@ func TestHandleStats(t *testing.T) {
 			}
 		})
 	}
+
+	// Percentiles default to 50 and 95, and can be chosen per request
+	for query, keys := range map[string][]string{
+		"":                {"temp_c_median", "temp_c_p50", "temp_c_p95", "humidity_median", "humidity_p50", "humidity_p95"},
+		"&percentiles=99": {"temp_c_median", "temp_c_p99", "humidity_median", "humidity_p99"},
+	} {
+		w := httptest.NewRecorder()
+		server.handleStats(w, httptest.NewRequest("GET", "/stats?device="+deviceAddr+query, nil))
+		var stats map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&stats)
+		for _, key := range keys {
+			if _, ok := stats[key]; !ok {
+				t.Errorf("Expected %s in stats for %q, got %v", key, query, stats)
+			}
+		}
+	}
+	w := httptest.NewRecorder()
+	server.handleStats(w, httptest.NewRequest("GET", "/stats?device="+deviceAddr+"&percentiles=200", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid percentile, got %d", w.Code)
+	}
+}
+
+// TestHandleStatsMultipleDevices tests stats for a list of devices or all of them in one request
+func TestHandleStatsMultipleDevices(t *testing.T) {
+	server := createTestServer(t)
+	addrs := []string{"AA:BB:CC:DD:EE:01", "AA:BB:CC:DD:EE:02", "AA:BB:CC:DD:EE:03"}
+	for i, addr := range addrs {
+		for j := 0; j <= i; j++ {
+			server.addReading(Reading{DeviceName: "Sensor", DeviceAddr: addr, TempC: 20 + float64(i), Humidity: 50, Timestamp: time.Now().Add(time.Duration(j) * time.Minute), ClientID: "test-client"})
+		}
+	}
+
+	getStats := func(query string, wantStatus int) map[string]map[string]interface{} {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.handleStats(w, httptest.NewRequest("GET", "/stats?"+query, nil))
+		if w.Code != wantStatus {
+			t.Fatalf("%s: expected status %d, got %d: %s", query, wantStatus, w.Code, w.Body.String())
+		}
+		var stats map[string]map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&stats)
+		return stats
+	}
+
+	stats := getStats("device="+addrs[0]+","+addrs[2]+",11:22:33:44:55:66,"+addrs[0], http.StatusOK)
+	if len(stats) != 3 || stats[addrs[0]]["count"] != 1.0 || stats[addrs[2]]["count"] != 3.0 || stats[addrs[2]]["temp_c_avg"] != 22.0 {
+		t.Errorf("Unexpected stats for a list of devices: %v", stats)
+	}
+	if unknown, ok := stats["11:22:33:44:55:66"]; !ok || len(unknown) != 0 {
+		t.Errorf("Expected empty stats for an unknown device, got %v", unknown)
+	}
+
+	stats = getStats("device=all&percentiles=99", http.StatusOK)
+	if len(stats) != len(addrs) || stats[addrs[1]]["count"] != 2.0 || stats[addrs[1]]["temp_c_p99"] != 21.0 {
+		t.Errorf("Unexpected stats for all devices: %v", stats)
+	}
+
+	// A single device keeps the flat response
+	w := httptest.NewRecorder()
+	server.handleStats(w, httptest.NewRequest("GET", "/stats?device="+addrs[1], nil))
+	var single map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&single)
+	if single["count"] != 2.0 {
+		t.Errorf("Expected flat stats for a single device, got %v", single)
+	}
+
+	getStats("device=all&from=2024-01-01T00:00:00Z", http.StatusBadRequest)
+	getStats("device="+strings.Repeat("AA:BB:CC:DD:EE:FF,", maxStatsDevices)+"11:22:33:44:55:66", http.StatusOK)
+	tooMany := make([]string, maxStatsDevices+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("AA:BB:CC:DD:%02X:%02X", i/256, i%256)
+	}
+	getStats("device="+strings.Join(tooMany, ","), http.StatusBadRequest)
 }
 
 // TestHandleStatsInvalidMethod tests invalid methods for /stats
@@ -629,6 +849,248 @@ func TestDashboardDataCaching(t *testing.T) {
 	}
 }
 
+// TestDashboardCacheIncremental tests that new readings update the cached dashboard data in place of a
+// full rebuild, without changing data already served, and that the cache is still rebuilt after its TTL
+func TestDashboardCacheIncremental(t *testing.T) {
+	server := createTestServer(t)
+	server.dashboardCache.ttl = 100 * time.Millisecond
+
+	reading := func(addr, clientID string, tempC float64) Reading {
+		return Reading{
+			DeviceName: "GVH5075_" + addr[len(addr)-2:],
+			DeviceAddr: addr,
+			TempC:      tempC,
+			Humidity:   50.0,
+			Battery:    90,
+			Timestamp:  time.Now(),
+			ClientID:   clientID,
+		}
+	}
+	getDashboard := func() DashboardData {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/dashboard/data", nil)
+		w := httptest.NewRecorder()
+		server.handleDashboardData(w, req)
+		var data DashboardData
+		if err := json.NewDecoder(w.Body).Decode(&data); err != nil {
+			t.Fatalf("Failed to decode dashboard data: %v", err)
+		}
+		return data
+	}
+	deviceTemp := func(data DashboardData, addr string) float64 {
+		for _, d := range data.Devices {
+			if d.DeviceAddr == addr {
+				return d.TempC
+			}
+		}
+		return math.NaN()
+	}
+
+	server.addReading(reading("AA:BB:CC:DD:EE:01", "client-a", 20.0))
+	getDashboard() // populate the cache
+	served := server.dashboardCache.Get(defaultDashboardReadings)
+	if served == nil {
+		t.Fatal("Expected the dashboard data to be cached")
+	}
+
+	// A reading for a known device and one for a new device and client both show up from the cache
+	server.addReading(reading("AA:BB:CC:DD:EE:01", "client-a", 21.0))
+	server.addReading(reading("AA:BB:CC:DD:EE:02", "client-b", 22.0))
+	data := getDashboard()
+	if got := deviceTemp(data, "AA:BB:CC:DD:EE:01"); got != 21.0 {
+		t.Errorf("Expected device 01 at 21.0 from the cache, got %v", got)
+	}
+	if got := deviceTemp(data, "AA:BB:CC:DD:EE:02"); got != 22.0 {
+		t.Errorf("Expected new device 02 at 22.0 from the cache, got %v", got)
+	}
+	if data.TotalReadings != 3 || len(data.Clients) != 2 || data.ActiveClients != 2 {
+		t.Errorf("Expected 3 readings from 2 active clients, got %d readings, %d clients, %d active",
+			data.TotalReadings, len(data.Clients), data.ActiveClients)
+	}
+	if n := len(data.RecentReadings["AA:BB:CC:DD:EE:01"]); n != 2 {
+		t.Errorf("Expected 2 recent readings for device 01, got %d", n)
+	}
+
+	// Data handed out before the updates is left alone
+	if served.Devices[0].TempC != 20.0 || len(served.Devices) != 1 || served.TotalReadings != 1 {
+		t.Errorf("Expected previously served data to be unchanged, got %d devices, temp %v, %d readings",
+			len(served.Devices), served.Devices[0].TempC, served.TotalReadings)
+	}
+
+	// After the TTL the cache is rebuilt and still reflects the latest reading
+	time.Sleep(150 * time.Millisecond)
+	server.addReading(reading("AA:BB:CC:DD:EE:01", "client-a", 23.0))
+	if server.dashboardCache.Get(defaultDashboardReadings) != nil {
+		t.Error("Expected the expired entry not to be refreshed by a reading")
+	}
+	if got := deviceTemp(getDashboard(), "AA:BB:CC:DD:EE:01"); got != 23.0 {
+		t.Errorf("Expected device 01 at 23.0 after the TTL, got %v", got)
+	}
+
+	// Removing stale clients and devices drops the cache
+	server.cleanupStale(time.Now().Add(server.config.ClientTimeout * 11))
+	if server.dashboardCache.Get(defaultDashboardReadings) != nil {
+		t.Error("Expected cleanup to clear the dashboard cache")
+	}
+}
+
+// TestDashboardDataETag tests that an unchanged dashboard answers a matching If-None-Match with
+// 304, and that a new or merged reading changes the ETag
+func TestDashboardDataETag(t *testing.T) {
+	server := createTestServer(t)
+	handler := server.compressionMiddleware(http.HandlerFunc(server.handleDashboardData))
+	server.addReading(Reading{
+		DeviceName: "GVH5075_1234",
+		DeviceAddr: "AA:BB:CC:DD:EE:01",
+		TempC:      20.0,
+		Humidity:   50.0,
+		Battery:    90,
+		Timestamp:  time.Now(),
+		ClientID:   "client-a",
+	})
+
+	get := func(target, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	first := get("/dashboard/data", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("Expected 200 with a weak ETag, got %d with %q", first.Code, etag)
+	}
+	if cc := first.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("Expected Cache-Control: no-cache, got %q", cc)
+	}
+
+	notModified := get("/dashboard/data", etag)
+	if notModified.Code != http.StatusNotModified {
+		t.Fatalf("Expected 304 for a matching ETag, got %d", notModified.Code)
+	}
+	if notModified.Body.Len() != 0 || notModified.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Expected an empty, uncompressed 304, got %d bytes with Content-Encoding %q",
+			notModified.Body.Len(), notModified.Header().Get("Content-Encoding"))
+	}
+	if got := notModified.Header().Get("ETag"); got != etag {
+		t.Errorf("Expected the 304 to repeat ETag %q, got %q", etag, got)
+	}
+
+	// Different query parameters give different payloads, so they don't share an ETag
+	if w := get("/dashboard/data?units=F", etag); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 for other units with the same ETag, got %d", w.Code)
+	}
+
+	server.addReading(Reading{
+		DeviceName: "GVH5075_1234",
+		DeviceAddr: "AA:BB:CC:DD:EE:01",
+		TempC:      21.0,
+		Humidity:   50.0,
+		Battery:    90,
+		RSSI:       -80,
+		Timestamp:  time.Now(),
+		ClientID:   "client-a",
+	})
+	changed := get("/dashboard/data", etag)
+	if changed.Code != http.StatusOK {
+		t.Fatalf("Expected 200 after a new reading, got %d", changed.Code)
+	}
+	newETag := changed.Header().Get("ETag")
+	if newETag == etag {
+		t.Error("Expected a new reading to change the ETag")
+	}
+	if w := get("/dashboard/data", newETag); w.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for the new ETag, got %d", w.Code)
+	}
+
+	// A stronger reading from another client replaces the latest one in a merge
+	server.config.MergeWindow = 5 * time.Second
+	server.addReading(Reading{
+		DeviceName: "GVH5075_1234",
+		DeviceAddr: "AA:BB:CC:DD:EE:01",
+		TempC:      22.0,
+		Humidity:   50.0,
+		Battery:    90,
+		RSSI:       -60,
+		Timestamp:  time.Now(),
+		ClientID:   "client-b",
+	})
+	merged := get("/dashboard/data", newETag)
+	if merged.Code != http.StatusOK || merged.Header().Get("ETag") == newETag {
+		t.Fatalf("Expected a merged reading to change the ETag, got %d with %q", merged.Code, merged.Header().Get("ETag"))
+	}
+	w := httptest.NewRecorder()
+	server.handleDashboardData(w, httptest.NewRequest("GET", "/dashboard/data", nil))
+	var data DashboardData
+	if err := json.NewDecoder(w.Body).Decode(&data); err != nil {
+		t.Fatalf("Failed to decode dashboard data: %v", err)
+	}
+	if len(data.Devices) != 1 || data.Devices[0].TempC != 22.0 || data.TotalReadings != 2 {
+		t.Errorf("Expected the merged reading in the dashboard without counting it, got %d readings and %+v",
+			data.TotalReadings, data.Devices)
+	}
+}
+
+// TestDashboardDataLimit tests the limit parameter for recent readings per device
+func TestDashboardDataLimit(t *testing.T) {
+	server := createTestServer(t)
+
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 80; i++ {
+		server.addReading(Reading{
+			DeviceName: "Test Sensor",
+			DeviceAddr: "AA:BB:CC:DD:EE:FF",
+			TempC:      20 + float64(i)/10,
+			Humidity:   50.0,
+			Battery:    85,
+			Timestamp:  start.Add(time.Duration(i) * time.Minute),
+			ClientID:   "test-client",
+		})
+	}
+
+	recentCount := func(query string) int {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/dashboard/data"+query, nil)
+		w := httptest.NewRecorder()
+		server.handleDashboardData(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %q, got %d", query, w.Code)
+		}
+		var dashData DashboardData
+		json.NewDecoder(w.Body).Decode(&dashData)
+		return len(dashData.RecentReadings["AA:BB:CC:DD:EE:FF"])
+	}
+
+	// Each limit is cached separately, so asking for one doesn't change what the other gets
+	if got := recentCount("?limit=50"); got != 50 {
+		t.Errorf("Expected 50 readings with limit=50, got %d", got)
+	}
+	if got := recentCount(""); got != defaultDashboardReadings {
+		t.Errorf("Expected %d readings by default, got %d", defaultDashboardReadings, got)
+	}
+	if got := recentCount("?limit=50"); got != 50 {
+		t.Errorf("Expected 50 cached readings with limit=50, got %d", got)
+	}
+	// Limits above the cap are clamped; this device only has 80 readings
+	if got := recentCount("?limit=5000"); got != 80 {
+		t.Errorf("Expected all 80 readings with a clamped limit, got %d", got)
+	}
+
+	for _, limit := range []string{"0", "-5", "ten"} {
+		req := httptest.NewRequest("GET", "/dashboard/data?limit="+limit, nil)
+		w := httptest.NewRecorder()
+		server.handleDashboardData(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for limit=%s, got %d", limit, w.Code)
+		}
+	}
+}
+
 // TestAuthMiddleware tests the authentication middleware
 func TestAuthMiddleware(t *testing.T) {
 	adminKey := "test-admin-key-123"
@@ -679,7 +1141,7 @@ func TestAuthMiddleware(t *testing.T) {
 			name:           "Client key on admin endpoint",
 			apiKey:         clientKey,
 			path:           "/api/keys",
-			expectedStatus: http.StatusOK, // Current implementation allows client keys on all endpoints
+			expectedStatus: http.StatusUnauthorized,
 		},
 		{
 			name:           "Health endpoint without auth",
@@ -775,6 +1237,64 @@ func TestCompressionMiddleware(t *testing.T) {
 	})
 }
 
+// TestDecompressionMiddleware tests that a gzipped reading is decompressed before auth and stored
+func TestDecompressionMiddleware(t *testing.T) {
+	server := createTestServerWithAuth(t, "test-admin-key", map[string]string{"client-key": "test-client"})
+	handler := server.decompressionMiddleware(server.authMiddleware(http.HandlerFunc(server.handleReadings)))
+
+	body, _ := json.Marshal(Reading{
+		DeviceName: "Test Sensor",
+		DeviceAddr: "AA:BB:CC:DD:EE:FF",
+		TempC:      22.5,
+		Humidity:   45.0,
+		Battery:    85,
+		Timestamp:  time.Now(),
+		ClientID:   "test-client",
+	})
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(body)
+	gz.Close()
+
+	req := httptest.NewRequest("POST", "/readings", bytes.NewReader(compressed.Bytes()))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("X-API-Key", "client-key")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if ring := deviceRing(server, "AA:BB:CC:DD:EE:FF"); ring == nil || ring.Len() != 1 {
+		t.Errorf("Expected the gzipped reading to be stored")
+	}
+
+	tests := []struct {
+		name           string
+		encoding       string
+		body           []byte
+		expectedStatus int
+	}{
+		{"plain body", "", body, http.StatusCreated},
+		{"not gzip", "gzip", body, http.StatusBadRequest},
+		{"unsupported encoding", "br", body, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/readings", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			req.Header.Set("X-API-Key", "client-key")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
 // TestRateLimitMiddleware tests the rate limiting middleware
 func TestRateLimitMiddleware(t *testing.T) {
 	server := createTestServer(t)
@@ -837,14 +1357,17 @@ func TestHandleAPIKeysGET(t *testing.T) {
 		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
 	}
 
-	var result map[string]string
+	var result map[string]APIKey
 	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
 		t.Fatalf("Failed to decode response: %v", err)
 	}
 
-	// The GET endpoint returns client keys (not admin key)
-	if result[clientKey] != "client-1" {
-		t.Error("Expected client key in response")
+	// The GET endpoint returns hashes of client keys (not admin key), never the keys themselves
+	if result[hashAPIKey(clientKey)].ClientID != "client-1" {
+		t.Error("Expected client key hash in response")
+	}
+	if _, exists := result[clientKey]; exists {
+		t.Error("Expected plaintext client key not to be listed")
 	}
 }
 
@@ -899,63 +1422,677 @@ func TestHandleAPIKeysDELETE(t *testing.T) {
 	}
 
 	// Verify key was deleted
-	if _, exists := server.auth.APIKeys[clientKey]; exists {
+	if _, exists := server.auth.APIKeys[hashAPIKey(clientKey)]; exists {
 		t.Error("Key should have been deleted")
 	}
 }
 
-// TestHandleAPIKeysInvalidMethod tests invalid methods for /api/keys
-func TestHandleAPIKeysInvalidMethod(t *testing.T) {
+// TestAPIKeysHashedAtRest tests that created keys authenticate but only their hashes are persisted
+func TestAPIKeysHashedAtRest(t *testing.T) {
 	adminKey := "test-admin-key-123"
 	server := createTestServerWithAuth(t, adminKey, make(map[string]string))
+	server.config.PersistenceEnabled = true
 
-	req := httptest.NewRequest("PUT", "/api/keys", nil)
+	req := httptest.NewRequest("POST", "/api/keys", strings.NewReader(`{"client_id":"new-client"}`))
 	req.Header.Set("X-API-Key", adminKey)
 	w := httptest.NewRecorder()
-
 	server.handleAPIKeys(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created map[string]string
+	json.NewDecoder(w.Body).Decode(&created)
+	newKey := created["api_key"]
 
-	if w.Code != http.StatusMethodNotAllowed {
-		t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	authData, err := os.ReadFile(filepath.Join(server.config.StorageDir, "auth.json"))
+	if err != nil {
+		t.Fatalf("Failed to read auth.json: %v", err)
+	}
+	if strings.Contains(string(authData), newKey) {
+		t.Error("auth.json should not contain the plaintext key")
+	}
+	if !strings.Contains(string(authData), hashAPIKey(newKey)) {
+		t.Error("auth.json should contain the key's hash")
 	}
-}
 
-// TestAddReadingUpdatesState tests that addReading properly updates server state
-func TestAddReadingUpdatesState(t *testing.T) {
-	server := createTestServer(t)
+	// The plaintext key authenticates; its hash does not
+	handler := server.authMiddleware(http.HandlerFunc(server.handleDevices))
+	for key, expected := range map[string]int{newKey: http.StatusOK, hashAPIKey(newKey): http.StatusUnauthorized} {
+		req = httptest.NewRequest("GET", "/devices", nil)
+		req.Header.Set("X-API-Key", key)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != expected {
+			t.Errorf("Key %q: expected status %d, got %d", key, expected, w.Code)
+		}
+	}
 
-	reading := Reading{
-		DeviceName:    "Test Sensor",
-		DeviceAddr:    "AA:BB:CC:DD:EE:FF",
-		TempC:         25.5,
-		TempF:         77.9,
-		Humidity:      60.0,
-		DewPointC:     12.5,
-		DewPointF:     54.5,
-		AbsHumidity:   13.8,
-		SteamPressure: 19.1,
-		Battery:       85,
-		RSSI:          -67,
-		Timestamp:     time.Now(),
-		ClientID:      "test-client",
+	// Keys can be deleted by the hash GET lists
+	req = httptest.NewRequest("DELETE", "/api/keys?key="+hashAPIKey(newKey), nil)
+	req.Header.Set("X-API-Key", adminKey)
+	w = httptest.NewRecorder()
+	server.handleAPIKeys(w, req)
+	if w.Code != http.StatusOK || len(server.auth.APIKeys) != 0 {
+		t.Errorf("Expected key deleted by hash, got status %d and %d keys", w.Code, len(server.auth.APIKeys))
 	}
+}
 
-	server.addReading(reading)
+// TestLoadDataHashesPlaintextAPIKeys tests the migration of keys saved in plaintext before keys were hashed
+func TestLoadDataHashesPlaintextAPIKeys(t *testing.T) {
+	server := createTestServerWithAuth(t, "test-admin", make(map[string]string))
+	authPath := filepath.Join(server.config.StorageDir, "auth.json")
+	legacy := `{"enable_auth": true, "api_keys": {"legacy-plaintext-key": "old-client", "` + hashAPIKey("already-hashed-key") + `": "new-client"}}`
+	os.WriteFile(authPath, []byte(legacy), 0600)
 
-	// Check device was created
-	devices := server.getDevices()
-	if len(devices) != 1 {
-		t.Fatalf("Expected 1 device, got %d", len(devices))
+	server.loadData()
+
+	if len(server.auth.APIKeys) != 2 || server.auth.APIKeys[hashAPIKey("legacy-plaintext-key")].ClientID != "old-client" ||
+		server.auth.APIKeys[hashAPIKey("already-hashed-key")].ClientID != "new-client" {
+		t.Errorf("Unexpected keys after migration: %v", server.auth.APIKeys)
 	}
-	if devices[0].DeviceName != "Test Sensor" {
-		t.Errorf("Expected device name 'Test Sensor', got '%s'", devices[0].DeviceName)
+	authData, _ := os.ReadFile(authPath)
+	if strings.Contains(string(authData), "legacy-plaintext-key") {
+		t.Error("Expected auth.json to be rewritten without the plaintext key")
+	}
+	if !strings.Contains(string(authData), `"client_id": "old-client"`) {
+		t.Error("Expected auth.json to be rewritten with key details instead of bare client IDs")
+	}
+
+	// The migrated key still authenticates
+	req := httptest.NewRequest("GET", "/devices", nil)
+	req.Header.Set("X-API-Key", "legacy-plaintext-key")
+	w := httptest.NewRecorder()
+	server.authMiddleware(http.HandlerFunc(server.handleDevices)).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected migrated key to authenticate, got %d", w.Code)
+	}
+}
+
+// TestAPIKeyExpiry tests creating keys with a ttl and rejecting them once expired
+func TestAPIKeyExpiry(t *testing.T) {
+	adminKey := "test-admin-key-123"
+	server := createTestServerWithAuth(t, adminKey, make(map[string]string))
+
+	createKey := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/keys", strings.NewReader(body))
+		req.Header.Set("X-API-Key", adminKey)
+		w := httptest.NewRecorder()
+		server.handleAPIKeys(w, req)
+		return w
+	}
+
+	for _, body := range []string{`{"client_id":"c","ttl":"soon"}`, `{"client_id":"c","ttl":"-1h"}`, `{"client_id":"c","ttl":"0s"}`} {
+		if w := createKey(body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, w.Code)
+		}
+	}
+
+	w := createKey(`{"client_id":"contractor","ttl":"24h"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		APIKey    string    `json:"api_key"`
+		CreatedAt time.Time `json:"created_at"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	json.NewDecoder(w.Body).Decode(&created)
+	if !created.ExpiresAt.Equal(created.CreatedAt.Add(24 * time.Hour)) {
+		t.Errorf("Expected expiry 24h after creation, got %v and %v", created.CreatedAt, created.ExpiresAt)
+	}
+
+	// The listing carries the creation and expiry times
+	req := httptest.NewRequest("GET", "/api/keys", nil)
+	req.Header.Set("X-API-Key", adminKey)
+	w = httptest.NewRecorder()
+	server.handleAPIKeys(w, req)
+	var listed map[string]APIKey
+	json.NewDecoder(w.Body).Decode(&listed)
+	details := listed[hashAPIKey(created.APIKey)]
+	if details.ClientID != "contractor" || !details.CreatedAt.Equal(created.CreatedAt) || details.ExpiresAt == nil || !details.ExpiresAt.Equal(created.ExpiresAt) {
+		t.Errorf("Unexpected listed key details: %+v", details)
+	}
+
+	handler := server.authMiddleware(http.HandlerFunc(server.handleDevices))
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/devices", nil)
+		req.Header.Set("X-API-Key", created.APIKey)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	if w := get(); w.Code != http.StatusOK {
+		t.Errorf("Expected unexpired key to authenticate, got %d", w.Code)
+	}
+
+	// Once past its expiry the key is rejected with a clear message
+	past := time.Now().Add(-time.Minute)
+	details.ExpiresAt = &past
+	server.auth.APIKeys[hashAPIKey(created.APIKey)] = details
+	if w := get(); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "API key expired") {
+		t.Errorf("Expected expired key to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestAPIKeyScopes tests that read-scoped keys can GET but not POST
+func TestAPIKeyScopes(t *testing.T) {
+	adminKey := "test-admin-key-123"
+	server := createTestServerWithAuth(t, adminKey, map[string]string{"legacy-key": "legacy"})
+
+	createKey := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/keys", strings.NewReader(body))
+		req.Header.Set("X-API-Key", adminKey)
+		w := httptest.NewRecorder()
+		server.handleAPIKeys(w, req)
+		return w
+	}
+
+	if w := createKey(`{"client_id":"dashboard","scope":"admin"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for unknown scope, got %d", w.Code)
+	}
+	w := createKey(`{"client_id":"dashboard","scope":"read"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&created)
+	readKey, _ := created["api_key"].(string)
+	if created["scope"] != APIKeyScopeRead {
+		t.Errorf("Expected scope read in response, got %v", created["scope"])
+	}
+
+	// The listing shows each key's scope, with keys from before scopes existing as readwrite
+	req := httptest.NewRequest("GET", "/api/keys", nil)
+	req.Header.Set("X-API-Key", adminKey)
+	w = httptest.NewRecorder()
+	server.handleAPIKeys(w, req)
+	var listed map[string]APIKey
+	json.NewDecoder(w.Body).Decode(&listed)
+	if got := listed[hashAPIKey(readKey)].Scope; got != APIKeyScopeRead {
+		t.Errorf("Expected listed scope read, got %q", got)
+	}
+	if got := listed[hashAPIKey("legacy-key")].Scope; got != APIKeyScopeReadWrite {
+		t.Errorf("Expected legacy key listed as readwrite, got %q", got)
+	}
+
+	serve := func(method, path, key, body string, handler http.HandlerFunc) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		server.authMiddleware(handler).ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := serve("GET", "/devices", readKey, "", server.handleDevices); code != http.StatusOK {
+		t.Errorf("Expected read key to GET /devices, got %d", code)
+	}
+	readingBody := func(clientID string) string {
+		body, _ := json.Marshal(Reading{
+			DeviceName: "Test Sensor",
+			DeviceAddr: "AA:BB:CC:DD:EE:FF",
+			TempC:      22.5,
+			Humidity:   45.0,
+			Battery:    85,
+			Timestamp:  time.Now(),
+			ClientID:   clientID,
+		})
+		return string(body)
+	}
+	if code := serve("POST", "/readings", readKey, readingBody("dashboard"), server.handleReadings); code != http.StatusForbidden {
+		t.Errorf("Expected read key POST /readings to get 403, got %d", code)
+	}
+	if code := serve("POST", "/readings", "legacy-key", readingBody("legacy"), server.handleReadings); code != http.StatusCreated {
+		t.Errorf("Expected unscoped key to keep write access, got %d", code)
+	}
+}
+
+// TestAPIKeyDisable tests that a disabled key is kept but rejected, and works again once re-enabled
+func TestAPIKeyDisable(t *testing.T) {
+	adminKey := "test-admin-key-123"
+	server := createTestServerWithAuth(t, adminKey, map[string]string{"kitchen-key": "kitchen"})
+	server.config.PersistenceEnabled = true
+
+	patch := func(key, apiKey, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", "/api/keys?key="+key, strings.NewReader(body))
+		req.Header.Set("X-API-Key", apiKey)
+		w := httptest.NewRecorder()
+		server.handleAPIKeys(w, req)
+		return w
+	}
+	get := func() int {
+		req := httptest.NewRequest("GET", "/devices", nil)
+		req.Header.Set("X-API-Key", "kitchen-key")
+		w := httptest.NewRecorder()
+		server.authMiddleware(http.HandlerFunc(server.handleDevices)).ServeHTTP(w, req)
+		return w.Code
+	}
+	listed := func() APIKey {
+		req := httptest.NewRequest("GET", "/api/keys", nil)
+		req.Header.Set("X-API-Key", adminKey)
+		w := httptest.NewRecorder()
+		server.handleAPIKeys(w, req)
+		var keys map[string]APIKey
+		json.NewDecoder(w.Body).Decode(&keys)
+		return keys[hashAPIKey("kitchen-key")]
+	}
+
+	if code := get(); code != http.StatusOK || !listed().Enabled {
+		t.Fatalf("Expected the key to start enabled, got %d", code)
+	}
+	if w := patch("kitchen-key", "kitchen-key", `{"enabled":false}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a client key to be refused, got %d", w.Code)
+	}
+	for _, body := range []string{`{}`, `not json`} {
+		if w := patch("kitchen-key", adminKey, body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, w.Code)
+		}
+	}
+	if w := patch("missing-key", adminKey, `{"enabled":false}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown key, got %d", w.Code)
+	}
+
+	// Disabled by hash, as listed by GET
+	if w := patch(hashAPIKey("kitchen-key"), adminKey, `{"enabled":false}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if code := get(); code != http.StatusUnauthorized {
+		t.Errorf("Expected the disabled key to be rejected, got %d", code)
+	}
+	if key := listed(); key.Enabled || key.ClientID != "kitchen" {
+		t.Errorf("Expected the key to be listed as disabled, got %+v", key)
+	}
+
+	// The state survives a reload
+	server.loadData()
+	if get() != http.StatusUnauthorized {
+		t.Error("Expected the key to stay disabled after reloading auth.json")
+	}
+
+	if w := patch("kitchen-key", adminKey, `{"enabled":true}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if code := get(); code != http.StatusOK {
+		t.Errorf("Expected the re-enabled key to authenticate, got %d", code)
+	}
+}
+
+// TestRotateAdminKey tests that rotating the admin key retires the old one, keeps client keys
+// working, and is persisted
+func TestRotateAdminKey(t *testing.T) {
+	oldAdminKey := "test-admin-key-123"
+	server := createTestServerWithAuth(t, oldAdminKey, map[string]string{"kitchen-key": "kitchen"})
+	server.config.PersistenceEnabled = true
+
+	serve := func(method, path, key string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		server.authMiddleware(handler).ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("POST", "/api/keys/rotate-admin", "kitchen-key", server.handleRotateAdminKey); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a client key to be refused, got %d", w.Code)
+	}
+	w := serve("POST", "/api/keys/rotate-admin", oldAdminKey, server.handleRotateAdminKey)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var rotated struct {
+		AdminKey  string    `json:"admin_key"`
+		RotatedAt time.Time `json:"rotated_at"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&rotated); err != nil || rotated.AdminKey == "" || rotated.AdminKey == oldAdminKey {
+		t.Fatalf("Expected a new admin key in the response, got %+v (%v)", rotated, err)
+	}
+
+	if w := serve("GET", "/api/keys/usage", oldAdminKey, server.handleAPIKeyUsage); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the old admin key to be rejected, got %d", w.Code)
+	}
+	if w := serve("GET", "/api/keys/usage", rotated.AdminKey, server.handleAPIKeyUsage); w.Code != http.StatusOK {
+		t.Errorf("Expected the new admin key to work, got %d", w.Code)
+	}
+	if w := serve("GET", "/devices", "kitchen-key", server.handleDevices); w.Code != http.StatusOK {
+		t.Errorf("Expected client keys to be unaffected, got %d", w.Code)
+	}
+
+	// A restart with the old -admin-key picks the rotated key up from auth.json
+	server.auth.AdminKey = oldAdminKey
+	server.auth.AdminKeyRotatedAt = nil
+	server.loadData()
+	if server.auth.AdminKey != rotated.AdminKey {
+		t.Error("Expected the rotated admin key to be loaded from auth.json")
+	}
+}
+
+// TestTrustedCIDRs tests that clients on a trusted network can post readings without a key,
+// but still need the admin key for admin endpoints
+func TestTrustedCIDRs(t *testing.T) {
+	adminKey := "test-admin-key-123"
+	server := createTestServerWithAuth(t, adminKey, map[string]string{"kitchen-key": "kitchen"})
+	var err error
+	if server.config.TrustedCIDRs, err = parseCIDRList("192.168.1.0/24, fd00::/8"); err != nil {
+		t.Fatalf("parseCIDRList: %v", err)
+	}
+	if server.config.TrustedProxies, err = parseCIDRList("10.0.0.1/32"); err != nil {
+		t.Fatalf("parseCIDRList: %v", err)
+	}
+	if _, err := parseCIDRList("192.168.1.0/24,lan"); err == nil {
+		t.Error("Expected an invalid CIDR to be rejected")
+	}
+
+	serve := func(method, path, remoteAddr, forwardedFor, key string, handler http.HandlerFunc) int {
+		var body io.Reader
+		if method == "POST" {
+			data, _ := json.Marshal(Reading{
+				DeviceName: "Test Sensor",
+				DeviceAddr: "AA:BB:CC:DD:EE:FF",
+				TempC:      22.5,
+				Humidity:   45.0,
+				Battery:    85,
+				Timestamp:  time.Now(),
+				ClientID:   "lan-client",
+			})
+			body = bytes.NewReader(data)
+		}
+		req := httptest.NewRequest(method, path, body)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		server.authMiddleware(handler).ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		name         string
+		method, path string
+		remoteAddr   string
+		forwardedFor string
+		key          string
+		handler      http.HandlerFunc
+		want         int
+	}{
+		{"trusted IP posts a reading", "POST", "/readings", "192.168.1.20:5000", "", "", server.handleReadings, http.StatusCreated},
+		{"trusted IPv6 reads devices", "GET", "/devices", "[fd00::20]:5000", "", "", server.handleDevices, http.StatusOK},
+		{"trusted IP behind a proxy", "POST", "/readings", "10.0.0.1:5000", "192.168.1.20", "", server.handleReadings, http.StatusCreated},
+		{"untrusted IP", "POST", "/readings", "203.0.113.5:5000", "", "", server.handleReadings, http.StatusUnauthorized},
+		{"forwarded-for from an untrusted proxy", "POST", "/readings", "203.0.113.5:5000", "192.168.1.20", "", server.handleReadings, http.StatusUnauthorized},
+		{"spoofed hop left of the real client", "GET", "/devices", "10.0.0.1:5000", "192.168.1.5, 203.0.113.9", "", server.handleDevices, http.StatusUnauthorized},
+		{"trusted client through two proxies", "GET", "/devices", "10.0.0.1:5000", "192.168.1.20, 10.0.0.1", "", server.handleDevices, http.StatusOK},
+		{"trusted IP with an invalid key", "GET", "/devices", "192.168.1.20:5000", "", "wrong-key", server.handleDevices, http.StatusUnauthorized},
+		{"trusted IP blocked from /api/keys", "GET", "/api/keys", "192.168.1.20:5000", "", "", server.handleAPIKeys, http.StatusUnauthorized},
+		{"trusted IP blocked from /api/keys/usage", "GET", "/api/keys/usage", "192.168.1.20:5000", "", "", server.handleAPIKeyUsage, http.StatusUnauthorized},
+		{"trusted IP blocked from /admin/storage", "GET", "/admin/storage", "192.168.1.20:5000", "", "", server.handleStorageUsage, http.StatusUnauthorized},
+		{"trusted IP with the admin key", "GET", "/api/keys", "192.168.1.20:5000", "", adminKey, server.handleAPIKeys, http.StatusOK},
+		{"client key can't create keys", "POST", "/api/keys", "203.0.113.5:5000", "", "kitchen-key", server.handleAPIKeys, http.StatusUnauthorized},
+		{"client key can't read usage", "GET", "/api/keys/usage", "192.168.1.20:5000", "", "kitchen-key", server.handleAPIKeyUsage, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if got := serve(tt.method, tt.path, tt.remoteAddr, tt.forwardedFor, tt.key, tt.handler); got != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+// TestAuthLockout tests that an IP is refused after repeated bad keys, even with a valid key,
+// and let back in once the cooldown has passed
+func TestAuthLockout(t *testing.T) {
+	server := createTestServerWithAuth(t, "test-admin-key-123", map[string]string{"kitchen-key": "kitchen"})
+	server.authLockout = NewAuthLockout(server.shutdownCtx, 3, time.Minute, 200*time.Millisecond)
+
+	getForwarded := func(remoteAddr, forwardedFor, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/devices", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		server.authMiddleware(http.HandlerFunc(server.handleDevices)).ServeHTTP(w, req)
+		return w
+	}
+	get := func(remoteAddr, key string) *httptest.ResponseRecorder {
+		return getForwarded(remoteAddr, "", key)
+	}
+
+	for i := 0; i < 3; i++ {
+		if w := get("203.0.113.5:4000", fmt.Sprintf("guess-%d", i)); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Attempt %d: expected status 401, got %d", i+1, w.Code)
+		}
+	}
+	w := get("203.0.113.5:4000", "kitchen-key")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" || !strings.Contains(w.Body.String(), `"locked_out"`) {
+		t.Errorf("Expected a locked out IP to get 429 with Retry-After, got %d %q: %s", w.Code, w.Header().Get("Retry-After"), w.Body.String())
+	}
+	if w := get("198.51.100.7:4000", "kitchen-key"); w.Code != http.StatusOK {
+		t.Errorf("Expected other IPs to be unaffected, got %d", w.Code)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if w := get("203.0.113.5:4000", "kitchen-key"); w.Code != http.StatusOK {
+		t.Errorf("Expected the IP to be let back in after the cooldown, got %d", w.Code)
+	}
+
+	// Behind a trusted proxy, the lockout follows the address the proxy saw, not the spoofable
+	// hops to its left, nor how an IPv6 address is spelled
+	var err error
+	if server.config.TrustedProxies, err = parseCIDRList("10.0.0.1/32"); err != nil {
+		t.Fatalf("parseCIDRList: %v", err)
+	}
+	for i, forwarded := range []string{"192.0.2.1, 2001:db8::5", "192.0.2.2, 2001:0db8:0:0::5", "victim, 2001:db8:0::5"} {
+		if w := getForwarded("10.0.0.1:4000", forwarded, fmt.Sprintf("guess-%d", i)); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Forwarded attempt %d: expected status 401, got %d", i+1, w.Code)
+		}
+	}
+	if w := getForwarded("10.0.0.1:4000", "198.51.100.1, 2001:db8::5", "kitchen-key"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected rotating X-Forwarded-For not to dodge the lockout, got %d", w.Code)
+	}
+	if w := getForwarded("10.0.0.1:4000", "2001:db8::6", "kitchen-key"); w.Code != http.StatusOK {
+		t.Errorf("Expected other clients behind the proxy to be unaffected, got %d", w.Code)
+	}
+}
+
+// TestAuthLockoutWindow tests that failures only count within the window, a success clears
+// them, and cleanup forgets expired entries
+func TestAuthLockoutWindow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	al := NewAuthLockout(ctx, 3, time.Minute, 10*time.Minute)
+	now := time.Now()
+	ip := "203.0.113.5"
+
+	al.RecordFailure(ip, now)
+	al.RecordFailure(ip, now.Add(10*time.Second))
+	if al.RecordFailure(ip, now.Add(2*time.Minute)) {
+		t.Error("Expected failures outside the window not to add up to a lockout")
+	}
+	al.RecordFailure(ip, now.Add(2*time.Minute+time.Second))
+	al.RecordSuccess(ip)
+	if al.RecordFailure(ip, now.Add(2*time.Minute+2*time.Second)) {
+		t.Error("Expected a success to clear earlier failures")
+	}
+	al.RecordFailure(ip, now.Add(2*time.Minute+3*time.Second))
+	if !al.RecordFailure(ip, now.Add(2*time.Minute+4*time.Second)) {
+		t.Fatal("Expected the third failure within the window to lock the IP out")
+	}
+	if until, locked := al.LockedUntil(ip, now.Add(5*time.Minute)); !locked || !until.Equal(now.Add(12*time.Minute+4*time.Second)) {
+		t.Errorf("Expected a lockout until %v, got %v %v", now.Add(12*time.Minute+4*time.Second), until, locked)
+	}
+	if _, locked := al.LockedUntil(ip, now.Add(13*time.Minute)); locked {
+		t.Error("Expected the lockout to end after the cooldown")
+	}
+
+	al.cleanup(now.Add(5 * time.Minute))
+	if len(al.failures) != 1 {
+		t.Error("Expected cleanup to keep a locked out IP")
+	}
+	al.cleanup(now.Add(13 * time.Minute))
+	if len(al.failures) != 0 {
+		t.Error("Expected cleanup to forget the IP once its lockout and window have passed")
+	}
+}
+
+// TestAPIKeyUsage tests that a key's last use and request count advance with authenticated requests
+func TestAPIKeyUsage(t *testing.T) {
+	adminKey := "test-admin-key-123"
+	server := createTestServerWithAuth(t, adminKey, map[string]string{"kitchen-key": "kitchen", "stale-key": "stale"})
+	server.config.PersistenceEnabled = true
+
+	get := func(key string) {
+		req := httptest.NewRequest("GET", "/devices", nil)
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		server.authMiddleware(http.HandlerFunc(server.handleDevices)).ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+	}
+	usage := func() []APIKeyUsage {
+		req := httptest.NewRequest("GET", "/api/keys/usage", nil)
+		req.Header.Set("X-API-Key", adminKey)
+		w := httptest.NewRecorder()
+		server.authMiddleware(http.HandlerFunc(server.handleAPIKeyUsage)).ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+		var report []APIKeyUsage
+		json.NewDecoder(w.Body).Decode(&report)
+		return report
+	}
+
+	get("kitchen-key")
+	first := usage()
+	if len(first) != 2 {
+		t.Fatalf("Expected 2 keys in usage report, got %d", len(first))
+	}
+	// The never-used key is listed first
+	if first[0].ClientID != "stale" || first[0].LastUsed != nil || first[0].Requests != 0 {
+		t.Errorf("Expected unused stale key first, got %+v", first[0])
+	}
+	if first[1].ClientID != "kitchen" || first[1].LastUsed == nil || first[1].Requests != 1 {
+		t.Fatalf("Expected one recorded use of the kitchen key, got %+v", first[1])
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	get("kitchen-key")
+	second := usage()
+	if second[1].Requests != 2 || !second[1].LastUsed.After(*first[1].LastUsed) {
+		t.Errorf("Expected last used to advance with a second request, got %+v then %+v", first[1], second[1])
+	}
+
+	// Non-admin keys can't see usage, and are turned away before their use is recorded
+	req := httptest.NewRequest("GET", "/api/keys/usage", nil)
+	req.Header.Set("X-API-Key", "kitchen-key")
+	w := httptest.NewRecorder()
+	server.authMiddleware(http.HandlerFunc(server.handleAPIKeyUsage)).ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for client key, got %d", w.Code)
+	}
+
+	// The key listing carries usage too, and saving persists it
+	req = httptest.NewRequest("GET", "/api/keys", nil)
+	req.Header.Set("X-API-Key", adminKey)
+	w = httptest.NewRecorder()
+	server.handleAPIKeys(w, req)
+	var listed map[string]APIKey
+	json.NewDecoder(w.Body).Decode(&listed)
+	if got := listed[hashAPIKey("kitchen-key")]; got.Requests != 2 || got.LastUsed == nil {
+		t.Errorf("Expected listing to include usage, got %+v", got)
+	}
+
+	server.saveData()
+	restarted := createTestServerWithAuth(t, adminKey, make(map[string]string))
+	restarted.config.StorageDir = server.config.StorageDir
+	restarted.config.PersistenceEnabled = true
+	restarted.loadData()
+	saved := restarted.auth.APIKeys[hashAPIKey("kitchen-key")]
+	if saved.Requests != 2 || saved.LastUsed == nil {
+		t.Fatalf("Expected usage to be persisted, got %+v", saved)
+	}
+	server = restarted
+	get("kitchen-key")
+	if got := usage()[1].Requests; got != 3 {
+		t.Errorf("Expected request count to continue from the saved value, got %d", got)
+	}
+}
+
+// TestHandleAPIKeysRequiresAdmin tests that every /api/keys method refuses client keys, even
+// when called without the middleware
+func TestHandleAPIKeysRequiresAdmin(t *testing.T) {
+	adminKey := "test-admin-key-123"
+	server := createTestServerWithAuth(t, adminKey, map[string]string{"kitchen-key": "kitchen"})
+
+	for _, method := range []string{"GET", "POST", "PATCH", "DELETE"} {
+		req := httptest.NewRequest(method, "/api/keys?key=kitchen-key", strings.NewReader(`{"client_id":"intruder"}`))
+		req.Header.Set("X-API-Key", "kitchen-key")
+		w := httptest.NewRecorder()
+		server.handleAPIKeys(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected status 401, got %d", method, w.Code)
+		}
+	}
+	if len(server.auth.APIKeys) != 1 {
+		t.Errorf("Expected the keys to be untouched, got %d keys", len(server.auth.APIKeys))
+	}
+}
+
+// TestHandleAPIKeysInvalidMethod tests invalid methods for /api/keys
+func TestHandleAPIKeysInvalidMethod(t *testing.T) {
+	adminKey := "test-admin-key-123"
+	server := createTestServerWithAuth(t, adminKey, make(map[string]string))
+
+	req := httptest.NewRequest("PUT", "/api/keys", nil)
+	req.Header.Set("X-API-Key", adminKey)
+	w := httptest.NewRecorder()
+
+	server.handleAPIKeys(w, req)
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
+
+// TestAddReadingUpdatesState tests that addReading properly updates server state
+func TestAddReadingUpdatesState(t *testing.T) {
+	server := createTestServer(t)
+
+	reading := Reading{
+		DeviceName:    "Test Sensor",
+		DeviceAddr:    "AA:BB:CC:DD:EE:FF",
+		TempC:         25.5,
+		TempF:         77.9,
+		Humidity:      60.0,
+		DewPointC:     12.5,
+		DewPointF:     54.5,
+		AbsHumidity:   13.8,
+		SteamPressure: 19.1,
+		Battery:       85,
+		RSSI:          -67,
+		Timestamp:     time.Now(),
+		ClientID:      "test-client",
+	}
+
+	server.addReading(reading)
+
+	// Check device was created
+	devices := server.getDevices()
+	if len(devices) != 1 {
+		t.Fatalf("Expected 1 device, got %d", len(devices))
+	}
+	if devices[0].DeviceName != "Test Sensor" {
+		t.Errorf("Expected device name 'Test Sensor', got '%s'", devices[0].DeviceName)
 	}
 	if devices[0].TempC != 25.5 {
 		t.Errorf("Expected temperature 25.5, got %f", devices[0].TempC)
 	}
 
 	// Check client was created
-	clients := server.getClients()
+	clients := server.getClients(clientFilter{})
 	if len(clients) != 1 {
 		t.Fatalf("Expected 1 client, got %d", len(clients))
 	}
@@ -967,9 +2104,7 @@ func TestAddReadingUpdatesState(t *testing.T) {
 	}
 
 	// Check readings were stored (key is the raw DeviceAddr from the reading)
-	server.mu.RLock()
-	readings := server.readings["AA:BB:CC:DD:EE:FF"]
-	server.mu.RUnlock()
+	readings := deviceRing(server, "AA:BB:CC:DD:EE:FF").Readings()
 
 	if len(readings) != 1 {
 		t.Errorf("Expected 1 reading, got %d", len(readings))
@@ -1001,7 +2136,7 @@ func TestGetDeviceStats(t *testing.T) {
 		})
 	}
 
-	stats := server.getDeviceStats(deviceAddr)
+	stats := server.getDeviceStats(deviceAddr, defaultPercentiles)
 
 	// Verify stats are calculated - note the key is "count" not "reading_count"
 	count, ok := stats["count"].(int)
@@ -1025,13 +2160,23 @@ func TestGetDeviceStats(t *testing.T) {
 	} else {
 		t.Error("Expected temp_c_avg to be present")
 	}
+
+	// Both rise by one a minute
+	for _, key := range []string{"temp_c_trend_per_hour", "humidity_trend_per_hour"} {
+		if trend, ok := stats[key].(float64); !ok || math.Abs(trend-60) > 1e-3 {
+			t.Errorf("Expected %s of 60, got %v", key, stats[key])
+		}
+	}
+	if stddev, ok := stats["temp_c_stddev"].(float64); !ok || math.Abs(stddev-math.Sqrt(8.25)) > 1e-9 {
+		t.Errorf("Expected temp_c_stddev %v, got %v", math.Sqrt(8.25), stats["temp_c_stddev"])
+	}
 }
 
 // TestGetDeviceStatsNoReadings tests stats for device with no readings
 func TestGetDeviceStatsNoReadings(t *testing.T) {
 	server := createTestServer(t)
 
-	stats := server.getDeviceStats("nonexistent")
+	stats := server.getDeviceStats("nonexistent", defaultPercentiles)
 
 	// For nonexistent device, the stats map should be empty or count should be 0/nil
 	if count, exists := stats["count"]; exists && count.(int) != 0 {
@@ -1089,6 +2234,178 @@ func TestSecurityHeadersAllSet(t *testing.T) {
 	}
 }
 
+// TestInstanceHeaders verifies the instance and version headers are set and the instance ID is stable
+func TestInstanceHeaders(t *testing.T) {
+	server := createTestServer(t)
+	server.config.InstanceHeaders = true
+
+	handler := server.instanceHeadersMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}))
+
+	var instanceIDs []string
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/devices", nil))
+
+		if got := w.Header().Get("X-Govee-Version"); got != Version {
+			t.Errorf("Expected X-Govee-Version %q, got %q", Version, got)
+		}
+		instanceIDs = append(instanceIDs, w.Header().Get("X-Govee-Instance"))
+	}
+
+	if instanceIDs[0] == "" {
+		t.Fatal("Missing X-Govee-Instance header")
+	}
+	for _, id := range instanceIDs[1:] {
+		if id != instanceIDs[0] {
+			t.Errorf("Expected stable instance ID %q, got %q", instanceIDs[0], id)
+		}
+	}
+
+	// A second process gets a different ID
+	if other := createTestServer(t); other.instanceID == server.instanceID {
+		t.Errorf("Expected distinct instance IDs, both were %q", server.instanceID)
+	}
+
+	// Disabled: no headers
+	server.config.InstanceHeaders = false
+	w := httptest.NewRecorder()
+	server.instanceHeadersMiddleware(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Header().Get("X-Govee-Instance") != "" || w.Header().Get("X-Govee-Version") != "" {
+		t.Errorf("Expected no instance headers when disabled, got %v", w.Header())
+	}
+}
+
+// TestCORSMiddleware tests preflight and simple requests from allowed and other origins
+func TestCORSMiddleware(t *testing.T) {
+	server := createTestServerWithAuth(t, "test-admin-key", map[string]string{"client-key": "test-client"})
+	server.config.CORSOrigins = []string{"https://dash.example.com"}
+	handler := server.corsMiddleware(server.authMiddleware(http.HandlerFunc(server.handleDevices)))
+
+	// Preflight: answered without an API key, allowing X-API-Key
+	req := httptest.NewRequest("OPTIONS", "/devices", nil)
+	req.Header.Set("Origin", "https://dash.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	req.Header.Set("Access-Control-Request-Headers", "x-api-key")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected preflight status 204, got %d", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://dash.example.com" {
+		t.Errorf("Expected allowed origin to be echoed, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(got, "X-API-Key") {
+		t.Errorf("Expected X-API-Key in allowed headers, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(got, "GET") || !strings.Contains(got, "POST") {
+		t.Errorf("Expected GET and POST in allowed methods, got %q", got)
+	}
+
+	// Simple GET from the allowed origin
+	req = httptest.NewRequest("GET", "/devices", nil)
+	req.Header.Set("Origin", "https://dash.example.com")
+	req.Header.Set("X-API-Key", "client-key")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://dash.example.com" {
+		t.Errorf("Expected allowed origin on GET, got %q", got)
+	}
+	if got := w.Header().Get("Vary"); got != "Origin" {
+		t.Errorf("Expected Vary: Origin, got %q", got)
+	}
+
+	// Other origins get no CORS headers
+	req = httptest.NewRequest("GET", "/devices", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	req.Header.Set("X-API-Key", "client-key")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Expected no CORS headers for other origins, got %q", got)
+	}
+
+	// Wildcard
+	server.config.CORSOrigins = []string{"*"}
+	req = httptest.NewRequest("GET", "/devices", nil)
+	req.Header.Set("Origin", "https://anywhere.example.com")
+	req.Header.Set("X-API-Key", "client-key")
+	w = httptest.NewRecorder()
+	server.corsMiddleware(http.HandlerFunc(server.handleDevices)).ServeHTTP(w, req)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Expected wildcard origin, got %q", got)
+	}
+
+	// Disabled by default: no headers, and preflights fall through to auth as before
+	server.config.CORSOrigins = nil
+	req = httptest.NewRequest("OPTIONS", "/devices", nil)
+	req.Header.Set("Origin", "https://dash.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	w = httptest.NewRecorder()
+	server.corsMiddleware(server.authMiddleware(http.HandlerFunc(server.handleDevices))).ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected unchanged behavior with CORS disabled, got %d with %q", w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+// TestLoggingMiddleware tests that requests are logged with the status the handler wrote
+func TestLoggingMiddleware(t *testing.T) {
+	server := createTestServer(t)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	handler := server.loggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/teapot":
+			w.WriteHeader(http.StatusTeapot)
+			w.WriteHeader(http.StatusInternalServerError) // Ignored by net/http, so not logged
+			w.Write([]byte("short and stout"))
+		case "/devices":
+			w.Write([]byte("[]"))
+		default:
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		}
+	}))
+
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{"POST", "/teapot", "POST /teapot from 192.0.2.1: 418 (15 bytes) in "},
+		{"GET", "/devices", "GET /devices from 192.0.2.1: 200 (2 bytes) in "},
+		{"GET", "/clients", "GET /clients from 192.0.2.1: 401 (13 bytes) in "},
+	}
+	for _, tt := range tests {
+		logs.Reset()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if !strings.Contains(logs.String(), tt.want) {
+			t.Errorf("Expected log line containing %q, got %q", tt.want, logs.String())
+		}
+	}
+
+	// The status reaches the client unchanged
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/teapot", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("Expected status 418, got %d", w.Code)
+	}
+
+	// Health checks aren't logged
+	logs.Reset()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+	if logs.Len() != 0 {
+		t.Errorf("Expected no log for /health, got %q", logs.String())
+	}
+}
+
 // TestMultipleDevices tests handling multiple devices
 func TestMultipleDevices(t *testing.T) {
 	server := createTestServer(t)
@@ -1143,7 +2460,7 @@ func TestMultipleClients(t *testing.T) {
 	}
 
 	// Verify all clients are tracked
-	clientList := server.getClients()
+	clientList := server.getClients(clientFilter{})
 	if len(clientList) != 3 {
 		t.Errorf("Expected 3 clients, got %d", len(clientList))
 	}
@@ -1184,6 +2501,57 @@ func BenchmarkAddReading(b *testing.B) {
 	}
 }
 
+// BenchmarkConcurrentAddReading measures addReading throughput with 8 and 16 concurrent writers,
+// either all writing one device (so they share a shard lock, as every write once shared the
+// server lock) or each writing its own device
+func BenchmarkConcurrentAddReading(b *testing.B) {
+	for _, writers := range []int{8, 16} {
+		for _, perWriterDevice := range []bool{false, true} {
+			name := fmt.Sprintf("writers=%d/one-device", writers)
+			if perWriterDevice {
+				name = fmt.Sprintf("writers=%d/device-per-writer", writers)
+			}
+			b.Run(name, func(b *testing.B) {
+				tmpDir := b.TempDir()
+				config := &Config{
+					Port:               8080,
+					ClientTimeout:      5 * time.Minute,
+					ReadingsPerDevice:  1000,
+					StorageDir:         tmpDir,
+					PersistenceEnabled: false,
+				}
+				server := NewServer(config, &AuthConfig{EnableAuth: false}, NewStorageManager(&StorageConfig{BaseDir: tmpDir}))
+				defer server.shutdownCancel()
+
+				b.ResetTimer()
+				var wg sync.WaitGroup
+				for w := 0; w < writers; w++ {
+					wg.Add(1)
+					go func(w int) {
+						defer wg.Done()
+						reading := Reading{
+							DeviceName: "Benchmark Sensor",
+							DeviceAddr: "AA:BB:CC:DD:EE:FF",
+							TempC:      25.5,
+							Humidity:   60.0,
+							Battery:    85,
+							ClientID:   fmt.Sprintf("benchmark-client-%d", w),
+						}
+						if perWriterDevice {
+							reading.DeviceAddr = fmt.Sprintf("AA:BB:CC:DD:EE:%02X", w)
+						}
+						for i := w; i < b.N; i += writers {
+							reading.Timestamp = time.Now()
+							server.addReading(reading)
+						}
+					}(w)
+				}
+				wg.Wait()
+			})
+		}
+	}
+}
+
 // BenchmarkGetDeviceStats benchmarks statistics calculation
 func BenchmarkGetDeviceStats(b *testing.B) {
 	tmpDir := b.TempDir()
@@ -1200,8 +2568,8 @@ func BenchmarkGetDeviceStats(b *testing.B) {
 	server := NewServer(config, auth, storageManager)
 	defer server.shutdownCancel()
 
-	// Add test data
-	for i := 0; i < 100; i++ {
+	// Add test data, filling the device's readings
+	for i := 0; i < config.ReadingsPerDevice; i++ {
 		server.addReading(Reading{
 			DeviceName:    "Benchmark Sensor",
 			DeviceAddr:    "AA:BB:CC:DD:EE:FF",
@@ -1220,7 +2588,287 @@ func BenchmarkGetDeviceStats(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		server.getDeviceStats("aabbccddeeff")
+		server.getDeviceStats("AA:BB:CC:DD:EE:FF", defaultPercentiles)
+	}
+}
+
+// discardResponseWriter is an http.ResponseWriter that throws the body away, so benchmarks
+// measure the encoder's allocations rather than a recorder's buffer
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d *discardResponseWriter) Header() http.Header         { return d.header }
+func (d *discardResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (d *discardResponseWriter) WriteHeader(int)             {}
+
+// BenchmarkRespondReadings compares marshaling 100k readings at once with streaming them
+func BenchmarkRespondReadings(b *testing.B) {
+	readings := make([]Reading, 100000)
+	for i := range readings {
+		readings[i] = Reading{
+			DeviceName: "Benchmark Sensor",
+			DeviceAddr: "AA:BB:CC:DD:EE:FF",
+			TempC:      20.0 + float64(i%10),
+			Humidity:   50.0 + float64(i%10),
+			Battery:    85,
+			RSSI:       -67,
+			Timestamp:  time.Now(),
+			ClientID:   "benchmark-client",
+		}
+	}
+
+	// B/op hides the cost of respondJSON because encoding/json pools its buffer across
+	// iterations, so also report how much a single call allocates starting from empty pools
+	measure := func(b *testing.B, respond func(http.ResponseWriter, []Reading)) {
+		b.ReportAllocs()
+		var allocated uint64
+		var before, after runtime.MemStats
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			runtime.GC()
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			b.StartTimer()
+
+			respond(&discardResponseWriter{header: http.Header{}}, readings)
+
+			b.StopTimer()
+			runtime.ReadMemStats(&after)
+			allocated += after.TotalAlloc - before.TotalAlloc
+			b.StartTimer()
+		}
+		b.ReportMetric(float64(allocated)/float64(b.N)/(1<<20), "MB-cold/op")
+	}
+
+	b.Run("respondJSON", func(b *testing.B) {
+		measure(b, func(w http.ResponseWriter, r []Reading) { respondJSON(w, r) })
+	})
+	b.Run("streaming", func(b *testing.B) {
+		measure(b, respondJSONReadings)
+	})
+}
+
+// TestReadingRing tests that the ring keeps the newest readings in chronological order
+func TestReadingRing(t *testing.T) {
+	ring := newReadingRing(3)
+	if ring.Len() != 0 || len(ring.Readings()) != 0 {
+		t.Fatal("Expected empty ring")
+	}
+
+	for i := 1; i <= 5; i++ {
+		ring.Add(Reading{TempC: float64(i)})
+	}
+
+	temps := func(readings []Reading) []float64 {
+		var result []float64
+		for _, r := range readings {
+			result = append(result, r.TempC)
+		}
+		return result
+	}
+	if got := temps(ring.Readings()); fmt.Sprint(got) != "[3 4 5]" {
+		t.Errorf("Expected readings [3 4 5], got %v", got)
+	}
+	if got := temps(ring.Recent(2)); fmt.Sprint(got) != "[4 5]" {
+		t.Errorf("Expected recent readings [4 5], got %v", got)
+	}
+	if ring.Len() != 3 || ring.Last().TempC != 5 || ring.At(0).TempC != 3 {
+		t.Errorf("Unexpected ring state: len %d, last %.0f, first %.0f", ring.Len(), ring.Last().TempC, ring.At(0).TempC)
+	}
+
+	// Returned readings are copies
+	ring.Readings()[0].TempC = 99
+	if ring.At(0).TempC != 3 {
+		t.Error("Modifying returned readings changed the ring")
+	}
+
+	// A zero-capacity ring stores nothing
+	empty := newReadingRing(0)
+	empty.Add(Reading{TempC: 1})
+	if empty.Len() != 0 {
+		t.Errorf("Expected zero-capacity ring to stay empty, got %d readings", empty.Len())
+	}
+}
+
+// TestReadingRingStatsMatchRecompute tests that the incrementally maintained stats match
+// computeStats over the same readings through random inserts, evictions and replacements
+func TestReadingRingStatsMatchRecompute(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	start := time.Now()
+
+	for _, capacity := range []int{1, 7, 50} {
+		ring := newReadingRing(capacity)
+		for i := 0; i < 2000; i++ {
+			reading := Reading{
+				TempC:         math.Round(rng.NormFloat64()*50) / 10,
+				Humidity:      rng.Float64() * 100,
+				DewPointC:     float64(rng.Intn(5)),
+				AbsHumidity:   rng.Float64() * 20,
+				SteamPressure: 10 + float64(i%13),
+				Timestamp:     start.Add(time.Duration(i) * time.Second),
+			}
+			if ring.Len() > 0 && rng.Intn(5) == 0 {
+				ring.ReplaceLast(reading)
+			} else {
+				ring.Add(reading)
+			}
+
+			got, want := ring.Stats(), computeStats(ring.Readings(), false)
+			if len(got) != len(want) {
+				t.Fatalf("capacity %d, insert %d: expected %d stats, got %d", capacity, i, len(want), len(got))
+			}
+			for key, expected := range want {
+				switch expected := expected.(type) {
+				case float64:
+					if math.Abs(got[key].(float64)-expected) > 1e-9 {
+						t.Fatalf("capacity %d, insert %d: %s = %v, brute force %v", capacity, i, key, got[key], expected)
+					}
+				default:
+					if got[key] != expected {
+						t.Fatalf("capacity %d, insert %d: %s = %v, brute force %v", capacity, i, key, got[key], expected)
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestAddReadingBoundsPerDevice tests that only the newest ReadingsPerDevice readings are kept, oldest first
+func TestAddReadingBoundsPerDevice(t *testing.T) {
+	server := createTestServer(t)
+	deviceAddr := "AA:BB:CC:DD:EE:FF"
+	start := time.Now().Add(-time.Hour)
+
+	for i := 0; i < server.config.ReadingsPerDevice+25; i++ {
+		server.addReading(Reading{
+			DeviceName: "GVH5075_TEST",
+			DeviceAddr: deviceAddr,
+			TempC:      20.0,
+			Humidity:   50.0,
+			Timestamp:  start.Add(time.Duration(i) * time.Minute),
+			ClientID:   "test-client",
+		})
+	}
+
+	readings, err := server.getDeviceReadings(deviceAddr, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("getDeviceReadings failed: %v", err)
+	}
+	if len(readings) != server.config.ReadingsPerDevice {
+		t.Fatalf("Expected %d readings, got %d", server.config.ReadingsPerDevice, len(readings))
+	}
+	if !readings[0].Timestamp.Equal(start.Add(25 * time.Minute)) {
+		t.Errorf("Expected oldest kept reading at minute 25, got %v", readings[0].Timestamp.Sub(start))
+	}
+	for i := 1; i < len(readings); i++ {
+		if !readings[i].Timestamp.After(readings[i-1].Timestamp) {
+			t.Fatalf("Readings out of order at %d", i)
+		}
+	}
+
+	if count := server.getDeviceStats(deviceAddr, defaultPercentiles)["count"]; count != server.config.ReadingsPerDevice {
+		t.Errorf("Expected stats over %d readings, got %v", server.config.ReadingsPerDevice, count)
+	}
+}
+
+// TestAddReadingSanitizesDeviceName tests that addReading cleans names of new and updated devices
+func TestAddReadingSanitizesDeviceName(t *testing.T) {
+	server := createTestServer(t)
+	deviceAddr := "AA:BB:CC:DD:EE:FF"
+	add := func(addr, name string) {
+		server.addReading(Reading{DeviceName: name, DeviceAddr: addr, TempC: 20.0, Humidity: 50.0, Timestamp: time.Now(), ClientID: "test-client"})
+	}
+
+	steps := []struct {
+		name     string
+		expected string
+	}{
+		{"  Kitchen  ", "Kitchen"},
+		{"Pantry", "Pantry"},                        // Renames are applied
+		{"<script>alert('xss')</script>", "Pantry"}, // HTML keeps the current name
+		{"Pantry\x1b[2J", "Pantry"},                 // So do control characters
+		{strings.Repeat("a", maxDeviceNameLength+1), "Pantry"},
+	}
+	for _, step := range steps {
+		add(deviceAddr, step.name)
+		if got := deviceStatus(server, deviceAddr).DeviceName; got != step.expected {
+			t.Errorf("After %q: expected device name %q, got %q", step.name, step.expected, got)
+		}
+		if got := deviceRing(server, deviceAddr).Last().DeviceName; got != step.expected {
+			t.Errorf("After %q: expected stored reading name %q, got %q", step.name, step.expected, got)
+		}
+	}
+
+	// A new device with an unusable name is named after its address
+	add("11:22:33:44:55:66", "<b>bold</b>")
+	if got := deviceStatus(server, "11:22:33:44:55:66").DeviceName; got != "11:22:33:44:55:66" {
+		t.Errorf("Expected new device to be named after its address, got %q", got)
+	}
+}
+
+// TestMemoryWindowDuration tests that each device's in-memory cap is sized to the memory window at its own cadence
+func TestMemoryWindowDuration(t *testing.T) {
+	server := createTestServer(t)
+	server.config.MemoryWindowDuration = time.Hour
+	server.config.MemoryWindowMin = 10
+	server.config.MemoryWindowMax = 5000
+
+	// Two hours of readings from a device reporting every 10s and one reporting every minute
+	start := time.Now().Add(-2 * time.Hour)
+	cadences := map[string]time.Duration{
+		"AA:BB:CC:DD:EE:01": 10 * time.Second,
+		"AA:BB:CC:DD:EE:02": time.Minute,
+	}
+	for deviceAddr, cadence := range cadences {
+		for ts := start; ts.Before(start.Add(2 * time.Hour)); ts = ts.Add(cadence) {
+			server.addReading(Reading{DeviceName: "GVH5075_TEST", DeviceAddr: deviceAddr, TempC: 20.0, Humidity: 50.0, Timestamp: ts, ClientID: "test-client"})
+		}
+	}
+
+	for deviceAddr, cadence := range cadences {
+		target := int(time.Hour / cadence)
+		ring := deviceRing(server, deviceAddr)
+		if got := ring.Cap(); got < target*9/10 || got > target*11/10 {
+			t.Errorf("%s: expected a cap near %d, got %d", deviceAddr, target, got)
+		}
+		if span := ring.Last().Timestamp.Sub(ring.At(0).Timestamp); span < 54*time.Minute || span > 66*time.Minute {
+			t.Errorf("%s: expected about an hour of history, got %v", deviceAddr, span)
+		}
+		if count := server.getDeviceStats(deviceAddr, defaultPercentiles)["count"]; count != ring.Len() {
+			t.Errorf("%s: expected stats over %d readings, got %v", deviceAddr, ring.Len(), count)
+		}
+	}
+
+	// The bounds win over the window
+	server.config.MemoryWindowMax = 50
+	server.addReading(Reading{DeviceName: "GVH5075_TEST", DeviceAddr: "AA:BB:CC:DD:EE:01", TempC: 20.0, Humidity: 50.0, Timestamp: start.Add(2 * time.Hour), ClientID: "test-client"})
+	if got := deviceRing(server, "AA:BB:CC:DD:EE:01").Cap(); got != 50 {
+		t.Errorf("Expected the cap clamped to 50, got %d", got)
+	}
+}
+
+// TestRespondJSONReadings tests that streamed readings form a valid JSON array
+func TestRespondJSONReadings(t *testing.T) {
+	for _, n := range []int{0, 1, 3} {
+		readings := make([]Reading, n)
+		for i := range readings {
+			readings[i] = Reading{DeviceAddr: "AA:BB:CC:DD:EE:FF", TempC: float64(i), Timestamp: time.Now()}
+		}
+
+		w := httptest.NewRecorder()
+		respondJSONReadings(w, readings)
+
+		var decoded []Reading
+		if err := json.Unmarshal(w.Body.Bytes(), &decoded); err != nil {
+			t.Fatalf("Invalid JSON for %d readings: %v: %s", n, err, w.Body.String())
+		}
+		if decoded == nil || len(decoded) != n {
+			t.Errorf("Expected an array of %d readings, got %v", n, decoded)
+		}
+		if n > 0 && decoded[n-1].TempC != float64(n-1) {
+			t.Errorf("Unexpected last reading: %+v", decoded[n-1])
+		}
 	}
 }
 
@@ -1329,6 +2977,68 @@ func TestHandleHealthCheckPOST(t *testing.T) {
 	}
 }
 
+// TestHandleReadiness tests that /ready fails until data is loaded and while storage can't be written
+func TestHandleReadiness(t *testing.T) {
+	server := createTestServer(t)
+	server.config.PersistenceEnabled = true
+	server.ready.Store(false)
+
+	check := func(want int) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.handleReadiness(w, httptest.NewRequest("GET", "/ready", nil))
+		if w.Code != want {
+			t.Errorf("Expected status %d, got %d: %s", want, w.Code, w.Body.String())
+		}
+	}
+
+	check(http.StatusServiceUnavailable)
+
+	server.loadData()
+	check(http.StatusOK)
+
+	// A storage path that is a file can't have files created in it
+	notDir := filepath.Join(server.config.StorageDir, "not-a-dir")
+	os.WriteFile(notDir, nil, 0644)
+	server.config.StorageDir = notDir
+	check(http.StatusServiceUnavailable)
+
+	w := httptest.NewRecorder()
+	server.handleReadiness(w, httptest.NewRequest("POST", "/ready", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
+
+// TestHandleVersion tests that /version reports the build metadata without needing an API key
+func TestHandleVersion(t *testing.T) {
+	server := createTestServerWithAuth(t, "admin-key", nil)
+
+	oldVersion, oldCommit, oldBuildDate := Version, Commit, BuildDate
+	defer func() { Version, Commit, BuildDate = oldVersion, oldCommit, oldBuildDate }()
+	Version, Commit, BuildDate = "2.1.0", "abc1234", "2024-01-01T00:00:00Z"
+
+	w := httptest.NewRecorder()
+	server.authMiddleware(http.HandlerFunc(server.handleVersion)).ServeHTTP(w, httptest.NewRequest("GET", "/version", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var info VersionInfo
+	if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	want := VersionInfo{Version: "2.1.0", Commit: "abc1234", BuildDate: "2024-01-01T00:00:00Z"}
+	if info != want {
+		t.Errorf("Expected %+v, got %+v", want, info)
+	}
+
+	w = httptest.NewRecorder()
+	server.handleVersion(w, httptest.NewRequest("POST", "/version", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
+
 // TestClientTimeout tests client timeout behavior
 func TestClientTimeout(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -1374,7 +3084,7 @@ func TestClientTimeout(t *testing.T) {
 	})
 
 	// Verify client is active
-	clients := server.getClients()
+	clients := server.getClients(clientFilter{})
 	if len(clients) != 1 {
 		t.Fatalf("Expected 1 client, got %d", len(clients))
 	}
@@ -1386,7 +3096,7 @@ func TestClientTimeout(t *testing.T) {
 	time.Sleep(200 * time.Millisecond)
 
 	// Verify client is now inactive (checkClientTimeouts runs periodically)
-	clients = server.getClients()
+	clients = server.getClients(clientFilter{})
 	if len(clients) > 0 && clients[0].IsActive {
 		t.Log("Client may still be active if timeout checker hasn't run yet")
 	}
@@ -1408,7 +3118,7 @@ func TestDefaultAPIKey(t *testing.T) {
 	auth := &AuthConfig{
 		EnableAuth:      true,
 		AdminKey:        "admin-key",
-		APIKeys:         make(map[string]string),
+		APIKeys:         make(map[string]APIKey),
 		DefaultAPIKey:   "default-key-12345",
 		AllowDefaultKey: true,
 	}
@@ -1615,6 +3325,52 @@ func TestAuthMiddlewareAdminKey(t *testing.T) {
 	}
 }
 
+// TestAuthMiddlewareKeyComparison tests the constant-time admin and default key checks
+func TestAuthMiddlewareKeyComparison(t *testing.T) {
+	adminKey := "test-admin-key"
+	server := createTestServerWithAuth(t, adminKey, make(map[string]string))
+	server.auth.DefaultAPIKey = "test-default-key"
+	server.auth.AllowDefaultKey = true
+	handler := server.authMiddleware(http.HandlerFunc(server.handleDevices))
+
+	tests := []struct {
+		key      string
+		expected int
+	}{
+		{adminKey, http.StatusOK},
+		{"test-default-key", http.StatusOK},
+		{"test-admin-ke", http.StatusUnauthorized},   // Prefix
+		{"test-admin-keyy", http.StatusUnauthorized}, // Extension
+		{"TEST-ADMIN-KEY", http.StatusUnauthorized},
+		{"wrong", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/devices", nil)
+		req.Header.Set("X-API-Key", tt.key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.expected {
+			t.Errorf("Key %q: expected status %d, got %d", tt.key, tt.expected, w.Code)
+		}
+	}
+
+	// The default key stops working once it's no longer allowed
+	server.auth.AllowDefaultKey = false
+	req := httptest.NewRequest("GET", "/devices", nil)
+	req.Header.Set("X-API-Key", "test-default-key")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected disallowed default key to be rejected, got %d", w.Code)
+	}
+
+	// An unset admin key never matches, even an empty presented key
+	server.auth.AdminKey = ""
+	if server.isAdminRequest(httptest.NewRequest("POST", "/alerts", nil)) {
+		t.Error("Expected a request without a key not to be an admin request when no admin key is set")
+	}
+}
+
 // TestAuthMiddlewareClientKey tests client key authentication
 func TestAuthMiddlewareClientKey(t *testing.T) {
 	adminKey := "test-admin-key"
@@ -1637,25 +3393,201 @@ func TestAuthMiddlewareClientKey(t *testing.T) {
 	}
 }
 
-// TestAuthMiddlewareAPIKeysEndpoint tests auth for /api/keys endpoint
-func TestAuthMiddlewareAPIKeysEndpoint(t *testing.T) {
-	adminKey := "test-admin-key"
-	clientKey := "client-key"
-	server := createTestServerWithAuth(t, adminKey, map[string]string{
-		clientKey: "test-client",
+// TestAuthMiddlewareBearer tests that keys are accepted from an Authorization: Bearer header ahead of X-API-Key
+func TestAuthMiddlewareBearer(t *testing.T) {
+	server := createTestServerWithAuth(t, "test-admin-key", map[string]string{
+		"client-specific-key": "specific-client",
 	})
+	handler := server.authMiddleware(http.HandlerFunc(server.handleDevices))
 
-	// Admin key should work
-	req := httptest.NewRequest("GET", "/api/keys", nil)
-	req.Header.Set("X-API-Key", adminKey)
-	req.RemoteAddr = "192.0.2.1:1234"
+	tests := []struct {
+		name          string
+		authorization string
+		apiKey        string
+		expected      int
+	}{
+		{"Bearer admin key", "Bearer test-admin-key", "", http.StatusOK},
+		{"Bearer client key", "Bearer client-specific-key", "", http.StatusOK},
+		{"Lowercase scheme", "bearer client-specific-key", "", http.StatusOK},
+		{"Bearer wins over X-API-Key", "Bearer test-admin-key", "wrong-key", http.StatusOK},
+		{"Invalid bearer key", "Bearer wrong-key", "", http.StatusUnauthorized},
+		{"Missing token", "Bearer", "", http.StatusUnauthorized},
+		{"Empty token", "Bearer   ", "", http.StatusUnauthorized},
+		{"Token with spaces", "Bearer client-specific-key extra", "", http.StatusUnauthorized},
+		{"Other scheme", "Basic dXNlcjpwYXNz", "", http.StatusUnauthorized},
+		{"Malformed header falls back to X-API-Key", "Basic dXNlcjpwYXNz", "client-specific-key", http.StatusOK},
+	}
 
-	handler := server.authMiddleware(http.HandlerFunc(server.handleAPIKeys))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/devices", nil)
+			req.Header.Set("Authorization", tt.authorization)
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			req.RemoteAddr = "192.0.2.1:1234"
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+
+	// A Bearer client key posting readings is still held to its client ID
+	body, _ := json.Marshal(Reading{DeviceName: "Test", DeviceAddr: "AA:BB:CC:DD:EE:FF", TempC: 20.0, Humidity: 50.0, Timestamp: time.Now(), ClientID: "other-client"})
+	req := httptest.NewRequest("POST", "/readings", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer client-specific-key")
 	w := httptest.NewRecorder()
-	handler.ServeHTTP(w, req)
+	server.authMiddleware(http.HandlerFunc(server.handleReadings)).ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a client ID mismatch, got %d", w.Code)
+	}
 
-	if w.Code != http.StatusOK {
-		t.Errorf("Expected status %d with admin key on /api/keys, got %d", http.StatusOK, w.Code)
+	// Admin-only actions accept the admin key as a Bearer token
+	req = httptest.NewRequest("POST", "/alerts", nil)
+	req.Header.Set("Authorization", "Bearer test-admin-key")
+	if !server.isAdminRequest(req) {
+		t.Error("Expected a Bearer admin key to be recognised as an admin request")
+	}
+}
+
+// newTestCertificate creates a certificate for cn signed by parent (self-signed if parent is nil)
+func newTestCertificate(t *testing.T, cn string, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	issuer, signer := template, any(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// TestAuthMiddlewareClientCert tests authenticating clients by a verified TLS certificate's CN
+func TestAuthMiddlewareClientCert(t *testing.T) {
+	server := createTestServerWithAuth(t, "test-admin-key", map[string]string{"client-key": "key-client"})
+
+	ca := newTestCertificate(t, "Test CA", nil)
+	caPath := filepath.Join(t.TempDir(), "client-ca.pem")
+	os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}), 0600)
+	clientCAs, err := loadCertPool(caPath)
+	if err != nil {
+		t.Fatalf("Failed to load client CA: %v", err)
+	}
+	if _, err := loadCertPool(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("Expected an error loading a missing CA file")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/readings", server.authMiddleware(http.HandlerFunc(server.handleReadings)))
+	mux.Handle("/devices", server.authMiddleware(http.HandlerFunc(server.handleDevices)))
+	ts := httptest.NewUnstartedServer(mux)
+	ts.TLS = &tls.Config{ClientCAs: clientCAs, ClientAuth: tls.RequireAndVerifyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	// Each client gets its own transport so connections (and their certificates) aren't shared
+	clientFor := func(cert tls.Certificate) *http.Client {
+		transport := ts.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+		return &http.Client{Transport: transport}
+	}
+	post := func(client *http.Client, clientID string, apiKey string) int {
+		body, _ := json.Marshal(Reading{
+			DeviceName: "Test Sensor",
+			DeviceAddr: "AA:BB:CC:DD:EE:FF",
+			TempC:      22.5,
+			Humidity:   45.0,
+			Battery:    85,
+			Timestamp:  time.Now(),
+			ClientID:   clientID,
+		})
+		req, _ := http.NewRequest("POST", ts.URL+"/readings", bytes.NewReader(body))
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	kitchen := clientFor(newTestCertificate(t, "client-kitchen", &ca))
+
+	// The certificate's CN is the client ID, no API key needed
+	if code := post(kitchen, "client-kitchen", ""); code != http.StatusCreated {
+		t.Errorf("Expected certificate to authenticate the reading, got %d", code)
+	}
+	resp, err := kitchen.Get(ts.URL + "/devices")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected certificate to authenticate GET /devices, got %d", resp.StatusCode)
+	}
+
+	// Readings must still match the authenticated client, even when a valid API key for the
+	// other client ID is sent as well: the certificate takes precedence
+	if code := post(kitchen, "key-client", "client-key"); code != http.StatusUnauthorized {
+		t.Errorf("Expected client ID mismatch with the certificate's CN to get 401, got %d", code)
+	}
+
+	// CNs that aren't valid client IDs are rejected
+	if code := post(clientFor(newTestCertificate(t, "<kitchen>", &ca)), "<kitchen>", ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected invalid CN to get 401, got %d", code)
+	}
+
+	// Certificates from another CA fail the handshake
+	other := newTestCertificate(t, "Other CA", nil)
+	if _, err := clientFor(newTestCertificate(t, "client-kitchen", &other)).Get(ts.URL + "/devices"); err == nil {
+		t.Error("Expected certificate from an unknown CA to be refused")
+	}
+}
+
+// TestAuthMiddlewareAPIKeysEndpoint tests auth for /api/keys endpoint
+func TestAuthMiddlewareAPIKeysEndpoint(t *testing.T) {
+	adminKey := "test-admin-key"
+	clientKey := "client-key"
+	server := createTestServerWithAuth(t, adminKey, map[string]string{
+		clientKey: "test-client",
+	})
+
+	// Admin key should work
+	req := httptest.NewRequest("GET", "/api/keys", nil)
+	req.Header.Set("X-API-Key", adminKey)
+	req.RemoteAddr = "192.0.2.1:1234"
+
+	handler := server.authMiddleware(http.HandlerFunc(server.handleAPIKeys))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d with admin key on /api/keys, got %d", http.StatusOK, w.Code)
 	}
 }
 
@@ -1882,8 +3814,8 @@ func TestLoadDataFromFiles(t *testing.T) {
 	auth := AuthConfig{
 		EnableAuth: true,
 		AdminKey:   "admin-key",
-		APIKeys: map[string]string{
-			"key1": "client1",
+		APIKeys: map[string]APIKey{
+			"key1": {ClientID: "client1", Enabled: true},
 		},
 	}
 	authData, _ := json.Marshal(auth)
@@ -1920,8 +3852,8 @@ func TestLoadDataFromFiles(t *testing.T) {
 	server.loadData()
 
 	// Verify devices were loaded
-	if len(server.devices) != 1 {
-		t.Errorf("Expected 1 device loaded, got %d", len(server.devices))
+	if devices := server.getDevices(); len(devices) != 1 {
+		t.Errorf("Expected 1 device loaded, got %d", len(devices))
 	}
 
 	// Verify clients were loaded and marked as inactive
@@ -2003,7 +3935,7 @@ func TestEnforceRetentionWithOldPartitions(t *testing.T) {
 	os.WriteFile(fmt.Sprintf("%s/test.json", currentPartitionDir), []byte("{}"), 0644)
 
 	// Enforce retention
-	err := sm.enforceRetention()
+	_, err := sm.enforceRetention(context.Background())
 	if err != nil {
 		t.Errorf("enforceRetention failed: %v", err)
 	}
@@ -2016,12 +3948,6 @@ func TestEnforceRetentionWithOldPartitions(t *testing.T) {
 func TestLoadReadingsFromGzipFile(t *testing.T) {
 	tmpDir := t.TempDir()
 
-	storageConfig := &StorageConfig{
-		BaseDir:          tmpDir,
-		TimePartitioning: false,
-	}
-	sm := NewStorageManager(storageConfig)
-
 	// Create a compressed readings file
 	readings := []Reading{
 		{
@@ -2051,7 +3977,7 @@ func TestLoadReadingsFromGzipFile(t *testing.T) {
 
 	// Try to load from the regular path (should find .gz version)
 	regularPath := fmt.Sprintf("%s/readings_aabbccddeeff.json", tmpDir)
-	loadedReadings, err := sm.loadReadingsFromFile(regularPath)
+	loadedReadings, err := loadReadingsFromFile(regularPath)
 	if err != nil {
 		t.Errorf("Failed to load from gzip file: %v", err)
 	}
@@ -2065,19 +3991,13 @@ func TestLoadReadingsFromGzipFile(t *testing.T) {
 func TestLoadReadingsFromCorruptedGzipFile(t *testing.T) {
 	tmpDir := t.TempDir()
 
-	storageConfig := &StorageConfig{
-		BaseDir:          tmpDir,
-		TimePartitioning: false,
-	}
-	sm := NewStorageManager(storageConfig)
-
 	// Create corrupted gzip file
 	gzipPath := fmt.Sprintf("%s/readings_aabbccddeeff.json.gz", tmpDir)
 	os.WriteFile(gzipPath, []byte("not a valid gzip file"), 0644)
 
 	// Try to load - should fail
 	regularPath := fmt.Sprintf("%s/readings_aabbccddeeff.json", tmpDir)
-	_, err := sm.loadReadingsFromFile(regularPath)
+	_, err := loadReadingsFromFile(regularPath)
 	if err == nil {
 		t.Error("Expected error loading corrupted gzip file")
 	}
@@ -2356,7 +4276,7 @@ func TestHandleAPIKeysDelete(t *testing.T) {
 	}
 
 	// Verify key was deleted
-	if _, exists := server.auth.APIKeys["delete-me-key"]; exists {
+	if _, exists := server.auth.APIKeys[hashAPIKey("delete-me-key")]; exists {
 		t.Error("Key should have been deleted")
 	}
 
@@ -2477,6 +4397,62 @@ func TestServerWithLogger(t *testing.T) {
 	}
 }
 
+// TestServerLogRotation tests that the reading log rotates into backups once it passes its size limit
+func TestServerLogRotation(t *testing.T) {
+	tmpDir := t.TempDir()
+	logFile := filepath.Join(tmpDir, "readings.log")
+
+	server := createTestServer(t)
+	server.config.LogFile = logFile
+	server.config.LogMaxSize = 1024
+	server.config.LogMaxBackups = 2
+	logger, err := openRotatingFile(logFile, server.config.LogMaxSize, server.config.LogMaxBackups)
+	if err != nil {
+		t.Fatalf("Failed to open log file: %v", err)
+	}
+	server.logger = logger
+
+	// Concurrent writers must not interleave lines, even across a rotation
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				server.addReading(Reading{
+					DeviceName: "Test Device",
+					DeviceAddr: fmt.Sprintf("AA:BB:CC:DD:EE:%02d", i),
+					TempC:      20.0 + float64(j),
+					Humidity:   50.0,
+					Battery:    85,
+					Timestamp:  time.Now(),
+					ClientID:   "test-client",
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, name := range []string{logFile, logFile + ".1", logFile + ".2"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("Expected %s to exist: %v", name, err)
+		}
+		if len(data) > 1024 {
+			t.Errorf("Expected %s to stay within the size limit, got %d bytes", name, len(data))
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var reading Reading
+			if err := json.Unmarshal([]byte(line), &reading); err != nil {
+				t.Errorf("Expected whole JSON lines in %s, got %q", name, line)
+			}
+		}
+	}
+	if _, err := os.Stat(logFile + ".3"); !os.IsNotExist(err) {
+		t.Error("Expected no more than 2 backups")
+	}
+}
+
 // TestCompressPartitionNonExistent tests compressing non-existent partition
 func TestCompressPartitionNonExistent(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -2490,7 +4466,7 @@ func TestCompressPartitionNonExistent(t *testing.T) {
 	sm := NewStorageManager(storageConfig)
 
 	// Try to compress non-existent partition
-	err := sm.compressPartition("/nonexistent/path")
+	_, _, err := sm.compressPartition("/nonexistent/path")
 	if err == nil {
 		t.Error("Expected error compressing non-existent partition")
 	}
@@ -2551,6 +4527,169 @@ func TestSaveDataToStorage(t *testing.T) {
 	}
 }
 
+// TestDevicePartitions tests that /admin/device-partitions reports every partition holding a device's readings
+func TestDevicePartitions(t *testing.T) {
+	server := createTestServerWithAuth(t, "admin-key", map[string]string{"client-key": "client"})
+	tmpDir := t.TempDir()
+	server.storageManager = NewStorageManager(&StorageConfig{
+		BaseDir:           tmpDir,
+		TimePartitioning:  true,
+		PartitionInterval: 720 * time.Hour,
+	})
+
+	// Two months of readings for the device, and an unrelated device in a third partition
+	march := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	april := time.Date(2024, 4, 2, 8, 0, 0, 0, time.UTC)
+	files := map[string][]Reading{
+		"2024-03/readings_aabbccddeeff.json": {{DeviceAddr: "AA:BB:CC:DD:EE:FF", Timestamp: march}, {DeviceAddr: "AA:BB:CC:DD:EE:FF", Timestamp: march.Add(time.Hour)}},
+		"2024-04/readings_aabbccddeeff.json": {{DeviceAddr: "AA:BB:CC:DD:EE:FF", Timestamp: april}},
+		"2024-05/readings_112233445566.json": {{DeviceAddr: "11:22:33:44:55:66", Timestamp: april.AddDate(0, 1, 0)}},
+	}
+	for name, readings := range files {
+		path := filepath.Join(tmpDir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		data, _ := json.Marshal(readings)
+		os.WriteFile(path, data, 0644)
+	}
+
+	// Client keys can't see the storage layout
+	req := httptest.NewRequest("GET", "/admin/device-partitions?device=AA:BB:CC:DD:EE:FF", nil)
+	req.Header.Set("X-API-Key", "client-key")
+	w := httptest.NewRecorder()
+	server.handleDevicePartitions(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a client key, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/admin/device-partitions?device=AA:BB:CC:DD:EE:FF", nil)
+	req.Header.Set("X-API-Key", "admin-key")
+	w = httptest.NewRecorder()
+	server.handleDevicePartitions(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var response struct {
+		DeviceAddr string            `json:"device_addr"`
+		Partitions []DevicePartition `json:"partitions"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Partitions) != 2 {
+		t.Fatalf("Expected 2 partitions, got %+v", response.Partitions)
+	}
+	first, second := response.Partitions[0], response.Partitions[1]
+	if first.Partition != "2024-03" || first.Readings != 2 || !first.First.Equal(march) || !first.Last.Equal(march.Add(time.Hour)) {
+		t.Errorf("Unexpected first partition: %+v", first)
+	}
+	if second.Partition != "2024-04" || second.Readings != 1 || !second.First.Equal(april) || !second.Last.Equal(april) {
+		t.Errorf("Unexpected second partition: %+v", second)
+	}
+
+	// Missing and malformed device parameters are rejected
+	for _, query := range []string{"", "?device=../etc"} {
+		req = httptest.NewRequest("GET", "/admin/device-partitions"+query, nil)
+		req.Header.Set("X-API-Key", "admin-key")
+		w = httptest.NewRecorder()
+		server.handleDevicePartitions(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %q, got %d", query, w.Code)
+		}
+	}
+}
+
+// TestHandleMaintenance tests running retention, compaction and saves through /admin/maintenance
+func TestHandleMaintenance(t *testing.T) {
+	server := createTestServerWithAuth(t, "admin-key", map[string]string{"client-key": "client"})
+	server.config.PersistenceEnabled = true
+	tmpDir := t.TempDir()
+	server.config.StorageDir = tmpDir
+	server.storageManager = NewStorageManager(&StorageConfig{
+		BaseDir:           tmpDir,
+		TimePartitioning:  true,
+		PartitionInterval: 720 * time.Hour,
+		RetentionPeriod:   365 * 24 * time.Hour,
+	})
+
+	// An expired partition, one within retention and the current one
+	readings := make([]Reading, 100)
+	for i := range readings {
+		readings[i] = Reading{DeviceAddr: "AA:BB:CC:DD:EE:FF", TempC: 21.5, Humidity: 45, Timestamp: time.Now()}
+	}
+	data, _ := json.Marshal(readings)
+	expired := filepath.Join(tmpDir, "2020-01")
+	older := filepath.Join(tmpDir, time.Now().AddDate(0, -2, 0).Format("2006-01"))
+	current := filepath.Join(tmpDir, time.Now().Format("2006-01"))
+	for _, dir := range []string{expired, older, current} {
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(filepath.Join(dir, "readings_aabbccddeeff.json"), data, 0644)
+	}
+
+	run := func(key, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/admin/maintenance", strings.NewReader(body))
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		server.handleMaintenance(w, req)
+		return w
+	}
+	summary := func(w *httptest.ResponseRecorder) MaintenanceSummary {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var s MaintenanceSummary
+		if err := json.NewDecoder(w.Body).Decode(&s); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return s
+	}
+
+	if w := run("client-key", `{"action":"retention"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a client key, got %d", w.Code)
+	}
+
+	// Compression is off, so retention only removes the expired partition
+	got := summary(run("admin-key", `{"action":"retention"}`))
+	want := MaintenanceSummary{Action: "retention", PartitionsRemoved: 1, BytesReclaimed: int64(len(data))}
+	if got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Error("Expected the expired partition to be removed")
+	}
+
+	// Compaction compresses the older partition but leaves the current one alone
+	got = summary(run("admin-key", `{"action":"compact"}`))
+	if got.Action != "compact" || got.FilesCompressed != 1 || got.PartitionsRemoved != 0 || got.BytesReclaimed <= 0 {
+		t.Errorf("Unexpected compact summary: %+v", got)
+	}
+	if _, err := os.Stat(filepath.Join(older, "readings_aabbccddeeff.json.gz")); err != nil {
+		t.Errorf("Expected the older partition to be compressed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(current, "readings_aabbccddeeff.json")); err != nil {
+		t.Errorf("Expected the current partition to stay uncompressed: %v", err)
+	}
+
+	if got := summary(run("admin-key", `{"action":"save"}`)); got != (MaintenanceSummary{Action: "save"}) {
+		t.Errorf("Unexpected save summary: %+v", got)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "devices.json")); err != nil {
+		t.Errorf("Expected save to write devices.json: %v", err)
+	}
+
+	if w := run("admin-key", `{"action":"defrag"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown action, got %d", w.Code)
+	}
+
+	// Work stops once the server starts shutting down
+	server.shutdownCancel()
+	if w := run("admin-key", `{"action":"compact"}`); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 during shutdown, got %d", w.Code)
+	}
+}
+
 // TestRateLimitMiddlewareBasic tests basic rate limiting
 func TestRateLimitMiddlewareBasic(t *testing.T) {
 	server := createTestServer(t)
@@ -2787,7 +4926,7 @@ func TestEnforceRetentionNoRetention(t *testing.T) {
 	os.MkdirAll(fmt.Sprintf("%s/2022-01", tmpDir), 0755)
 	os.MkdirAll(fmt.Sprintf("%s/2024-01", tmpDir), 0755)
 
-	err := sm.enforceRetention()
+	_, err := sm.enforceRetention(context.Background())
 	if err != nil {
 		t.Errorf("enforceRetention failed: %v", err)
 	}
@@ -2890,8 +5029,1421 @@ func TestValidateReadingOldTimestamp(t *testing.T) {
 		ClientID:   "test",
 	}
 
-	err := validateReading(&reading)
+	err := validateReading(&reading, defaultMaxReadingAge)
 	if err == nil {
 		t.Error("Expected error for old timestamp")
 	}
+
+	// A wider window accepts it, as does disabling the lower bound
+	if err := validateReading(&reading, 72*time.Hour); err != nil {
+		t.Errorf("Expected 48h-old reading to pass with a 72h window, got %v", err)
+	}
+	if err := validateReading(&reading, 0); err != nil {
+		t.Errorf("Expected 48h-old reading to pass with the window disabled, got %v", err)
+	}
+}
+
+// TestHandleReadingsMaxReadingAge tests that POST /readings applies the configured window
+func TestHandleReadingsMaxReadingAge(t *testing.T) {
+	server := createTestServer(t)
+	reading := Reading{
+		DeviceName: "Test",
+		DeviceAddr: "AA:BB:CC:DD:EE:FF",
+		TempC:      25.0,
+		Humidity:   50.0,
+		Battery:    85,
+		Timestamp:  time.Now().Add(-48 * time.Hour),
+		ClientID:   "test",
+	}
+	body, _ := json.Marshal(reading)
+
+	for _, tt := range []struct {
+		maxAge time.Duration
+		want   int
+	}{
+		{defaultMaxReadingAge, http.StatusBadRequest},
+		{72 * time.Hour, http.StatusCreated},
+	} {
+		server.config.MaxReadingAge = tt.maxAge
+		w := httptest.NewRecorder()
+		server.handleReadings(w, httptest.NewRequest("POST", "/readings", bytes.NewReader(body)))
+		if w.Code != tt.want {
+			t.Errorf("With max age %v expected status %d, got %d: %s", tt.maxAge, tt.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+// TestHandleReadingsHeatIndex tests that the heat index, frost point and mixing ratio are kept when sent
+// and omitted when an older client leaves them out
+func TestHandleReadingsHeatIndex(t *testing.T) {
+	server := createTestServer(t)
+
+	post := func(body string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.handleReadings(w, httptest.NewRequest("POST", "/readings", strings.NewReader(body)))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+	}
+	now := time.Now().Format(time.RFC3339)
+
+	post(`{"device_name": "New", "device_addr": "AA:BB:CC:DD:EE:01", "temp_c": 32, "humidity": 70,
+		"heat_index_c": 40.4, "heat_index_f": 104.72, "frost_point_c": 25.8, "mixing_ratio": 21.34,
+		"client_id": "c1", "timestamp": "` + now + `"}`)
+	post(`{"device_name": "Old", "device_addr": "AA:BB:CC:DD:EE:02", "temp_c": 32, "humidity": 70,
+		"client_id": "c1", "timestamp": "` + now + `"}`)
+
+	w := httptest.NewRecorder()
+	server.handleDevices(w, httptest.NewRequest("GET", "/devices", nil))
+	var devices []map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&devices); err != nil {
+		t.Fatalf("Failed to decode devices: %v", err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("Expected 2 devices, got %d", len(devices))
+	}
+	for _, d := range devices {
+		switch d["device_name"] {
+		case "New":
+			if d["heat_index_c"] != 40.4 || d["heat_index_f"] != 104.72 {
+				t.Errorf("Expected heat index 40.4/104.72, got %v/%v", d["heat_index_c"], d["heat_index_f"])
+			}
+			if d["frost_point_c"] != 25.8 || d["mixing_ratio"] != 21.34 {
+				t.Errorf("Expected frost point 25.8 and mixing ratio 21.34, got %v/%v", d["frost_point_c"], d["mixing_ratio"])
+			}
+		case "Old":
+			if _, ok := d["heat_index_c"]; ok {
+				t.Errorf("Expected no heat index for an older client's reading, got %v", d["heat_index_c"])
+			}
+			if _, ok := d["mixing_ratio"]; ok {
+				t.Errorf("Expected no mixing ratio for an older client's reading, got %v", d["mixing_ratio"])
+			}
+		}
+	}
+}
+
+// TestHandleReadingsVPD tests that VPD is kept when sent, filled in when an older client leaves it out,
+// and covered by device stats
+func TestHandleReadingsVPD(t *testing.T) {
+	server := createTestServer(t)
+	now := time.Now()
+
+	for i, body := range []string{
+		`{"device_name": "Tent", "device_addr": "AA:BB:CC:DD:EE:01", "temp_c": 25, "humidity": 70, "vpd": 0.95, "client_id": "c1", "timestamp": "%s"}`,
+		`{"device_name": "Tent", "device_addr": "AA:BB:CC:DD:EE:01", "temp_c": 25, "humidity": 60, "client_id": "c1", "timestamp": "%s"}`,
+	} {
+		body = fmt.Sprintf(body, now.Add(time.Duration(i)*time.Second).Format(time.RFC3339))
+		w := httptest.NewRecorder()
+		server.handleReadings(w, httptest.NewRequest("POST", "/readings", strings.NewReader(body)))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+	}
+
+	devices := server.getDevices()
+	if len(devices) != 1 || devices[0].VPD != 1.27 {
+		t.Fatalf("Expected VPD 1.27 filled in for the older client's reading, got %+v", devices)
+	}
+
+	stats := server.getDeviceStats("AA:BB:CC:DD:EE:01", defaultPercentiles)
+	if stats["vpd_min"] != 0.95 || stats["vpd_max"] != 1.27 || math.Abs(stats["vpd_avg"].(float64)-1.11) > 1e-9 {
+		t.Errorf("Expected VPD stats 0.95/1.27/1.11, got %v/%v/%v", stats["vpd_min"], stats["vpd_max"], stats["vpd_avg"])
+	}
+}
+
+// TestCustomReadingValidator tests that registered validators can reject readings
+func TestCustomReadingValidator(t *testing.T) {
+	server := createTestServer(t)
+	server.AddValidator(ReadingValidatorFunc(func(r *Reading) error {
+		if r.TempC > 40 && r.Humidity > 90 {
+			return fmt.Errorf("implausible humidity %.1f%% at %.1f°C", r.Humidity, r.TempC)
+		}
+		return nil
+	}))
+
+	tests := []struct {
+		name           string
+		tempC          float64
+		humidity       float64
+		expectedStatus int
+	}{
+		{"Accepted by validator", 22.0, 50.0, http.StatusCreated},
+		{"Rejected by validator", 45.0, 95.0, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reading := Reading{
+				DeviceName: "Test Sensor",
+				DeviceAddr: "AA:BB:CC:DD:EE:FF",
+				TempC:      tt.tempC,
+				Humidity:   tt.humidity,
+				Battery:    80,
+				Timestamp:  time.Now(),
+				ClientID:   "test-client",
+			}
+			body, _ := json.Marshal(reading)
+			req := httptest.NewRequest("POST", "/readings", bytes.NewReader(body))
+			w := httptest.NewRecorder()
+			server.handleReadings(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	if count := deviceRing(server, "AA:BB:CC:DD:EE:FF").Len(); count != 1 {
+		t.Errorf("Expected 1 stored reading, got %d", count)
+	}
+}
+
+// TestRateLimitMiddlewareRetryAfter tests that rate-limited responses include Retry-After and a JSON body
+func TestRateLimitMiddlewareRetryAfter(t *testing.T) {
+	server := createTestServer(t)
+	handler := server.rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 50; i++ {
+		req := httptest.NewRequest("GET", "/devices", nil)
+		req.RemoteAddr = "192.168.1.50:12345"
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code == http.StatusTooManyRequests {
+			break
+		}
+	}
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected to be rate limited, last status %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected Retry-After of 1 second, got %q", w.Header().Get("Retry-After"))
+	}
+
+	var body struct {
+		Error             string `json:"error"`
+		RetryAfterSeconds int    `json:"retry_after_seconds"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to parse 429 body: %v", err)
+	}
+	if body.Error != "rate_limited" || body.RetryAfterSeconds != 1 {
+		t.Errorf("Unexpected 429 body: %+v", body)
+	}
+}
+
+// TestMergeRedundantReadings tests that near-simultaneous readings from two clients are merged
+func TestMergeRedundantReadings(t *testing.T) {
+	server := createTestServer(t)
+	server.config.MergeWindow = 5 * time.Second
+
+	now := time.Now()
+	base := Reading{
+		DeviceName: "Test Sensor",
+		DeviceAddr: "AA:BB:CC:DD:EE:FF",
+		TempC:      21.0,
+		Humidity:   50.0,
+		Battery:    80,
+	}
+
+	first := base
+	first.ClientID = "pi-kitchen"
+	first.RSSI = -80
+	first.Timestamp = now
+	server.addReading(first)
+
+	second := base
+	second.ClientID = "pi-hallway"
+	second.RSSI = -60
+	second.TempC = 21.1
+	second.Timestamp = now.Add(2 * time.Second)
+	server.addReading(second)
+
+	readings := deviceRing(server, "AA:BB:CC:DD:EE:FF").Readings()
+	if len(readings) != 1 {
+		t.Fatalf("Expected 1 merged reading, got %d", len(readings))
+	}
+	if readings[0].ClientID != "pi-hallway" || readings[0].RSSI != -60 {
+		t.Errorf("Expected strongest RSSI reading to be kept, got client %s RSSI %d", readings[0].ClientID, readings[0].RSSI)
+	}
+	if deviceStatus(server, "AA:BB:CC:DD:EE:FF").TempC != 21.1 {
+		t.Errorf("Expected device status to reflect merged reading")
+	}
+	if _, exists := server.clients["pi-hallway"]; !exists {
+		t.Error("Expected redundant client to be tracked")
+	}
+	// The device moved to the client whose reading was kept
+	if server.clients["pi-hallway"].DeviceCount != 1 || server.clients["pi-kitchen"].DeviceCount != 0 {
+		t.Errorf("Expected the device to count for pi-hallway only, got pi-hallway %d, pi-kitchen %d",
+			server.clients["pi-hallway"].DeviceCount, server.clients["pi-kitchen"].DeviceCount)
+	}
+
+	// A weaker reading from the first client inside the window is discarded
+	third := first
+	third.Timestamp = now.Add(3 * time.Second)
+	server.addReading(third)
+	if deviceRing(server, "AA:BB:CC:DD:EE:FF").Len() != 1 || deviceRing(server, "AA:BB:CC:DD:EE:FF").At(0).RSSI != -60 {
+		t.Error("Expected weaker redundant reading to be discarded")
+	}
+	if server.clients["pi-hallway"].DeviceCount != 1 || server.clients["pi-kitchen"].DeviceCount != 0 {
+		t.Error("Expected a discarded reading not to move the device")
+	}
+
+	// Readings outside the window are stored normally
+	fourth := first
+	fourth.Timestamp = now.Add(30 * time.Second)
+	server.addReading(fourth)
+	if deviceRing(server, "AA:BB:CC:DD:EE:FF").Len() != 2 {
+		t.Errorf("Expected reading outside merge window to be stored, got %d readings", deviceRing(server, "AA:BB:CC:DD:EE:FF").Len())
+	}
+}
+
+// TestMergeRedundantReadingsSQLite tests that the reading a merge keeps is the one stored in
+// SQLite, whether the weaker reading was already inserted or is still queued
+func TestMergeRedundantReadingsSQLite(t *testing.T) {
+	for _, buffered := range []bool{false, true} {
+		t.Run(fmt.Sprintf("buffered=%v", buffered), func(t *testing.T) {
+			server := createTestServer(t)
+			server.config.MergeWindow = 5 * time.Second
+			store := NewSQLiteStorage(filepath.Join(t.TempDir(), "govee.db"))
+			if err := store.Initialize(); err != nil {
+				t.Fatalf("Failed to initialize SQLite: %v", err)
+			}
+			defer store.Close()
+			if buffered {
+				store.StartWriteBuffer(100, time.Hour)
+			}
+			server.readingWriter = store
+
+			now := time.Now()
+			weak := Reading{DeviceName: "Test Sensor", DeviceAddr: "AA:BB:CC:DD:EE:FF", TempC: 21.0, Humidity: 50.0,
+				Battery: 80, RSSI: -80, Timestamp: now, ClientID: "pi-kitchen"}
+			strong := weak
+			strong.ClientID = "pi-hallway"
+			strong.RSSI = -60
+			strong.TempC = 21.1
+			strong.Timestamp = now.Add(2 * time.Second)
+			server.addReading(weak)
+			server.addReading(strong)
+
+			// A weaker reading inside the window changes nothing
+			weaker := weak
+			weaker.Timestamp = now.Add(3 * time.Second)
+			server.addReading(weaker)
+
+			if err := store.flushQueue(); err != nil {
+				t.Fatalf("Failed to flush: %v", err)
+			}
+			readings, err := store.LoadAllDeviceReadings("AA:BB:CC:DD:EE:FF")
+			if err != nil {
+				t.Fatalf("Failed to load readings: %v", err)
+			}
+			if len(readings) != 1 || readings[0].ClientID != "pi-hallway" || readings[0].RSSI != -60 || readings[0].TempC != 21.1 {
+				t.Errorf("Expected only the strongest reading in SQLite, got %+v", readings)
+			}
+		})
+	}
+}
+
+// TestRejectLog tests that rejected readings are written to the reject log with their reason
+func TestRejectLog(t *testing.T) {
+	server := createTestServer(t)
+	logPath := server.config.StorageDir + "/rejects.log"
+	rejectLog, err := openRotatingFile(logPath, 0, 1)
+	if err != nil {
+		t.Fatalf("Failed to open reject log: %v", err)
+	}
+	server.rejectLog = rejectLog
+	defer rejectLog.Close()
+
+	invalid := []Reading{
+		{DeviceName: "Hot Sensor", DeviceAddr: "AA:BB:CC:DD:EE:FF", TempC: 150, Battery: 50, Timestamp: time.Now(), ClientID: "flaky-client"},
+		{DeviceName: "Bad Battery", DeviceAddr: "AA:BB:CC:DD:EE:FF", TempC: 20, Battery: 150, Timestamp: time.Now(), ClientID: "flaky-client"},
+	}
+	for _, reading := range invalid {
+		body, _ := json.Marshal(reading)
+		req := httptest.NewRequest("POST", "/readings", bytes.NewReader(body))
+		req.RemoteAddr = "192.0.2.10:5555"
+		w := httptest.NewRecorder()
+		server.handleReadings(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected 400, got %d", w.Code)
+		}
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read reject log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 reject log entries, got %d", len(lines))
+	}
+
+	var entry rejectedReading
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("Invalid reject log entry: %v", err)
+	}
+	if !strings.Contains(entry.Reason, "temperature out of range") {
+		t.Errorf("Unexpected reason: %s", entry.Reason)
+	}
+	if entry.RemoteIP != "192.0.2.10" || entry.ClientID != "flaky-client" || entry.Reading.TempC != 150 {
+		t.Errorf("Unexpected reject log entry: %+v", entry)
+	}
+}
+
+// TestAuditLog tests that key changes and authentication failures are recorded in the audit log
+func TestAuditLog(t *testing.T) {
+	adminKey := "test-admin-key-123"
+	server := createTestServerWithAuth(t, adminKey, make(map[string]string))
+	logPath := server.config.StorageDir + "/audit.log"
+	auditLog, err := openRotatingFile(logPath, 0, 1)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	server.auditLog = auditLog
+	defer auditLog.Close()
+
+	readEntries := func() []auditEntry {
+		data, err := os.ReadFile(logPath)
+		if err != nil {
+			t.Fatalf("Failed to read audit log: %v", err)
+		}
+		var entries []auditEntry
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var entry auditEntry
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("Invalid audit log entry %q: %v", line, err)
+			}
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+
+	// Create then delete a key
+	handler := server.authMiddleware(http.HandlerFunc(server.handleAPIKeys))
+	req := httptest.NewRequest("POST", "/api/keys", strings.NewReader(`{"client_id":"kitchen"}`))
+	req.Header.Set("X-API-Key", adminKey)
+	req.RemoteAddr = "192.0.2.10:5555"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var created map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&created)
+	newKey, _ := created["api_key"].(string)
+
+	req = httptest.NewRequest("DELETE", "/api/keys?key="+newKey, nil)
+	req.Header.Set("X-API-Key", adminKey)
+	req.RemoteAddr = "192.0.2.10:5555"
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected key to be deleted, got %d", w.Code)
+	}
+
+	entries := readEntries()
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 audit entries, got %d: %+v", len(entries), entries)
+	}
+	for i, event := range []string{auditKeyCreated, auditKeyDeleted} {
+		entry := entries[i]
+		if entry.Event != event || entry.ClientID != "kitchen" || entry.KeyHash != hashAPIKey(newKey) ||
+			entry.RemoteIP != "192.0.2.10" || entry.Timestamp.IsZero() {
+			t.Errorf("Unexpected %s entry: %+v", event, entry)
+		}
+	}
+	if strings.Contains(fmt.Sprint(entries), newKey) {
+		t.Error("Expected the audit log not to contain the plaintext key")
+	}
+
+	// The deleted key now fails authentication
+	req = httptest.NewRequest("GET", "/devices", nil)
+	req.Header.Set("X-API-Key", newKey)
+	req.RemoteAddr = "198.51.100.7:4444"
+	w = httptest.NewRecorder()
+	server.authMiddleware(http.HandlerFunc(server.handleDevices)).ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401, got %d", w.Code)
+	}
+
+	entries = readEntries()
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 audit entries, got %d", len(entries))
+	}
+	if failure := entries[2]; failure.Event != auditAuthFailed || failure.RemoteIP != "198.51.100.7" ||
+		failure.Path != "/devices" || failure.Reason != "invalid API key" {
+		t.Errorf("Unexpected auth failure entry: %+v", failure)
+	}
+}
+
+// TestRotatingFile tests that the file rotates into a backup once it exceeds its size limit
+func TestRotatingFile(t *testing.T) {
+	path := t.TempDir() + "/rotating.log"
+	rf, err := openRotatingFile(path, 20, 1)
+	if err != nil {
+		t.Fatalf("Failed to open rotating file: %v", err)
+	}
+	defer rf.Close()
+
+	rf.WriteString("0123456789\n")
+	rf.WriteString("0123456789\n")
+
+	backup, err := os.ReadFile(path + ".1")
+	if err != nil {
+		t.Fatalf("Expected backup file after rotation: %v", err)
+	}
+	if string(backup) != "0123456789\n" {
+		t.Errorf("Unexpected backup content: %q", backup)
+	}
+	current, _ := os.ReadFile(path)
+	if string(current) != "0123456789\n" {
+		t.Errorf("Unexpected current content: %q", current)
+	}
+}
+
+// TestRotatingFileRotationFails tests that writes carry on in the current file while rotation
+// fails, and that rotation resumes once the problem is gone
+func TestRotatingFileRotationFails(t *testing.T) {
+	path := t.TempDir() + "/rotating.log"
+	rf, err := openRotatingFile(path, 20, 1)
+	if err != nil {
+		t.Fatalf("Failed to open rotating file: %v", err)
+	}
+	defer rf.Close()
+
+	// A non-empty directory in the backup's place makes the rename fail
+	if err := os.MkdirAll(path+".1/blocker", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := rf.WriteString("0123456789\n"); err != nil {
+			t.Fatalf("Expected write %d to succeed despite failed rotation: %v", i, err)
+		}
+	}
+	if current, _ := os.ReadFile(path); len(current) != 33 {
+		t.Errorf("Expected all three writes in the current file, got %q", current)
+	}
+
+	os.RemoveAll(path + ".1")
+	rf.WriteString("abcdefghij\n")
+	if backup, _ := os.ReadFile(path + ".1"); len(backup) != 33 {
+		t.Errorf("Expected the oversized file to be rotated, got backup %q", backup)
+	}
+	if current, _ := os.ReadFile(path); string(current) != "abcdefghij\n" {
+		t.Errorf("Unexpected current content after rotation: %q", current)
+	}
+}
+
+// TestDevicePreferredUnits tests that device metadata units drive /devices and /dashboard/data
+func TestDevicePreferredUnits(t *testing.T) {
+	server := createTestServer(t)
+
+	for _, addr := range []string{"AA:BB:CC:DD:EE:01", "AA:BB:CC:DD:EE:02"} {
+		server.addReading(Reading{
+			DeviceName: "GVH5075_" + addr[len(addr)-2:],
+			DeviceAddr: addr,
+			TempC:      20.0,
+			TempF:      68.0,
+			Humidity:   50.0,
+			DewPointC:  9.3,
+			DewPointF:  48.74,
+			Battery:    90,
+			Timestamp:  time.Now(),
+			ClientID:   "test-client",
+		})
+	}
+
+	// Assign Fahrenheit to the first device
+	req := httptest.NewRequest("PUT", "/api/metadata", strings.NewReader(`{"device_addr":"AA:BB:CC:DD:EE:01","units":"F"}`))
+	w := httptest.NewRecorder()
+	server.handleDeviceMetadata(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 setting metadata, got %d: %s", w.Code, w.Body.String())
+	}
+
+	checkUnits := func(devices []*DeviceStatus, expected map[string]string) {
+		t.Helper()
+		if len(devices) != len(expected) {
+			t.Fatalf("Expected %d devices, got %d", len(expected), len(devices))
+		}
+		for _, d := range devices {
+			units := expected[d.DeviceAddr]
+			want := d.TempC
+			if units == "f" {
+				want = d.TempF
+			}
+			if d.Units != units || d.Temperature != want {
+				t.Errorf("Device %s: got units=%q temperature=%v, expected units=%q temperature=%v",
+					d.DeviceAddr, d.Units, d.Temperature, units, want)
+			}
+		}
+	}
+
+	preferred := map[string]string{"AA:BB:CC:DD:EE:01": "f", "AA:BB:CC:DD:EE:02": "c"}
+
+	// /devices uses each device's preferred units
+	req = httptest.NewRequest("GET", "/devices", nil)
+	w = httptest.NewRecorder()
+	server.handleDevices(w, req)
+	var devices []*DeviceStatus
+	if err := json.NewDecoder(w.Body).Decode(&devices); err != nil {
+		t.Fatalf("Failed to decode devices: %v", err)
+	}
+	checkUnits(devices, preferred)
+
+	// /dashboard/data does too, both on a cache miss and a cache hit
+	for i := 0; i < 2; i++ {
+		req = httptest.NewRequest("GET", "/dashboard/data", nil)
+		w = httptest.NewRecorder()
+		server.handleDashboardData(w, req)
+		var data DashboardData
+		if err := json.NewDecoder(w.Body).Decode(&data); err != nil {
+			t.Fatalf("Failed to decode dashboard data: %v", err)
+		}
+		checkUnits(data.Devices, preferred)
+	}
+
+	// A request-level override wins over the preferred units
+	req = httptest.NewRequest("GET", "/devices?units=c", nil)
+	w = httptest.NewRecorder()
+	server.handleDevices(w, req)
+	devices = nil
+	if err := json.NewDecoder(w.Body).Decode(&devices); err != nil {
+		t.Fatalf("Failed to decode devices: %v", err)
+	}
+	checkUnits(devices, map[string]string{"AA:BB:CC:DD:EE:01": "c", "AA:BB:CC:DD:EE:02": "c"})
+
+	// Invalid units are rejected
+	req = httptest.NewRequest("GET", "/devices?units=k", nil)
+	w = httptest.NewRecorder()
+	server.handleDevices(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid units, got %d", w.Code)
+	}
+
+	// Deleting the only metadata entry is persisted, so it doesn't come back on restart
+	server.config.PersistenceEnabled = true
+	server.saveData()
+	req = httptest.NewRequest("DELETE", "/api/metadata?device=AA:BB:CC:DD:EE:01", nil)
+	w = httptest.NewRecorder()
+	server.handleDeviceMetadata(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 deleting metadata, got %d: %s", w.Code, w.Body.String())
+	}
+	restarted := createTestServer(t)
+	restarted.config.StorageDir = server.config.StorageDir
+	restarted.loadData()
+	if len(restarted.deviceMetadata) != 0 {
+		t.Errorf("Expected no metadata after a restart, got %v", restarted.deviceMetadata)
+	}
+}
+
+// TestDeviceLocationAndTags tests assigning locations and tags through metadata and filtering /devices by tag
+func TestDeviceLocationAndTags(t *testing.T) {
+	server := createTestServer(t)
+
+	for _, addr := range []string{"AA:BB:CC:DD:EE:01", "AA:BB:CC:DD:EE:02", "AA:BB:CC:DD:EE:03"} {
+		server.addReading(Reading{
+			DeviceName: "GVH5075_" + addr[len(addr)-2:],
+			DeviceAddr: addr,
+			TempC:      20.0,
+			Humidity:   50.0,
+			Battery:    90,
+			Timestamp:  time.Now(),
+			ClientID:   "test-client",
+			Location:   "Garage",
+		})
+	}
+
+	setMetadata := func(method, body string, expectedStatus int) {
+		t.Helper()
+		req := httptest.NewRequest(method, "/api/metadata", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.handleDeviceMetadata(w, req)
+		if w.Code != expectedStatus {
+			t.Fatalf("%s %s: expected status %d, got %d: %s", method, body, expectedStatus, w.Code, w.Body.String())
+		}
+	}
+	setMetadata("PUT", `{"device_addr":"AA:BB:CC:DD:EE:01","units":"f","location":"Kitchen","tags":["kitchen","Downstairs","KITCHEN"]}`, http.StatusOK)
+	setMetadata("PUT", `{"device_addr":"AA:BB:CC:DD:EE:02","tags":["upstairs"]}`, http.StatusOK)
+	setMetadata("PUT", `{"device_addr":"AA:BB:CC:DD:EE:02","tags":["<script>"]}`, http.StatusBadRequest)
+	setMetadata("PUT", `{"device_addr":"AA:BB:CC:DD:EE:02","location":"`+strings.Repeat("x", maxLabelLength+1)+`"}`, http.StatusBadRequest)
+
+	// PATCH changes only the fields given
+	setMetadata("PATCH", `{"device_addr":"AA:BB:CC:DD:EE:01","tags":["kitchen"]}`, http.StatusOK)
+	server.mu.RLock()
+	meta := *server.deviceMetadata["AA:BB:CC:DD:EE:01"]
+	server.mu.RUnlock()
+	if meta.Units != "f" || meta.Location != "Kitchen" || len(meta.Tags) != 1 {
+		t.Errorf("Expected PATCH to keep units and location, got %+v", meta)
+	}
+
+	getDevices := func(query string) map[string]*DeviceStatus {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/devices"+query, nil)
+		w := httptest.NewRecorder()
+		server.handleDevices(w, req)
+		var devices []*DeviceStatus
+		if err := json.NewDecoder(w.Body).Decode(&devices); err != nil {
+			t.Fatalf("Failed to decode devices: %v", err)
+		}
+		byAddr := make(map[string]*DeviceStatus)
+		for _, d := range devices {
+			byAddr[d.DeviceAddr] = d
+		}
+		return byAddr
+	}
+
+	all := getDevices("")
+	if len(all) != 3 {
+		t.Fatalf("Expected 3 devices, got %d", len(all))
+	}
+	// Metadata overrides the client's location; otherwise the client's is kept
+	if d := all["AA:BB:CC:DD:EE:01"]; d.Location != "Kitchen" || !hasTag(d.Tags, "kitchen") {
+		t.Errorf("Expected device 01 in Kitchen tagged kitchen, got location=%q tags=%v", d.Location, d.Tags)
+	}
+	if d := all["AA:BB:CC:DD:EE:03"]; d.Location != "Garage" || len(d.Tags) != 0 {
+		t.Errorf("Expected device 03 in the Garage without tags, got location=%q tags=%v", d.Location, d.Tags)
+	}
+
+	tagged := getDevices("?tag=Kitchen")
+	if len(tagged) != 1 || tagged["AA:BB:CC:DD:EE:01"] == nil {
+		t.Errorf("Expected only device 01 tagged kitchen, got %v", tagged)
+	}
+	if none := getDevices("?tag=attic"); len(none) != 0 {
+		t.Errorf("Expected no devices tagged attic, got %d", len(none))
+	}
+}
+
+// TestDevicePruning tests that stale devices are removed after the prune window and kept when pruning is disabled
+func TestDevicePruning(t *testing.T) {
+	addStale := func(server *Server) {
+		server.addReading(Reading{
+			DeviceName: "GVH5075_AAAA",
+			DeviceAddr: "AA:BB:CC:DD:EE:FF",
+			TempC:      21.0,
+			Humidity:   45.0,
+			Battery:    80,
+			Timestamp:  time.Now(),
+			ClientID:   "test-client",
+		})
+	}
+
+	t.Run("Short prune window removes stale device", func(t *testing.T) {
+		server := createTestServer(t)
+		server.config.DevicePruneAfter = 50 * time.Millisecond
+		addStale(server)
+
+		server.cleanupStale(time.Now())
+		if len(server.getDevices()) != 1 {
+			t.Fatal("Expected fresh device to be retained")
+		}
+
+		server.cleanupStale(time.Now().Add(100 * time.Millisecond))
+		if len(server.getDevices()) != 0 {
+			t.Error("Expected stale device to be pruned")
+		}
+		if deviceRing(server, "AA:BB:CC:DD:EE:FF") != nil {
+			t.Error("Expected stale device readings to be pruned")
+		}
+	})
+
+	t.Run("Zero retains device indefinitely", func(t *testing.T) {
+		server := createTestServer(t)
+		server.config.DevicePruneAfter = 0
+		addStale(server)
+
+		server.cleanupStale(time.Now().Add(10 * 365 * 24 * time.Hour))
+		if len(server.getDevices()) != 1 {
+			t.Error("Expected device to be retained when pruning is disabled")
+		}
+	})
+}
+
+// TestGetReadingsBucket tests that the bucket parameter downsamples readings and keeps the order parameter
+func TestGetReadingsBucket(t *testing.T) {
+	server := createTestServer(t)
+	deviceAddr := "AA:BB:CC:DD:EE:FF"
+	start := time.Now().Truncate(time.Hour).Add(-time.Hour)
+
+	// One reading a minute for an hour
+	for i := 0; i < 60; i++ {
+		server.addReading(Reading{
+			DeviceName: "GVH5075_TEST",
+			DeviceAddr: deviceAddr,
+			TempC:      float64(i),
+			Humidity:   50.0,
+			Battery:    90,
+			Timestamp:  start.Add(time.Duration(i) * time.Minute),
+			ClientID:   "test-client",
+		})
+	}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.handleReadings(w, httptest.NewRequest("GET", "/readings?device="+deviceAddr+query, nil))
+		return w
+	}
+
+	w := get("&bucket=15m&order=desc")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var got []Reading
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("Failed to decode readings: %v", err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("Expected 4 buckets, got %d", len(got))
+	}
+	// Newest bucket first, averaging minutes 45 to 59
+	if !got[0].Timestamp.Equal(start.Add(45*time.Minute)) || got[0].TempC != 52 {
+		t.Errorf("Unexpected newest bucket: %v at %.1f°C", got[0].Timestamp, got[0].TempC)
+	}
+
+	for _, bucket := range []string{"0s", "-5m", "often"} {
+		if w := get("&bucket=" + bucket); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for bucket=%s, got %d", bucket, w.Code)
+		}
+	}
+}
+
+// TestHandleGaps tests /gaps over stored and not yet saved readings
+func TestHandleGaps(t *testing.T) {
+	server := createTestServer(t)
+	deviceAddr := "AA:BB:CC:DD:EE:FF"
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	at := func(m int) Reading {
+		return Reading{DeviceName: "GVH5075_TEST", DeviceAddr: deviceAddr, TempC: 21, Humidity: 50, Timestamp: start.Add(time.Duration(m) * time.Minute), ClientID: "test-client"}
+	}
+
+	// Saved readings every 5 minutes for the first half hour, then silence until minute 60,
+	// then readings held only in memory
+	var saved []Reading
+	for m := 0; m <= 30; m += 5 {
+		saved = append(saved, at(m))
+	}
+	if err := server.storageManager.saveReadings(deviceAddr, saved); err != nil {
+		t.Fatalf("Failed to save readings: %v", err)
+	}
+	for m := 60; m <= 80; m += 5 {
+		server.addReading(at(m))
+	}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.handleGaps(w, httptest.NewRequest("GET", "/gaps?device="+deviceAddr+query, nil))
+		return w
+	}
+
+	w := get("&from=2024-03-01T00:00:00Z&to=2024-03-01T02:00:00Z&threshold=15m")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var gaps []Gap
+	if err := json.NewDecoder(w.Body).Decode(&gaps); err != nil {
+		t.Fatalf("Failed to decode gaps: %v", err)
+	}
+	// The silence after minute 30, and the end of the range after the last reading at minute 80
+	if len(gaps) != 2 || gaps[0].Duration != "30m0s" || gaps[1].Duration != "40m0s" || !gaps[1].End.Equal(start.Add(2*time.Hour)) {
+		t.Errorf("Unexpected gaps: %+v", gaps)
+	}
+
+	for _, query := range []string{"&threshold=0s", "&threshold=soon", "&from=yesterday", "&from=2024-03-02T00:00:00Z&to=2024-03-01T00:00:00Z"} {
+		if w := get(query); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %q, got %d", query, w.Code)
+		}
+	}
+	w = httptest.NewRecorder()
+	server.handleGaps(w, httptest.NewRequest("GET", "/gaps", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a device, got %d", w.Code)
+	}
+}
+
+// TestHandleReadingsLatest tests returning only a device's newest reading, falling back to SQLite
+func TestHandleReadingsLatest(t *testing.T) {
+	server := createTestServer(t)
+	deviceAddr := "AA:BB:CC:DD:EE:FF"
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 3; i++ {
+		server.addReading(Reading{DeviceName: "Sensor", DeviceAddr: deviceAddr, TempC: 20 + float64(i), Humidity: 50, Timestamp: start.Add(time.Duration(i) * time.Minute), ClientID: "test-client"})
+	}
+
+	getLatest := func(device string, wantStatus int) Reading {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.handleReadingsLatest(w, httptest.NewRequest("GET", "/readings/latest?device="+device, nil))
+		if w.Code != wantStatus {
+			t.Fatalf("Expected status %d for %q, got %d: %s", wantStatus, device, w.Code, w.Body.String())
+		}
+		var reading Reading
+		if wantStatus == http.StatusOK {
+			json.NewDecoder(w.Body).Decode(&reading)
+		}
+		return reading
+	}
+
+	if latest := getLatest(deviceAddr, http.StatusOK); latest.TempC != 22 || !latest.Timestamp.Equal(start.Add(2*time.Minute)) {
+		t.Errorf("Expected the newest reading at 22°C, got %+v", latest)
+	}
+	getLatest("11:22:33:44:55:66", http.StatusNotFound)
+	getLatest("", http.StatusBadRequest)
+
+	// Devices not in memory are looked up in the database
+	storage := NewSQLiteStorage(filepath.Join(t.TempDir(), "latest.db"))
+	if err := storage.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer storage.Close()
+	server.aggregateStore = storage
+	stored := []Reading{
+		{DeviceName: "Shed", DeviceAddr: "11:22:33:44:55:66", TempC: 8, Timestamp: start, ClientID: "test-client"},
+		{DeviceName: "Shed", DeviceAddr: "11:22:33:44:55:66", TempC: 9, Timestamp: start.Add(time.Minute), ClientID: "test-client"},
+		{DeviceName: "Loft", DeviceAddr: "11:22:33:44:55:77", TempC: 30, Timestamp: start.Add(2 * time.Minute), ClientID: "test-client"},
+	}
+	for _, reading := range stored {
+		if err := storage.SaveReadings(reading.DeviceAddr, []Reading{reading}); err != nil {
+			t.Fatalf("SaveReadings failed: %v", err)
+		}
+	}
+	if latest := getLatest("11:22:33:44:55:66", http.StatusOK); latest.TempC != 9 || latest.DeviceName != "Shed" {
+		t.Errorf("Expected the newest stored reading at 9°C, got %+v", latest)
+	}
+	getLatest("11:22:33:44:55:88", http.StatusNotFound)
+
+	// Like /readings, the alias is filled in and client IDs are hidden in privacy mode
+	server.deviceAliases[deviceAddr] = "Kitchen"
+	server.deviceAliases["11:22:33:44:55:66"] = "Shed"
+	server.config.PrivacyMode = true
+	for _, device := range []string{deviceAddr, "11:22:33:44:55:66"} {
+		latest := getLatest(device, http.StatusOK)
+		if latest.DisplayName == "" || latest.ClientID != server.publicClientID("test-client") {
+			t.Errorf("Expected %s with its alias and a hashed client ID, got %+v", device, latest)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	server.handleReadingsLatest(w, httptest.NewRequest("POST", "/readings/latest?device="+deviceAddr, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for POST, got %d", w.Code)
+	}
+}
+
+// TestGetReadingsOrder tests the order parameter for in-memory and storage-backed readings
+func TestGetReadingsOrder(t *testing.T) {
+	server := createTestServer(t)
+	deviceAddr := "AA:BB:CC:DD:EE:FF"
+	now := time.Now().Truncate(time.Second)
+
+	var readings []Reading
+	for i := 0; i < 3; i++ {
+		reading := Reading{
+			DeviceName: "GVH5075_TEST",
+			DeviceAddr: deviceAddr,
+			TempC:      20.0 + float64(i),
+			Humidity:   50.0,
+			Battery:    90,
+			Timestamp:  now.Add(time.Duration(i-3) * time.Minute),
+			ClientID:   "test-client",
+		}
+		server.addReading(reading)
+		readings = append(readings, reading)
+	}
+	if err := server.storageManager.saveReadings(deviceAddr, readings); err != nil {
+		t.Fatalf("Failed to save readings: %v", err)
+	}
+
+	timeRange := fmt.Sprintf("&from=%s&to=%s", now.Add(-time.Hour).Format(time.RFC3339), now.Format(time.RFC3339))
+	tests := []struct {
+		name       string
+		query      string
+		descending bool
+	}{
+		{"In-memory default", "", false},
+		{"In-memory asc", "&order=asc", false},
+		{"In-memory desc", "&order=desc", true},
+		{"Storage default", timeRange, false},
+		{"Storage asc", timeRange + "&order=asc", false},
+		{"Storage desc", timeRange + "&order=desc", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/readings?device="+deviceAddr+tt.query, nil)
+			w := httptest.NewRecorder()
+			server.handleReadings(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			var got []Reading
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("Failed to decode readings: %v", err)
+			}
+			if len(got) != 3 {
+				t.Fatalf("Expected 3 readings, got %d", len(got))
+			}
+			for i := 1; i < len(got); i++ {
+				if got[i].Timestamp.Before(got[i-1].Timestamp) != tt.descending {
+					t.Errorf("Reading %d out of order: %v after %v", i, got[i].Timestamp, got[i-1].Timestamp)
+				}
+			}
+		})
+	}
+
+	// The in-memory store must stay chronological after a descending request
+	first := deviceRing(server, deviceAddr).At(0).TempC
+	if first != 20.0 {
+		t.Errorf("Descending request reordered stored readings, first is now %.1f", first)
+	}
+
+	req := httptest.NewRequest("GET", "/readings?device="+deviceAddr+"&order=sideways", nil)
+	w := httptest.NewRecorder()
+	server.handleReadings(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid order, got %d", w.Code)
+	}
+}
+
+// TestPrivacyModeHashesClientIDs tests that responses carry hashed client IDs while storage keeps the real ones
+func TestPrivacyModeHashesClientIDs(t *testing.T) {
+	server := createTestServer(t)
+	server.config.PrivacyMode = true
+	server.config.PrivacySalt = "test-salt"
+
+	deviceAddr := "AA:BB:CC:DD:EE:FF"
+	server.addReading(Reading{
+		DeviceName: "GVH5075_TEST",
+		DeviceAddr: deviceAddr,
+		TempC:      21.0,
+		Humidity:   45.0,
+		Battery:    80,
+		Timestamp:  time.Now(),
+		ClientID:   "kitchen-pi",
+	})
+
+	hashed := server.publicClientID("kitchen-pi")
+	if hashed == "kitchen-pi" || !strings.HasPrefix(hashed, "client-") {
+		t.Fatalf("Expected hashed client ID, got %q", hashed)
+	}
+	if server.publicClientID("kitchen-pi") != hashed {
+		t.Error("Expected hashed client ID to be stable")
+	}
+
+	get := func(path string, handler http.HandlerFunc) string {
+		req := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected status 200, got %d", path, w.Code)
+		}
+		return w.Body.String()
+	}
+
+	responses := map[string]string{
+		"/clients":        get("/clients", server.handleClients),
+		"/devices":        get("/devices", server.handleDevices),
+		"/dashboard/data": get("/dashboard/data", server.handleDashboardData),
+		"/readings":       get("/readings?device="+deviceAddr, server.handleReadings),
+	}
+	for path, body := range responses {
+		if strings.Contains(body, "kitchen-pi") {
+			t.Errorf("%s leaked the raw client ID: %s", path, body)
+		}
+		if !strings.Contains(body, hashed) {
+			t.Errorf("%s missing hashed client ID %q: %s", path, hashed, body)
+		}
+	}
+
+	// Internal state and persisted data keep the real client ID
+	server.clientsMu.RLock()
+	_, clientStored := server.clients["kitchen-pi"]
+	server.clientsMu.RUnlock()
+	deviceClient := deviceStatus(server, deviceAddr).ClientID
+	readingClient := deviceRing(server, deviceAddr).At(0).ClientID
+	if !clientStored || deviceClient != "kitchen-pi" || readingClient != "kitchen-pi" {
+		t.Errorf("Expected stored client ID to remain kitchen-pi (client stored: %v, device: %q, reading: %q)",
+			clientStored, deviceClient, readingClient)
+	}
+
+	server.saveData()
+	devicesData, err := os.ReadFile(server.config.StorageDir + "/devices.json")
+	if err != nil {
+		t.Fatalf("Failed to read saved devices: %v", err)
+	}
+	if !strings.Contains(string(devicesData), "kitchen-pi") {
+		t.Error("Expected saved devices to keep the real client ID")
+	}
+}
+
+// TestSuspectReadingThresholds tests that a temperature jump is suspect under a tight threshold but ok under a loose one
+func TestSuspectReadingThresholds(t *testing.T) {
+	tests := []struct {
+		name            string
+		tempDelta       float64
+		humidityDelta   float64
+		tempJump        float64
+		humidityJump    float64
+		expectedQuality string
+	}{
+		{"Tight temperature threshold", 2.0, 10.0, 5.0, 0, qualitySuspect},
+		{"Loose temperature threshold", 10.0, 10.0, 5.0, 0, qualityOK},
+		{"Tight humidity threshold", 2.0, 5.0, 0, 15.0, qualitySuspect},
+		{"Loose humidity threshold", 2.0, 20.0, 0, 15.0, qualityOK},
+		{"Disabled thresholds", 0, 0, 5.0, 15.0, qualityOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := createTestServer(t)
+			server.config.SuspectTempDeltaPerMin = tt.tempDelta
+			server.config.SuspectHumidityDeltaPerMin = tt.humidityDelta
+
+			deviceAddr := "AA:BB:CC:DD:EE:FF"
+			start := time.Now().Add(-time.Hour)
+			server.addReading(Reading{
+				DeviceName: "GVH5075_FREEZER",
+				DeviceAddr: deviceAddr,
+				TempC:      -18.0,
+				Humidity:   40.0,
+				Timestamp:  start,
+				ClientID:   "test-client",
+			})
+			// One minute later
+			server.addReading(Reading{
+				DeviceName: "GVH5075_FREEZER",
+				DeviceAddr: deviceAddr,
+				TempC:      -18.0 + tt.tempJump,
+				Humidity:   40.0 + tt.humidityJump,
+				Timestamp:  start.Add(time.Minute),
+				ClientID:   "test-client",
+			})
+
+			readings := deviceRing(server, deviceAddr).Readings()
+			if readings[0].Quality != qualityOK {
+				t.Errorf("Expected first reading to be ok, got %q", readings[0].Quality)
+			}
+			if readings[1].Quality != tt.expectedQuality {
+				t.Errorf("Expected quality %q, got %q", tt.expectedQuality, readings[1].Quality)
+			}
+		})
+	}
+}
+
+// TestStatsTimeWeightedAverage tests that time weighting stops dense sampling from skewing range averages
+func TestStatsTimeWeightedAverage(t *testing.T) {
+	server := createTestServer(t)
+	deviceAddr := "AA:BB:CC:DD:EE:FF"
+	start := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	// An hour of 20°C sampled every minute, then ten hours of 30°C sampled hourly
+	var readings []Reading
+	for i := 0; i <= 60; i++ {
+		readings = append(readings, Reading{DeviceAddr: deviceAddr, TempC: 20.0, Humidity: 40.0, Timestamp: start.Add(time.Duration(i) * time.Minute)})
+	}
+	for i := 2; i <= 11; i++ {
+		readings = append(readings, Reading{DeviceAddr: deviceAddr, TempC: 30.0, Humidity: 60.0, Timestamp: start.Add(time.Duration(i) * time.Hour)})
+	}
+	if err := server.storageManager.saveReadings(deviceAddr, readings); err != nil {
+		t.Fatalf("Failed to save readings: %v", err)
+	}
+
+	getStats := func(weighting string) map[string]interface{} {
+		t.Helper()
+		url := fmt.Sprintf("/stats?device=%s&from=%s&to=%s", deviceAddr,
+			start.Format(time.RFC3339), start.Add(12*time.Hour).Format(time.RFC3339))
+		if weighting != "" {
+			url += "&weighting=" + weighting
+		}
+		req := httptest.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		server.handleStats(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var stats map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&stats)
+		return stats
+	}
+
+	// Simple mean: (61*20 + 10*30) / 71
+	simple := getStats("")
+	if simple["temp_c_median"] != 20.0 || simple["temp_c_p95"] != 30.0 {
+		t.Errorf("Expected range median 20 and p95 30, got %v and %v", simple["temp_c_median"], simple["temp_c_p95"])
+	}
+	if avg := simple["temp_c_avg"].(float64); math.Abs(avg-1520.0/71) > 0.001 {
+		t.Errorf("Expected simple average %.3f, got %.3f", 1520.0/71, avg)
+	}
+	if simple["weighting"] != "count" {
+		t.Errorf("Expected count weighting, got %v", simple["weighting"])
+	}
+
+	// Time-weighted: 90 minutes represented by 20°C, 570 minutes by 30°C
+	weighted := getStats("time")
+	if avg := weighted["temp_c_avg"].(float64); math.Abs(avg-18900.0/660) > 0.001 {
+		t.Errorf("Expected time-weighted average %.3f, got %.3f", 18900.0/660, avg)
+	}
+	if weighted["weighting"] != "time" || weighted["count"].(float64) != 71 {
+		t.Errorf("Unexpected weighting or count: %v, %v", weighted["weighting"], weighted["count"])
+	}
+	// Min and max don't depend on weighting
+	if weighted["temp_c_min"].(float64) != 20.0 || weighted["temp_c_max"].(float64) != 30.0 {
+		t.Errorf("Unexpected min/max: %v/%v", weighted["temp_c_min"], weighted["temp_c_max"])
+	}
+
+	req := httptest.NewRequest("GET", "/stats?device="+deviceAddr+"&weighting=median", nil)
+	w := httptest.NewRecorder()
+	server.handleStats(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for unknown weighting, got %d", w.Code)
+	}
+}
+
+// TestStatsFromDatabase tests that -db-stats computes range stats in the database, which may
+// hold history the partitions don't
+func TestStatsFromDatabase(t *testing.T) {
+	server := createTestServer(t)
+	storage := NewSQLiteStorage(filepath.Join(t.TempDir(), "stats.db"))
+	if err := storage.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer storage.Close()
+	server.aggregateStore = storage
+
+	deviceAddr := "AA:BB:CC:DD:EE:FF"
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	var readings []Reading
+	for i := 0; i < 4; i++ {
+		readings = append(readings, Reading{DeviceName: "Test", DeviceAddr: deviceAddr, TempC: 20 + float64(i), Humidity: 50, Timestamp: start.Add(time.Duration(i) * time.Hour), ClientID: "test"})
+	}
+	if err := storage.SaveReadings(deviceAddr, readings); err != nil {
+		t.Fatalf("SaveReadings failed: %v", err)
+	}
+
+	getStats := func(query string) map[string]interface{} {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.handleStats(w, httptest.NewRequest("GET", "/stats?device="+deviceAddr+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var stats map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&stats)
+		return stats
+	}
+	rangeQuery := "&from=2024-03-01T00:00:00Z&to=2024-03-01T02:00:00Z"
+
+	// Off by default: the readings are only in the database, so the range is empty
+	if stats := getStats(rangeQuery); len(stats) != 0 {
+		t.Errorf("Expected no stats without -db-stats, got %v", stats)
+	}
+
+	server.config.DBStats = true
+	stats := getStats(rangeQuery)
+	if stats["count"] != 3.0 || stats["temp_c_avg"] != 21.0 || stats["temp_c_max"] != 22.0 || stats["first_reading"] != "2024-03-01T00:00:00Z" {
+		t.Errorf("Unexpected stats from the database: %v", stats)
+	}
+
+	// Time weighting still needs the readings themselves
+	if stats := getStats(rangeQuery + "&weighting=time"); len(stats) != 0 {
+		t.Errorf("Expected time-weighted stats to load readings, got %v", stats)
+	}
+}
+
+// TestStatsAllFromAggregates tests fleet-wide range stats computed from SQLite hourly aggregates
+func TestStatsAllFromAggregates(t *testing.T) {
+	server := createTestServer(t)
+
+	// Without SQLite the endpoint is unavailable
+	w := httptest.NewRecorder()
+	server.handleStatsAll(w, httptest.NewRequest("GET", "/stats/all", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status 501 without SQLite, got %d", w.Code)
+	}
+
+	storage := NewSQLiteStorage(filepath.Join(t.TempDir(), "stats.db"))
+	if err := storage.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer storage.Close()
+	server.aggregateStore = storage
+
+	// Three devices with 10 readings each over 5 hours, plus one device only seen before the range
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	devices := []string{"AA:BB:CC:DD:EE:01", "AA:BB:CC:DD:EE:02", "AA:BB:CC:DD:EE:03"}
+	for d, addr := range devices {
+		var readings []Reading
+		for i := 0; i < 10; i++ {
+			readings = append(readings, Reading{
+				DeviceName: "GVH5075_TEST",
+				DeviceAddr: addr,
+				TempC:      float64(10*(d+1) + i),
+				Humidity:   40.0 + float64(d),
+				Timestamp:  start.Add(time.Duration(i) * 30 * time.Minute),
+				ClientID:   "test-client",
+			})
+		}
+		if err := storage.SaveReadings(addr, readings); err != nil {
+			t.Fatalf("SaveReadings failed: %v", err)
+		}
+	}
+	old := Reading{DeviceName: "GVH5075_OLD", DeviceAddr: "AA:BB:CC:DD:EE:99", TempC: 5, Timestamp: start.Add(-48 * time.Hour)}
+	if err := storage.SaveReadings(old.DeviceAddr, []Reading{old}); err != nil {
+		t.Fatalf("SaveReadings failed: %v", err)
+	}
+	if err := storage.ComputeAggregates(start.Add(3 * time.Hour)); err != nil {
+		t.Fatalf("ComputeAggregates failed: %v", err)
+	}
+
+	w = httptest.NewRecorder()
+	server.handleStatsAll(w, httptest.NewRequest("GET", "/stats/all?from=2024-03-01T00:00:00Z&to=2024-03-01T06:00:00Z", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var result struct {
+		Devices   map[string]map[string]interface{} `json:"devices"`
+		Truncated bool                              `json:"truncated"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(result.Devices) != len(devices) || result.Truncated {
+		t.Fatalf("Expected stats for %d devices, got %d (truncated %v)", len(devices), len(result.Devices), result.Truncated)
+	}
+	for d, addr := range devices {
+		stats, ok := result.Devices[addr]
+		if !ok {
+			t.Errorf("Missing stats for %s", addr)
+			continue
+		}
+		base := float64(10 * (d + 1))
+		if stats["count"] != 10.0 || stats["hours"] != 5.0 {
+			t.Errorf("%s: expected 10 readings in 5 hours, got %v in %v", addr, stats["count"], stats["hours"])
+		}
+		if stats["temp_c_min"] != base || stats["temp_c_max"] != base+9 {
+			t.Errorf("%s: expected temperature range %.0f-%.0f, got %v-%v", addr, base, base+9, stats["temp_c_min"], stats["temp_c_max"])
+		}
+		if avg := stats["temp_c_avg"].(float64); math.Abs(avg-(base+4.5)) > 1e-9 {
+			t.Errorf("%s: expected average temperature %.1f, got %v", addr, base+4.5, avg)
+		}
+		if stats["humidity_avg"] != 40.0+float64(d) {
+			t.Errorf("%s: expected average humidity %v, got %v", addr, 40.0+float64(d), stats["humidity_avg"])
+		}
+	}
+
+	// Invalid ranges
+	for _, query := range []string{"from=yesterday", "from=2024-03-02T00:00:00Z&to=2024-03-01T00:00:00Z", "from=2020-01-01T00:00:00Z&to=2024-03-01T00:00:00Z"} {
+		w = httptest.NewRecorder()
+		server.handleStatsAll(w, httptest.NewRequest("GET", "/stats/all?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", query, w.Code)
+		}
+	}
+}
+
+// TestDeviceReadingCountAfterEviction tests that reading_count keeps counting every reading received
+// while retained_count stops at the in-memory limit
+func TestDeviceReadingCountAfterEviction(t *testing.T) {
+	server := createTestServer(t)
+	limit := server.config.ReadingsPerDevice
+	start := time.Now().Add(-time.Duration(limit*2) * time.Minute)
+
+	total := limit + limit/2
+	for i := 0; i < total; i++ {
+		server.addReading(Reading{
+			DeviceName: "GVH5075_EEFF",
+			DeviceAddr: "AA:BB:CC:DD:EE:FF",
+			TempC:      20.0 + float64(i%5),
+			Humidity:   50.0,
+			Battery:    90,
+			Timestamp:  start.Add(time.Duration(i) * time.Minute),
+			ClientID:   "test-client",
+		})
+	}
+
+	if ring := deviceRing(server, "AA:BB:CC:DD:EE:FF"); ring.Len() != limit {
+		t.Fatalf("Expected %d readings retained in memory, got %d", limit, ring.Len())
+	}
+
+	req := httptest.NewRequest("GET", "/devices", nil)
+	w := httptest.NewRecorder()
+	server.handleDevices(w, req)
+	var devices []*DeviceStatus
+	if err := json.NewDecoder(w.Body).Decode(&devices); err != nil {
+		t.Fatalf("Failed to decode devices: %v", err)
+	}
+	if len(devices) != 1 {
+		t.Fatalf("Expected 1 device, got %d", len(devices))
+	}
+	if devices[0].ReadingCount != total {
+		t.Errorf("Expected reading_count %d after eviction, got %d", total, devices[0].ReadingCount)
+	}
+	if devices[0].RetainedCount != limit {
+		t.Errorf("Expected retained_count %d, got %d", limit, devices[0].RetainedCount)
+	}
+
+	// The stored status isn't changed by listing
+	if stored := deviceStatus(server, "AA:BB:CC:DD:EE:FF"); stored.RetainedCount != 0 {
+		t.Errorf("Expected retained_count to be filled in only for responses, got %d stored", stored.RetainedCount)
+	}
+}
+
+// TestClientDeviceCountWhenDeviceMoves tests that device counts follow a device from one client to another
+func TestClientDeviceCountWhenDeviceMoves(t *testing.T) {
+	server := createTestServer(t)
+
+	send := func(addr, clientID string) {
+		server.addReading(Reading{
+			DeviceName: "GVH5075_" + addr[len(addr)-2:],
+			DeviceAddr: addr,
+			TempC:      20.0,
+			Humidity:   50.0,
+			Battery:    90,
+			Timestamp:  time.Now(),
+			ClientID:   clientID,
+		})
+	}
+	deviceCounts := func() map[string]int {
+		counts := make(map[string]int)
+		for _, c := range server.getClients(clientFilter{}) {
+			counts[c.ClientID] = c.DeviceCount
+		}
+		return counts
+	}
+
+	send("AA:BB:CC:DD:EE:01", "client-a")
+	send("AA:BB:CC:DD:EE:02", "client-a")
+	send("AA:BB:CC:DD:EE:01", "client-a")
+	if counts := deviceCounts(); counts["client-a"] != 2 {
+		t.Fatalf("Expected client-a to have 2 devices, got %v", counts)
+	}
+
+	// Device 01 is now heard by client-b instead
+	send("AA:BB:CC:DD:EE:01", "client-b")
+	if counts := deviceCounts(); counts["client-a"] != 1 || counts["client-b"] != 1 {
+		t.Errorf("Expected 1 device each after the move, got %v", counts)
+	}
+
+	// Further readings through client-b don't change the counts, and moving back restores them
+	send("AA:BB:CC:DD:EE:01", "client-b")
+	send("AA:BB:CC:DD:EE:01", "client-a")
+	if counts := deviceCounts(); counts["client-a"] != 2 || counts["client-b"] != 0 {
+		t.Errorf("Expected client-a 2 and client-b 0 after moving back, got %v", counts)
+	}
+
+	// Counts are rebuilt from the devices rather than trusted from the saved clients
+	server.clientsMu.Lock()
+	server.clients["client-a"].DeviceCount = 7
+	server.rLockShards()
+	server.rebuildClientDevices()
+	server.rUnlockShards()
+	server.clientsMu.Unlock()
+	if counts := deviceCounts(); counts["client-a"] != 2 || counts["client-b"] != 0 {
+		t.Errorf("Expected rebuilt counts client-a 2 and client-b 0, got %v", counts)
+	}
 }
@@ -6,7 +6,12 @@ import (
 	"time"
 )
 
-// MigrateJSONToSQLite migrates data from JSON files to SQLite database
+// migrateBatchSize is how many readings MigrateJSONToSQLite inserts per transaction
+const migrateBatchSize = 1000
+
+// MigrateJSONToSQLite migrates data from JSON files, flat or time-partitioned, to a SQLite
+// database. Readings the database already has for the same device and timestamp are skipped,
+// so an interrupted migration can simply be run again.
 func MigrateJSONToSQLite(jsonDir, sqlitePath string) error {
 	log.Printf("Starting migration from JSON (%s) to SQLite (%s)", jsonDir, sqlitePath)
 
@@ -31,7 +36,10 @@ func MigrateJSONToSQLite(jsonDir, sqlitePath string) error {
 
 	log.Printf("Found %d devices to migrate", len(devices))
 
-	totalReadings := 0
+	// Timestamps already stored for each device address, loaded as each address is first seen
+	stored := make(map[string]map[int64]bool)
+
+	totalReadings, totalSkipped := 0, 0
 	for i, device := range devices {
 		log.Printf("Migrating device %d/%d: %s", i+1, len(devices), device)
 
@@ -47,24 +55,45 @@ func MigrateJSONToSQLite(jsonDir, sqlitePath string) error {
 			continue
 		}
 
+		// Drop readings already in the database, or repeated in the JSON files
+		fresh := make([]Reading, 0, len(readings))
+		for _, r := range readings {
+			timestamps, ok := stored[r.DeviceAddr]
+			if !ok {
+				if timestamps, err = sqliteStorage.readingTimestamps(r.DeviceAddr); err != nil {
+					return fmt.Errorf("failed to check existing readings for device %s: %v", r.DeviceAddr, err)
+				}
+				stored[r.DeviceAddr] = timestamps
+			}
+			if timestamps[r.Timestamp.UnixNano()] {
+				continue
+			}
+			timestamps[r.Timestamp.UnixNano()] = true
+			fresh = append(fresh, r)
+		}
+		skipped := len(readings) - len(fresh)
+		totalSkipped += skipped
+		if skipped > 0 {
+			log.Printf("  Skipping %d readings already in the database", skipped)
+		}
+
 		// Save to SQLite in batches
-		batchSize := 1000
-		for i := 0; i < len(readings); i += batchSize {
-			end := i + batchSize
-			if end > len(readings) {
-				end = len(readings)
+		for i := 0; i < len(fresh); i += migrateBatchSize {
+			end := i + migrateBatchSize
+			if end > len(fresh) {
+				end = len(fresh)
 			}
-			batch := readings[i:end]
+			batch := fresh[i:end]
 
 			if err := sqliteStorage.SaveReadings(device, batch); err != nil {
 				return fmt.Errorf("failed to save readings for device %s: %v", device, err)
 			}
 			totalReadings += len(batch)
-			log.Printf("  Migrated %d/%d readings", end, len(readings))
+			log.Printf("  Migrated %d/%d readings", end, len(fresh))
 		}
 	}
 
-	log.Printf("Migration complete! Migrated %d readings from %d devices", totalReadings, len(devices))
+	log.Printf("Migration complete! Migrated %d readings from %d devices (%d duplicates skipped)", totalReadings, len(devices), totalSkipped)
 	return nil
 }
 
@@ -142,5 +171,5 @@ func RunMigration(jsonDir, sqlitePath string, verify bool) error {
 	return nil
 }
 
-// To run migration as a standalone tool, create a separate package:
-// go run -tags migrate server/migrate.go -json=./data -sqlite=./data/readings.db
+// To run the migration without starting the server:
+// ./govee-server -migrate-from=json -migrate-to=sqlite -storage=./data -db-path=./data/readings.db
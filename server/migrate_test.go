@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"testing"
@@ -155,6 +156,57 @@ func TestMigrateJSONToSQLiteMultipleDevices(t *testing.T) {
 	}
 }
 
+// TestMigrateJSONToSQLitePartitioned tests migrating the time-partitioned layout the server
+// writes, and that running the migration again adds no duplicates
+func TestMigrateJSONToSQLitePartitioned(t *testing.T) {
+	tmpDir := t.TempDir()
+	jsonDir := filepath.Join(tmpDir, "data")
+	sqlitePath := filepath.Join(tmpDir, "test.db")
+
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	readingsAt := func(addr string, from time.Time, n int) []Reading {
+		readings := make([]Reading, n)
+		for i := range readings {
+			readings[i] = Reading{DeviceName: "Device", DeviceAddr: addr, TempC: 20, Humidity: 50, Timestamp: from.Add(time.Duration(i) * time.Minute), ClientID: "client1"}
+		}
+		return readings
+	}
+	// A compressed older partition, a current one split into numbered files, and a second device
+	files := map[string][]Reading{
+		"2024-03/readings_aabbccddee01.json":     readingsAt("AA:BB:CC:DD:EE:01", start, 5),
+		"2024-04/readings_aabbccddee01.000.json": readingsAt("AA:BB:CC:DD:EE:01", start.AddDate(0, 1, 0), 3),
+		"2024-04/readings_aabbccddee01.001.json": readingsAt("AA:BB:CC:DD:EE:01", start.AddDate(0, 1, 1), 2),
+		"2024-04/readings_aabbccddee02.json":     readingsAt("AA:BB:CC:DD:EE:02", start.AddDate(0, 1, 0), 4),
+	}
+	for name, readings := range files {
+		path := filepath.Join(jsonDir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		data, _ := json.Marshal(readings)
+		os.WriteFile(path, data, 0644)
+	}
+	sm := NewStorageManager(&StorageConfig{BaseDir: jsonDir, TimePartitioning: true})
+	if _, _, err := sm.compressPartition(filepath.Join(jsonDir, "2024-03")); err != nil {
+		t.Fatalf("Failed to compress partition: %v", err)
+	}
+
+	for run := 1; run <= 2; run++ {
+		if err := MigrateJSONToSQLite(jsonDir, sqlitePath); err != nil {
+			t.Fatalf("Migration run %d failed: %v", run, err)
+		}
+
+		sqliteStorage := NewSQLiteStorage(sqlitePath)
+		if err := sqliteStorage.Initialize(); err != nil {
+			t.Fatalf("Failed to initialize SQLite for verification: %v", err)
+		}
+		for addr, want := range map[string]int64{"AA:BB:CC:DD:EE:01": 10, "AA:BB:CC:DD:EE:02": 4} {
+			if count, _ := sqliteStorage.GetReadingCountByDevice(addr); count != want {
+				t.Errorf("Run %d: expected %d readings for %s, got %d", run, want, addr, count)
+			}
+		}
+		sqliteStorage.Close()
+	}
+}
+
 // TestBackupJSONData tests backup creation
 func TestBackupJSONData(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Notifier delivers alert events to one destination. Webhooks, email and Slack/Discord share
+// the firing logic in notifyAlert; another destination only needs to implement this.
+type Notifier interface {
+	Notify(event AlertEvent) error
+	// String names the destination in logs, without any credentials it holds
+	String() string
+}
+
+// notifyAlert delivers an event to webhookURL, if set, and to every notifier configured for
+// all alerts, each in the background, logging (but otherwise ignoring) failures
+func (s *Server) notifyAlert(event AlertEvent, webhookURL string) {
+	notifiers := s.alertNotifiers
+	if webhookURL != "" {
+		notifiers = append([]Notifier{&webhookNotifier{client: s.webhookClient, url: webhookURL}}, notifiers...)
+	}
+	for _, n := range notifiers {
+		go func(n Notifier) {
+			if err := n.Notify(event); err != nil {
+				log.Printf("Failed to deliver alert to %s: %v", n, err)
+			}
+		}(n)
+	}
+}
+
+// webhookNotifier posts alert events as JSON
+type webhookNotifier struct {
+	client *http.Client
+	url    string
+}
+
+func (n *webhookNotifier) Notify(event AlertEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshaling alert event: %w", err)
+	}
+
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// String gives only the host, since webhook URLs often carry a token in the path or query
+func (n *webhookNotifier) String() string {
+	if u, err := url.Parse(n.url); err == nil {
+		return "webhook on " + u.Host
+	}
+	return "webhook"
+}
+
+// defaultChatTemplate formats alerts posted to Slack and Discord. bold is the platform's markup.
+const defaultChatTemplate = `:warning: {{bold .Name}} {{.Kind}}: {{.Metric}} is {{.Value}} ({{.Op}} {{.Threshold}}) at {{.Time}}`
+
+// chatAlert is what chat message templates are executed with
+type chatAlert struct {
+	AlertEvent
+	// Name is the display name, or the device address if there is none
+	Name string
+	// Kind is the alert type in words, e.g. "low battery"
+	Kind string
+	// Time is the event timestamp in RFC 3339
+	Time string
+}
+
+// chatNotifier posts alert events to a Slack or Discord incoming webhook as a formatted message
+type chatNotifier struct {
+	client   *http.Client
+	platform string // "slack" or "discord"
+	url      string
+	tmpl     *template.Template
+}
+
+// newChatNotifier returns a notifier for a Slack or Discord incoming webhook, formatting
+// messages with tmpl (defaultChatTemplate if empty)
+func newChatNotifier(client *http.Client, platform, webhookURL, tmpl string) (*chatNotifier, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%s webhook must be an http or https URL", platform)
+	}
+
+	bold := "*%s*" // Slack mrkdwn
+	if platform == "discord" {
+		bold = "**%s**"
+	}
+	if tmpl == "" {
+		tmpl = defaultChatTemplate
+	}
+	t, err := template.New(platform).Funcs(template.FuncMap{
+		"bold": func(s string) string { return fmt.Sprintf(bold, s) },
+	}).Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid alert chat template: %v", err)
+	}
+	return &chatNotifier{client: client, platform: platform, url: webhookURL, tmpl: t}, nil
+}
+
+func (n *chatNotifier) Notify(event AlertEvent) error {
+	alert := chatAlert{
+		AlertEvent: event,
+		Name:       event.Device,
+		Kind:       strings.ReplaceAll(event.Type, "_", " "),
+		Time:       event.Timestamp.Format(time.RFC3339),
+	}
+	if event.DisplayName != "" {
+		alert.Name = event.DisplayName
+	}
+	var text strings.Builder
+	if err := n.tmpl.Execute(&text, alert); err != nil {
+		return fmt.Errorf("formatting message: %w", err)
+	}
+
+	// Slack takes the message as "text", Discord as "content"
+	field := "text"
+	if n.platform == "discord" {
+		field = "content"
+	}
+	payload, err := json.Marshal(map[string]string{field: text.String()})
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s webhook returned status %d", n.platform, resp.StatusCode)
+	}
+	return nil
+}
+
+// String leaves out the webhook URL, which is itself the credential
+func (n *chatNotifier) String() string {
+	return n.platform
+}
+
+// smtpTimeout bounds how long a single email delivery may take
+const smtpTimeout = 30 * time.Second
+
+// emailNotifier sends alert events as plain-text email through an SMTP relay
+type emailNotifier struct {
+	addr string // host:port of the SMTP server
+	from string
+	to   []string
+	// send delivers a formatted message; sendMail unless replaced in tests
+	send func(addr, from string, to []string, msg []byte) error
+}
+
+// newEmailNotifier checks the SMTP settings and returns a notifier for them. host may omit
+// the port, which defaults to 25; to is a comma-separated list of addresses.
+func newEmailNotifier(host, from, to string) (*emailNotifier, error) {
+	if host == "" || from == "" {
+		return nil, fmt.Errorf("-alert-email needs -smtp-host and -smtp-from")
+	}
+	addr := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		addr = net.JoinHostPort(host, "25")
+	}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -smtp-from address %q: %v", from, err)
+	}
+	recipients, err := mail.ParseAddressList(to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -alert-email address list %q: %v", to, err)
+	}
+
+	n := &emailNotifier{addr: addr, from: sender.Address, send: sendMail}
+	for _, r := range recipients {
+		n.to = append(n.to, r.Address)
+	}
+	return n, nil
+}
+
+func (n *emailNotifier) Notify(event AlertEvent) error {
+	return n.send(n.addr, n.from, n.to, formatAlertEmail(n.from, n.to, event))
+}
+
+func (n *emailNotifier) String() string {
+	return "email to " + strings.Join(n.to, ", ")
+}
+
+// formatAlertEmail renders an alert event as a plain-text email message
+func formatAlertEmail(from string, to []string, event AlertEvent) []byte {
+	device := event.Device
+	if event.DisplayName != "" {
+		device = fmt.Sprintf("%s (%s)", event.DisplayName, event.Device)
+	}
+	// Header values must stay on one line
+	oneLine := strings.NewReplacer("\r", " ", "\n", " ")
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", oneLine.Replace(fmt.Sprintf("Govee alert: %s %s", device, strings.ReplaceAll(event.Type, "_", " "))))
+	fmt.Fprintf(&b, "Date: %s\r\n", event.Timestamp.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	fmt.Fprintf(&b, "%s\r\n\r\n", event.Message)
+	fmt.Fprintf(&b, "Device:    %s\r\n", device)
+	fmt.Fprintf(&b, "Alert:     %s\r\n", event.Type)
+	if event.RuleID != "" {
+		fmt.Fprintf(&b, "Rule:      %s\r\n", event.RuleID)
+	}
+	fmt.Fprintf(&b, "Condition: %s %s %g\r\n", event.Metric, event.Op, event.Threshold)
+	fmt.Fprintf(&b, "Value:     %g\r\n", event.Value)
+	fmt.Fprintf(&b, "Time:      %s\r\n", event.Timestamp.Format(time.RFC3339))
+	return b.Bytes()
+}
+
+// sendMail delivers a message like smtp.SendMail, but with a timeout so an unresponsive
+// relay can't hold a goroutine forever. STARTTLS is used when the server offers it.
+func sendMail(addr, from string, to []string, msg []byte) error {
+	conn, err := net.DialTimeout("tcp", addr, smtpTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	host, _, _ := net.SplitHostPort(addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sentEmail is a message captured by a mock emailNotifier
+type sentEmail struct {
+	addr, from string
+	to         []string
+	msg        string
+}
+
+// mockEmailNotifier returns an email notifier that captures messages instead of sending them
+func mockEmailNotifier(t *testing.T, to string) (*emailNotifier, chan sentEmail) {
+	t.Helper()
+	n, err := newEmailNotifier("mail.example.com", "govee@example.com", to)
+	if err != nil {
+		t.Fatalf("newEmailNotifier: %v", err)
+	}
+	sent := make(chan sentEmail, 10)
+	n.send = func(addr, from string, to []string, msg []byte) error {
+		sent <- sentEmail{addr: addr, from: from, to: to, msg: string(msg)}
+		return nil
+	}
+	return n, sent
+}
+
+// TestNewEmailNotifier tests validation of the SMTP flags
+func TestNewEmailNotifier(t *testing.T) {
+	tests := []struct {
+		host, from, to string
+		wantAddr       string
+		wantTo         []string
+		wantErr        bool
+	}{
+		{"mail.example.com", "govee@example.com", "me@example.com", "mail.example.com:25", []string{"me@example.com"}, false},
+		{"mail.example.com:587", "Govee <govee@example.com>", "a@example.com, B <b@example.com>", "mail.example.com:587", []string{"a@example.com", "b@example.com"}, false},
+		{"", "govee@example.com", "me@example.com", "", nil, true},
+		{"mail.example.com", "", "me@example.com", "", nil, true},
+		{"mail.example.com", "not an address", "me@example.com", "", nil, true},
+		{"mail.example.com", "govee@example.com", "me@example.com, nobody", "", nil, true},
+	}
+	for _, tt := range tests {
+		n, err := newEmailNotifier(tt.host, tt.from, tt.to)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("newEmailNotifier(%q, %q, %q) expected an error", tt.host, tt.from, tt.to)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("newEmailNotifier(%q, %q, %q) unexpected error: %v", tt.host, tt.from, tt.to, err)
+			continue
+		}
+		if n.addr != tt.wantAddr || strings.Join(n.to, ",") != strings.Join(tt.wantTo, ",") {
+			t.Errorf("newEmailNotifier(%q, %q, %q) = %s to %v, expected %s to %v", tt.host, tt.from, tt.to, n.addr, n.to, tt.wantAddr, tt.wantTo)
+		}
+	}
+}
+
+// TestEmailAlerts tests that threshold and low-battery alerts are emailed alongside webhooks
+func TestEmailAlerts(t *testing.T) {
+	webhookCalls := make(chan struct{}, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		webhookCalls <- struct{}{}
+	}))
+	defer webhook.Close()
+
+	email, sent := mockEmailNotifier(t, "me@example.com, you@example.com")
+	server := createTestServer(t)
+	server.alertNotifiers = []Notifier{email}
+	server.config.LowBatteryThreshold = 20
+	deviceAddr := "AA:BB:CC:DD:EE:FF"
+	server.deviceAliases[deviceAddr] = "Wine Cellar"
+
+	body := fmt.Sprintf(`{"device":%q,"metric":"temp_c","op":">","value":15,"webhook_url":%q}`, deviceAddr, webhook.URL)
+	req := httptest.NewRequest("POST", "/alerts", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	server.handleAlerts(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	expectEmail := func() sentEmail {
+		t.Helper()
+		select {
+		case m := <-sent:
+			return m
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected an alert email")
+		}
+		return sentEmail{}
+	}
+
+	server.addReading(Reading{
+		DeviceName: "GVH5075_WINE",
+		DeviceAddr: deviceAddr,
+		TempC:      16.5,
+		Humidity:   60.0,
+		Battery:    10,
+		Timestamp:  time.Now(),
+		ClientID:   "test-client",
+	})
+
+	m := expectEmail()
+	if m.addr != "mail.example.com:25" || m.from != "govee@example.com" || len(m.to) != 2 {
+		t.Errorf("Unexpected envelope: %s from %s to %v", m.addr, m.from, m.to)
+	}
+	for _, want := range []string{
+		"To: me@example.com, you@example.com\r\n",
+		"Subject: Govee alert: Wine Cellar (AA:BB:CC:DD:EE:FF) threshold\r\n",
+		"Content-Type: text/plain; charset=utf-8\r\n\r\n",
+		"Condition: temp_c > 15\r\n",
+		"Value:     16.5\r\n",
+	} {
+		if !strings.Contains(m.msg, want) {
+			t.Errorf("Expected email to contain %q, got:\n%s", want, m.msg)
+		}
+	}
+	select {
+	case <-webhookCalls:
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the rule's webhook to fire as well")
+	}
+
+	// Built-in alerts are emailed even without -alert-webhook
+	server.scanDeviceAlerts(time.Now())
+	m = expectEmail()
+	if !strings.Contains(m.msg, "Subject: Govee alert: Wine Cellar (AA:BB:CC:DD:EE:FF) low battery\r\n") {
+		t.Errorf("Expected a low battery email, got:\n%s", m.msg)
+	}
+}
+
+// TestSendMail tests the SMTP conversation against a minimal in-process server
+func TestSendMail(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	transcript := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		r := bufio.NewReader(conn)
+		reply := func(s string) { fmt.Fprintf(conn, "%s\r\n", s) }
+		reply("220 localhost ESMTP")
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			switch {
+			case inData && line == ".":
+				inData = false
+				reply("250 queued")
+			case inData:
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250 localhost")
+			case line == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case line == "QUIT":
+				reply("221 bye")
+				transcript <- lines
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+		transcript <- lines
+	}()
+
+	msg := formatAlertEmail("govee@example.com", []string{"me@example.com"}, AlertEvent{
+		Type:      "offline",
+		Device:    "AA:BB:CC:DD:EE:FF",
+		Message:   "AA:BB:CC:DD:EE:FF not seen for 1h0m0s",
+		Timestamp: time.Now(),
+	})
+	if err := sendMail(listener.Addr().String(), "govee@example.com", []string{"me@example.com"}, msg); err != nil {
+		t.Fatalf("sendMail: %v", err)
+	}
+
+	lines := strings.Join(<-transcript, "\n")
+	for _, want := range []string{
+		"MAIL FROM:<govee@example.com>",
+		"RCPT TO:<me@example.com>",
+		"Subject: Govee alert: AA:BB:CC:DD:EE:FF offline",
+		"AA:BB:CC:DD:EE:FF not seen for 1h0m0s",
+	} {
+		if !strings.Contains(lines, want) {
+			t.Errorf("Expected the SMTP conversation to contain %q, got:\n%s", want, lines)
+		}
+	}
+}
+
+// TestChatNotifier tests the Slack and Discord messages, a custom template and error statuses
+func TestChatNotifier(t *testing.T) {
+	var status atomic.Int32
+	posted := make(chan map[string]string, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Invalid chat payload: %v", err)
+		}
+		posted <- body
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer hook.Close()
+
+	event := AlertEvent{
+		Type:        "low_battery",
+		Device:      "AA:BB:CC:DD:EE:FF",
+		DisplayName: "Wine Cellar",
+		Metric:      "battery",
+		Op:          "<",
+		Threshold:   15,
+		Value:       12,
+		Message:     "AA:BB:CC:DD:EE:FF battery is at 12%",
+		Timestamp:   time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		platform, tmpl string
+		field, want    string
+	}{
+		{"slack", "", "text", ":warning: *Wine Cellar* low battery: battery is 12 (< 15) at 2024-01-15T10:30:00Z"},
+		{"discord", "", "content", ":warning: **Wine Cellar** low battery: battery is 12 (< 15) at 2024-01-15T10:30:00Z"},
+		{"slack", "{{.Device}}: {{.Message}}", "text", "AA:BB:CC:DD:EE:FF: AA:BB:CC:DD:EE:FF battery is at 12%"},
+	}
+	status.Store(http.StatusOK)
+	for _, tt := range tests {
+		n, err := newChatNotifier(hook.Client(), tt.platform, hook.URL, tt.tmpl)
+		if err != nil {
+			t.Fatalf("newChatNotifier(%s): %v", tt.platform, err)
+		}
+		if err := n.Notify(event); err != nil {
+			t.Errorf("%s Notify: %v", tt.platform, err)
+		}
+		if got := (<-posted)[tt.field]; got != tt.want {
+			t.Errorf("%s %s = %q, expected %q", tt.platform, tt.field, got, tt.want)
+		}
+	}
+
+	// A rejected post is reported, not ignored
+	status.Store(http.StatusForbidden)
+	n, _ := newChatNotifier(hook.Client(), "slack", hook.URL, "")
+	if err := n.Notify(event); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected an error for status 403, got %v", err)
+	}
+	<-posted
+
+	if _, err := newChatNotifier(hook.Client(), "slack", hook.URL, "{{.Name"); err == nil {
+		t.Error("Expected an invalid template to be rejected")
+	}
+	if _, err := newChatNotifier(hook.Client(), "discord", "ftp://example.com/hook", ""); err == nil {
+		t.Error("Expected a non-http webhook URL to be rejected")
+	}
+}
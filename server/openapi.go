@@ -0,0 +1,57 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:generate cp ../openapi/openapi.yaml openapi.yaml
+
+// openAPISpec is a copy of openapi/openapi.yaml, which is the one to edit: go generate
+// refreshes the copy (the Docker build only sees this directory), and TestOpenAPISpecInSync
+// fails while it's stale
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+	openAPIErr  error
+)
+
+// openAPIDocument returns the OpenAPI document as JSON, converting the embedded YAML the
+// first time it's asked for
+func openAPIDocument() ([]byte, error) {
+	openAPIOnce.Do(func() {
+		var doc map[string]interface{}
+		if openAPIErr = yaml.Unmarshal(openAPISpec, &doc); openAPIErr != nil {
+			return
+		}
+		openAPIJSON, openAPIErr = json.Marshal(doc)
+	})
+	return openAPIJSON, openAPIErr
+}
+
+// handleOpenAPI serves the OpenAPI 3 document describing the API, so integrators and tools
+// like Swagger UI can find it on the server itself
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	doc, err := openAPIDocument()
+	if err != nil {
+		log.Printf("Failed to convert the OpenAPI document: %v", err)
+		http.Error(w, "OpenAPI document unavailable", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(doc)
+}
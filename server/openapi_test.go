@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestOpenAPIDocument tests that /openapi.json is valid JSON listing the core endpoints,
+// schemas and the X-API-Key security scheme
+func TestOpenAPIDocument(t *testing.T) {
+	server := createTestServer(t)
+	w := httptest.NewRecorder()
+	server.handleOpenAPI(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Expected a JSON document, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	var doc struct {
+		OpenAPI    string                     `json:"openapi"`
+		Paths      map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			SecuritySchemes map[string]struct {
+				Type string `json:"type"`
+				In   string `json:"in"`
+				Name string `json:"name"`
+			} `json:"securitySchemes"`
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("Expected an OpenAPI 3 document, got version %q", doc.OpenAPI)
+	}
+	for _, path := range []string{"/readings", "/devices", "/clients", "/stats", "/dashboard/data", "/api/keys"} {
+		if _, ok := doc.Paths[path]; !ok {
+			t.Errorf("Expected path %s in the document", path)
+		}
+	}
+	for _, schema := range []string{"Reading", "DeviceStatus", "ClientStatus"} {
+		if _, ok := doc.Components.Schemas[schema]; !ok {
+			t.Errorf("Expected schema %s in the document", schema)
+		}
+	}
+	if scheme := doc.Components.SecuritySchemes["ApiKeyAuth"]; scheme.Type != "apiKey" || scheme.In != "header" || scheme.Name != "X-API-Key" {
+		t.Errorf("Expected the X-API-Key header scheme, got %+v", scheme)
+	}
+}
+
+// TestOpenAPISpecInSync tests that the embedded copy of openapi/openapi.yaml is up to date
+func TestOpenAPISpecInSync(t *testing.T) {
+	source, err := os.ReadFile("../openapi/openapi.yaml")
+	if err != nil {
+		t.Skipf("openapi/openapi.yaml not available: %v", err)
+	}
+	if !bytes.Equal(source, openAPISpec) {
+		t.Error("server/openapi.yaml is out of date with openapi/openapi.yaml; run go generate ./server")
+	}
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -168,6 +173,39 @@ func TestParsePartitionTime(t *testing.T) {
 	}
 }
 
+// TestWeeklyPartitionRoundTrip tests that a weekly partition name parses back to the Monday of the same ISO week
+func TestWeeklyPartitionRoundTrip(t *testing.T) {
+	config := &StorageConfig{
+		BaseDir:           t.TempDir(),
+		TimePartitioning:  true,
+		PartitionInterval: 7 * 24 * time.Hour,
+	}
+
+	sm := NewStorageManager(config)
+
+	// Cover several year boundaries, including years whose week 1 starts in December
+	// and years with a week 53
+	start := time.Date(2019, 12, 1, 12, 0, 0, 0, time.UTC)
+	end := time.Date(2027, 1, 31, 12, 0, 0, 0, time.UTC)
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		dir := filepath.Base(sm.getPartitionDirForTime(day))
+		parsed, err := sm.parsePartitionTime(dir)
+		if err != nil {
+			t.Fatalf("parsePartitionTime(%s) failed: %v", dir, err)
+		}
+
+		wantYear, wantWeek := day.ISOWeek()
+		gotYear, gotWeek := parsed.ISOWeek()
+		if gotYear != wantYear || gotWeek != wantWeek {
+			t.Errorf("%s -> %s -> %s: got week %d-W%02d, want %d-W%02d",
+				day.Format("2006-01-02"), dir, parsed.Format("2006-01-02"), gotYear, gotWeek, wantYear, wantWeek)
+		}
+		if parsed.Weekday() != time.Monday {
+			t.Errorf("%s parsed to %s, a %s rather than a Monday", dir, parsed.Format("2006-01-02"), parsed.Weekday())
+		}
+	}
+}
+
 // TestIsCompressed tests compressed file detection
 func TestIsCompressed(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -217,8 +255,8 @@ func TestServerSaveAndLoadData(t *testing.T) {
 	auth := &AuthConfig{
 		EnableAuth: true,
 		AdminKey:   "test-admin-key",
-		APIKeys: map[string]string{
-			"client-key": "test-client",
+		APIKeys: map[string]APIKey{
+			"client-key": {ClientID: "test-client", Enabled: true},
 		},
 	}
 
@@ -309,7 +347,7 @@ func TestEnforceRetention(t *testing.T) {
 	sm := NewStorageManager(config)
 
 	// Enforce retention
-	err := sm.enforceRetention()
+	_, err := sm.enforceRetention(context.Background())
 	if err != nil {
 		t.Fatalf("Failed to enforce retention: %v", err)
 	}
@@ -321,6 +359,178 @@ func TestEnforceRetention(t *testing.T) {
 	}
 }
 
+// TestEnforceDeviceRetention tests that per-device retention periods remove a device's files
+// from partitions that are kept, and keep partitions the global period would remove
+func TestEnforceDeviceRetention(t *testing.T) {
+	tmpDir := t.TempDir()
+	now := time.Now()
+
+	writeReadings := func(partition, addr string) {
+		dir := filepath.Join(tmpDir, partition)
+		os.MkdirAll(dir, 0755)
+		sanitized, _ := sanitizeDeviceAddr(addr)
+		data, _ := json.Marshal([]Reading{{DeviceName: "Test", DeviceAddr: addr, TempC: 20.0, Humidity: 40.0, Timestamp: now}})
+		os.WriteFile(filepath.Join(dir, readingsFileName(sanitized, -1)), data, 0644)
+	}
+	freezer, guest, other := "AA:BB:CC:DD:EE:01", "AA:BB:CC:DD:EE:02", "AA:BB:CC:DD:EE:03"
+	recent := now.AddDate(0, -2, 0).Format("2006-01")
+	old := now.AddDate(-3, 0, 0).Format("2006-01")
+	older := now.AddDate(-3, -1, 0).Format("2006-01")
+	writeReadings(recent, freezer)
+	writeReadings(recent, guest)
+	writeReadings(old, guest)
+	writeReadings(old, other)
+	writeReadings(older, freezer)
+
+	sm := NewStorageManager(&StorageConfig{
+		BaseDir:           tmpDir,
+		TimePartitioning:  true,
+		PartitionInterval: 720 * time.Hour,
+		RetentionPeriod:   365 * 24 * time.Hour,
+		DeviceRetention: map[string]time.Duration{
+			"aabbccddee01": 0,
+			"aabbccddee02": 30 * 24 * time.Hour,
+		},
+	})
+
+	summary, err := sm.enforceRetention(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to enforce retention: %v", err)
+	}
+	if summary.PartitionsRemoved != 1 || summary.FilesRemoved != 1 {
+		t.Errorf("Expected 1 partition and 1 file removed, got %+v", summary)
+	}
+
+	exists := func(partition, file string) bool {
+		_, err := os.Stat(filepath.Join(tmpDir, partition, file))
+		return err == nil
+	}
+	if !exists(recent, "readings_aabbccddee01.json") || exists(recent, "readings_aabbccddee02.json") {
+		t.Error("Expected only the guest room's 30-day retention to apply to the recent partition")
+	}
+	if exists(old, "") {
+		t.Error("Expected the partition with only expired devices to be removed")
+	}
+	if !exists(older, "readings_aabbccddee01.json") {
+		t.Error("Expected the freezer's data to be kept forever")
+	}
+}
+
+// TestKeepAggregatesAfterRetention tests that with KeepAggregatesForever, readings removed by
+// retention stay queryable as hourly aggregates
+func TestKeepAggregatesAfterRetention(t *testing.T) {
+	server := createTestServer(t)
+	sm := server.storageManager
+	sm.config.TimePartitioning = true
+	sm.config.PartitionInterval = 720 * time.Hour
+	sm.config.RetentionPeriod = 30 * 24 * time.Hour
+	sm.config.KeepAggregatesForever = true
+
+	deviceAddr := "AA:BB:CC:DD:EE:FF"
+	hour := time.Now().AddDate(0, -3, 0).UTC().Truncate(time.Hour)
+	readings := []Reading{
+		{DeviceName: "Test", DeviceAddr: deviceAddr, TempC: 20.0, Humidity: 40.0, Timestamp: hour.Add(5 * time.Minute)},
+		{DeviceName: "Test", DeviceAddr: deviceAddr, TempC: 22.0, Humidity: 50.0, Timestamp: hour.Add(35 * time.Minute)},
+		{DeviceName: "Test", DeviceAddr: deviceAddr, TempC: 24.0, Humidity: 60.0, Timestamp: hour.Add(70 * time.Minute)},
+	}
+	partition := filepath.Join(sm.config.BaseDir, hour.Format("2006-01"))
+	os.MkdirAll(partition, 0755)
+	data, _ := json.Marshal(readings)
+	os.WriteFile(filepath.Join(partition, "readings_aabbccddeeff.json"), data, 0644)
+
+	summary, err := sm.enforceRetention(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to enforce retention: %v", err)
+	}
+	if summary.PartitionsRemoved != 1 {
+		t.Fatalf("Expected the expired partition to be removed, got %+v", summary)
+	}
+	if remaining, _ := server.getDeviceReadings(deviceAddr, hour.Add(-time.Hour), hour.Add(3*time.Hour)); len(remaining) != 0 {
+		t.Fatalf("Expected the raw readings to be gone, got %d", len(remaining))
+	}
+
+	url := fmt.Sprintf("/aggregates/hourly?device=%s&from=%s&to=%s", deviceAddr,
+		hour.Add(-time.Hour).Format(time.RFC3339), hour.Add(3*time.Hour).Format(time.RFC3339))
+	w := httptest.NewRecorder()
+	server.handleHourlyAggregates(w, httptest.NewRequest("GET", url, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Aggregates []AggregateReading `json:"aggregates"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Aggregates) != 2 {
+		t.Fatalf("Expected 2 hourly aggregates, got %+v", response.Aggregates)
+	}
+	latest, earliest := response.Aggregates[0], response.Aggregates[1]
+	if !earliest.Timestamp.Equal(hour) || earliest.Count != 2 || earliest.AvgTempC != 21.0 || earliest.MinHumidity != 40.0 || earliest.MaxHumidity != 50.0 {
+		t.Errorf("Unexpected aggregate for the first hour: %+v", earliest)
+	}
+	if !latest.Timestamp.Equal(hour.Add(time.Hour)) || latest.Count != 1 || latest.AvgTempC != 24.0 {
+		t.Errorf("Unexpected aggregate for the second hour: %+v", latest)
+	}
+}
+
+// TestDiskUsage tests the storage directory's disk usage totals, by partition, and their
+// /admin/storage and /metrics output
+func TestDiskUsage(t *testing.T) {
+	server := createTestServer(t)
+	baseDir := server.storageManager.config.BaseDir
+
+	files := map[string]int{
+		"devices.json":                          100,
+		"2024-01/readings_aabbccddeeff.json":    1000,
+		"2024-01/readings_aabbccddee01.json":    500,
+		"2024-02/readings_aabbccddeeff.json.gz": 250,
+	}
+	for name, size := range files {
+		path := filepath.Join(baseDir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, make([]byte, size), 0644)
+	}
+	os.MkdirAll(filepath.Join(baseDir, "2024-03"), 0755)
+
+	usage, err := server.storageManager.DiskUsage()
+	if err != nil {
+		t.Fatalf("DiskUsage failed: %v", err)
+	}
+	if usage.TotalBytes != 1850 {
+		t.Errorf("Expected 1850 bytes in total, got %d", usage.TotalBytes)
+	}
+	want := []PartitionUsage{
+		{Partition: ".", Files: 1, Bytes: 100},
+		{Partition: "2024-01", Files: 2, Bytes: 1500},
+		{Partition: "2024-02", Files: 1, Bytes: 250},
+		{Partition: "2024-03", Files: 0, Bytes: 0},
+	}
+	if fmt.Sprint(usage.Partitions) != fmt.Sprint(want) {
+		t.Errorf("Expected partitions %v, got %v", want, usage.Partitions)
+	}
+
+	w := httptest.NewRecorder()
+	server.handleStorageUsage(w, httptest.NewRequest("GET", "/admin/storage", nil))
+	var body DiskUsage
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body.TotalBytes != 1850 || len(body.Partitions) != 4 {
+		t.Errorf("Unexpected /admin/storage response %+v (%v)", body, err)
+	}
+
+	w = httptest.NewRecorder()
+	server.handleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		"# TYPE govee_storage_bytes gauge",
+		`govee_storage_bytes{partition="."} 100`,
+		`govee_storage_bytes{partition="2024-01"} 1500`,
+		`govee_storage_files{partition="2024-02"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("Expected /metrics to contain %q, got:\n%s", line, w.Body.String())
+		}
+	}
+}
+
 // TestCompressPartition tests partition compression
 func TestCompressPartition(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -350,7 +560,7 @@ func TestCompressPartition(t *testing.T) {
 	sm := NewStorageManager(config)
 
 	// Compress the partition
-	err := sm.compressPartition(partitionDir)
+	_, _, err := sm.compressPartition(partitionDir)
 	if err != nil {
 		t.Fatalf("Failed to compress partition: %v", err)
 	}
@@ -367,6 +577,72 @@ func TestCompressPartition(t *testing.T) {
 	}
 }
 
+// TestCompressPartitionCodecs tests compressing a partition with each -compression codec and
+// reading it back, including partitions compressed with a codec other than the configured one
+func TestCompressPartitionCodecs(t *testing.T) {
+	testData := []Reading{
+		{DeviceName: "Test Device", DeviceAddr: "AA:BB:CC:DD:EE:FF", TempC: 25.0, Humidity: 50.0, Timestamp: time.Now().Add(-time.Hour).Truncate(time.Second)},
+		{DeviceName: "Test Device", DeviceAddr: "AA:BB:CC:DD:EE:FF", TempC: 25.5, Humidity: 51.0, Timestamp: time.Now().Truncate(time.Second)},
+	}
+	jsonData, _ := json.Marshal(testData)
+
+	tests := []struct {
+		compression string
+		ext         string
+	}{
+		{"", ".gz"}, // gzip is the default
+		{"gzip", ".gz"},
+		{"zstd", ".zst"},
+		{"none", ""},
+	}
+	for _, tt := range tests {
+		t.Run("compression="+tt.compression, func(t *testing.T) {
+			partitionDir := filepath.Join(t.TempDir(), "2022-01")
+			os.MkdirAll(partitionDir, 0755)
+			jsonFile := filepath.Join(partitionDir, "readings_AABBCCDDEEFF.json")
+			os.WriteFile(jsonFile, jsonData, 0644)
+
+			sm := NewStorageManager(&StorageConfig{BaseDir: filepath.Dir(partitionDir), CompressOldData: true, Compression: tt.compression})
+			files, _, err := sm.compressPartition(partitionDir)
+			if err != nil {
+				t.Fatalf("Failed to compress partition: %v", err)
+			}
+
+			if tt.ext == "" {
+				if files != 0 || isCompressed(partitionDir) {
+					t.Errorf("Expected no compression, got %d files compressed", files)
+				}
+			} else {
+				if _, err := os.Stat(jsonFile + tt.ext); err != nil || files != 1 {
+					t.Errorf("Expected %s to be created, got %d files compressed (%v)", jsonFile+tt.ext, files, err)
+				}
+				if _, err := os.Stat(jsonFile); err == nil {
+					t.Error("Original file was not removed after compression")
+				}
+				if !isCompressed(partitionDir) {
+					t.Error("Expected the partition to count as compressed")
+				}
+			}
+
+			// Reading doesn't depend on the configured codec
+			readings, err := loadDeviceFiles(partitionDir, "AABBCCDDEEFF")
+			if err != nil || len(readings) != len(testData) || !readings[1].Timestamp.Equal(testData[1].Timestamp) {
+				t.Errorf("Expected %d readings back, got %d (%v)", len(testData), len(readings), err)
+			}
+
+			// A second run leaves already compressed files alone, even with another codec
+			other := NewStorageManager(&StorageConfig{BaseDir: filepath.Dir(partitionDir), Compression: "zstd"})
+			if files, _, err := other.compressPartition(partitionDir); err != nil || (tt.ext != "" && files != 0) {
+				t.Errorf("Expected compressed files to be skipped, got %d compressed (%v)", files, err)
+			}
+		})
+	}
+
+	if err := validateCompression("brotli"); err == nil {
+		t.Error("Expected an unknown codec to be rejected")
+	}
+}
+
 // TestLoadReadingsFromFile tests loading readings from a file
 func TestLoadReadingsFromFile(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -388,14 +664,8 @@ func TestLoadReadingsFromFile(t *testing.T) {
 	jsonFile := filepath.Join(tmpDir, "readings_AABBCCDDEEFF.json")
 	os.WriteFile(jsonFile, jsonData, 0644)
 
-	config := &StorageConfig{
-		BaseDir: tmpDir,
-	}
-
-	sm := NewStorageManager(config)
-
 	// Load readings
-	loaded, err := sm.loadReadingsFromFile(jsonFile)
+	loaded, err := loadReadingsFromFile(jsonFile)
 	if err != nil {
 		t.Fatalf("Failed to load readings: %v", err)
 	}
@@ -468,6 +738,74 @@ func TestLoadReadings(t *testing.T) {
 	}
 }
 
+// TestSaveReadingsRollsFiles tests that readings beyond MaxReadingsPerFile are split into numbered files
+func TestSaveReadingsRollsFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	config := &StorageConfig{
+		BaseDir:            tmpDir,
+		TimePartitioning:   true,
+		PartitionInterval:  720 * time.Hour,
+		MaxReadingsPerFile: 1000,
+	}
+
+	sm := NewStorageManager(config)
+
+	start := time.Now().Add(-time.Hour)
+	readings := make([]Reading, 2500)
+	for i := range readings {
+		readings[i] = Reading{
+			DeviceName: "Test Device",
+			DeviceAddr: "AA:BB:CC:DD:EE:FF",
+			TempC:      20.0 + float64(i%10),
+			Humidity:   50.0,
+			Timestamp:  start.Add(time.Duration(i) * time.Second),
+		}
+	}
+
+	if err := sm.saveReadings("AABBCCDDEEFF", readings); err != nil {
+		t.Fatalf("Failed to save readings: %v", err)
+	}
+
+	partitionDir := sm.getCurrentPartitionDir()
+	files, _ := filepath.Glob(filepath.Join(partitionDir, "readings_*.json"))
+	expected := []string{"readings_aabbccddeeff.000.json", "readings_aabbccddeeff.001.json", "readings_aabbccddeeff.002.json"}
+	if len(files) != len(expected) {
+		t.Fatalf("Expected %d files, got %v", len(expected), files)
+	}
+	for i, file := range files {
+		if filepath.Base(file) != expected[i] {
+			t.Errorf("Expected file %s, got %s", expected[i], filepath.Base(file))
+		}
+	}
+
+	loaded, err := sm.loadReadings("AABBCCDDEEFF", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Failed to load readings: %v", err)
+	}
+	if len(loaded) != len(readings) {
+		t.Fatalf("Expected %d readings, got %d", len(readings), len(loaded))
+	}
+	for i := range loaded {
+		if !loaded[i].Timestamp.Equal(readings[i].Timestamp) {
+			t.Fatalf("Reading %d out of order", i)
+		}
+	}
+
+	// Saving fewer readings again replaces the numbered files with a single one
+	if err := sm.saveReadings("AABBCCDDEEFF", readings[:500]); err != nil {
+		t.Fatalf("Failed to save readings: %v", err)
+	}
+	files, _ = filepath.Glob(filepath.Join(partitionDir, "readings_*.json"))
+	if len(files) != 1 || filepath.Base(files[0]) != "readings_aabbccddeeff.json" {
+		t.Errorf("Expected only the unnumbered file, got %v", files)
+	}
+	loaded, _ = sm.loadReadings("AABBCCDDEEFF", time.Time{}, time.Time{})
+	if len(loaded) != 500 {
+		t.Errorf("Expected 500 readings after re-save, got %d", len(loaded))
+	}
+}
+
 // BenchmarkSaveReadings benchmarks saving readings
 func BenchmarkSaveReadings(b *testing.B) {
 	tmpDir := b.TempDir()
@@ -497,3 +835,41 @@ func BenchmarkSaveReadings(b *testing.B) {
 		sm.saveReadings("AABBCCDDEEFF", readings)
 	}
 }
+
+// BenchmarkLoadReadings benchmarks loading a device's readings from 24 monthly partitions with
+// different numbers of workers (loadReadings uses one per CPU); run with -cpu to vary GOMAXPROCS
+func BenchmarkLoadReadings(b *testing.B) {
+	tmpDir := b.TempDir()
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	var partitions []string
+	for month := 0; month < 24; month++ {
+		partitionStart := start.AddDate(0, month, 0)
+		readings := make([]Reading, 2000)
+		for i := range readings {
+			readings[i] = Reading{
+				DeviceName: "Benchmark Device",
+				DeviceAddr: "AA:BB:CC:DD:EE:FF",
+				TempC:      25.0,
+				Humidity:   50.0,
+				Timestamp:  partitionStart.Add(time.Duration(i) * time.Minute),
+			}
+		}
+		partition := filepath.Join(tmpDir, partitionStart.Format("2006-01"))
+		os.MkdirAll(partition, 0755)
+		data, _ := json.Marshal(readings)
+		os.WriteFile(filepath.Join(partition, "readings_aabbccddeeff.json"), data, 0644)
+		partitions = append(partitions, partition)
+	}
+
+	for _, workers := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				readings, err := loadPartitions(partitions, "aabbccddeeff", workers)
+				if err != nil || len(readings) != 24*2000 {
+					b.Fatalf("Expected %d readings, got %d (%v)", 24*2000, len(readings), err)
+				}
+			}
+		})
+	}
+}
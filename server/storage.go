@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
@@ -13,6 +15,9 @@ import (
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // StorageBackend defines the interface for different storage implementations
@@ -24,17 +29,19 @@ type StorageBackend interface {
 	// SaveReadings saves readings for a device
 	SaveReadings(deviceAddr string, readings []Reading) error
 
-	// LoadReadings loads readings for a device within a time range
+	// LoadReadings loads readings for a device within a time range, oldest first
 	LoadReadings(deviceAddr string, fromTime, toTime time.Time) ([]Reading, error)
 
-	// LoadAllDeviceReadings loads all readings for a device
+	// LoadAllDeviceReadings loads all readings for a device, oldest first
 	LoadAllDeviceReadings(deviceAddr string) ([]Reading, error)
 
 	// GetDevices returns a list of all unique device addresses
 	GetDevices() ([]string, error)
 
-	// DeleteOldReadings removes readings older than the retention period
-	DeleteOldReadings(cutoffTime time.Time) error
+	// DeleteOldReadings removes readings older than cutoffTime or, for the devices in
+	// deviceCutoffs (keyed by sanitized address), older than their own cutoff. A zero cutoff
+	// keeps a device's readings.
+	DeleteOldReadings(cutoffTime time.Time, deviceCutoffs map[string]time.Time) error
 
 	// GetReadingCount returns the total number of readings
 	GetReadingCount() (int64, error)
@@ -51,6 +58,10 @@ type StorageBackend interface {
 	// GetHourlyAggregates returns hourly aggregated data
 	GetHourlyAggregates(deviceAddr string, fromTime, toTime time.Time) ([]AggregateReading, error)
 
+	// GetDeviceStats returns min, max and average of a device's readings within a time range
+	// (zero times leave it open), with the same keys as the server's /stats
+	GetDeviceStats(deviceAddr string, fromTime, toTime time.Time) (map[string]interface{}, error)
+
 	// Close closes the storage backend
 	Close() error
 }
@@ -73,6 +84,20 @@ type SQLiteStorage struct {
 	db       *sql.DB
 	dbPath   string
 	mu       sync.RWMutex
+	// End of the last hour rolled up into hourly_aggregates by ComputeAggregates
+	rolledUpTo time.Time
+	// Keep hourly aggregates when DeleteOldReadings removes their readings
+	keepAggregates bool
+
+	// Write-behind queue of readings awaiting a batched insert (see StartWriteBuffer)
+	queueMu     sync.Mutex
+	queue       []queuedReading
+	dropped     int
+	batchSize   int
+	queueClosed bool
+	flushNow    chan struct{}
+	stopFlusher chan struct{}
+	flusherDone chan struct{}
 }
 
 // NewSQLiteStorage creates a new SQLite storage backend
@@ -82,6 +107,14 @@ func NewSQLiteStorage(dbPath string) *SQLiteStorage {
 	}
 }
 
+// SetKeepAggregatesForever makes DeleteOldReadings roll readings up into hourly_aggregates
+// before removing them, and leave the aggregates in place
+func (s *SQLiteStorage) SetKeepAggregatesForever(keep bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keepAggregates = keep
+}
+
 // Initialize sets up the SQLite database and creates tables
 func (s *SQLiteStorage) Initialize() error {
 	s.mu.Lock()
@@ -115,6 +148,11 @@ func (s *SQLiteStorage) Initialize() error {
 		dew_point_c REAL NOT NULL,
 		dew_point_f REAL NOT NULL,
 		steam_pressure REAL NOT NULL,
+		heat_index_c REAL NOT NULL DEFAULT 0,
+		heat_index_f REAL NOT NULL DEFAULT 0,
+		vpd REAL NOT NULL DEFAULT 0,
+		frost_point_c REAL NOT NULL DEFAULT 0,
+		mixing_ratio REAL NOT NULL DEFAULT 0,
 		battery INTEGER NOT NULL,
 		rssi INTEGER NOT NULL,
 		timestamp DATETIME NOT NULL,
@@ -148,6 +186,9 @@ func (s *SQLiteStorage) Initialize() error {
 	if _, err := s.db.Exec(schema); err != nil {
 		return fmt.Errorf("failed to create schema: %v", err)
 	}
+	if err := s.addMissingReadingColumns(); err != nil {
+		return err
+	}
 
 	// Set pragmas for better performance
 	pragmas := []string{
@@ -164,34 +205,120 @@ func (s *SQLiteStorage) Initialize() error {
 	return nil
 }
 
+// addedReadingColumns are readings columns added after the table was first released, so
+// databases created by older versions can be upgraded in place
+var addedReadingColumns = []struct{ name, definition string }{
+	{"heat_index_c", "REAL NOT NULL DEFAULT 0"},
+	{"heat_index_f", "REAL NOT NULL DEFAULT 0"},
+	{"vpd", "REAL NOT NULL DEFAULT 0"},
+	{"frost_point_c", "REAL NOT NULL DEFAULT 0"},
+	{"mixing_ratio", "REAL NOT NULL DEFAULT 0"},
+}
+
+// addMissingReadingColumns adds any of addedReadingColumns the readings table lacks; the caller must hold s.mu
+func (s *SQLiteStorage) addMissingReadingColumns() error {
+	rows, err := s.db.Query("SELECT name FROM pragma_table_info('readings')")
+	if err != nil {
+		return fmt.Errorf("failed to read readings columns: %v", err)
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read readings columns: %v", err)
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read readings columns: %v", err)
+	}
+
+	for _, col := range addedReadingColumns {
+		if existing[col.name] {
+			continue
+		}
+		if _, err := s.db.Exec(fmt.Sprintf("ALTER TABLE readings ADD COLUMN %s %s", col.name, col.definition)); err != nil {
+			return fmt.Errorf("failed to add column %s: %v", col.name, err)
+		}
+	}
+	return nil
+}
+
+// sqliteSpan starts a span for a SQLite query; the StorageBackend interface carries no context,
+// so these spans are roots of their own traces
+func sqliteSpan(name string, attrs ...attribute.KeyValue) trace.Span {
+	_, span := startSpan(context.Background(), name, append(attrs, semconv.DBSystemSqlite)...)
+	return span
+}
+
 // SaveReadings saves readings to SQLite database
-func (s *SQLiteStorage) SaveReadings(deviceAddr string, readings []Reading) error {
+func (s *SQLiteStorage) SaveReadings(deviceAddr string, readings []Reading) (err error) {
+	span := sqliteSpan("sqlite.SaveReadings", deviceAttr(deviceAddr), attribute.Int("govee.readings", len(readings)))
+	defer func() { endSpan(span, err) }()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	return s.insertReadings(readings)
+}
+
+// queuedReading is a reading waiting to be inserted. A reading that replaces an earlier one
+// of the same device, because a merge kept the one with the stronger RSSI, deletes the
+// stored reading with the replaces timestamp first.
+type queuedReading struct {
+	reading  Reading
+	replaces time.Time
+}
+
+// insertReadings inserts readings in one transaction; the caller must hold s.mu
+func (s *SQLiteStorage) insertReadings(readings []Reading) error {
+	batch := make([]queuedReading, len(readings))
+	for i, r := range readings {
+		batch[i] = queuedReading{reading: r}
+	}
+	return s.writeReadings(batch)
+}
+
+// writeReadings inserts queued readings in one transaction, in order, deleting the readings
+// they replace; the caller must hold s.mu
+func (s *SQLiteStorage) writeReadings(batch []queuedReading) error {
 	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %v", err)
 	}
 	defer tx.Rollback()
 
+	deleteStmt, err := tx.Prepare("DELETE FROM readings WHERE device_addr = ? AND timestamp = ?")
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %v", err)
+	}
+	defer deleteStmt.Close()
+
 	stmt, err := tx.Prepare(`
 		INSERT INTO readings (
 			device_name, device_addr, temp_c, temp_f, temp_offset,
 			humidity, humidity_offset, abs_humidity, dew_point_c, dew_point_f,
-			steam_pressure, battery, rssi, timestamp, client_id
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			steam_pressure, heat_index_c, heat_index_f, vpd, frost_point_c, mixing_ratio, battery, rssi, timestamp, client_id
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
 		return fmt.Errorf("failed to prepare statement: %v", err)
 	}
 	defer stmt.Close()
 
-	for _, r := range readings {
+	for _, q := range batch {
+		if !q.replaces.IsZero() {
+			if _, err := deleteStmt.Exec(q.reading.DeviceAddr, q.replaces); err != nil {
+				return fmt.Errorf("failed to delete replaced reading: %v", err)
+			}
+		}
+		r := q.reading
 		_, err := stmt.Exec(
 			r.DeviceName, r.DeviceAddr, r.TempC, r.TempF, r.TempOffset,
 			r.Humidity, r.HumidityOffset, r.AbsHumidity, r.DewPointC, r.DewPointF,
-			r.SteamPressure, r.Battery, r.RSSI, r.Timestamp, r.ClientID,
+			r.SteamPressure, r.HeatIndexC, r.HeatIndexF, r.VPD, r.FrostPointC, r.MixingRatio, r.Battery, r.RSSI, r.Timestamp, r.ClientID,
 		)
 		if err != nil {
 			return fmt.Errorf("failed to insert reading: %v", err)
@@ -206,17 +333,20 @@ func (s *SQLiteStorage) SaveReadings(deviceAddr string, readings []Reading) erro
 }
 
 // LoadReadings loads readings from SQLite within a time range
-func (s *SQLiteStorage) LoadReadings(deviceAddr string, fromTime, toTime time.Time) ([]Reading, error) {
+func (s *SQLiteStorage) LoadReadings(deviceAddr string, fromTime, toTime time.Time) (_ []Reading, err error) {
+	span := sqliteSpan("sqlite.LoadReadings", deviceAttr(deviceAddr))
+	defer func() { endSpan(span, err) }()
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	query := `
 		SELECT device_name, device_addr, temp_c, temp_f, temp_offset,
 			   humidity, humidity_offset, abs_humidity, dew_point_c, dew_point_f,
-			   steam_pressure, battery, rssi, timestamp, client_id
+			   steam_pressure, heat_index_c, heat_index_f, vpd, frost_point_c, mixing_ratio, battery, rssi, timestamp, client_id
 		FROM readings
 		WHERE device_addr = ? AND timestamp >= ? AND timestamp <= ?
-		ORDER BY timestamp DESC
+		ORDER BY timestamp ASC
 	`
 
 	rows, err := s.db.Query(query, deviceAddr, fromTime, toTime)
@@ -229,17 +359,20 @@ func (s *SQLiteStorage) LoadReadings(deviceAddr string, fromTime, toTime time.Ti
 }
 
 // LoadAllDeviceReadings loads all readings for a device
-func (s *SQLiteStorage) LoadAllDeviceReadings(deviceAddr string) ([]Reading, error) {
+func (s *SQLiteStorage) LoadAllDeviceReadings(deviceAddr string) (_ []Reading, err error) {
+	span := sqliteSpan("sqlite.LoadAllDeviceReadings", deviceAttr(deviceAddr))
+	defer func() { endSpan(span, err) }()
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	query := `
 		SELECT device_name, device_addr, temp_c, temp_f, temp_offset,
 			   humidity, humidity_offset, abs_humidity, dew_point_c, dew_point_f,
-			   steam_pressure, battery, rssi, timestamp, client_id
+			   steam_pressure, heat_index_c, heat_index_f, vpd, frost_point_c, mixing_ratio, battery, rssi, timestamp, client_id
 		FROM readings
 		WHERE device_addr = ?
-		ORDER BY timestamp DESC
+		ORDER BY timestamp ASC
 	`
 
 	rows, err := s.db.Query(query, deviceAddr)
@@ -259,7 +392,7 @@ func (s *SQLiteStorage) scanReadings(rows *sql.Rows) ([]Reading, error) {
 		err := rows.Scan(
 			&r.DeviceName, &r.DeviceAddr, &r.TempC, &r.TempF, &r.TempOffset,
 			&r.Humidity, &r.HumidityOffset, &r.AbsHumidity, &r.DewPointC, &r.DewPointF,
-			&r.SteamPressure, &r.Battery, &r.RSSI, &r.Timestamp, &r.ClientID,
+			&r.SteamPressure, &r.HeatIndexC, &r.HeatIndexF, &r.VPD, &r.FrostPointC, &r.MixingRatio, &r.Battery, &r.RSSI, &r.Timestamp, &r.ClientID,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan reading: %v", err)
@@ -298,20 +431,100 @@ func (s *SQLiteStorage) GetDevices() ([]string, error) {
 	return devices, nil
 }
 
-// DeleteOldReadings removes readings older than cutoff time
-func (s *SQLiteStorage) DeleteOldReadings(cutoffTime time.Time) error {
+// DeleteOldReadings removes readings older than cutoff time, or their device's own cutoff
+func (s *SQLiteStorage) DeleteOldReadings(cutoffTime time.Time, deviceCutoffs map[string]time.Time) (err error) {
+	span := sqliteSpan("sqlite.DeleteOldReadings")
+	defer func() { endSpan(span, err) }()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	result, err := s.db.Exec("DELETE FROM readings WHERE timestamp < ?", cutoffTime)
+	if len(deviceCutoffs) == 0 {
+		if cutoffTime.IsZero() {
+			return nil
+		}
+		return s.deleteReadingsBefore("", cutoffTime)
+	}
+
+	// Addresses are stored as the clients sent them, so match them to the overrides by their
+	// sanitized form and delete device by device
+	rows, err := s.db.Query("SELECT DISTINCT device_addr FROM readings")
+	if err != nil {
+		return fmt.Errorf("failed to list devices: %v", err)
+	}
+	var addrs []string
+	for rows.Next() {
+		var addr string
+		if err := rows.Scan(&addr); err != nil {
+			rows.Close()
+			return err
+		}
+		addrs = append(addrs, addr)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, addr := range addrs {
+		cutoff := cutoffTime
+		if sanitized, err := sanitizeDeviceAddr(addr); err == nil {
+			if c, ok := deviceCutoffs[sanitized]; ok {
+				cutoff = c
+			}
+		}
+		if cutoff.IsZero() {
+			continue
+		}
+		if err := s.deleteReadingsBefore(addr, cutoff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteReadingsBefore deletes the readings of deviceAddr (every device if empty) older than
+// cutoff, and their hourly aggregates unless they are kept. Caller must hold s.mu.
+func (s *SQLiteStorage) deleteReadingsBefore(deviceAddr string, cutoff time.Time) error {
+	readingsQuery, aggregatesQuery := "DELETE FROM readings WHERE timestamp < ?", "DELETE FROM hourly_aggregates WHERE hour_timestamp < ?"
+	args := []interface{}{cutoff}
+	if deviceAddr != "" {
+		readingsQuery = "DELETE FROM readings WHERE device_addr = ? AND timestamp < ?"
+		aggregatesQuery = "DELETE FROM hourly_aggregates WHERE device_addr = ? AND hour_timestamp < ?"
+		args = []interface{}{deviceAddr, cutoff}
+	}
+
+	if s.keepAggregates {
+		// Roll up every hour the cutoff reaches into, whole, so an hour it splits is summarised
+		// from all of its readings
+		hourEnd := cutoff.Truncate(time.Hour).Add(time.Hour)
+		where, rollupArgs := "timestamp < ?", []interface{}{hourEnd}
+		if deviceAddr != "" {
+			where, rollupArgs = "device_addr = ? AND timestamp < ?", []interface{}{deviceAddr, hourEnd}
+		}
+		rows, err := s.db.Query(fmt.Sprintf(hourlyAggregateQuery, where), rollupArgs...)
+		if err != nil {
+			return fmt.Errorf("failed to roll up old readings: %v", err)
+		}
+		aggregates, err := scanComputedAggregates(rows)
+		rows.Close()
+		if err != nil {
+			return err
+		}
+		if err := s.upsertAggregates(aggregates); err != nil {
+			return err
+		}
+	}
+
+	result, err := s.db.Exec(readingsQuery, args...)
 	if err != nil {
 		return fmt.Errorf("failed to delete old readings: %v", err)
 	}
 
 	affected, _ := result.RowsAffected()
-	if affected > 0 {
+	if affected > 0 && !s.keepAggregates {
 		// Also delete old aggregates
-		if _, err := s.db.Exec("DELETE FROM hourly_aggregates WHERE hour_timestamp < ?", cutoffTime); err != nil {
+		if _, err := s.db.Exec(aggregatesQuery, args...); err != nil {
 			log.Printf("Warning: failed to delete old aggregates: %v", err)
 		}
 	}
@@ -339,15 +552,40 @@ func (s *SQLiteStorage) GetReadingCountByDevice(deviceAddr string) (int64, error
 	return count, err
 }
 
+// readingTimestamps returns the timestamps of a device's stored readings as Unix nanoseconds
+func (s *SQLiteStorage) readingTimestamps(deviceAddr string) (map[int64]bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query("SELECT timestamp FROM readings WHERE device_addr = ?", deviceAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query timestamps: %v", err)
+	}
+	defer rows.Close()
+
+	timestamps := make(map[int64]bool)
+	for rows.Next() {
+		var ts time.Time
+		if err := rows.Scan(&ts); err != nil {
+			return nil, fmt.Errorf("failed to scan timestamp: %v", err)
+		}
+		timestamps[ts.UnixNano()] = true
+	}
+	return timestamps, rows.Err()
+}
+
 // GetLatestReadings returns the N most recent readings
-func (s *SQLiteStorage) GetLatestReadings(limit int) ([]Reading, error) {
+func (s *SQLiteStorage) GetLatestReadings(limit int) (_ []Reading, err error) {
+	span := sqliteSpan("sqlite.GetLatestReadings")
+	defer func() { endSpan(span, err) }()
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	query := `
 		SELECT device_name, device_addr, temp_c, temp_f, temp_offset,
 			   humidity, humidity_offset, abs_humidity, dew_point_c, dew_point_f,
-			   steam_pressure, battery, rssi, timestamp, client_id
+			   steam_pressure, heat_index_c, heat_index_f, vpd, frost_point_c, mixing_ratio, battery, rssi, timestamp, client_id
 		FROM readings
 		ORDER BY timestamp DESC
 		LIMIT ?
@@ -363,7 +601,10 @@ func (s *SQLiteStorage) GetLatestReadings(limit int) ([]Reading, error) {
 }
 
 // GetReadingsPage returns paginated readings with filtering
-func (s *SQLiteStorage) GetReadingsPage(offset, limit int, deviceAddr, clientID string, fromTime, toTime time.Time) ([]Reading, int64, error) {
+func (s *SQLiteStorage) GetReadingsPage(offset, limit int, deviceAddr, clientID string, fromTime, toTime time.Time) (_ []Reading, _ int64, err error) {
+	span := sqliteSpan("sqlite.GetReadingsPage", deviceAttr(deviceAddr), clientAttr(clientID))
+	defer func() { endSpan(span, err) }()
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -404,7 +645,7 @@ func (s *SQLiteStorage) GetReadingsPage(offset, limit int, deviceAddr, clientID
 	query := fmt.Sprintf(`
 		SELECT device_name, device_addr, temp_c, temp_f, temp_offset,
 			   humidity, humidity_offset, abs_humidity, dew_point_c, dew_point_f,
-			   steam_pressure, battery, rssi, timestamp, client_id
+			   steam_pressure, heat_index_c, heat_index_f, vpd, frost_point_c, mixing_ratio, battery, rssi, timestamp, client_id
 		FROM readings
 		%s
 		ORDER BY timestamp DESC
@@ -423,7 +664,10 @@ func (s *SQLiteStorage) GetReadingsPage(offset, limit int, deviceAddr, clientID
 }
 
 // GetHourlyAggregates returns hourly aggregated data
-func (s *SQLiteStorage) GetHourlyAggregates(deviceAddr string, fromTime, toTime time.Time) ([]AggregateReading, error) {
+func (s *SQLiteStorage) GetHourlyAggregates(deviceAddr string, fromTime, toTime time.Time) (_ []AggregateReading, err error) {
+	span := sqliteSpan("sqlite.GetHourlyAggregates", deviceAttr(deviceAddr))
+	defer func() { endSpan(span, err) }()
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -460,15 +704,109 @@ func (s *SQLiteStorage) GetHourlyAggregates(deviceAddr string, fromTime, toTime
 		return s.computeHourlyAggregates(deviceAddr, fromTime, toTime)
 	}
 
+	// Hours after the newest rolled-up one (e.g. the current, incomplete hour) aren't in the table yet
+	if tailStart := aggregates[0].Timestamp.Add(time.Hour); !tailStart.After(toTime) {
+		tail, err := s.computeHourlyAggregates(deviceAddr, tailStart, toTime)
+		if err != nil {
+			return nil, err
+		}
+		aggregates = append(tail, aggregates...)
+	}
+
 	return aggregates, nil
 }
 
-// computeHourlyAggregates computes aggregates on-the-fly when not pre-computed
-func (s *SQLiteStorage) computeHourlyAggregates(deviceAddr string, fromTime, toTime time.Time) ([]AggregateReading, error) {
-	query := `
+// GetDeviceStats computes a device's stats over a time range in one aggregate query, plus the
+// first and last reading times
+func (s *SQLiteStorage) GetDeviceStats(deviceAddr string, fromTime, toTime time.Time) (_ map[string]interface{}, err error) {
+	span := sqliteSpan("sqlite.GetDeviceStats", deviceAttr(deviceAddr))
+	defer func() { endSpan(span, err) }()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	where := []string{"device_addr = ?"}
+	args := []interface{}{deviceAddr}
+	if !fromTime.IsZero() {
+		where = append(where, "timestamp >= ?")
+		args = append(args, fromTime)
+	}
+	if !toTime.IsZero() {
+		where = append(where, "timestamp <= ?")
+		args = append(args, toTime)
+	}
+	whereClause := strings.Join(where, " AND ")
+
+	// The statMetrics keys are also the column names
+	columns := []string{"COUNT(*)"}
+	for _, m := range statMetrics {
+		columns = append(columns, fmt.Sprintf("MIN(%[1]s), MAX(%[1]s), AVG(%[1]s)", m.key))
+	}
+	query := fmt.Sprintf("SELECT %s FROM readings WHERE %s", strings.Join(columns, ", "), whereClause)
+
+	var count int
+	values := make([]sql.NullFloat64, 3*len(statMetrics))
+	dest := []interface{}{&count}
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+	if err := s.db.QueryRow(query, args...).Scan(dest...); err != nil {
+		return nil, fmt.Errorf("failed to query device stats: %v", err)
+	}
+
+	stats := make(map[string]interface{})
+	if count == 0 {
+		return stats, nil
+	}
+	stats["count"] = count
+	stats["weighting"] = "count"
+	for i, m := range statMetrics {
+		stats[m.key+"_min"] = values[3*i].Float64
+		stats[m.key+"_max"] = values[3*i+1].Float64
+		stats[m.key+"_avg"] = values[3*i+2].Float64
+	}
+
+	// Aggregates lose the column type, so read the bounding timestamps as rows
+	var first, last time.Time
+	boundQuery := "SELECT timestamp FROM readings WHERE %s ORDER BY timestamp %s LIMIT 1"
+	if err := s.db.QueryRow(fmt.Sprintf(boundQuery, whereClause, "ASC"), args...).Scan(&first); err != nil {
+		return nil, fmt.Errorf("failed to query first reading: %v", err)
+	}
+	if err := s.db.QueryRow(fmt.Sprintf(boundQuery, whereClause, "DESC"), args...).Scan(&last); err != nil {
+		return nil, fmt.Errorf("failed to query last reading: %v", err)
+	}
+	stats["first_reading"] = first
+	stats["last_reading"] = last
+
+	// Sums for the trend fit, with time in hours since the first reading
+	trendQuery := fmt.Sprintf(`
+		SELECT SUM(t), SUM(t*t),
+			SUM(temp_c), SUM(t*temp_c), SUM(temp_c*temp_c),
+			SUM(humidity), SUM(t*humidity), SUM(humidity*humidity)
+		FROM (
+			SELECT temp_c, humidity, (unixepoch(timestamp, 'subsec') - unixepoch(?, 'subsec')) / 3600.0 AS t
+			FROM readings WHERE %s
+		)`, whereClause)
+	temp := trendSums{n: float64(count)}
+	humidity := trendSums{n: float64(count)}
+	trendArgs := append([]interface{}{first}, args...)
+	if err := s.db.QueryRow(trendQuery, trendArgs...).Scan(&temp.sumT, &temp.sumTT,
+		&temp.sumY, &temp.sumTY, &temp.sumYY,
+		&humidity.sumY, &humidity.sumTY, &humidity.sumYY); err != nil {
+		return nil, fmt.Errorf("failed to query device trends: %v", err)
+	}
+	humidity.sumT, humidity.sumTT = temp.sumT, temp.sumTT
+	addTrendStats(stats, temp, humidity)
+
+	return stats, nil
+}
+
+// hourlyAggregateQuery groups raw readings by device and hour; callers fill in the WHERE clause.
+// SQLite has no 'start of hour' modifier, so the hour is truncated with strftime.
+const hourlyAggregateQuery = `
 		SELECT
 			device_addr,
-			datetime(timestamp, 'start of hour') as hour,
+			strftime('%%Y-%%m-%%d %%H:00:00', timestamp) as hour,
 			AVG(temp_c) as avg_temp,
 			MIN(temp_c) as min_temp,
 			MAX(temp_c) as max_temp,
@@ -477,17 +815,26 @@ func (s *SQLiteStorage) computeHourlyAggregates(deviceAddr string, fromTime, toT
 			MAX(humidity) as max_humidity,
 			COUNT(*) as count
 		FROM readings
-		WHERE device_addr = ? AND timestamp >= ? AND timestamp <= ?
-		GROUP BY device_addr, datetime(timestamp, 'start of hour')
+		WHERE %s
+		GROUP BY device_addr, hour
 		ORDER BY hour DESC
 	`
 
+// computeHourlyAggregates computes aggregates on-the-fly when not pre-computed
+func (s *SQLiteStorage) computeHourlyAggregates(deviceAddr string, fromTime, toTime time.Time) ([]AggregateReading, error) {
+	query := fmt.Sprintf(hourlyAggregateQuery, "device_addr = ? AND timestamp >= ? AND timestamp <= ?")
+
 	rows, err := s.db.Query(query, deviceAddr, fromTime, toTime)
 	if err != nil {
 		return nil, fmt.Errorf("failed to compute aggregates: %v", err)
 	}
 	defer rows.Close()
 
+	return scanComputedAggregates(rows)
+}
+
+// scanComputedAggregates scans the rows of hourlyAggregateQuery
+func scanComputedAggregates(rows *sql.Rows) ([]AggregateReading, error) {
 	var aggregates []AggregateReading
 	for rows.Next() {
 		var a AggregateReading
@@ -506,21 +853,260 @@ func (s *SQLiteStorage) computeHourlyAggregates(deviceAddr string, fromTime, toT
 	return aggregates, nil
 }
 
-// Close closes the database connection
+// aggregateLookback is how far before the previous rollup ComputeAggregates looks again,
+// so readings that arrive late (e.g. replayed from a client's spool) still land in their hour
+const aggregateLookback = 24 * time.Hour
+
+// ComputeAggregates upserts hourly aggregates for all hours completed before upTo.
+// The first call covers every reading; later calls only revisit recent hours.
+func (s *SQLiteStorage) ComputeAggregates(upTo time.Time) (err error) {
+	span := sqliteSpan("sqlite.ComputeAggregates")
+	defer func() { endSpan(span, err) }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Only roll up completed hours; the current hour is computed on the fly by GetHourlyAggregates
+	end := upTo.UTC().Truncate(time.Hour)
+	var start time.Time
+	if !s.rolledUpTo.IsZero() {
+		start = s.rolledUpTo.Add(-aggregateLookback)
+	}
+
+	rows, err := s.db.Query(fmt.Sprintf(hourlyAggregateQuery, "timestamp >= ? AND timestamp < ?"), start, end)
+	if err != nil {
+		return fmt.Errorf("failed to compute aggregates: %v", err)
+	}
+	aggregates, err := scanComputedAggregates(rows)
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	if err := s.upsertAggregates(aggregates); err != nil {
+		return err
+	}
+
+	s.rolledUpTo = end
+	return nil
+}
+
+// upsertAggregates writes hourly aggregates to hourly_aggregates, replacing any already there
+// for the same hours. Caller must hold s.mu.
+func (s *SQLiteStorage) upsertAggregates(aggregates []AggregateReading) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO hourly_aggregates (
+			device_addr, hour_timestamp, avg_temp_c, min_temp_c, max_temp_c,
+			avg_humidity, min_humidity, max_humidity, count
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(device_addr, hour_timestamp) DO UPDATE SET
+			avg_temp_c = excluded.avg_temp_c,
+			min_temp_c = excluded.min_temp_c,
+			max_temp_c = excluded.max_temp_c,
+			avg_humidity = excluded.avg_humidity,
+			min_humidity = excluded.min_humidity,
+			max_humidity = excluded.max_humidity,
+			count = excluded.count
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, a := range aggregates {
+		_, err := stmt.Exec(
+			a.DeviceAddr, a.Timestamp, a.AvgTempC, a.MinTempC, a.MaxTempC,
+			a.AvgHumidity, a.MinHumidity, a.MaxHumidity, a.Count,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to upsert aggregate: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// RunAggregateRollup calls ComputeAggregates right away and then every interval until ctx is done
+func (s *SQLiteStorage) RunAggregateRollup(ctx context.Context, interval time.Duration) {
+	rollup := func() {
+		if err := s.ComputeAggregates(time.Now()); err != nil {
+			log.Printf("Error rolling up hourly aggregates: %v", err)
+		}
+	}
+
+	rollup()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rollup()
+		case <-ctx.Done():
+			log.Println("Aggregate rollup shutting down")
+			return
+		}
+	}
+}
+
+// maxQueuedBatches bounds the write-behind queue, in batches, while inserts are failing;
+// beyond it the oldest readings are dropped
+const maxQueuedBatches = 100
+
+// StartWriteBuffer makes Enqueue queue readings in memory and starts a goroutine inserting
+// them in one transaction every flushInterval, or sooner once batchSize readings are queued.
+// Close stops the goroutine and inserts whatever is still queued before closing the database.
+func (s *SQLiteStorage) StartWriteBuffer(batchSize int, flushInterval time.Duration) {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+
+	s.batchSize = batchSize
+	s.flushNow = make(chan struct{}, 1)
+	s.stopFlusher = make(chan struct{})
+	s.flusherDone = make(chan struct{})
+	go s.runFlusher(flushInterval)
+}
+
+// Enqueue queues a reading for the next batched insert. Without StartWriteBuffer the
+// reading is inserted right away.
+func (s *SQLiteStorage) Enqueue(reading Reading) error {
+	return s.enqueue(queuedReading{reading: reading})
+}
+
+// EnqueueReplacement queues a reading that replaces the device's reading at the replaced
+// timestamp, which may itself still be queued; it is deleted when the replacement is inserted
+func (s *SQLiteStorage) EnqueueReplacement(reading Reading, replaced time.Time) error {
+	return s.enqueue(queuedReading{reading: reading, replaces: replaced})
+}
+
+// enqueue adds a reading to the write-behind queue, or writes it right away without StartWriteBuffer
+func (s *SQLiteStorage) enqueue(q queuedReading) (err error) {
+	s.queueMu.Lock()
+	if s.flusherDone == nil {
+		s.queueMu.Unlock()
+		span := sqliteSpan("sqlite.SaveReadings", deviceAttr(q.reading.DeviceAddr), attribute.Int("govee.readings", 1))
+		defer func() { endSpan(span, err) }()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.writeReadings([]queuedReading{q})
+	}
+	defer s.queueMu.Unlock()
+
+	if s.queueClosed {
+		return fmt.Errorf("write buffer is closed")
+	}
+	s.queue = append(s.queue, q)
+	s.trimQueue()
+	if len(s.queue) >= s.batchSize {
+		select {
+		case s.flushNow <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// trimQueue drops the oldest queued readings beyond maxQueuedBatches; the caller must hold s.queueMu
+func (s *SQLiteStorage) trimQueue() {
+	if excess := len(s.queue) - s.batchSize*maxQueuedBatches; excess > 0 {
+		s.queue = append(s.queue[:0:0], s.queue[excess:]...)
+		s.dropped += excess
+	}
+}
+
+// flushQueue inserts all queued readings in one transaction. On failure they're put back
+// at the front of the queue for the next flush.
+func (s *SQLiteStorage) flushQueue() (err error) {
+	s.queueMu.Lock()
+	batch, dropped := s.queue, s.dropped
+	s.queue, s.dropped = nil, 0
+	s.queueMu.Unlock()
+
+	if dropped > 0 {
+		log.Printf("SQLite write queue full, dropped %d oldest readings", dropped)
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	span := sqliteSpan("sqlite.FlushQueue", attribute.Int("govee.readings", len(batch)))
+	defer func() { endSpan(span, err) }()
+
+	s.mu.Lock()
+	err = s.writeReadings(batch)
+	s.mu.Unlock()
+
+	if err != nil {
+		s.queueMu.Lock()
+		s.queue = append(batch, s.queue...)
+		s.trimQueue()
+		s.queueMu.Unlock()
+		return fmt.Errorf("failed to flush %d readings: %v", len(batch), err)
+	}
+	return nil
+}
+
+// runFlusher flushes the queue every interval and whenever a full batch is waiting, until Close
+func (s *SQLiteStorage) runFlusher(interval time.Duration) {
+	defer close(s.flusherDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.flushNow:
+		case <-s.stopFlusher:
+			return
+		}
+		if err := s.flushQueue(); err != nil {
+			log.Printf("Error writing readings to SQLite: %v", err)
+		}
+	}
+}
+
+// Close drains the write buffer, if started, and closes the database connection
 func (s *SQLiteStorage) Close() error {
+	s.queueMu.Lock()
+	done := s.flusherDone
+	if done != nil && !s.queueClosed {
+		s.queueClosed = true
+		close(s.stopFlusher)
+	}
+	s.queueMu.Unlock()
+
+	var flushErr error
+	if done != nil {
+		<-done
+		flushErr = s.flushQueue()
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if s.db != nil {
-		return s.db.Close()
+		if err := s.db.Close(); err != nil {
+			return err
+		}
 	}
-	return nil
+	return flushErr
 }
 
 // JSONStorage implements StorageBackend using JSON files (legacy support)
 type JSONStorage struct {
 	baseDir string
 	mu      sync.RWMutex
+	// Keep hourly aggregates in aggregates files when DeleteOldReadings removes their readings
+	keepAggregates bool
 }
 
 // NewJSONStorage creates a new JSON file-based storage backend
@@ -530,6 +1116,14 @@ func NewJSONStorage(baseDir string) *JSONStorage {
 	}
 }
 
+// SetKeepAggregatesForever makes DeleteOldReadings roll readings up into the device's
+// aggregates file before removing them
+func (j *JSONStorage) SetKeepAggregatesForever(keep bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.keepAggregates = keep
+}
+
 // Initialize sets up the JSON storage directories
 func (j *JSONStorage) Initialize() error {
 	return os.MkdirAll(j.baseDir, 0755)
@@ -573,7 +1167,28 @@ func (j *JSONStorage) LoadReadings(deviceAddr string, fromTime, toTime time.Time
 	return filtered, nil
 }
 
-// LoadAllDeviceReadings loads all readings for a device from JSON
+// readingsDirs returns the base directory followed by its subdirectories, so the time
+// partitions written by the server's StorageManager are read along with flat files
+func (j *JSONStorage) readingsDirs() ([]string, error) {
+	entries, err := os.ReadDir(j.baseDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	dirs := []string{j.baseDir}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, filepath.Join(j.baseDir, entry.Name()))
+		}
+	}
+	return dirs, nil
+}
+
+// LoadAllDeviceReadings loads all readings for a device from JSON, including numbered and
+// compressed files in every partition
 func (j *JSONStorage) LoadAllDeviceReadings(deviceAddr string) ([]Reading, error) {
 	j.mu.RLock()
 	defer j.mu.RUnlock()
@@ -583,67 +1198,104 @@ func (j *JSONStorage) LoadAllDeviceReadings(deviceAddr string) ([]Reading, error
 		return nil, err
 	}
 
-	deviceFile := filepath.Join(j.baseDir, fmt.Sprintf("readings_%s.json", sanitizedAddr))
-	data, err := os.ReadFile(deviceFile)
+	dirs, err := j.readingsDirs()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return []Reading{}, nil
-		}
-		return nil, fmt.Errorf("failed to read readings file: %v", err)
+		return nil, err
 	}
 
-	var readings []Reading
-	if err := json.Unmarshal(data, &readings); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal readings: %v", err)
+	readings := []Reading{}
+	for _, dir := range dirs {
+		dirReadings, err := loadDeviceFiles(dir, sanitizedAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read readings in %s: %v", dir, err)
+		}
+		readings = append(readings, dirReadings...)
 	}
 
+	// Match the SQLite backend's chronological order
+	sort.Slice(readings, func(i, k int) bool {
+		return readings[i].Timestamp.Before(readings[k].Timestamp)
+	})
+
 	return readings, nil
 }
 
-// GetDevices returns all device addresses from JSON files
+// GetDevices returns all device addresses from JSON files, in the base directory or any partition
 func (j *JSONStorage) GetDevices() ([]string, error) {
 	j.mu.RLock()
 	defer j.mu.RUnlock()
 
-	files, err := filepath.Glob(filepath.Join(j.baseDir, "readings_*.json"))
+	dirs, err := j.readingsDirs()
 	if err != nil {
 		return nil, err
 	}
 
+	seen := make(map[string]bool)
 	var devices []string
-	for _, file := range files {
-		base := filepath.Base(file)
-		// Extract device address from filename: readings_<addr>.json
-		addr := strings.TrimPrefix(base, "readings_")
-		addr = strings.TrimSuffix(addr, ".json")
-		devices = append(devices, addr)
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			addr, ok := readingsFileAddr(entry.Name())
+			if entry.IsDir() || !ok {
+				continue
+			}
+			if !seen[addr] {
+				seen[addr] = true
+				devices = append(devices, addr)
+			}
+		}
 	}
+	sort.Strings(devices)
 
 	return devices, nil
 }
 
 // DeleteOldReadings removes old readings from JSON files
-func (j *JSONStorage) DeleteOldReadings(cutoffTime time.Time) error {
+func (j *JSONStorage) DeleteOldReadings(cutoffTime time.Time, deviceCutoffs map[string]time.Time) error {
+	j.mu.RLock()
+	keepAggregates := j.keepAggregates
+	j.mu.RUnlock()
+
 	devices, err := j.GetDevices()
 	if err != nil {
 		return err
 	}
 
 	for _, device := range devices {
+		// GetDevices gives sanitized addresses
+		cutoff := cutoffTime
+		if c, ok := deviceCutoffs[device]; ok {
+			cutoff = c
+		}
+		if cutoff.IsZero() {
+			continue
+		}
+
 		readings, err := j.LoadAllDeviceReadings(device)
 		if err != nil {
 			continue
 		}
 
 		// Filter out old readings
-		var kept []Reading
+		var kept, expired []Reading
 		for _, r := range readings {
-			if r.Timestamp.After(cutoffTime) {
+			if r.Timestamp.After(cutoff) {
 				kept = append(kept, r)
+			} else {
+				expired = append(expired, r)
 			}
 		}
 
-		if len(kept) != len(readings) {
+		if len(expired) > 0 {
+			if keepAggregates {
+				path := filepath.Join(j.baseDir, aggregatesFileName(device))
+				if err := archiveAggregates(path, hourlyAggregates(expired[0].DeviceAddr, expired)); err != nil {
+					return fmt.Errorf("failed to keep aggregates for %s: %v", device, err)
+				}
+			}
 			j.SaveReadings(device, kept)
 		}
 	}
@@ -761,13 +1413,47 @@ func (j *JSONStorage) GetReadingsPage(offset, limit int, deviceAddr, clientID st
 	return allReadings[offset:end], total, nil
 }
 
-// GetHourlyAggregates returns aggregated data (computed on-the-fly for JSON)
+// GetDeviceStats returns device stats over a time range, reducing the loaded readings in code
+func (j *JSONStorage) GetDeviceStats(deviceAddr string, fromTime, toTime time.Time) (map[string]interface{}, error) {
+	readings, err := j.LoadAllDeviceReadings(deviceAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	var inRange []Reading
+	for _, r := range readings {
+		if (fromTime.IsZero() || !r.Timestamp.Before(fromTime)) && (toTime.IsZero() || !r.Timestamp.After(toTime)) {
+			inRange = append(inRange, r)
+		}
+	}
+	return computeStats(inRange, false), nil
+}
+
+// GetHourlyAggregates returns aggregated data (computed on-the-fly for JSON), together with
+// any kept after their readings were deleted
 func (j *JSONStorage) GetHourlyAggregates(deviceAddr string, fromTime, toTime time.Time) ([]AggregateReading, error) {
 	readings, err := j.LoadReadings(deviceAddr, fromTime, toTime)
 	if err != nil {
 		return nil, err
 	}
+	aggregates := hourlyAggregates(deviceAddr, readings)
 
+	sanitizedAddr, err := sanitizeDeviceAddr(deviceAddr)
+	if err != nil {
+		return nil, err
+	}
+	kept, err := loadAggregatesFile(filepath.Join(j.baseDir, aggregatesFileName(sanitizedAddr)))
+	if err != nil {
+		return nil, err
+	}
+	if len(kept) == 0 {
+		return aggregates, nil
+	}
+	return mergeAggregates(aggregates, aggregatesInRange(kept, fromTime, toTime)), nil
+}
+
+// hourlyAggregates groups readings into hourly aggregates, newest hour first
+func hourlyAggregates(deviceAddr string, readings []Reading) []AggregateReading {
 	// Group by hour
 	hourlyData := make(map[string]*AggregateReading)
 	for _, r := range readings {
@@ -816,9 +1502,92 @@ func (j *JSONStorage) GetHourlyAggregates(deviceAddr string, fromTime, toTime ti
 		return aggregates[i].Timestamp.After(aggregates[j].Timestamp)
 	})
 
+	return aggregates
+}
+
+// mergeAggregates combines sets of hourly aggregates into one, newest hour first. Aggregates
+// for the same hour are combined as if computed from both sets of readings.
+func mergeAggregates(sets ...[]AggregateReading) []AggregateReading {
+	byHour := make(map[int64]*AggregateReading)
+	for _, set := range sets {
+		for _, a := range set {
+			hour := a.Timestamp.Unix()
+			existing, ok := byHour[hour]
+			if !ok {
+				a := a
+				byHour[hour] = &a
+				continue
+			}
+			total := existing.Count + a.Count
+			if total > 0 {
+				existing.AvgTempC = (existing.AvgTempC*float64(existing.Count) + a.AvgTempC*float64(a.Count)) / float64(total)
+				existing.AvgHumidity = (existing.AvgHumidity*float64(existing.Count) + a.AvgHumidity*float64(a.Count)) / float64(total)
+			}
+			existing.MinTempC = math.Min(existing.MinTempC, a.MinTempC)
+			existing.MaxTempC = math.Max(existing.MaxTempC, a.MaxTempC)
+			existing.MinHumidity = math.Min(existing.MinHumidity, a.MinHumidity)
+			existing.MaxHumidity = math.Max(existing.MaxHumidity, a.MaxHumidity)
+			existing.Count = total
+		}
+	}
+
+	merged := make([]AggregateReading, 0, len(byHour))
+	for _, a := range byHour {
+		merged = append(merged, *a)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Timestamp.After(merged[j].Timestamp)
+	})
+	return merged
+}
+
+// aggregatesInRange returns the aggregates for the hours from fromTime to toTime; zero times
+// leave the range open
+func aggregatesInRange(aggregates []AggregateReading, fromTime, toTime time.Time) []AggregateReading {
+	var inRange []AggregateReading
+	for _, a := range aggregates {
+		if (fromTime.IsZero() || !a.Timestamp.Before(fromTime.Truncate(time.Hour))) && (toTime.IsZero() || !a.Timestamp.After(toTime)) {
+			inRange = append(inRange, a)
+		}
+	}
+	return inRange
+}
+
+// aggregatesFileName returns the name of the file holding a device's hourly aggregates kept
+// after its readings expired
+func aggregatesFileName(sanitizedAddr string) string {
+	return fmt.Sprintf("aggregates_%s.json", sanitizedAddr)
+}
+
+// loadAggregatesFile reads kept hourly aggregates, newest hour first. A missing file holds none.
+func loadAggregatesFile(path string) ([]AggregateReading, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var aggregates []AggregateReading
+	if err := json.Unmarshal(data, &aggregates); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
 	return aggregates, nil
 }
 
+// archiveAggregates adds hourly aggregates to the kept aggregates file at path
+func archiveAggregates(path string, aggregates []AggregateReading) error {
+	existing, err := loadAggregatesFile(path)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(mergeAggregates(existing, aggregates))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
 // Close is a no-op for JSON storage
 func (j *JSONStorage) Close() error {
 	return nil
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"database/sql"
+	"fmt"
+	"math"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 )
@@ -120,7 +124,7 @@ func TestSQLiteStorage(t *testing.T) {
 
 	// Test DeleteOldReadings
 	cutoff := time.Now().Add(-30 * time.Minute)
-	if err := storage.DeleteOldReadings(cutoff); err != nil {
+	if err := storage.DeleteOldReadings(cutoff, nil); err != nil {
 		t.Fatalf("Failed to delete old readings: %v", err)
 	}
 
@@ -424,6 +428,93 @@ func TestSQLiteGetHourlyAggregates(t *testing.T) {
 	}
 }
 
+// TestSQLiteComputeAggregates tests that the rollup fills hourly_aggregates and queries read from it
+func TestSQLiteComputeAggregates(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	storage := NewSQLiteStorage(dbPath)
+	storage.Initialize()
+	defer storage.Close()
+
+	deviceAddr := "AA:BB:CC:DD:EE:FF"
+	baseTime := time.Date(2023, 6, 15, 14, 0, 0, 0, time.UTC)
+	var readings []Reading
+	for i := 0; i < 6; i++ {
+		// Three readings in 14:00 and three in 15:00
+		readings = append(readings, Reading{
+			DeviceName: "Test",
+			DeviceAddr: deviceAddr,
+			TempC:      20.0 + float64(i),
+			Humidity:   40.0 + float64(i),
+			Timestamp:  baseTime.Add(time.Duration(i) * 20 * time.Minute),
+			ClientID:   "test",
+		})
+	}
+	storage.SaveReadings(deviceAddr, readings)
+
+	// Roll up at 15:30: only the completed 14:00 hour is stored
+	if err := storage.ComputeAggregates(baseTime.Add(90 * time.Minute)); err != nil {
+		t.Fatalf("ComputeAggregates failed: %v", err)
+	}
+	var count int
+	storage.db.QueryRow("SELECT COUNT(*) FROM hourly_aggregates").Scan(&count)
+	if count != 1 {
+		t.Fatalf("Expected 1 rolled-up hour, got %d", count)
+	}
+
+	// Roll up again after 16:00: both hours are stored, and re-running doesn't duplicate them
+	storage.ComputeAggregates(baseTime.Add(2 * time.Hour))
+	storage.ComputeAggregates(baseTime.Add(2 * time.Hour))
+	storage.db.QueryRow("SELECT COUNT(*) FROM hourly_aggregates").Scan(&count)
+	if count != 2 {
+		t.Fatalf("Expected 2 rolled-up hours, got %d", count)
+	}
+
+	// With the raw readings gone, results can only come from the aggregate table
+	storage.db.Exec("DELETE FROM readings")
+	aggregates, err := storage.GetHourlyAggregates(deviceAddr, baseTime, baseTime.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to get hourly aggregates: %v", err)
+	}
+	if len(aggregates) != 2 {
+		t.Fatalf("Expected 2 aggregates from the table, got %d", len(aggregates))
+	}
+	// Newest first
+	if agg := aggregates[0]; agg.Count != 3 || agg.MinTempC != 23.0 || agg.MaxTempC != 25.0 || agg.AvgHumidity != 44.0 {
+		t.Errorf("Unexpected 15:00 aggregate: %+v", agg)
+	}
+	if agg := aggregates[1]; agg.Count != 3 || agg.AvgTempC != 21.0 || !agg.Timestamp.Equal(baseTime) {
+		t.Errorf("Unexpected 14:00 aggregate: %+v", agg)
+	}
+}
+
+// TestSQLiteHourlyAggregatesIncludeCurrentHour tests that hours not yet rolled up are computed on the fly
+func TestSQLiteHourlyAggregatesIncludeCurrentHour(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	storage := NewSQLiteStorage(dbPath)
+	storage.Initialize()
+	defer storage.Close()
+
+	deviceAddr := "AA:BB:CC:DD:EE:FF"
+	baseTime := time.Date(2023, 6, 15, 14, 0, 0, 0, time.UTC)
+	storage.SaveReadings(deviceAddr, []Reading{
+		{DeviceName: "Test", DeviceAddr: deviceAddr, TempC: 20.0, Humidity: 40.0, Timestamp: baseTime.Add(10 * time.Minute), ClientID: "test"},
+		{DeviceName: "Test", DeviceAddr: deviceAddr, TempC: 22.0, Humidity: 42.0, Timestamp: baseTime.Add(70 * time.Minute), ClientID: "test"},
+	})
+	storage.ComputeAggregates(baseTime.Add(80 * time.Minute))
+
+	aggregates, err := storage.GetHourlyAggregates(deviceAddr, baseTime, baseTime.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to get hourly aggregates: %v", err)
+	}
+	if len(aggregates) != 2 || aggregates[0].AvgTempC != 22.0 || aggregates[1].AvgTempC != 20.0 {
+		t.Errorf("Expected the rolled-up hour plus the current hour, got %+v", aggregates)
+	}
+}
+
 // TestSQLiteGetReadingsPageFilters tests page filtering
 func TestSQLiteGetReadingsPageFilters(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -463,6 +554,72 @@ func TestSQLiteGetReadingsPageFilters(t *testing.T) {
 	}
 }
 
+// TestSQLiteUpgradesOlderSchema tests that columns added since a database was created are added on open
+func TestSQLiteUpgradesOlderSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "old.db")
+	now := time.Now()
+
+	// A readings table as created before the heat index columns existed
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE readings (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			device_name TEXT NOT NULL, device_addr TEXT NOT NULL,
+			temp_c REAL NOT NULL, temp_f REAL NOT NULL, temp_offset REAL NOT NULL,
+			humidity REAL NOT NULL, humidity_offset REAL NOT NULL, abs_humidity REAL NOT NULL,
+			dew_point_c REAL NOT NULL, dew_point_f REAL NOT NULL, steam_pressure REAL NOT NULL,
+			battery INTEGER NOT NULL, rssi INTEGER NOT NULL, timestamp DATETIME NOT NULL,
+			client_id TEXT NOT NULL, created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		INSERT INTO readings (device_name, device_addr, temp_c, temp_f, temp_offset, humidity, humidity_offset,
+			abs_humidity, dew_point_c, dew_point_f, steam_pressure, battery, rssi, timestamp, client_id)
+		VALUES ('Old', 'AA:BB:CC:DD:EE:FF', 20, 68, 0, 50, 0, 8.6, 9.3, 48.7, 11.7, 90, -60, ?, 'c1');
+	`, now.Add(-time.Minute))
+	db.Close()
+	if err != nil {
+		t.Fatalf("Failed to create old schema: %v", err)
+	}
+
+	storage := NewSQLiteStorage(dbPath)
+	if err := storage.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize storage over old schema: %v", err)
+	}
+	defer storage.Close()
+
+	if err := storage.SaveReadings("AA:BB:CC:DD:EE:FF", []Reading{
+		{DeviceName: "New", DeviceAddr: "AA:BB:CC:DD:EE:FF", TempC: 32, HeatIndexC: 40.4, HeatIndexF: 104.72, VPD: 1.43,
+			FrostPointC: -2.5, MixingRatio: 8.1, Timestamp: now, ClientID: "c1"},
+	}); err != nil {
+		t.Fatalf("Failed to save reading: %v", err)
+	}
+
+	readings, err := storage.LoadAllDeviceReadings("AA:BB:CC:DD:EE:FF")
+	if err != nil || len(readings) != 2 {
+		t.Fatalf("Expected 2 readings, got %d (err %v)", len(readings), err)
+	}
+	if readings[0].HeatIndexC != 0 {
+		t.Errorf("Expected old reading to have no heat index, got %v", readings[0].HeatIndexC)
+	}
+	if readings[1].HeatIndexC != 40.4 || readings[1].HeatIndexF != 104.72 || readings[1].VPD != 1.43 {
+		t.Errorf("Expected heat index 40.4/104.72 and VPD 1.43 to round-trip, got %v/%v and %v",
+			readings[1].HeatIndexC, readings[1].HeatIndexF, readings[1].VPD)
+	}
+	if readings[1].FrostPointC != -2.5 || readings[1].MixingRatio != 8.1 {
+		t.Errorf("Expected frost point -2.5 and mixing ratio 8.1 to round-trip, got %v and %v",
+			readings[1].FrostPointC, readings[1].MixingRatio)
+	}
+
+	// Reopening an upgraded database leaves it alone
+	reopened := NewSQLiteStorage(dbPath)
+	if err := reopened.Initialize(); err != nil {
+		t.Fatalf("Failed to reopen upgraded database: %v", err)
+	}
+	reopened.Close()
+}
+
 // TestJSONLoadReadingsTimeRange tests JSON time-filtered loading
 func TestJSONLoadReadingsTimeRange(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -653,7 +810,7 @@ func TestJSONDeleteOldReadings(t *testing.T) {
 
 	// Delete readings older than 24 hours
 	cutoff := now.Add(-24 * time.Hour)
-	err := storage.DeleteOldReadings(cutoff)
+	err := storage.DeleteOldReadings(cutoff, nil)
 	if err != nil {
 		t.Fatalf("Failed to delete old readings: %v", err)
 	}
@@ -728,6 +885,59 @@ func TestSQLiteClose(t *testing.T) {
 	// Should not panic
 }
 
+// TestSQLiteWriteBufferDrainsOnClose tests that every enqueued reading is inserted by the time Close returns
+func TestSQLiteWriteBufferDrainsOnClose(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	storage := NewSQLiteStorage(dbPath)
+	if err := storage.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize: %v", err)
+	}
+	// Full batches are flushed as they fill; the interval is too long to fire during the test
+	storage.StartWriteBuffer(3000, time.Hour)
+
+	const writers, perWriter = 4, 2500
+	start := time.Now().Add(-time.Hour)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			addr := fmt.Sprintf("AA:BB:CC:DD:EE:%02X", w)
+			for i := 0; i < perWriter; i++ {
+				reading := Reading{DeviceName: "Test", DeviceAddr: addr, TempC: 20, Humidity: 50, Timestamp: start.Add(time.Duration(i) * time.Millisecond)}
+				if err := storage.Enqueue(reading); err != nil {
+					t.Errorf("Enqueue failed: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := storage.Enqueue(Reading{DeviceAddr: "AA:BB:CC:DD:EE:00"}); err == nil {
+		t.Error("Expected Enqueue to fail after Close")
+	}
+
+	reopened := NewSQLiteStorage(dbPath)
+	if err := reopened.Initialize(); err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	defer reopened.Close()
+
+	count, err := reopened.GetReadingCount()
+	if err != nil {
+		t.Fatalf("GetReadingCount failed: %v", err)
+	}
+	if count != writers*perWriter {
+		t.Errorf("Expected %d readings in the database, got %d", writers*perWriter, count)
+	}
+}
+
 // TestJSONClose tests closing JSON storage
 func TestJSONClose(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -739,3 +949,215 @@ func TestJSONClose(t *testing.T) {
 		t.Errorf("Close should return nil: %v", err)
 	}
 }
+
+// TestStorageBackendsChronologicalOrder tests that both backends load readings oldest first
+func TestStorageBackendsChronologicalOrder(t *testing.T) {
+	tmpDir := t.TempDir()
+	backends := map[string]StorageBackend{
+		"SQLite": NewSQLiteStorage(filepath.Join(tmpDir, "test.db")),
+		"JSON":   NewJSONStorage(filepath.Join(tmpDir, "json")),
+	}
+
+	now := time.Now()
+	deviceAddr := "AA:BB:CC:DD:EE:FF"
+	// Saved out of order on purpose
+	readings := []Reading{
+		{DeviceName: "Test", DeviceAddr: deviceAddr, TempC: 25.0, Timestamp: now.Add(-1 * time.Hour), ClientID: "test"},
+		{DeviceName: "Test", DeviceAddr: deviceAddr, TempC: 30.0, Timestamp: now, ClientID: "test"},
+		{DeviceName: "Test", DeviceAddr: deviceAddr, TempC: 20.0, Timestamp: now.Add(-3 * time.Hour), ClientID: "test"},
+	}
+
+	for name, storage := range backends {
+		t.Run(name, func(t *testing.T) {
+			if err := storage.Initialize(); err != nil {
+				t.Fatalf("Failed to initialize storage: %v", err)
+			}
+			defer storage.Close()
+			storage.SaveReadings(deviceAddr, readings)
+
+			ranged, err := storage.LoadReadings(deviceAddr, now.Add(-4*time.Hour), now.Add(time.Hour))
+			if err != nil {
+				t.Fatalf("Failed to load readings: %v", err)
+			}
+			all, err := storage.LoadAllDeviceReadings(deviceAddr)
+			if err != nil {
+				t.Fatalf("Failed to load all readings: %v", err)
+			}
+
+			for _, loaded := range [][]Reading{ranged, all} {
+				if len(loaded) != 3 {
+					t.Fatalf("Expected 3 readings, got %d", len(loaded))
+				}
+				if loaded[0].TempC != 20.0 || loaded[1].TempC != 25.0 || loaded[2].TempC != 30.0 {
+					t.Errorf("Expected chronological order, got %v, %v, %v", loaded[0].TempC, loaded[1].TempC, loaded[2].TempC)
+				}
+			}
+		})
+	}
+}
+
+// TestStorageBackendsDeviceStats tests that both backends compute the same stats as computeStats
+func TestStorageBackendsDeviceStats(t *testing.T) {
+	tmpDir := t.TempDir()
+	backends := map[string]StorageBackend{
+		"SQLite": NewSQLiteStorage(filepath.Join(tmpDir, "test.db")),
+		"JSON":   NewJSONStorage(filepath.Join(tmpDir, "json")),
+	}
+
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	deviceAddr := "AA:BB:CC:DD:EE:FF"
+	var readings []Reading
+	for i := 0; i < 10; i++ {
+		tempC, humidity := 18.0+float64(i), 40.0+2*float64(i)
+		readings = append(readings, Reading{
+			DeviceName:    "Test",
+			DeviceAddr:    deviceAddr,
+			TempC:         tempC,
+			Humidity:      humidity,
+			DewPointC:     tempC - 8,
+			AbsHumidity:   humidity / 5,
+			SteamPressure: humidity / 4,
+			VPD:           1.5 - float64(i)/10,
+			Timestamp:     start.Add(time.Duration(i) * time.Hour),
+			ClientID:      "test",
+		})
+	}
+	// Hours 2 to 6 inclusive
+	from, to := start.Add(2*time.Hour), start.Add(6*time.Hour)
+	want := computeStats(readings[2:7], false)
+
+	for name, storage := range backends {
+		t.Run(name, func(t *testing.T) {
+			if err := storage.Initialize(); err != nil {
+				t.Fatalf("Failed to initialize storage: %v", err)
+			}
+			defer storage.Close()
+			storage.SaveReadings(deviceAddr, readings)
+
+			got, err := storage.GetDeviceStats(deviceAddr, from, to)
+			if err != nil {
+				t.Fatalf("GetDeviceStats failed: %v", err)
+			}
+			if len(got) != len(want) {
+				t.Errorf("Expected %d keys, got %d: %v", len(want), len(got), got)
+			}
+			for key, wantValue := range want {
+				switch w := wantValue.(type) {
+				case float64:
+					if g, ok := got[key].(float64); !ok || math.Abs(g-w) > 1e-9 {
+						t.Errorf("%s: expected %v, got %v", key, w, got[key])
+					}
+				case time.Time:
+					if g, ok := got[key].(time.Time); !ok || !g.Equal(w) {
+						t.Errorf("%s: expected %v, got %v", key, w, got[key])
+					}
+				default:
+					if got[key] != wantValue {
+						t.Errorf("%s: expected %v, got %v", key, wantValue, got[key])
+					}
+				}
+			}
+
+			// Open-ended ranges and unknown devices
+			if all, err := storage.GetDeviceStats(deviceAddr, time.Time{}, time.Time{}); err != nil || all["count"] != 10 {
+				t.Errorf("Expected 10 readings with no range, got %v (%v)", all["count"], err)
+			}
+			if none, err := storage.GetDeviceStats("11:22:33:44:55:66", from, to); err != nil || len(none) != 0 {
+				t.Errorf("Expected empty stats for an unknown device, got %v (%v)", none, err)
+			}
+		})
+	}
+}
+
+// TestStorageBackendsDeleteOldReadingsPerDevice tests that per-device cutoffs override the
+// global one in both backends, including keeping a device's readings forever
+func TestStorageBackendsDeleteOldReadingsPerDevice(t *testing.T) {
+	tmpDir := t.TempDir()
+	backends := map[string]StorageBackend{
+		"SQLite": NewSQLiteStorage(filepath.Join(tmpDir, "test.db")),
+		"JSON":   NewJSONStorage(filepath.Join(tmpDir, "json")),
+	}
+
+	now := time.Now()
+	freezer, guest, other := "AA:BB:CC:DD:EE:01", "AA:BB:CC:DD:EE:02", "AA:BB:CC:DD:EE:03"
+	ages := []time.Duration{1000 * 24 * time.Hour, 200 * 24 * time.Hour, 60 * 24 * time.Hour, time.Hour}
+
+	for name, storage := range backends {
+		t.Run(name, func(t *testing.T) {
+			if err := storage.Initialize(); err != nil {
+				t.Fatalf("Failed to initialize storage: %v", err)
+			}
+			defer storage.Close()
+			for _, addr := range []string{freezer, guest, other} {
+				var readings []Reading
+				for _, age := range ages {
+					readings = append(readings, Reading{DeviceName: "Test", DeviceAddr: addr, TempC: 20.0, Timestamp: now.Add(-age), ClientID: "test"})
+				}
+				storage.SaveReadings(addr, readings)
+			}
+
+			// A year by default, 30 days for the guest room and forever for the freezer
+			err := storage.DeleteOldReadings(now.Add(-365*24*time.Hour), map[string]time.Time{
+				"aabbccddee01": {},
+				"aabbccddee02": now.Add(-30 * 24 * time.Hour),
+			})
+			if err != nil {
+				t.Fatalf("Failed to delete old readings: %v", err)
+			}
+
+			for addr, want := range map[string]int64{freezer: 4, guest: 1, other: 3} {
+				if count, err := storage.GetReadingCountByDevice(addr); err != nil || count != want {
+					t.Errorf("Expected %d readings left for %s, got %d (%v)", want, addr, count, err)
+				}
+			}
+		})
+	}
+}
+
+// TestStorageBackendsKeepAggregates tests that both backends keep hourly aggregates of the
+// readings DeleteOldReadings removes when asked to
+func TestStorageBackendsKeepAggregates(t *testing.T) {
+	tmpDir := t.TempDir()
+	sqliteStorage := NewSQLiteStorage(filepath.Join(tmpDir, "test.db"))
+	jsonStorage := NewJSONStorage(filepath.Join(tmpDir, "json"))
+	sqliteStorage.SetKeepAggregatesForever(true)
+	jsonStorage.SetKeepAggregatesForever(true)
+	backends := map[string]StorageBackend{"SQLite": sqliteStorage, "JSON": jsonStorage}
+
+	deviceAddr := "AA:BB:CC:DD:EE:FF"
+	hour := time.Now().Add(-90 * 24 * time.Hour).UTC().Truncate(time.Hour)
+	readings := []Reading{
+		{DeviceName: "Test", DeviceAddr: deviceAddr, TempC: 20.0, Humidity: 40.0, Timestamp: hour.Add(10 * time.Minute), ClientID: "test"},
+		{DeviceName: "Test", DeviceAddr: deviceAddr, TempC: 24.0, Humidity: 60.0, Timestamp: hour.Add(40 * time.Minute), ClientID: "test"},
+		{DeviceName: "Test", DeviceAddr: deviceAddr, TempC: 25.0, Humidity: 55.0, Timestamp: time.Now().Add(-time.Hour), ClientID: "test"},
+	}
+
+	for name, storage := range backends {
+		t.Run(name, func(t *testing.T) {
+			if err := storage.Initialize(); err != nil {
+				t.Fatalf("Failed to initialize storage: %v", err)
+			}
+			defer storage.Close()
+			storage.SaveReadings(deviceAddr, readings)
+
+			if err := storage.DeleteOldReadings(time.Now().Add(-30*24*time.Hour), nil); err != nil {
+				t.Fatalf("Failed to delete old readings: %v", err)
+			}
+			if count, _ := storage.GetReadingCountByDevice(deviceAddr); count != 1 {
+				t.Fatalf("Expected 1 raw reading left, got %d", count)
+			}
+
+			aggregates, err := storage.GetHourlyAggregates(deviceAddr, hour.Add(-time.Hour), hour.Add(time.Hour))
+			if err != nil {
+				t.Fatalf("Failed to get hourly aggregates: %v", err)
+			}
+			if len(aggregates) != 1 {
+				t.Fatalf("Expected 1 aggregate for the deleted hour, got %+v", aggregates)
+			}
+			a := aggregates[0]
+			if !a.Timestamp.Equal(hour) || a.Count != 2 || a.AvgTempC != 22.0 || a.MinTempC != 20.0 || a.MaxHumidity != 60.0 {
+				t.Errorf("Unexpected aggregate for the deleted hour: %+v", a)
+			}
+		})
+	}
+}
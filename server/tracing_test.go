@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// useInMemoryTracing installs a tracer provider recording to an in-memory exporter for the test
+func useInMemoryTracing(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		otel.SetTracerProvider(noop.NewTracerProvider())
+	})
+	return exporter
+}
+
+// spanAttr returns the value of a span attribute, or "" if it isn't set
+func spanAttr(span tracetest.SpanStub, key attribute.Key) string {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+// TestTracingReadingsPost tests that a POST /readings produces a server span with device and client attributes
+func TestTracingReadingsPost(t *testing.T) {
+	exporter := useInMemoryTracing(t)
+	server := createTestServer(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/readings", server.handleReadings)
+	handler := tracingMiddleware(mux)
+
+	body, _ := json.Marshal(Reading{
+		DeviceName: "GVH5075_TEST",
+		DeviceAddr: "AA:BB:CC:DD:EE:FF",
+		TempC:      21.5,
+		Humidity:   45.0,
+		Battery:    90,
+		Timestamp:  time.Now(),
+		ClientID:   "test-client",
+	})
+	req := httptest.NewRequest("POST", "/readings", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name != "POST /readings" {
+		t.Errorf("Expected span name %q, got %q", "POST /readings", span.Name)
+	}
+	if got := spanAttr(span, "govee.device_addr"); got != "AA:BB:CC:DD:EE:FF" {
+		t.Errorf("Expected device attribute, got %q", got)
+	}
+	if got := spanAttr(span, "govee.client_id"); got != "test-client" {
+		t.Errorf("Expected client attribute, got %q", got)
+	}
+	if got := spanAttr(span, "http.response.status_code"); got != "201" {
+		t.Errorf("Expected status code attribute 201, got %q", got)
+	}
+}
+
+// TestTracingStorageSpans tests that saving and loading readings are traced
+func TestTracingStorageSpans(t *testing.T) {
+	exporter := useInMemoryTracing(t)
+	server := createTestServer(t)
+
+	readings := []Reading{{DeviceAddr: "AA:BB:CC:DD:EE:FF", Timestamp: time.Now()}}
+	if err := server.storageManager.saveReadings("AA:BB:CC:DD:EE:FF", readings); err != nil {
+		t.Fatalf("saveReadings failed: %v", err)
+	}
+	if _, err := server.storageManager.loadReadings("AA:BB:CC:DD:EE:FF", time.Time{}, time.Time{}); err != nil {
+		t.Fatalf("loadReadings failed: %v", err)
+	}
+
+	names := map[string]bool{}
+	for _, span := range exporter.GetSpans() {
+		names[span.Name] = true
+		if got := spanAttr(span, "govee.device_addr"); got != "AA:BB:CC:DD:EE:FF" {
+			t.Errorf("Span %s missing device attribute, got %q", span.Name, got)
+		}
+	}
+	if !names["storage.saveReadings"] || !names["storage.loadReadings"] {
+		t.Errorf("Expected storage spans, got %v", names)
+	}
+}